@@ -0,0 +1,286 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package hashlock implements the hashlock channel app for conditional
+// payments. A payment is locked with the hash of a secret preimage and paid
+// out to its receiver once the preimage is revealed. If the preimage is not
+// revealed before the timeout of the lock, the sender gets the locked funds
+// back. Hashlocks with the same hash in several channels make payments over
+// multiple hops atomic.
+//
+// The locked funds are moved into a sub-allocation of the state for every
+// lock, see AllocID. The timeouts are compared against the time of the state,
+// which the participants set with every update, so that ValidTransition is
+// deterministic. A final state must not contain locks.
+//
+// The hashlock app does not set the global app backend, so that it can be
+// used together with other apps, like the payment app. Instead, clients that
+// use hashlock channels have to use AppBackend as their app backend, see
+// client.Client.SetBackends.
+package hashlock // import "perun.network/go-perun/apps/hashlock"
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+)
+
+// App is a hashlock app.
+type App struct {
+	Addr wallet.Address
+}
+
+var _ channel.LockingApp = (*App)(nil)
+
+// Def returns the address of this hashlock app.
+func (a *App) Def() wallet.Address {
+	return a.Addr
+}
+
+// DecodeData decodes the hashlock Data from the reader.
+func (a *App) DecodeData(r io.Reader) (channel.Data, error) {
+	data := new(Data)
+	return data, data.Decode(r)
+}
+
+// ValidInit panics if State.Data is not *Data and checks that there are no
+// locks yet.
+func (a *App) ValidInit(_ *channel.Params, s *channel.State) error {
+	if data := assertData(s); len(data.Locks) > 0 {
+		return errors.New("initial state must not contain locks")
+	}
+	return validLockAllocs(nil, s.Locked)
+}
+
+// OwnsSubAlloc returns whether the locked sub-allocation with the given ID is
+// the sub-allocation of a lock, see AllocID.
+func (a *App) OwnsSubAlloc(id channel.ID) bool {
+	return isAllocID(id)
+}
+
+// ValidTransition checks the following rules:
+//   - The time of the state must not decrease and a final state must not
+//     contain locks.
+//   - Locks cannot be modified.
+//   - New locks are created by their sender, whose balances are reduced by the
+//     locked amounts.
+//   - Removed locks are claimed if their preimage is revealed, which pays the
+//     locked amounts to the receiver. Otherwise, they are released, which pays
+//     the locked amounts back to the sender. The receiver may release a lock
+//     at any time and the sender only once the time of the new state reached
+//     the timeout of the lock.
+//   - Every lock has its locked sub-allocation with the locked amounts, see
+//     AllocID.
+//   - Apart from locks, money flows only from the actor to the other
+//     participants, like in the payment app. If the locked sub-allocations of
+//     sub-channels change, sub-channels are funded or settled, which all
+//     participants have to agree on anyways. Then, the balances of all
+//     participants may change.
+func (a *App) ValidTransition(_ *channel.Params, from, to *channel.State, actor channel.Index) error {
+	fromData, toData := assertData(from), assertData(to)
+	if toData.Time.Before(fromData.Time) {
+		return errors.New("time must not decrease")
+	} else if to.IsFinal && len(toData.Locks) > 0 {
+		return errors.New("final state must not contain locks")
+	}
+
+	bals := cloneAllBals(from.OfParts) // expected balances before payments
+	for i := range fromData.Locks {
+		l := &fromData.Locks[i]
+		if m := toData.Lock(l.ID); m != nil {
+			if !l.equal(m) {
+				return errors.Errorf("lock %d modified", l.ID)
+			}
+			continue
+		}
+		switch {
+		case revealed(toData.Preimages, l.Hash):
+			unlock(bals, l, l.Receiver)
+		case actor == l.Receiver, !toData.Time.Before(l.Timeout):
+			unlock(bals, l, l.Sender)
+		default:
+			return errors.Errorf("participant[%d] releases lock %d before timeout", actor, l.ID)
+		}
+	}
+
+	for i := range toData.Locks {
+		l := &toData.Locks[i]
+		if fromData.Lock(l.ID) != nil {
+			continue
+		}
+		if err := validNewLock(toData, l, actor, len(to.OfParts), len(to.Assets)); err != nil {
+			return err
+		} else if err := lock(bals, l); err != nil {
+			return err
+		}
+	}
+
+	if err := validLockAllocs(toData.Locks, to.Locked); err != nil {
+		return err
+	}
+	if equalLocked(subChannelAllocs(from.Locked), subChannelAllocs(to.Locked)) {
+		return validPayments(bals, to.OfParts, actor)
+	}
+	return nil
+}
+
+// validNewLock checks that the new lock l in data is created by its sender.
+func validNewLock(data *Data, l *Lock, actor channel.Index, numParts, numAssets int) error {
+	if l.Sender != actor {
+		return errors.Errorf("participant[%d] creates lock %d of participant[%d]", actor, l.ID, l.Sender)
+	} else if int(l.Receiver) >= numParts || l.Receiver == l.Sender {
+		return errors.Errorf("invalid receiver %d of lock %d", l.Receiver, l.ID)
+	} else if len(l.Amounts) != numAssets {
+		return errors.Errorf("lock %d has %d amounts, expected %d", l.ID, len(l.Amounts), numAssets)
+	} else if !data.Time.Before(l.Timeout) {
+		return errors.Errorf("lock %d is timed out", l.ID)
+	}
+	var positive bool
+	for a, amount := range l.Amounts {
+		switch amount.Sign() {
+		case -1:
+			return errors.Errorf("lock %d has negative amount of asset %d", l.ID, a)
+		case 1:
+			positive = true
+		}
+	}
+	if !positive {
+		return errors.Errorf("lock %d has no positive amount", l.ID)
+	}
+	for i := range data.Locks {
+		if &data.Locks[i] != l && data.Locks[i].ID == l.ID {
+			return errors.Errorf("duplicate lock %d", l.ID)
+		}
+	}
+	return nil
+}
+
+// validPayments checks that only the actor's balances decrease from the
+// expected balances bals to the new balances.
+func validPayments(bals, to [][]channel.Bal, actor channel.Index) error {
+	for i, partBals := range bals {
+		for j, bal := range partBals {
+			if int(actor) == i && bal.Cmp(to[i][j]) == -1 {
+				return errors.Errorf("payer[%d] steals asset %d", i, j)
+			} else if int(actor) != i && bal.Cmp(to[i][j]) == 1 {
+				return errors.Errorf("payer[%d] reduces participant[%d]'s asset %d", actor, i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// validLockAllocs checks that the sub-allocations of locks in locked are
+// exactly the sub-allocations of the given locks with their amounts.
+func validLockAllocs(locks []Lock, locked []channel.SubAlloc) error {
+	if n := len(locked) - len(subChannelAllocs(locked)); n != len(locks) {
+		return errors.Errorf("%d locked sub-allocations of %d locks", n, len(locks))
+	}
+	for _, l := range locks {
+		idx := allocIdx(locked, AllocID(l.ID))
+		if idx < 0 || !equalBals(locked[idx].Bals, l.Amounts) {
+			return errors.Errorf("locked sub-allocation of lock %d does not match its amounts", l.ID)
+		}
+	}
+	return nil
+}
+
+// revealed returns whether the preimage of hash is in preimages.
+func revealed(preimages []Preimage, hash Hash) bool {
+	for _, p := range preimages {
+		if HashOf(p) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// lock moves the amounts of lock l from the balances of its sender to the
+// locked sub-allocation. An error is returned if the balances do not cover
+// them.
+func lock(bals [][]channel.Bal, l *Lock) error {
+	for a, amount := range l.Amounts {
+		bals[l.Sender][a].Sub(bals[l.Sender][a], amount)
+		if bals[l.Sender][a].Sign() < 0 {
+			return errors.Errorf("lock %d exceeds participant[%d]'s balance of asset %d", l.ID, l.Sender, a)
+		}
+	}
+	return nil
+}
+
+// unlock pays the locked amounts of lock l to the participant with index to.
+func unlock(bals [][]channel.Bal, l *Lock, to channel.Index) {
+	for a, amount := range l.Amounts {
+		bals[to][a].Add(bals[to][a], amount)
+	}
+}
+
+// equalLocked returns whether the sub-allocations a and b are equal.
+func equalLocked(a, b []channel.SubAlloc) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || !equalBals(a[i].Bals, b[i].Bals) {
+			return false
+		}
+	}
+	return true
+}
+
+// subChannelAllocs returns the sub-allocations in locked that are not the
+// sub-allocations of locks, i.e., that fund sub-channels.
+func subChannelAllocs(locked []channel.SubAlloc) []channel.SubAlloc {
+	var allocs []channel.SubAlloc
+	for _, sa := range locked {
+		if !isAllocID(sa.ID) {
+			allocs = append(allocs, sa)
+		}
+	}
+	return allocs
+}
+
+// allocIdx returns the index of the sub-allocation with the given ID in locked
+// or -1 if there is none.
+func allocIdx(locked []channel.SubAlloc, id channel.ID) int {
+	for i, sa := range locked {
+		if sa.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalBals(a, b []channel.Bal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cmp(b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneAllBals(bals [][]channel.Bal) [][]channel.Bal {
+	clone := make([][]channel.Bal, len(bals))
+	for i, partBals := range bals {
+		clone[i] = cloneBals(partBals)
+	}
+	return clone
+}
+
+func assertData(s *channel.State) *Data {
+	data, ok := s.Data.(*Data)
+	if !ok {
+		log.Panicf("hashlock app must have data of type *Data, has type %T", s.Data)
+	}
+	return data
+}
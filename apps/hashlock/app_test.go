@@ -0,0 +1,218 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+)
+
+func TestApp_ValidInit(t *testing.T) {
+	assert := assert.New(t)
+	app := new(App)
+
+	assert.Panics(func() { app.ValidInit(nil, &channel.State{Data: nil}) })
+	assert.Panics(func() { app.ValidInit(nil, &channel.State{Data: new(channel.MockOp)}) })
+
+	s := newState([][]int64{{10, 10}, {10, 10}})
+	assert.NoError(app.ValidInit(nil, s))
+	require.NoError(t, AddLock(s, newLock(1, 0, 5, time.Hour)))
+	assert.Error(app.ValidInit(nil, s))
+	s.Data.(*Data).Locks = nil
+	assert.Error(app.ValidInit(nil, s), "sub-allocation of lock")
+}
+
+func TestApp_ValidTransition(t *testing.T) {
+	app := new(App)
+	valid := func(t *testing.T, from, to *channel.State, actor channel.Index) {
+		assert.NoError(t, app.ValidTransition(nil, from, to, actor))
+	}
+	invalid := func(t *testing.T, from, to *channel.State, actor channel.Index) {
+		assert.Error(t, app.ValidTransition(nil, from, to, actor))
+	}
+	// locked returns a state in which participant 0 locked 5 for participant 1.
+	locked := func(timeout time.Duration) *channel.State {
+		s := newState([][]int64{{10, 10}, {10, 10}})
+		require.NoError(t, AddLock(s, newLock(1, 0, 5, timeout)))
+		return s
+	}
+
+	t.Run("payment", func(t *testing.T) {
+		from := newState([][]int64{{10, 10}, {10, 10}})
+		to := newState([][]int64{{5, 10}, {15, 10}})
+		valid(t, from, to, 0)
+		invalid(t, from, to, 1)
+	})
+
+	t.Run("lock", func(t *testing.T) {
+		from := newState([][]int64{{10, 10}, {10, 10}})
+		to := locked(time.Hour)
+		assert.Zero(t, to.OfParts[0][0].Cmp(big.NewInt(5)))
+		assert.Equal(t, []channel.SubAlloc{{ID: AllocID(1), Bals: []channel.Bal{big.NewInt(5), big.NewInt(0)}}}, to.Locked)
+		valid(t, from, to, 0)
+		invalid(t, from, to, 1) // not the sender
+
+		to.Data.(*Data).Locks[0].Amounts[0] = big.NewInt(4)
+		invalid(t, from, to, 0) // sub-allocation does not match
+		to = locked(time.Hour)
+		to.Locked = nil
+		invalid(t, from, to, 0) // no sub-allocation
+		to = locked(time.Hour)
+		to.Data.(*Data).Locks[0].Receiver = 0
+		invalid(t, from, to, 0) // sender is receiver
+
+		invalid(t, from, locked(-time.Second), 0) // timed out
+
+		to = locked(time.Hour)
+		l := newLock(1, 0, 1, time.Hour)
+		to.Data.(*Data).Locks = append(to.Data.(*Data).Locks, l)
+		invalid(t, from, to, 0) // duplicate ID
+	})
+
+	t.Run("locked funds", func(t *testing.T) {
+		from := locked(time.Hour)
+		valid(t, from, newStateWithLocked([][]int64{{0, 10}, {15, 10}}, from), 0)
+		// releasing pays the sender, not the receiver
+		to := newStateWithLocked([][]int64{{5, 10}, {15, 10}}, from)
+		require.NoError(t, Release(to, 1))
+		to.OfParts[0][0], to.OfParts[1][0] = big.NewInt(5), big.NewInt(15)
+		invalid(t, from, to, 1)
+	})
+
+	t.Run("modify", func(t *testing.T) {
+		from := locked(time.Hour)
+		to := from.Clone()
+		to.Data.(*Data).Locks[0].Timeout = from.Data.(*Data).Locks[0].Timeout.Add(time.Hour)
+		invalid(t, from, to, 0)
+		invalid(t, from, to, 1)
+	})
+
+	t.Run("claim", func(t *testing.T) {
+		from := locked(time.Hour)
+		to := from.Clone()
+		require.NoError(t, Claim(to, 1, preimage(1)))
+		assert.Zero(t, to.OfParts[1][0].Cmp(big.NewInt(15)))
+		assert.Empty(t, to.Locked)
+		valid(t, from, to, 0)
+		valid(t, from, to, 1)
+
+		to.Data.(*Data).Preimages = nil
+		invalid(t, from, to, 1) // not revealed
+		assert.Error(t, Claim(from.Clone(), 1, preimage(2)))
+	})
+
+	t.Run("release", func(t *testing.T) {
+		from := locked(time.Hour)
+		to := from.Clone()
+		require.NoError(t, Release(to, 1))
+		assert.Zero(t, to.OfParts[0][0].Cmp(big.NewInt(10)))
+		assert.Empty(t, to.Locked)
+		valid(t, from, to, 1)   // by receiver
+		invalid(t, from, to, 0) // by sender before timeout
+
+		SetTime(to, from.Data.(*Data).Locks[0].Timeout)
+		valid(t, from, to, 0) // by sender after timeout
+	})
+
+	t.Run("time", func(t *testing.T) {
+		from := newState([][]int64{{10, 10}, {10, 10}})
+		to := from.Clone()
+		SetTime(to, now.Add(-time.Second))
+		assert.Equal(t, now, to.Data.(*Data).Time, "time must not be decreased")
+		to.Data.(*Data).Time = now.Add(-time.Second)
+		invalid(t, from, to, 0)
+	})
+
+	t.Run("final", func(t *testing.T) {
+		from := newState([][]int64{{10, 10}, {10, 10}})
+		to := locked(time.Hour)
+		to.IsFinal = true
+		invalid(t, from, to, 0)
+	})
+
+	t.Run("sub-channels", func(t *testing.T) {
+		// funding a sub-channel reduces the balances of all participants
+		from := locked(time.Hour)
+		sub := channel.SubAlloc{ID: channel.ID{1}, Bals: []channel.Bal{big.NewInt(10), big.NewInt(10)}}
+		to := newStateWithLocked([][]int64{{0, 5}, {5, 5}}, from)
+		to.Locked = append(to.Locked, sub)
+		valid(t, from, to, 1)
+
+		// but the locks must stay locked
+		to = newStateWithData([][]int64{{0, 5}, {10, 5}}, from.Data)
+		to.Locked = []channel.SubAlloc{sub}
+		invalid(t, from, to, 1)
+	})
+}
+
+func TestAddLock(t *testing.T) {
+	s := newState([][]int64{{10, 10}, {10, 10}})
+	require.NoError(t, AddLock(s, newLock(1, 0, 6, time.Hour)))
+	assert.Error(t, AddLock(s, newLock(1, 1, 1, time.Hour)), "duplicate ID")
+	assert.Error(t, AddLock(s, newLock(2, 0, 5, time.Hour)), "exceeds balance")
+	assert.Error(t, AddLock(s, newLock(2, 2, 5, time.Hour)), "invalid sender")
+	assert.Len(t, s.Data.(*Data).Locks, 1)
+	assert.Len(t, s.Locked, 1)
+	assert.Zero(t, s.OfParts[0][0].Cmp(big.NewInt(4)))
+	assert.Error(t, Release(s, 2))
+}
+
+// now is the time of the states of the tests.
+var now = time.Unix(1e9, 0)
+
+// newLock returns a lock of amount of the first of two assets from participant
+// sender to the other of two participants with the hash of preimage(id).
+func newLock(id uint64, sender channel.Index, amount int64, timeout time.Duration) Lock {
+	return Lock{
+		ID:       id,
+		Sender:   sender,
+		Receiver: 1 - sender,
+		Amounts:  []channel.Bal{big.NewInt(amount), big.NewInt(0)},
+		Hash:     HashOf(preimage(id)),
+		Timeout:  now.Add(timeout),
+	}
+}
+
+func preimage(id uint64) (p Preimage) {
+	p[0] = byte(id)
+	return p
+}
+
+func newState(balsv [][]int64) *channel.State {
+	return newStateWithData(balsv, &Data{Time: now})
+}
+
+// newStateWithLocked returns a state with the given balances and the data and
+// locked sub-allocations of s.
+func newStateWithLocked(balsv [][]int64, s *channel.State) *channel.State {
+	state := newStateWithData(balsv, s.Data)
+	state.Locked = s.Clone().Locked
+	return state
+}
+
+func newStateWithData(balsv [][]int64, data channel.Data) *channel.State {
+	bigBalsv := make([][]channel.Bal, len(balsv))
+	for i, bals := range balsv {
+		bigBalsv[i] = make([]channel.Bal, len(bals))
+		for j, bal := range bals {
+			bigBalsv[i][j] = big.NewInt(bal)
+		}
+	}
+
+	return &channel.State{
+		Allocation: channel.Allocation{
+			Assets:  make([]channel.Asset, len(balsv[0])),
+			OfParts: bigBalsv,
+		},
+		Data: data.Clone(),
+	}
+}
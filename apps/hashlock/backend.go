@@ -0,0 +1,85 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// backend is the hashlock app backend that is returned by AppBackend. Unlike
+// the payment app, it is not set as the global app backend.
+var backend = new(Backend)
+
+// Backend is the hashlock app backend. The hashlock app's address has to be set
+// once before using the app by calling SetAppDef().
+type Backend struct {
+	def wallet.Address
+}
+
+// AppBackend returns the hashlock app backend. It creates hashlock apps and
+// all other apps with the global app backend, so that clients that use it as
+// their app backend can open hashlock channels next to the channels of the
+// globally set app, e.g., by calling
+// c.SetBackends(channel.Backends{App: hashlock.AppBackend()}).
+func AppBackend() *Backend {
+	return backend
+}
+
+// AppFromDefinition returns a hashlock app if def matches the address set
+// before. Other definitions are passed to the global app backend.
+func (b *Backend) AppFromDefinition(def wallet.Address) (channel.App, error) {
+	if b.def == nil {
+		panic("def is nil")
+	}
+
+	if !b.def.Equals(def) {
+		return channel.AppFromDefinition(def)
+	}
+
+	return &App{def}, nil
+}
+
+// AppFromDefinition returns a hashlock app if def matches the address set
+// before and an error otherwise.
+func AppFromDefinition(def wallet.Address) (channel.App, error) {
+	if backend.def == nil {
+		panic("set the hashlock app's address once with SetAppDef before calling AppFromDefinition")
+	}
+	if !backend.def.Equals(def) {
+		return nil, errors.Errorf("hashlock app has address %v, not %v", backend.def, def)
+	}
+	return backend.AppFromDefinition(def)
+}
+
+// SetAppDef sets the address of the hashlock app.
+func (b *Backend) SetAppDef(def wallet.Address) {
+	b.def = def
+}
+
+// SetAppDef sets the address of the hashlock app on the backend returned by
+// AppBackend.
+// The hashlock app's address must be set once at program start to the correct
+// address with this function.
+func SetAppDef(def wallet.Address) {
+	backend.SetAppDef(def)
+}
+
+// AppDef gets the address of the hashlock app.
+func (b *Backend) AppDef() wallet.Address {
+	return b.def
+}
+
+// AppDef gets the address of the hashlock app of the backend returned by
+// AppBackend.
+func AppDef() wallet.Address {
+	if backend.def == nil {
+		panic("set the hashlock app's address once with SetAppDef before calling AppDef")
+	}
+	return backend.AppDef()
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	_ "perun.network/go-perun/backend/sim" // backend init
+	"perun.network/go-perun/wallet/test"
+)
+
+func TestBackend(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	assert, require := assert.New(t), require.New(t)
+
+	require.NotNil(backend, "init() should have initialized the backend")
+
+	def := test.NewRandomAddress(rng)
+	SetAppDef(def)
+	assert.Equal(def, AppDef())
+
+	app, err := AppFromDefinition(test.NewRandomAddress(rng))
+	assert.Error(err)
+	assert.Nil(app)
+
+	app, err = AppFromDefinition(def)
+	require.NoError(err)
+	require.IsType(&App{}, app)
+	assert.Equal(def, app.Def())
+
+	// other definitions are passed to the global app backend, which is the
+	// payment app backend here
+	payDef := test.NewRandomAddress(rng)
+	payment.SetAppDef(payDef)
+	app, err = AppBackend().AppFromDefinition(payDef)
+	require.NoError(err)
+	assert.IsType(&payment.App{}, app)
+	app, err = AppBackend().AppFromDefinition(def)
+	require.NoError(err)
+	assert.IsType(&App{}, app)
+
+	r := new(Randomizer)
+	assert.Equal(def, r.NewRandomApp(rng).Def())
+	assert.IsType(&Data{}, r.NewRandomData(rng))
+}
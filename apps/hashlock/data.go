@@ -0,0 +1,234 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wire"
+)
+
+// MaxNumLocks is an artificial limit on the number of serialized locks and
+// preimages in the data of a hashlock channel.
+const MaxNumLocks = 1024
+
+type (
+	// Data is the app data of a hashlock channel.
+	Data struct {
+		// Locks are the open conditional payments.
+		Locks []Lock
+		// Preimages justify the claiming of locks that are removed by the
+		// update to this state. Preimages of earlier updates may be removed by
+		// any update.
+		Preimages []Preimage
+		// Time is the time of the state, which is set by the proposer of each
+		// update and must not decrease. The timeouts of the locks are compared
+		// against it, so that ValidTransition does not depend on the local
+		// clock. Participants should only accept updates whose time is close to
+		// their local clock.
+		Time time.Time
+	}
+
+	// Lock is a conditional payment. The amounts are moved from the balances
+	// of the sender into the locked sub-allocation with ID AllocID(ID). They
+	// are paid to the receiver if the preimage of Hash is revealed. Once the
+	// Time of the state reached Timeout, or if the receiver cancels the lock,
+	// they are released back to the sender.
+	Lock struct {
+		ID               uint64
+		Sender, Receiver channel.Index
+		Amounts          []channel.Bal // per asset
+		Hash             Hash
+		Timeout          time.Time
+	}
+
+	// Preimage is the secret preimage of a lock's hash.
+	Preimage = [32]byte
+	// Hash is the SHA256 hash of a preimage.
+	Hash = [32]byte
+)
+
+// allocTag is the prefix of the IDs of the locked sub-allocations of locks. As
+// the IDs of sub-channels are hashes, they cannot be confused with them.
+var allocTag = []byte("perun/hashlock")
+
+// AllocID returns the ID of the locked sub-allocation of the lock with the
+// given ID.
+func AllocID(id uint64) (aid channel.ID) {
+	copy(aid[:], allocTag)
+	binary.BigEndian.PutUint64(aid[len(aid)-8:], id)
+	return aid
+}
+
+// isAllocID returns whether aid is the ID of the sub-allocation of a lock.
+func isAllocID(aid channel.ID) bool {
+	return AllocID(binary.BigEndian.Uint64(aid[len(aid)-8:])) == aid
+}
+
+// HashOf returns the hash of the preimage.
+func HashOf(p Preimage) Hash {
+	return sha256.Sum256(p[:])
+}
+
+// Lock returns the lock with the given ID or nil if there is none.
+func (d *Data) Lock(id uint64) *Lock {
+	for i := range d.Locks {
+		if d.Locks[i].ID == id {
+			return &d.Locks[i]
+		}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the data.
+func (d *Data) Clone() channel.Data {
+	clone := &Data{Preimages: append([]Preimage(nil), d.Preimages...), Time: d.Time}
+	for _, l := range d.Locks {
+		clone.Locks = append(clone.Locks, l.Clone())
+	}
+	return clone
+}
+
+// Encode encodes the data into a writer.
+func (d *Data) Encode(w io.Writer) error {
+	if len(d.Locks) > MaxNumLocks || len(d.Preimages) > MaxNumLocks {
+		return errors.New("too many locks or preimages")
+	}
+	if err := wire.Encode(w, uint16(len(d.Locks))); err != nil {
+		return errors.WithMessage(err, "encoding number of locks")
+	}
+	for i := range d.Locks {
+		if err := d.Locks[i].Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding lock %d", i)
+		}
+	}
+	if err := wire.Encode(w, uint16(len(d.Preimages))); err != nil {
+		return errors.WithMessage(err, "encoding number of preimages")
+	}
+	for i, p := range d.Preimages {
+		if err := wire.Encode(w, p); err != nil {
+			return errors.WithMessagef(err, "encoding preimage %d", i)
+		}
+	}
+	var nsec int64 // the zero time is encoded as 0, which is not its UnixNano
+	if !d.Time.IsZero() {
+		nsec = d.Time.UnixNano()
+	}
+	return errors.WithMessage(wire.Encode(w, nsec), "encoding time")
+}
+
+// Decode decodes the data from a reader.
+func (d *Data) Decode(r io.Reader) error {
+	var numLocks, numPreimages uint16
+	if err := wire.Decode(r, &numLocks); err != nil {
+		return errors.WithMessage(err, "decoding number of locks")
+	} else if numLocks > MaxNumLocks {
+		return errors.New("too many locks")
+	}
+	d.Locks = nil
+	if numLocks > 0 {
+		d.Locks = make([]Lock, numLocks)
+	}
+	for i := range d.Locks {
+		if err := d.Locks[i].Decode(r); err != nil {
+			return errors.WithMessagef(err, "decoding lock %d", i)
+		}
+	}
+	if err := wire.Decode(r, &numPreimages); err != nil {
+		return errors.WithMessage(err, "decoding number of preimages")
+	} else if numPreimages > MaxNumLocks {
+		return errors.New("too many preimages")
+	}
+	d.Preimages = nil
+	if numPreimages > 0 {
+		d.Preimages = make([]Preimage, numPreimages)
+	}
+	for i := range d.Preimages {
+		if err := wire.Decode(r, &d.Preimages[i]); err != nil {
+			return errors.WithMessagef(err, "decoding preimage %d", i)
+		}
+	}
+	var nsec int64
+	if err := wire.Decode(r, &nsec); err != nil {
+		return errors.WithMessage(err, "decoding time")
+	}
+	d.Time = time.Time{}
+	if nsec != 0 {
+		d.Time = time.Unix(0, nsec)
+	}
+	return nil
+}
+
+// Clone returns a deep copy of the lock.
+func (l Lock) Clone() Lock {
+	l.Amounts = cloneBals(l.Amounts)
+	return l
+}
+
+// Encode encodes the lock into a writer.
+func (l *Lock) Encode(w io.Writer) error {
+	if len(l.Amounts) > channel.MaxNumAssets {
+		return errors.New("too many assets")
+	}
+	if err := wire.Encode(w, l.ID, l.Sender, l.Receiver, l.Hash, l.Timeout,
+		uint16(len(l.Amounts))); err != nil {
+		return err
+	}
+	for a, amount := range l.Amounts {
+		if err := wire.Encode(w, amount); err != nil {
+			return errors.WithMessagef(err, "encoding amount of asset %d", a)
+		}
+	}
+	return nil
+}
+
+// Decode decodes the lock from a reader.
+func (l *Lock) Decode(r io.Reader) error {
+	var numAssets uint16
+	if err := wire.Decode(r, &l.ID, &l.Sender, &l.Receiver, &l.Hash, &l.Timeout,
+		&numAssets); err != nil {
+		return err
+	}
+	if numAssets > channel.MaxNumAssets {
+		return errors.New("too many assets")
+	}
+	l.Amounts = make([]channel.Bal, numAssets)
+	for a := range l.Amounts {
+		if err := wire.Decode(r, &l.Amounts[a]); err != nil {
+			return errors.WithMessagef(err, "decoding amount of asset %d", a)
+		}
+	}
+	return nil
+}
+
+// equal returns whether the locks are equal.
+func (l *Lock) equal(m *Lock) bool {
+	if l.ID != m.ID || l.Sender != m.Sender || l.Receiver != m.Receiver ||
+		l.Hash != m.Hash || !l.Timeout.Equal(m.Timeout) || len(l.Amounts) != len(m.Amounts) {
+		return false
+	}
+	for a, amount := range l.Amounts {
+		if amount.Cmp(m.Amounts[a]) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneBals(bals []channel.Bal) []channel.Bal {
+	clone := make([]channel.Bal, len(bals))
+	for i, bal := range bals {
+		clone[i] = new(big.Int).Set(bal)
+	}
+	return clone
+}
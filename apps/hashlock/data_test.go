@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	iotest "perun.network/go-perun/pkg/io/test"
+)
+
+func TestDataSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xC0DE))
+	iotest.GenericSerializerTest(t, new(Data), newRandomData(rng, 1), newRandomData(rng, 3))
+}
+
+func TestDataClone(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xC10E))
+	data := newRandomData(rng, 2)
+	clone := data.Clone().(*Data)
+	require.Equal(t, data, clone)
+
+	clone.Locks[0].Amounts[0].Add(clone.Locks[0].Amounts[0], big.NewInt(1))
+	clone.Preimages[0][0]++
+	assert.NotEqual(t, data.Locks[0].Amounts[0], clone.Locks[0].Amounts[0])
+	assert.NotEqual(t, data.Preimages[0], clone.Preimages[0])
+}
+
+func TestApp_DecodeData(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xDEC0))
+	data := newRandomData(rng, 2)
+	var buf bytes.Buffer
+	require.NoError(t, data.Encode(&buf))
+	decoded, err := new(App).DecodeData(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+}
+
+func TestAllocID(t *testing.T) {
+	app := new(App)
+	assert.True(t, app.OwnsSubAlloc(AllocID(0)))
+	assert.True(t, app.OwnsSubAlloc(AllocID(42)))
+	assert.NotEqual(t, AllocID(1), AllocID(2))
+	assert.False(t, app.OwnsSubAlloc(channel.ID{1}))
+	assert.False(t, app.OwnsSubAlloc(channel.ID{}))
+}
+
+func newRandomData(rng *rand.Rand, numLocks int) *Data {
+	data := &Data{Preimages: make([]Preimage, numLocks), Time: time.Unix(0, rng.Int63())}
+	for i := range data.Preimages {
+		rng.Read(data.Preimages[i][:])
+		data.Locks = append(data.Locks, Lock{
+			ID:       rng.Uint64(),
+			Sender:   channel.Index(i % 2),
+			Receiver: channel.Index(1 - i%2),
+			Amounts:  []channel.Bal{big.NewInt(rng.Int63()), big.NewInt(rng.Int63())},
+			Hash:     HashOf(data.Preimages[i]),
+			Timeout:  time.Unix(0, rng.Int63()),
+		})
+	}
+	return data
+}
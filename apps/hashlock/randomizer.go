@@ -0,0 +1,35 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"math/rand"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/test"
+)
+
+// Randomizer implements channel.test.AppRandomizer. Like the app backend, it
+// is not set globally, see AppBackend.
+type Randomizer struct{}
+
+var _ test.AppRandomizer = (*Randomizer)(nil)
+
+// NewRandomApp always returns a hashlock app with the same address. Currently,
+// one hashlock address has to be set at program startup.
+func (*Randomizer) NewRandomApp(*rand.Rand) channel.App {
+	return &App{AppDef()}
+}
+
+// NewRandomData returns Data without locks, which is valid for all states, and
+// with random preimages.
+func (*Randomizer) NewRandomData(rng *rand.Rand) channel.Data {
+	data := &Data{Preimages: make([]Preimage, rng.Intn(3)+1)}
+	for i := range data.Preimages {
+		rng.Read(data.Preimages[i][:])
+	}
+	return data
+}
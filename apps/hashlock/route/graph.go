@@ -0,0 +1,139 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route
+
+import (
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/peer"
+)
+
+// Fee is the fee that a node charges for forwarding a payment. It is Base plus
+// Rate millionths of the forwarded amount.
+type Fee struct {
+	Base *big.Int
+	Rate uint64
+}
+
+// Of returns the fee for forwarding amount.
+func (f Fee) Of(amount *big.Int) *big.Int {
+	fee := new(big.Int).Mul(amount, new(big.Int).SetUint64(f.Rate))
+	fee.Quo(fee, big.NewInt(1000000))
+	if f.Base != nil {
+		fee.Add(fee, f.Base)
+	}
+	return fee
+}
+
+type (
+	// Graph is the network of channels that a node knows of. Its nodes are
+	// identified by their Perun address. It is safe for concurrent use.
+	Graph struct {
+		mtx   sync.RWMutex
+		nodes map[string]*node
+	}
+
+	node struct {
+		addr      peer.Address
+		fee       Fee
+		neighbors map[string]struct{}
+	}
+)
+
+// NewGraph creates a new empty Graph.
+func NewGraph() *Graph {
+	return &Graph{nodes: make(map[string]*node)}
+}
+
+// AddChannel adds a channel between a and b.
+func (g *Graph) AddChannel(a, b peer.Address) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.node(a).neighbors[b.String()] = struct{}{}
+	g.node(b).neighbors[a.String()] = struct{}{}
+}
+
+// RemoveChannel removes the channel between a and b.
+func (g *Graph) RemoveChannel(a, b peer.Address) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	delete(g.node(a).neighbors, b.String())
+	delete(g.node(b).neighbors, a.String())
+}
+
+// SetFee sets the forwarding fee of the node with the given address.
+func (g *Graph) SetFee(addr peer.Address, fee Fee) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.node(addr).fee = fee
+}
+
+// Fee returns the forwarding fee of the node with the given address. Nodes
+// without a fee forward for free.
+func (g *Graph) Fee(addr peer.Address) Fee {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	if n, ok := g.nodes[addr.String()]; ok {
+		return n.fee
+	}
+	return Fee{}
+}
+
+// Path returns a path with the least hops from one node to another. The path
+// starts with the first hop after from and ends with to.
+func (g *Graph) Path(from, to peer.Address) ([]peer.Address, error) {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+
+	// breadth-first search, visiting neighbors in a deterministic order
+	src, dst := from.String(), to.String()
+	prev := map[string]string{src: ""}
+	for queue := []string{src}; len(queue) > 0; queue = queue[1:] {
+		cur := queue[0]
+		if cur == dst {
+			break
+		}
+		n, ok := g.nodes[cur]
+		if !ok {
+			continue
+		}
+		neighbors := make([]string, 0, len(n.neighbors))
+		for nb := range n.neighbors {
+			neighbors = append(neighbors, nb)
+		}
+		sort.Strings(neighbors)
+		for _, nb := range neighbors {
+			if _, visited := prev[nb]; !visited {
+				prev[nb] = cur
+				queue = append(queue, nb)
+			}
+		}
+	}
+
+	if _, ok := prev[dst]; !ok || src == dst {
+		return nil, errors.Errorf("no path from %v to %v", from, to)
+	}
+	var path []peer.Address
+	for cur := dst; cur != src; cur = prev[cur] {
+		path = append([]peer.Address{g.nodes[cur].addr}, path...)
+	}
+	return path, nil
+}
+
+// node returns the node with the given address, adding it if necessary. The
+// graph must be locked by the caller.
+func (g *Graph) node(addr peer.Address) *node {
+	n, ok := g.nodes[addr.String()]
+	if !ok {
+		n = &node{addr: addr, neighbors: make(map[string]struct{})}
+		g.nodes[addr.String()] = n
+	}
+	return n
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route_test
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/hashlock/route"
+	"perun.network/go-perun/peer"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestGraph(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x64A))
+	addrs := make([]peer.Address, 5)
+	for i := range addrs {
+		addrs[i] = wallettest.NewRandomAddress(rng)
+	}
+	g := route.NewGraph()
+	// 0 - 1 - 2 - 3, 0 - 4 - 3
+	g.AddChannel(addrs[0], addrs[1])
+	g.AddChannel(addrs[1], addrs[2])
+	g.AddChannel(addrs[2], addrs[3])
+	g.AddChannel(addrs[0], addrs[4])
+	g.AddChannel(addrs[4], addrs[3])
+
+	path, err := g.Path(addrs[0], addrs[3])
+	require.NoError(t, err)
+	assert.Equal(t, []peer.Address{addrs[4], addrs[3]}, path)
+
+	path, err = g.Path(addrs[1], addrs[2])
+	require.NoError(t, err)
+	assert.Equal(t, []peer.Address{addrs[2]}, path)
+
+	g.RemoveChannel(addrs[4], addrs[3])
+	path, err = g.Path(addrs[0], addrs[3])
+	require.NoError(t, err)
+	assert.Equal(t, []peer.Address{addrs[1], addrs[2], addrs[3]}, path)
+
+	_, err = g.Path(addrs[0], addrs[0])
+	assert.Error(t, err)
+	_, err = g.Path(addrs[0], wallettest.NewRandomAddress(rng))
+	assert.Error(t, err)
+
+	fee := route.Fee{Base: big.NewInt(3)}
+	g.SetFee(addrs[1], fee)
+	assert.Equal(t, fee, g.Fee(addrs[1]))
+	assert.Equal(t, route.Fee{}, g.Fee(addrs[2]))
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/apps/hashlock"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/msg"
+)
+
+// MsgType is the external wire message type of routed payments. It must not
+// be used by other external message types.
+const MsgType = msg.Type(0xA0)
+
+// maxHops is an artificial limit on the number of hops of a route.
+const maxHops = 256
+
+func init() {
+	msg.RegisterExternalDecoder(MsgType,
+		func(r io.Reader) (msg.Msg, error) {
+			var m msgRoute
+			return &m, m.Decode(r)
+		}, "RoutedPayment")
+}
+
+// msgRoute is sent by a hop of a routed payment to the next hop, before it
+// locks the payment in their channel. It tells the next hop where to forward
+// the payment.
+type msgRoute struct {
+	Hash  hashlock.Hash
+	Asset channel.Asset
+	// Path contains the hops after the receiver of the message, ending with
+	// the destination. It is empty if the receiver is the destination.
+	Path []peer.Address
+	// Amounts are the amounts of the asset that are locked for the hops in
+	// Path.
+	Amounts []*big.Int
+}
+
+// Type returns this message's type: MsgType
+func (*msgRoute) Type() msg.Type {
+	return MsgType
+}
+
+func (m msgRoute) Encode(w io.Writer) error {
+	if len(m.Path) > maxHops || len(m.Path) != len(m.Amounts) {
+		return errors.Errorf("invalid path of %d hops with %d amounts", len(m.Path), len(m.Amounts))
+	}
+	if err := wire.Encode(w, m.Hash, m.Asset, uint16(len(m.Path))); err != nil {
+		return err
+	}
+	for i, addr := range m.Path {
+		if err := wire.Encode(w, addr, m.Amounts[i]); err != nil {
+			return errors.WithMessagef(err, "encoding hop %d", i)
+		}
+	}
+	return nil
+}
+
+func (m *msgRoute) Decode(r io.Reader) (err error) {
+	backends := channel.DecodingBackends(r)
+	if err := wire.Decode(r, &m.Hash); err != nil {
+		return err
+	}
+	if m.Asset, err = backends.DecodeAsset(r); err != nil {
+		return errors.WithMessage(err, "decoding asset")
+	}
+	var numHops uint16
+	if err := wire.Decode(r, &numHops); err != nil {
+		return err
+	} else if numHops > maxHops {
+		return errors.Errorf("too many hops: %d", numHops)
+	}
+	m.Path, m.Amounts = make([]peer.Address, numHops), make([]*big.Int, numHops)
+	for i := range m.Path {
+		if m.Path[i], err = backends.DecodeAddress(r); err != nil {
+			return errors.WithMessagef(err, "decoding address of hop %d", i)
+		}
+		if err := wire.Decode(r, &m.Amounts[i]); err != nil {
+			return errors.WithMessagef(err, "decoding amount of hop %d", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route
+
+import (
+	"math/big"
+	"math/rand"
+	"testing"
+
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/peer"
+	wallettest "perun.network/go-perun/wallet/test"
+	"perun.network/go-perun/wire/msg"
+)
+
+func TestRouteMsgSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x4047))
+	for hops := 0; hops < 4; hops++ {
+		m := &msgRoute{Asset: channeltest.NewRandomAsset(rng)}
+		rng.Read(m.Hash[:])
+		for i := 0; i < hops; i++ {
+			m.Path = append(m.Path, peer.Address(wallettest.NewRandomAddress(rng)))
+			m.Amounts = append(m.Amounts, big.NewInt(rng.Int63()))
+		}
+		if hops == 0 {
+			m.Path, m.Amounts = []peer.Address{}, []*big.Int{}
+		}
+		msg.TestMsg(t, m)
+	}
+}
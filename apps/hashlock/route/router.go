@@ -0,0 +1,764 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package route implements multi-hop payments over hashlock channels. A
+// payment is routed over the path with the least hops in a Graph of known
+// channels. Every hop locks the payment to the next hop with the same hash,
+// which the destination generated with NewInvoice. When the destination claims
+// its lock by revealing the preimage, every hop learns the preimage and claims
+// its incoming lock, so that the payment is atomic. If forwarding fails, the
+// hops release their locks back to the sender.
+//
+// Atomicity requires that every hop can claim its incoming lock before its
+// timeout once its outgoing lock was claimed. Off-chain, this needs the peer
+// to accept the claim, so the router retries it until the timeout. Without
+// the peer's cooperation, the lock can only be claimed on-chain by progressing
+// the hashlock state, which the channel.Adjudicator does not support yet. So
+// a hop can lose the forwarded amount if the peer of its incoming lock stops
+// responding, and routers should only forward payments from peers that they
+// trust to cooperate.
+//
+// The clients of the routers have to use hashlock.AppBackend as their app
+// backend. The routers set the time of the hashlock states to their local
+// clock and reject updates whose time differs from it by more than the
+// ClockTolerance of their Config, so the clocks of the nodes must be loosely
+// synchronized.
+package route // import "perun.network/go-perun/apps/hashlock/route"
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/apps/hashlock"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wire/msg"
+)
+
+type (
+	// Config configures the timeouts and limits of a Router.
+	Config struct {
+		// ResponseTimeout is the timeout for responding to updates.
+		ResponseTimeout time.Duration
+		// UpdateTimeout is the timeout of the router's own channel updates.
+		UpdateTimeout time.Duration
+		// UpdateAttempts is the number of attempts of the router's own channel
+		// updates, which may collide with updates of the peer, and RetryDelay
+		// is the delay between the attempts.
+		UpdateAttempts int
+		RetryDelay     time.Duration
+		// RouteMsgTimeout is how long the router waits for the route message
+		// of an incoming lock.
+		RouteMsgTimeout time.Duration
+		// RouteExpiry is how long the router keeps route messages without a
+		// lock.
+		RouteExpiry time.Duration
+		// MaxRoutesPerPeer is the maximal number of route messages without a
+		// lock that the router keeps per peer. Further route messages of the
+		// peer are dropped.
+		MaxRoutesPerPeer int
+		// ClockTolerance is the time that the router waits after the timeout
+		// of a lock before it releases it, so that the peer's clock passed it,
+		// too. It is also the maximal difference of the time of an accepted
+		// state to the router's clock.
+		ClockTolerance time.Duration
+	}
+
+	// Router routes payments over the hashlock channels of a client. It
+	// forwards the payments of other nodes for the forwarding fee that is set
+	// for its own address in the graph. It is an UpdateHandler for its
+	// channels and rejects all updates that are not part of routed payments.
+	Router struct {
+		c          *client.Client
+		addr       peer.Address
+		graph      *Graph
+		hopTimeout time.Duration
+		cfg        Config
+		log        log.Logger
+
+		mtx      sync.Mutex
+		channels map[string]*routerChannel // by peer address
+		invoices map[hashlock.Hash]*invoice
+		payments map[hashlock.Hash]*payment
+	}
+
+	// routerChannel is a channel of the router.
+	routerChannel struct {
+		ch   *client.Channel
+		peer peer.Address
+		mtx  sync.Mutex // serializes the router's updates
+	}
+
+	invoice struct {
+		preimage hashlock.Preimage
+		amount   *big.Int
+	}
+
+	// payment is a payment that we send, forward or receive.
+	payment struct {
+		hash    hashlock.Hash
+		routed  chan struct{} // closed once route and from are set
+		route   *msgRoute
+		from    peer.Address
+		in, out *lockRef   // incoming and outgoing lock
+		done    chan error // result of our own payments, nil otherwise
+
+		resolveOnce sync.Once
+		resolved    chan struct{} // closed once the outgoing lock is removed
+	}
+
+	lockRef struct {
+		ch      *routerChannel
+		id      uint64
+		timeout time.Time
+	}
+)
+
+var _ client.UpdateHandler = (*Router)(nil)
+
+// DefaultConfig returns the config that is used by NewRouter.
+func DefaultConfig() Config {
+	return Config{
+		ResponseTimeout:  10 * time.Second,
+		UpdateTimeout:    10 * time.Second,
+		UpdateAttempts:   3,
+		RetryDelay:       100 * time.Millisecond,
+		RouteMsgTimeout:  2 * time.Second,
+		RouteExpiry:      time.Minute,
+		MaxRoutesPerPeer: hashlock.MaxNumLocks,
+		ClockTolerance:   time.Second,
+	}
+}
+
+// NewRouter creates a new router for client c, which has Perun address addr.
+// Payments are routed over the channels in graph. Every hop gets hopTimeout
+// to forward a payment, so the locks of a payment over n hops time out after n
+// times hopTimeout. All routers of a network should use the same hopTimeout.
+//
+// NewRouter must be called before the client connects to any peers. If any
+// argument is nil, NewRouter panics.
+func NewRouter(c *client.Client, addr peer.Address, graph *Graph, hopTimeout time.Duration) *Router {
+	if c == nil || addr == nil || graph == nil {
+		log.Panic("client, address and graph must not be nil")
+	}
+	r := &Router{
+		c:          c,
+		addr:       addr,
+		graph:      graph,
+		hopTimeout: hopTimeout,
+		cfg:        DefaultConfig(),
+		log:        c.Log().WithField("role", "router"),
+		channels:   make(map[string]*routerChannel),
+		invoices:   make(map[hashlock.Hash]*invoice),
+		payments:   make(map[hashlock.Hash]*payment),
+	}
+	c.HandleMsgs(func(m msg.Msg) bool { return m.Type() == MsgType }, r.handleRouteMsg)
+	return r
+}
+
+// SetConfig sets the config of the router. Like NewRouter, it must be called
+// before the client connects to any peers.
+func (r *Router) SetConfig(cfg Config) {
+	r.cfg = cfg
+}
+
+// AddChannel adds the two-party hashlock channel ch to the router and the
+// graph. The router starts handling the updates of the channel, so
+// ListenUpdates must not be called for it.
+func (r *Router) AddChannel(ch *client.Channel) error {
+	if len(ch.Params().Parts) != 2 {
+		return errors.New("only two-party channels can be routed over")
+	} else if _, ok := ch.State().Data.(*hashlock.Data); !ok {
+		return errors.New("not a hashlock channel")
+	}
+	rc := &routerChannel{ch: ch, peer: ch.Peers()[0]}
+	r.mtx.Lock()
+	if _, ok := r.channels[rc.peer.String()]; ok {
+		r.mtx.Unlock()
+		return errors.Errorf("already routing over a channel with %v", rc.peer)
+	}
+	r.channels[rc.peer.String()] = rc
+	r.mtx.Unlock()
+
+	r.graph.AddChannel(r.addr, rc.peer)
+	go ch.ListenUpdates(r)
+	return nil
+}
+
+// NewInvoice returns the hash of a new payment of at least amount to us. The
+// sender pays the invoice with Pay.
+func (r *Router) NewInvoice(amount *big.Int) (hashlock.Hash, error) {
+	var inv invoice
+	if _, err := rand.Read(inv.preimage[:]); err != nil {
+		return hashlock.Hash{}, errors.Wrap(err, "generating preimage")
+	}
+	inv.amount = new(big.Int).Set(amount)
+	hash := hashlock.HashOf(inv.preimage)
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.invoices[hash] = &inv
+	return hash, nil
+}
+
+// Pay pays amount of asset to the destination dest for the invoice with the
+// given hash. The payment is routed over the path with the least hops. On top
+// of the amount, the forwarding fees of all intermediate hops are paid. Pay
+// returns once the destination received the payment or the payment failed.
+//
+// If ctx is done before, the payment is still pending. Its locks are released
+// after their timeout if the payment fails.
+func (r *Router) Pay(ctx context.Context, dest peer.Address, asset channel.Asset, amount *big.Int, hash hashlock.Hash) error {
+	if amount.Sign() <= 0 {
+		return errors.New("amount must be positive")
+	}
+	path, err := r.graph.Path(r.addr, dest)
+	if err != nil {
+		return err
+	}
+	rc := r.channel(path[0])
+	if rc == nil {
+		return errors.Errorf("no channel with first hop %v", path[0])
+	}
+
+	// The amounts are calculated backwards from the destination: every
+	// intermediate hop gets its fee for forwarding the amount to the next hop.
+	amounts := make([]*big.Int, len(path))
+	amounts[len(path)-1] = new(big.Int).Set(amount)
+	for i := len(path) - 2; i >= 0; i-- {
+		amounts[i] = new(big.Int).Add(amounts[i+1], r.graph.Fee(path[i]).Of(amounts[i+1]))
+	}
+
+	p, err := r.newPayment(hash)
+	if err != nil {
+		return err
+	}
+	p.done = make(chan error, 1)
+	timeout := time.Now().Add(r.hopTimeout * time.Duration(len(path)))
+	m := &msgRoute{Hash: hash, Asset: asset, Path: path[1:], Amounts: amounts[1:]}
+	if err := r.lock(ctx, rc, p, m, amounts[0], timeout); err != nil {
+		r.deletePayment(hash)
+		return err
+	}
+	go r.expire(p, timeout)
+
+	select {
+	case err := <-p.done:
+		return err
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "payment pending")
+	}
+}
+
+// Handle handles the updates of the router's channels. It accepts updates that
+// add locks of routed payments for us and updates that claim or release locks.
+// All other updates are rejected.
+func (r *Router) Handle(up client.ChannelUpdate, res *client.UpdateResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.ResponseTimeout)
+	defer cancel()
+
+	followUps, err := r.checkUpdate(up, res)
+	if err != nil {
+		if err := res.Reject(ctx, err.Error()); err != nil {
+			r.log.Warnf("rejecting update: %v", err)
+		}
+		return
+	}
+	if err := res.Accept(ctx); err != nil {
+		r.log.Warnf("accepting update: %v", err)
+		return
+	}
+	for _, f := range followUps {
+		go f()
+	}
+}
+
+// checkUpdate checks that the update only adds locks of routed payments for us
+// and claims or releases locks. It returns the functions that have to be
+// called once the update is accepted.
+func (r *Router) checkUpdate(up client.ChannelUpdate, res *client.UpdateResponder) ([]func(), error) {
+	rc := r.channelByID(res.ChannelID())
+	cur := res.CurrentState()
+	curData, ok := cur.Data.(*hashlock.Data)
+	next, ok2 := up.State.Data.(*hashlock.Data)
+	if rc == nil || !ok || !ok2 {
+		return nil, errors.New("not a routed channel")
+	} else if up.ActorIdx == res.Idx() {
+		return nil, errors.New("not a routing update")
+	}
+
+	if d := next.Time.Sub(time.Now()); d > r.cfg.ClockTolerance || d < -r.cfg.ClockTolerance {
+		return nil, errors.Errorf("time of state differs from our clock by %v", d)
+	}
+
+	expected := cur.Clone() // the expected state after the lock changes
+	expected.Data.(*hashlock.Data).Preimages = nil
+	hashlock.SetTime(expected, next.Time)
+	var followUps []func()
+	for _, l := range curData.Locks {
+		if next.Lock(l.ID) != nil {
+			continue
+		}
+		l := l
+		if p, ok := findPreimage(next.Preimages, l.Hash); ok {
+			if err := hashlock.Claim(expected, l.ID, p); err != nil {
+				return nil, err
+			}
+			followUps = append(followUps, func() { r.removed(rc, &l, &p) })
+		} else {
+			if err := hashlock.Release(expected, l.ID); err != nil {
+				return nil, err
+			}
+			followUps = append(followUps, func() { r.removed(rc, &l, nil) })
+		}
+	}
+	for _, l := range next.Locks {
+		if curData.Lock(l.ID) != nil {
+			continue
+		}
+		f, err := r.checkLock(rc, cur, l)
+		if err != nil {
+			return nil, err
+		}
+		if err := hashlock.AddLock(expected, l); err != nil {
+			return nil, err
+		}
+		followUps = append(followUps, f)
+	}
+
+	if len(followUps) == 0 || up.State.IsFinal || !equalAlloc(&expected.Allocation, &up.State.Allocation) {
+		return nil, errors.New("not a routing update")
+	}
+	return followUps, nil
+}
+
+// checkLock checks that the new incoming lock l belongs to a payment that we
+// receive or can forward. It returns the function that claims or forwards the
+// payment once the lock is accepted.
+func (r *Router) checkLock(rc *routerChannel, cur *channel.State, l hashlock.Lock) (func(), error) {
+	p, err := r.awaitRoute(l.Hash)
+	if err != nil {
+		return nil, err
+	} else if !p.from.Equals(rc.peer) {
+		return nil, errors.New("route message from different peer")
+	} else if !time.Now().Before(l.Timeout) {
+		return nil, errors.New("lock timed out")
+	}
+	a, err := assetIdx(cur.Assets, p.route.Asset)
+	if err != nil {
+		return nil, err
+	}
+	for b, amount := range l.Amounts {
+		if b != a && amount.Sign() != 0 {
+			return nil, errors.Errorf("lock of unexpected asset %d", b)
+		}
+	}
+	amount := l.Amounts[a]
+
+	if len(p.route.Path) == 0 { // we are the destination
+		inv := r.invoice(l.Hash)
+		if inv == nil {
+			return nil, errors.New("unknown invoice")
+		} else if amount.Cmp(inv.amount) < 0 {
+			return nil, errors.Errorf("amount %v below invoice amount %v", amount, inv.amount)
+		}
+		return func() { r.receive(p, &lockRef{rc, l.ID, l.Timeout}, inv.preimage) }, nil
+	}
+
+	out := r.channel(p.route.Path[0])
+	fwd := p.route.Amounts[0]
+	timeout := l.Timeout.Add(-r.hopTimeout)
+	if out == nil {
+		return nil, errors.Errorf("no channel with next hop %v", p.route.Path[0])
+	} else if fee := new(big.Int).Sub(amount, fwd); fee.Cmp(r.graph.Fee(r.addr).Of(fwd)) < 0 {
+		return nil, errors.Errorf("fee %v too low", fee)
+	} else if !time.Now().Before(timeout) {
+		return nil, errors.New("timeout too short for forwarding")
+	}
+	return func() { r.forward(p, &lockRef{rc, l.ID, l.Timeout}, out, timeout) }, nil
+}
+
+// receive claims the incoming lock of a payment to us.
+func (r *Router) receive(p *payment, in *lockRef, preimage hashlock.Preimage) {
+	r.setIn(p, in)
+	if err := r.claim(in, preimage); err != nil {
+		r.log.Errorf("claiming payment: %v", err)
+		return
+	}
+	r.mtx.Lock()
+	delete(r.invoices, p.hash)
+	r.mtx.Unlock()
+	r.deletePayment(p.hash)
+}
+
+// forward locks the payment to the next hop. If that fails, the incoming lock
+// is released.
+func (r *Router) forward(p *payment, in *lockRef, out *routerChannel, timeout time.Time) {
+	r.setIn(p, in)
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.UpdateTimeout)
+	defer cancel()
+	m := &msgRoute{Hash: p.hash, Asset: p.route.Asset, Path: p.route.Path[1:], Amounts: p.route.Amounts[1:]}
+	if err := r.lock(ctx, out, p, m, p.route.Amounts[0], timeout); err != nil {
+		r.log.Warnf("forwarding payment: %v", err)
+		r.resolve(p, nil)
+		return
+	}
+	r.expire(p, timeout)
+}
+
+// removed handles the removal of lock l from a channel by the peer. If it was
+// the outgoing lock of a payment, the payment is resolved.
+func (r *Router) removed(rc *routerChannel, l *hashlock.Lock, preimage *hashlock.Preimage) {
+	if l.Sender != rc.ch.Idx() {
+		return // incoming lock released after timeout
+	}
+	r.mtx.Lock()
+	p := r.payments[l.Hash]
+	r.mtx.Unlock()
+	if p != nil {
+		r.resolve(p, preimage)
+	}
+}
+
+// expire releases the outgoing lock of the payment after its timeout, unless
+// it was resolved before.
+func (r *Router) expire(p *payment, timeout time.Time) {
+	select {
+	case <-p.resolved:
+		return
+	case <-time.After(time.Until(timeout) + r.cfg.ClockTolerance):
+	}
+	r.mtx.Lock()
+	out := p.out
+	r.mtx.Unlock()
+	if err := r.release(out); err != nil {
+		r.log.Errorf("releasing expired lock: %v", err)
+		return
+	}
+	r.resolve(p, nil)
+}
+
+// resolve resolves the payment once its outgoing lock is removed. If it was
+// claimed, the preimage is used to claim the incoming lock. Otherwise, the
+// incoming lock is released.
+func (r *Router) resolve(p *payment, preimage *hashlock.Preimage) {
+	var first bool
+	p.resolveOnce.Do(func() {
+		close(p.resolved)
+		first = true
+	})
+	if !first {
+		return
+	}
+	defer r.deletePayment(p.hash)
+
+	r.mtx.Lock()
+	in := p.in
+	r.mtx.Unlock()
+	if p.done == nil && in == nil {
+		return
+	} else if p.done != nil {
+		if preimage == nil {
+			p.done <- errors.New("payment failed")
+		} else {
+			p.done <- nil
+		}
+		return
+	}
+	if preimage != nil {
+		if err := r.claim(in, *preimage); err != nil {
+			r.log.Errorf("claiming forwarded payment: %v", err)
+		}
+	} else if err := r.release(in); err != nil {
+		r.log.Errorf("releasing failed payment: %v", err)
+	}
+}
+
+// lock sends the route message m to the peer of rc and then locks amount of
+// the payment for them.
+func (r *Router) lock(ctx context.Context, rc *routerChannel, p *payment, m *msgRoute, amount *big.Int, timeout time.Time) error {
+	if err := r.c.SendMsg(ctx, rc.peer, m); err != nil {
+		return errors.WithMessage(err, "sending route message")
+	}
+	return r.update(ctx, rc, func(s *channel.State) error {
+		a, err := assetIdx(s.Assets, m.Asset)
+		if err != nil {
+			return err
+		}
+		amounts := make([]channel.Bal, len(s.Assets))
+		for b := range amounts {
+			amounts[b] = new(big.Int)
+		}
+		amounts[a].Set(amount)
+		id := nextLockID(s.Data.(*hashlock.Data))
+		r.mtx.Lock()
+		p.out = &lockRef{rc, id, timeout}
+		r.mtx.Unlock()
+		return hashlock.AddLock(s, hashlock.Lock{
+			ID:       id,
+			Sender:   rc.ch.Idx(),
+			Receiver: 1 - rc.ch.Idx(),
+			Amounts:  amounts,
+			Hash:     p.hash,
+			Timeout:  timeout,
+		})
+	})
+}
+
+// claim claims the lock with the preimage. As the payment is only atomic if
+// our incoming lock is claimed before its timeout, failed claims are retried
+// until then.
+func (r *Router) claim(l *lockRef, preimage hashlock.Preimage) (err error) {
+	ctx, cancel := context.WithDeadline(context.Background(), l.timeout)
+	defer cancel()
+	for {
+		if l.ch.ch.IsClosed() {
+			return errors.New("channel closed")
+		} else if l.ch.ch.State().Data.(*hashlock.Data).Lock(l.id) == nil {
+			return errors.Errorf("lock %d removed", l.id)
+		}
+		err = r.update(ctx, l.ch, func(s *channel.State) error {
+			return hashlock.Claim(s, l.id, preimage)
+		})
+		if err == nil {
+			return nil
+		}
+		r.log.Warnf("claiming lock %d, retrying: %v", l.id, err)
+		select {
+		case <-time.After(r.cfg.RetryDelay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// release releases the lock.
+func (r *Router) release(l *lockRef) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.cfg.UpdateTimeout)
+	defer cancel()
+	return r.update(ctx, l.ch, func(s *channel.State) error {
+		return hashlock.Release(s, l.id)
+	})
+}
+
+// update proposes the update of rc's current state by modify. The update is
+// retried if it fails, e.g., because it collided with an update of the peer.
+func (r *Router) update(ctx context.Context, rc *routerChannel, modify func(*channel.State) error) (err error) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+
+	for i := 0; i < r.cfg.UpdateAttempts; i++ {
+		if i > 0 {
+			select {
+			case <-time.After(r.cfg.RetryDelay):
+			case <-ctx.Done():
+				return err
+			}
+		}
+		state := rc.ch.State().Clone()
+		state.Data.(*hashlock.Data).Preimages = nil
+		hashlock.SetTime(state, time.Now())
+		if err := modify(state); err != nil {
+			return err
+		}
+		state.Version = rc.ch.NextVersion()
+		err = rc.ch.Update(ctx, client.ChannelUpdate{State: state, ActorIdx: rc.ch.Idx()})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// handleRouteMsg stores the route message of an incoming payment.
+func (r *Router) handleRouteMsg(from peer.Address, m msg.Msg) {
+	route, ok := m.(*msgRoute)
+	if !ok || len(route.Path) != len(route.Amounts) {
+		r.log.WithField("peer", from).Warn("invalid route message")
+		return
+	}
+	if r.channel(from) == nil {
+		r.log.WithField("peer", from).Warn("route message from peer without channel")
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.numRoutes(from) >= r.cfg.MaxRoutesPerPeer {
+		r.log.WithField("peer", from).Warn("too many route messages without lock, dropping")
+		return
+	}
+	p := r.entry(route.Hash)
+	if p.route != nil {
+		r.log.WithField("peer", from).Warn("duplicate route message")
+		return
+	}
+	p.route, p.from = route, from
+	close(p.routed)
+}
+
+// numRoutes returns the number of stored route messages from the peer whose
+// incoming lock is not set. The router must be locked by the caller.
+func (r *Router) numRoutes(from peer.Address) (n int) {
+	for _, p := range r.payments {
+		if p.route != nil && p.in == nil && p.from.Equals(from) {
+			n++
+		}
+	}
+	return n
+}
+
+// awaitRoute waits for the route message of the payment with the given hash.
+func (r *Router) awaitRoute(hash hashlock.Hash) (*payment, error) {
+	r.mtx.Lock()
+	p := r.entry(hash)
+	r.mtx.Unlock()
+	select {
+	case <-p.routed:
+		return p, nil
+	case <-time.After(r.cfg.RouteMsgTimeout):
+		return nil, errors.New("no route message received")
+	}
+}
+
+// newPayment adds a new payment of ours with the given hash.
+func (r *Router) newPayment(hash hashlock.Hash) (*payment, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.payments[hash]; ok {
+		return nil, errors.New("payment with this hash already in progress")
+	}
+	return r.entry(hash), nil
+}
+
+// entry returns the payment with the given hash, adding it if necessary. Added
+// payments are deleted after RouteExpiry if they don't get an incoming or
+// outgoing lock. The router must be locked by the caller.
+func (r *Router) entry(hash hashlock.Hash) *payment {
+	p, ok := r.payments[hash]
+	if ok {
+		return p
+	}
+	p = &payment{hash: hash, routed: make(chan struct{}), resolved: make(chan struct{})}
+	r.payments[hash] = p
+	time.AfterFunc(r.cfg.RouteExpiry, func() {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		if p.in == nil && p.out == nil && r.payments[hash] == p {
+			delete(r.payments, hash)
+		}
+	})
+	return p
+}
+
+func (r *Router) deletePayment(hash hashlock.Hash) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	delete(r.payments, hash)
+}
+
+func (r *Router) setIn(p *payment, in *lockRef) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	p.in = in
+}
+
+func (r *Router) invoice(hash hashlock.Hash) *invoice {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.invoices[hash]
+}
+
+func (r *Router) channel(addr peer.Address) *routerChannel {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.channels[addr.String()]
+}
+
+func (r *Router) channelByID(id channel.ID) *routerChannel {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, rc := range r.channels {
+		if rc.ch.ID() == id {
+			return rc
+		}
+	}
+	return nil
+}
+
+// nextLockID returns an unused lock ID.
+func nextLockID(data *hashlock.Data) (id uint64) {
+	for _, l := range data.Locks {
+		if l.ID >= id {
+			id = l.ID + 1
+		}
+	}
+	return id
+}
+
+func findPreimage(preimages []hashlock.Preimage, hash hashlock.Hash) (hashlock.Preimage, bool) {
+	for _, p := range preimages {
+		if hashlock.HashOf(p) == hash {
+			return p, true
+		}
+	}
+	return hashlock.Preimage{}, false
+}
+
+// assetIdx returns the index of the asset in assets.
+func assetIdx(assets []channel.Asset, asset channel.Asset) (int, error) {
+	var want bytes.Buffer
+	if err := asset.Encode(&want); err != nil {
+		return 0, errors.WithMessage(err, "encoding asset")
+	}
+	for i, a := range assets {
+		var buf bytes.Buffer
+		if err := a.Encode(&buf); err != nil {
+			return 0, errors.WithMessagef(err, "encoding asset %d", i)
+		}
+		if bytes.Equal(buf.Bytes(), want.Bytes()) {
+			return i, nil
+		}
+	}
+	return 0, errors.New("asset not in channel")
+}
+
+// equalAlloc returns whether the balances and sub-allocations of a and b are
+// equal.
+func equalAlloc(a, b *channel.Allocation) bool {
+	if len(a.OfParts) != len(b.OfParts) || len(a.Locked) != len(b.Locked) {
+		return false
+	}
+	for i := range a.OfParts {
+		if !equalBals(a.OfParts[i], b.OfParts[i]) {
+			return false
+		}
+	}
+	for i := range a.Locked {
+		if a.Locked[i].ID != b.Locked[i].ID || !equalBals(a.Locked[i].Bals, b.Locked[i].Bals) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalBals(a, b []channel.Bal) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Cmp(b[i]) != 0 {
+			return false
+		}
+	}
+	return true
+}
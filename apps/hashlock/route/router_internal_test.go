@@ -0,0 +1,43 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"perun.network/go-perun/apps/hashlock"
+	"perun.network/go-perun/log"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestRouter_handleRouteMsg(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x7047))
+	peerA, peerB := wallettest.NewRandomAddress(rng), wallettest.NewRandomAddress(rng)
+	r := &Router{
+		cfg:      DefaultConfig(),
+		log:      log.Get(),
+		channels: map[string]*routerChannel{peerA.String(): {peer: peerA}},
+		payments: make(map[hashlock.Hash]*payment),
+	}
+	r.cfg.MaxRoutesPerPeer = 2
+	route := func(hash byte) *msgRoute { return &msgRoute{Hash: hashlock.Hash{hash}} }
+
+	r.handleRouteMsg(peerB, route(1))
+	assert.Empty(t, r.payments, "peer without channel")
+
+	r.handleRouteMsg(peerA, route(1))
+	r.handleRouteMsg(peerA, route(2))
+	r.handleRouteMsg(peerA, route(3))
+	assert.Len(t, r.payments, 2, "route messages of peer capped")
+
+	// payments with an incoming lock do not count
+	r.payments[hashlock.Hash{1}].in = &lockRef{id: 1}
+	r.handleRouteMsg(peerA, route(3))
+	assert.Len(t, r.payments, 3)
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package route_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/hashlock"
+	"perun.network/go-perun/apps/hashlock/route"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+const timeout = 10 * time.Second
+
+// network is a line of nodes, each of which has a channel with the next node.
+type network struct {
+	routers []*route.Router
+	addrs   []peer.Address
+	// chs[i] are the channels of node i, with the previous and next node.
+	chs   [][]*client.Channel
+	asset channel.Asset
+}
+
+func TestRouter(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xB0B))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// A - B - C, B charges a base fee of 1
+	net, closeAll := setupNetwork(t, rng, 3, route.Fee{Base: big.NewInt(1)})
+	defer closeAll()
+	a, b, c := net.routers[0], net.routers[1], net.routers[2]
+	ab, bc := net.chs[0][0], net.chs[2][0]
+
+	t.Run("success", func(t *testing.T) {
+		hash, err := c.NewInvoice(big.NewInt(10))
+		require.NoError(t, err)
+		require.NoError(t, a.Pay(ctx, net.addrs[2], net.asset, big.NewInt(10), hash))
+
+		assertBals(t, ab, 89, 111) // 10 plus fee 1
+		assertBals(t, bc, 90, 110)
+	})
+
+	t.Run("unknown invoice", func(t *testing.T) {
+		hash := hashlock.HashOf(hashlock.Preimage{1})
+		assert.Error(t, a.Pay(ctx, net.addrs[2], net.asset, big.NewInt(10), hash))
+
+		// the locks are released
+		assertBals(t, ab, 89, 111)
+		assertBals(t, bc, 90, 110)
+	})
+
+	t.Run("insufficient funds", func(t *testing.T) {
+		hash, err := c.NewInvoice(big.NewInt(100))
+		require.NoError(t, err)
+		// B can only forward 90 to C
+		assert.Error(t, a.Pay(ctx, net.addrs[2], net.asset, big.NewInt(100), hash))
+		assertBals(t, ab, 89, 111)
+	})
+
+	t.Run("no path", func(t *testing.T) {
+		hash, err := a.NewInvoice(big.NewInt(1))
+		require.NoError(t, err)
+		assert.Error(t, b.Pay(ctx, wallettest.NewRandomAddress(rng), net.asset, big.NewInt(1), hash))
+	})
+}
+
+func TestFee(t *testing.T) {
+	assert.Zero(t, big.NewInt(0).Cmp(route.Fee{}.Of(big.NewInt(100))))
+	assert.Zero(t, big.NewInt(7).Cmp(route.Fee{Base: big.NewInt(2), Rate: 5000}.Of(big.NewInt(1000))))
+}
+
+// assertBals asserts the balances of the participants of the hashlock channel
+// ch and that there are no locks.
+func assertBals(t *testing.T, ch *client.Channel, bal0, bal1 int64) {
+	state := ch.State()
+	assert.Zero(t, big.NewInt(bal0).Cmp(state.OfParts[0][0]), "participant 0 has %v", state.OfParts[0][0])
+	assert.Zero(t, big.NewInt(bal1).Cmp(state.OfParts[1][0]), "participant 1 has %v", state.OfParts[1][0])
+	assert.Empty(t, state.Data.(*hashlock.Data).Locks)
+}
+
+// setupNetwork sets up a line of n nodes, in which every node has a channel
+// with the next node. Every node has a balance of 100 in each channel. All
+// nodes charge the given fee.
+func setupNetwork(t *testing.T, rng *rand.Rand, n int, fee route.Fee) (*network, func()) {
+	var hub peertest.ConnHub
+	net := &network{
+		routers: make([]*route.Router, n),
+		addrs:   make([]peer.Address, n),
+		chs:     make([][]*client.Channel, n),
+		asset:   channeltest.NewRandomAsset(rng),
+	}
+	graph := route.NewGraph()
+	clients := make([]*client.Client, n)
+	accepted := make(chan error, n)
+	for i := range clients {
+		i, id := i, wallettest.NewRandomAccount(rng)
+		accRng := rand.New(rand.NewSource(rng.Int63()))
+		net.addrs[i] = id.Address()
+		graph.SetFee(id.Address(), fee)
+		clients[i] = client.New(id, hub.NewDialer(), client.NewPolicyHandler(client.ProposalPolicy{},
+			func(*client.ChannelProposalReq) wallet.Account { return wallettest.NewRandomAccount(accRng) },
+			func(ch *client.Channel, err error) {
+				if err == nil {
+					net.chs[i] = append(net.chs[i], ch)
+					err = net.routers[i].AddChannel(ch)
+				}
+				accepted <- err
+			}), nopFunder{}, nopAdjudicator{})
+		clients[i].SetBackends(channel.Backends{App: hashlock.AppBackend()})
+		net.routers[i] = route.NewRouter(clients[i], id.Address(), graph, time.Second)
+		go clients[i].Listen(hub.NewListener(id.Address()))
+	}
+	closeAll := func() {
+		for _, c := range clients {
+			assert.NoError(t, c.Close())
+		}
+		assert.NoError(t, hub.Close())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for i := 0; i < n-1; i++ {
+		ch, err := clients[i].ProposeChannel(ctx, &client.ChannelProposal{
+			ChallengeDuration: 10,
+			Nonce:             big.NewInt(rng.Int63()),
+			Account:           wallettest.NewRandomAccount(rng),
+			AppDef:            hashlock.AppDef(),
+			InitData:          new(hashlock.Data),
+			InitBals: &channel.Allocation{
+				Assets:  []channel.Asset{net.asset},
+				OfParts: [][]channel.Bal{{big.NewInt(100)}, {big.NewInt(100)}},
+			},
+			PeerAddrs: []peer.Address{net.addrs[i], net.addrs[i+1]},
+		})
+		require.NoError(t, err)
+		require.NoError(t, <-accepted)
+		net.chs[i] = append(net.chs[i], ch)
+		require.NoError(t, net.routers[i].AddChannel(ch))
+	}
+	return net, closeAll
+}
+
+type (
+	nopFunder      struct{}
+	nopAdjudicator struct{}
+)
+
+func (nopFunder) Fund(context.Context, channel.FundingReq) error { return nil }
+
+func (nopAdjudicator) Register(_ context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	return &channel.Registered{ID: req.Params.ID(), Idx: req.Idx, Version: req.Tx.Version, Timeout: time.Now()}, nil
+}
+
+func (nopAdjudicator) Withdraw(context.Context, channel.AdjudicatorReq) error { return nil }
+
+func (nopAdjudicator) SubscribeRegistered(ctx context.Context, _ *channel.Params) (channel.RegisteredSubscription, error) {
+	return channeltest.NewIdleRegisteredSub(ctx), nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package hashlock
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// The following functions modify a hashlock channel state in place. They are
+// meant to be applied to a clone of the current state to create the next
+// state, which has to be proposed by the sender of the lock for AddLock and by
+// any participant for Claim and Release. A participant other than the
+// receiver can only release a lock once the time of the state, see SetTime,
+// reached its timeout.
+
+// SetTime sets the time of the state to t, unless the state's time is later.
+// The proposer of an update should set it to their current time.
+func SetTime(s *channel.State, t time.Time) {
+	if data := assertData(s); t.After(data.Time) {
+		data.Time = t
+	}
+}
+
+// AddLock adds the lock l to the state. Its amounts are moved from the
+// sender's balances into the locked sub-allocation of the lock, so the
+// sender's balances must cover them.
+func AddLock(s *channel.State, l Lock) error {
+	data := assertData(s)
+	if data.Lock(l.ID) != nil {
+		return errors.Errorf("duplicate lock %d", l.ID)
+	} else if len(l.Amounts) != len(s.Assets) {
+		return errors.Errorf("lock has %d amounts, expected %d", len(l.Amounts), len(s.Assets))
+	} else if int(l.Sender) >= len(s.OfParts) {
+		return errors.Errorf("invalid sender %d", l.Sender)
+	}
+	bals := cloneAllBals(s.OfParts)
+	if err := lock(bals, &l); err != nil {
+		return err
+	}
+	s.OfParts = bals
+	s.Locked = append(s.Locked, channel.SubAlloc{ID: AllocID(l.ID), Bals: cloneBals(l.Amounts)})
+	data.Locks = append(data.Locks, l.Clone())
+	return nil
+}
+
+// Claim removes the lock with the given ID from the state, pays its amounts to
+// the receiver and reveals the preimage p.
+func Claim(s *channel.State, id uint64, p Preimage) error {
+	data := assertData(s)
+	l := data.Lock(id)
+	if l == nil {
+		return errors.Errorf("unknown lock %d", id)
+	} else if HashOf(p) != l.Hash {
+		return errors.Errorf("wrong preimage for lock %d", id)
+	}
+	data.Preimages = append(data.Preimages, p)
+	return remove(s, id, l.Receiver)
+}
+
+// Release removes the lock with the given ID from the state and pays its
+// amounts back to the sender.
+func Release(s *channel.State, id uint64) error {
+	l := assertData(s).Lock(id)
+	if l == nil {
+		return errors.Errorf("unknown lock %d", id)
+	}
+	return remove(s, id, l.Sender)
+}
+
+// remove removes the lock with the given ID and its locked sub-allocation from
+// the state and pays its amounts to the participant with index to.
+func remove(s *channel.State, id uint64, to channel.Index) error {
+	data := assertData(s)
+	idx := allocIdx(s.Locked, AllocID(id))
+	if idx < 0 {
+		return errors.Errorf("no locked sub-allocation of lock %d", id)
+	}
+	for i := range data.Locks {
+		if data.Locks[i].ID == id {
+			unlock(s.OfParts, &data.Locks[i], to)
+			data.Locks = append(data.Locks[:i], data.Locks[i+1:]...)
+			break
+		}
+	}
+	if len(data.Locks) == 0 {
+		data.Locks = nil
+	}
+	s.Locked = append(s.Locked[:idx], s.Locked[idx+1:]...)
+	if len(s.Locked) == 0 {
+		s.Locked = nil
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ package payment // import "perun.network/go-perun/apps/payment"
 
 import (
 	"io"
+	"math/big"
 
 	"github.com/pkg/errors"
 
@@ -32,9 +33,13 @@ func (a *App) DecodeData(io.Reader) (channel.Data, error) {
 }
 
 // ValidTransition checks that money flows only from the actor to the other
-// participants.
+// participants. If the locked sub-allocations change, sub-channels are funded
+// or settled instead, see validLockChange.
 func (a *App) ValidTransition(_ *channel.Params, from, to *channel.State, actor channel.Index) error {
 	assertNoData(to)
+	if !equalLocked(from.Locked, to.Locked) {
+		return validLockChange(from, to)
+	}
 
 	for i, bals := range from.OfParts {
 		for j, bal := range bals {
@@ -55,6 +60,90 @@ func (a *App) ValidInit(_ *channel.Params, s *channel.State) error {
 	return nil
 }
 
+// validLockChange checks a transition that funds or settles sub-channels. It
+// either adds new locked sub-allocations or removes existing ones, while all
+// other sub-allocations stay unchanged. When funding, the participants' balances
+// may only decrease and the decreases of each asset must sum up to exactly the
+// newly locked amount, so that every participant pays their share of the
+// sub-channels. When settling, the balances may only increase by exactly the
+// unlocked amount. How the amount is split among the participants is agreed on
+// by the participants of the sub-channels, as the sub-allocations don't record
+// the participants' shares.
+func validLockChange(from, to *channel.State) error {
+	added, removed := lockDiff(from.Locked, to.Locked), lockDiff(to.Locked, from.Locked)
+	if len(added) > 0 && len(removed) > 0 {
+		return errors.New("sub-channels must not be funded and settled at the same time")
+	} else if len(added) == 0 && len(removed) == 0 {
+		return errors.New("locked sub-allocations must not be reordered")
+	}
+	if len(added) > 0 {
+		return errors.WithMessage(validLocking(from, to, added), "funding sub-channels")
+	}
+	// settling is funding in reverse
+	return errors.WithMessage(validLocking(to, from, removed), "settling sub-channels")
+}
+
+// validLocking checks that going from the balances of unlocked to the balances
+// of locked, exactly the newly locked sub-allocations are paid by decreasing
+// the participants' balances.
+func validLocking(unlocked, locked *channel.State, newLocked []channel.SubAlloc) error {
+	for j := range newLocked[0].Bals {
+		paid := new(big.Int)
+		for i, bals := range unlocked.OfParts {
+			dec := new(big.Int).Sub(bals[j], locked.OfParts[i][j])
+			if dec.Sign() < 0 {
+				return errors.Errorf("participant[%d] receives asset %d", i, j)
+			}
+			paid.Add(paid, dec)
+		}
+		amount := new(big.Int)
+		for _, sa := range newLocked {
+			amount.Add(amount, sa.Bals[j])
+		}
+		if paid.Cmp(amount) != 0 {
+			return errors.Errorf("participants pay %v of asset %d for locked amount %v", paid, j, amount)
+		}
+	}
+	return nil
+}
+
+// lockDiff returns the sub-allocations of b whose ID is not in a. Sub-
+// allocations whose ID is in both a and b but which differ are also returned.
+func lockDiff(a, b []channel.SubAlloc) []channel.SubAlloc {
+	var diff []channel.SubAlloc
+	for _, sb := range b {
+		found := false
+		for _, sa := range a {
+			if sa.ID == sb.ID && equalLocked([]channel.SubAlloc{sa}, []channel.SubAlloc{sb}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			diff = append(diff, sb)
+		}
+	}
+	return diff
+}
+
+// equalLocked returns whether the sub-allocations a and b are equal.
+func equalLocked(a, b []channel.SubAlloc) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID || len(a[i].Bals) != len(b[i].Bals) {
+			return false
+		}
+		for j, bal := range a[i].Bals {
+			if bal.Cmp(b[i].Bals[j]) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func assertNoData(s *channel.State) {
 	_, ok := s.Data.(*NoData)
 	if !ok {
@@ -102,6 +102,39 @@ func TestApp_ValidTransition(t *testing.T) {
 		assert.Panics(t, func() { app.ValidTransition(nil, from, to, 0) })
 	})
 
+	t.Run("locked", func(t *testing.T) {
+		// funding a sub-channel reduces the balances of all participants
+		from := newStateWithAlloc(alloc{{10, 0}, {5, 20}})
+		to := newStateWithAlloc(alloc{{5, 0}, {5, 10}})
+		to.Locked = []channel.SubAlloc{{ID: channel.ID{1}, Bals: []channel.Bal{big.NewInt(5), big.NewInt(10)}}}
+		assert.Nil(t, app.ValidTransition(nil, from, to, 0))
+
+		// unchanged locked sub-allocations are checked as usual
+		to2 := newStateWithAlloc(alloc{{5, 5}, {0, 5}})
+		to2.Locked = to.Locked
+		assert.NotNil(t, app.ValidTransition(nil, to, to2, 0))
+
+		// settling a sub-channel increases the balances by the unlocked amount
+		assert.Nil(t, app.ValidTransition(nil, to, newStateWithAlloc(alloc{{8, 4}, {7, 16}}), 1))
+		assert.NotNil(t, app.ValidTransition(nil, to, newStateWithAlloc(alloc{{11, 0}, {4, 20}}), 1),
+			"settlement must not decrease balances")
+
+		// a lock must not shift funds to the proposer
+		shifted := newStateWithAlloc(alloc{{5, 5}, {5, 5}})
+		shifted.Locked = to.Locked
+		assert.NotNil(t, app.ValidTransition(nil, from, shifted, 0))
+
+		// the locked amount must be paid exactly
+		overpaid := newStateWithAlloc(alloc{{4, 0}, {5, 10}})
+		overpaid.Locked = to.Locked
+		assert.NotNil(t, app.ValidTransition(nil, from, overpaid, 0))
+
+		// sub-channels must not be funded and settled at once
+		swapped := to.Clone()
+		swapped.Locked[0].ID = channel.ID{2}
+		assert.NotNil(t, app.ValidTransition(nil, to, swapped, 0))
+	})
+
 	// Note: we don't need to test other invalid input as the framework guarantees
 	// to pass valid input.
 }
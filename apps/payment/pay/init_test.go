@@ -0,0 +1,25 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package pay_test
+
+import (
+	"math/rand"
+
+	"github.com/sirupsen/logrus"
+
+	"perun.network/go-perun/apps/payment"
+	_ "perun.network/go-perun/backend/sim" // backend init
+	plogrus "perun.network/go-perun/log/logrus"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+// This file initializes the blockchain and logging backend for the tests.
+func init() {
+	plogrus.Set(logrus.WarnLevel, &logrus.TextFormatter{ForceColors: true})
+
+	rng := rand.New(rand.NewSource(0x3b6c0f1d))
+	payment.SetAppDef(wallettest.NewRandomAddress(rng)) // payment app address has to be set once at startup
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package pay implements payments in two-party channels of the payment app
+// on top of the channel controller of package client.
+package pay // import "perun.network/go-perun/apps/payment/pay"
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+)
+
+// responseTimeout is the timeout of the AcceptHandler for responding to an
+// update.
+const responseTimeout = 10 * time.Second
+
+// Send pays amount of the given asset to the other participant of the
+// two-party payment channel ch. It returns once the other participant accepted
+// the payment.
+func Send(ctx context.Context, ch *client.Channel, asset channel.Asset, amount *big.Int) error {
+	return transfer(ctx, ch, ch.Idx(), asset, amount)
+}
+
+// Request requests amount of the given asset from the other participant of the
+// two-party payment channel ch. The update is proposed as a request with the
+// other participant as actor, who pays if they accept it, e.g., by their
+// AcceptHandler. Request returns once the payment is accepted.
+func Request(ctx context.Context, ch *client.Channel, asset channel.Asset, amount *big.Int) error {
+	return transfer(ctx, ch, 1-ch.Idx(), asset, amount)
+}
+
+// transfer proposes an update of ch in which the participant with index from
+// pays amount of the asset to the other participant.
+func transfer(
+	ctx context.Context,
+	ch *client.Channel,
+	from channel.Index,
+	asset channel.Asset,
+	amount *big.Int,
+) error {
+	if len(ch.Params().Parts) != 2 {
+		return errors.New("payments are only possible in two-party channels")
+	} else if !ch.Params().App.Def().Equals(payment.AppDef()) {
+		return errors.New("not a payment channel")
+	} else if amount.Sign() <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	state := ch.State().Clone()
+	a, err := assetIdx(state.Assets, asset)
+	if err != nil {
+		return err
+	}
+	bal := state.OfParts[from][a]
+	if bal.Cmp(amount) < 0 {
+		return errors.Errorf("insufficient balance of participant[%d]: %v < %v", from, bal, amount)
+	}
+	bal.Sub(bal, amount)
+	state.OfParts[1-from][a].Add(state.OfParts[1-from][a], amount)
+	state.Version = ch.NextVersion()
+
+	return ch.Update(ctx, client.ChannelUpdate{State: state, ActorIdx: from, Request: from != ch.Idx()})
+}
+
+// assetIdx returns the index of the asset in assets.
+func assetIdx(assets []channel.Asset, asset channel.Asset) (int, error) {
+	var want bytes.Buffer
+	if err := asset.Encode(&want); err != nil {
+		return 0, errors.WithMessage(err, "encoding asset")
+	}
+	for i, a := range assets {
+		var buf bytes.Buffer
+		if err := a.Encode(&buf); err != nil {
+			return 0, errors.WithMessagef(err, "encoding asset %d", i)
+		}
+		if bytes.Equal(buf.Bytes(), want.Bytes()) {
+			return i, nil
+		}
+	}
+	return 0, errors.New("asset not in channel")
+}
+
+// AcceptHandler returns an UpdateHandler for payment channels that accepts all
+// incoming payments and the payment requests of the other participant, as
+// long as the total amount of asset a that we pay in a channel stays within
+// limit[a]. A nil limit means no limit. All other updates are rejected.
+func AcceptHandler(limit []*big.Int) client.UpdateHandler {
+	return client.ChainUpdateHandler(client.UpdateHandlerFunc(acceptRequest),
+		client.AcceptPayments(), client.SpendingLimit(limit))
+}
+
+// acceptRequest accepts the update if it is a payment request, i.e., it was
+// requested by the peer with us as actor, who pays. The payment app ensures
+// that only our balances decrease.
+func acceptRequest(up client.ChannelUpdate, res *client.UpdateResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), responseTimeout)
+	defer cancel()
+	if !up.Request || up.ActorIdx != res.Idx() || up.State.IsFinal {
+		res.Reject(ctx, "not a payment") // errors are logged by the channel
+		return
+	}
+	res.Accept(ctx) // errors are logged by the channel
+}
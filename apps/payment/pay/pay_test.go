@@ -0,0 +1,125 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package pay_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/apps/payment/pay"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+const timeout = 5 * time.Second
+
+func TestSendRequest(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xA7))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The peer spends at most 10 of the first asset.
+	limit := []*big.Int{big.NewInt(10)}
+	ch, peerCh, asset, closeAll := openChannel(t, rng, limit)
+	defer closeAll()
+	bals := ch.State().Clone().OfParts
+
+	require.NoError(t, pay.Send(ctx, ch, asset, big.NewInt(3)))
+	require.NoError(t, pay.Request(ctx, ch, asset, big.NewInt(7)))
+	assert.Error(t, pay.Request(ctx, ch, asset, big.NewInt(5)), "spending limit exceeded")
+	assert.Error(t, pay.Send(ctx, ch, asset, new(big.Int).Add(bals[0][0], big.NewInt(5))),
+		"insufficient balance")
+	assert.Error(t, pay.Send(ctx, ch, channeltest.NewRandomAsset(rng), big.NewInt(1)), "unknown asset")
+	assert.Error(t, pay.Send(ctx, ch, asset, big.NewInt(0)), "zero amount")
+
+	// we paid 3 and received 7
+	state := ch.State()
+	assert.Zero(t, new(big.Int).Add(bals[0][0], big.NewInt(4)).Cmp(state.OfParts[0][0]))
+	assert.Zero(t, new(big.Int).Sub(bals[1][0], big.NewInt(4)).Cmp(state.OfParts[1][0]))
+	assert.Equal(t, state.Version, peerCh.State().Version)
+}
+
+// openChannel opens a payment channel between two clients. The peer's
+// channel is handled by an AcceptHandler with the given limit. The asset of
+// the first balance is returned.
+func openChannel(t *testing.T, rng *rand.Rand, limit []*big.Int) (
+	ch, peerCh *client.Channel, asset channel.Asset, closeAll func(),
+) {
+	var hub peertest.ConnHub
+	ids := []peer.Identity{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	peerChs, errs := make(chan *client.Channel, 1), make(chan error, 1)
+	clients := make([]*client.Client, 2)
+	for i, id := range ids {
+		accRng := rand.New(rand.NewSource(int64(i)))
+		clients[i] = client.New(id, hub.NewDialer(), client.NewPolicyHandler(client.ProposalPolicy{},
+			func(*client.ChannelProposalReq) wallet.Account { return wallettest.NewRandomAccount(accRng) },
+			func(ch *client.Channel, err error) {
+				if err != nil {
+					errs <- err
+					return
+				}
+				go ch.ListenUpdates(pay.AcceptHandler(limit))
+				peerChs <- ch
+			}), nopFunder{}, nopAdjudicator{})
+		go clients[i].Listen(hub.NewListener(id.Address()))
+	}
+	closeAll = func() {
+		for _, c := range clients {
+			assert.NoError(t, c.Close())
+		}
+		assert.NoError(t, hub.Close())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	bals := channeltest.NewRandomAllocation(rng, 2)
+	bals.Locked = nil
+	ch, err := clients[0].ProposeChannel(ctx, &client.ChannelProposal{
+		ChallengeDuration: 10,
+		Nonce:             big.NewInt(rng.Int63()),
+		Account:           wallettest.NewRandomAccount(rng),
+		AppDef:            payment.AppDef(),
+		InitData:          new(payment.NoData),
+		InitBals:          bals,
+		PeerAddrs:         []peer.Address{ids[0].Address(), ids[1].Address()},
+	})
+	require.NoError(t, err)
+	select {
+	case peerCh = <-peerChs:
+	case err := <-errs:
+		t.Fatalf("peer failed to accept: %v", err)
+	}
+	return ch, peerCh, bals.Assets[0], closeAll
+}
+
+type (
+	nopFunder      struct{}
+	nopAdjudicator struct{}
+)
+
+func (nopFunder) Fund(context.Context, channel.FundingReq) error { return nil }
+
+func (nopAdjudicator) Register(_ context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	return &channel.Registered{ID: req.Params.ID(), Idx: req.Idx, Version: req.Tx.Version, Timeout: time.Now()}, nil
+}
+
+func (nopAdjudicator) Withdraw(context.Context, channel.AdjudicatorReq) error { return nil }
+
+func (nopAdjudicator) SubscribeRegistered(ctx context.Context, _ *channel.Params) (channel.RegisteredSubscription, error) {
+	return channeltest.NewIdleRegisteredSub(ctx), nil
+}
@@ -9,7 +9,12 @@ package adjudicator
 //go:generate echo -e "\\e[01;31mMake sure you cloned the contracts submodule (git submodule update --init)\\e[0m"
 //go:generate solc --bin-runtime --optimize ../contracts/contracts/Adjudicator.sol --overwrite -o ./
 //go:generate solc --bin-runtime --optimize ../contracts/contracts/AssetHolderETH.sol --overwrite -o ./
+//go:generate solc --bin-runtime --optimize ../contracts/contracts/AssetHolderERC20.sol --overwrite -o ./
+//go:generate solc --bin-runtime --optimize ../contracts/contracts/PerunToken.sol --overwrite -o ./
 //go:generate abigen --pkg adjudicator --sol ../contracts/contracts/Adjudicator.sol --out adjudicator/Adjudicator.go
 //go:generate abigen --pkg assets --sol ../contracts/contracts/AssetHolderETH.sol --out assets/AssetHolderETH.go
+//go:generate abigen --pkg erc20 --sol ../contracts/vendor/openzeppelin-contracts/contracts/token/ERC20/IERC20.sol --out erc20/IERC20.go
+//go:generate abigen --pkg assetholdererc20 --sol ../contracts/contracts/AssetHolderERC20.sol --out assetholdererc20/AssetHolderERC20.go
+//go:generate abigen --pkg peruntoken --sol ../contracts/contracts/PerunToken.sol --out peruntoken/PerunToken.go
 //go:generate abigen --version
 //go:generate echo -e "\\e[01;31mGenerated bindings\\e[0m"
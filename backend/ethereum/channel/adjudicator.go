@@ -0,0 +1,425 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/backend/ethereum/bindings/adjudicator"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+)
+
+// phaseDispute is the dispute phase of states that were registered or refuted
+// with the Adjudicator contract.
+const phaseDispute = uint8(0)
+
+// compile time check that we implement the perun adjudicator interface
+var _ channel.Adjudicator = (*Adjudicator)(nil)
+
+// Adjudicator implements the channel.Adjudicator interface for Ethereum.
+type Adjudicator struct {
+	ContractBackend
+	contract *adjudicator.Adjudicator
+	abi      abi.ABI
+	log      log.Logger // structured logger
+
+	mu        sync.Mutex
+	regStart  map[channel.ID]uint64                   // first block to filter for registrations
+	concStart map[channel.ID]uint64                   // first block to filter for conclusions
+	states    map[channel.ID]adjudicator.ChannelState // states that we registered last
+}
+
+// registration is a state registration on the Adjudicator contract, i.e., a
+// Registered or Refuted event.
+type registration struct {
+	channel.Registered
+	timeout *big.Int    // on-chain timeout, needed for refuting and concluding
+	txHash  common.Hash // hash of the register or refute transaction
+}
+
+// NewAdjudicator creates a new ethereum adjudicator for the Adjudicator
+// contract at the given address. Withdrawn funds are sent to the on-chain
+// account of the backend.
+func NewAdjudicator(backend ContractBackend, contract common.Address) *Adjudicator {
+	contr, err := adjudicator.NewAdjudicator(contract, backend)
+	if err != nil {
+		log.Panicf("binding adjudicator contract: %v", err)
+	}
+	parsed, err := abi.JSON(strings.NewReader(adjudicator.AdjudicatorABI))
+	if err != nil {
+		log.Panicf("parsing adjudicator ABI: %v", err)
+	}
+	return &Adjudicator{
+		ContractBackend: backend,
+		contract:        contr,
+		abi:             parsed,
+		log:             log.WithField("account", backend.account.Address),
+		regStart:        make(map[channel.ID]uint64),
+		concStart:       make(map[channel.ID]uint64),
+		states:          make(map[channel.ID]adjudicator.ChannelState),
+	}
+}
+
+// errSubChannels is returned for requests with sub-channels.
+var errSubChannels = errors.New("sub-channels are not supported by the adjudicator contract")
+
+// Register registers the state of the request on-chain. If an older state is
+// already registered, it is refuted. If the same or a newer state is already
+// registered, that registration is returned.
+//
+// Final states are not registered, but concluded on-chain directly. The
+// returned registration of a final state is marked as Concluded and has no
+// timeout. If the channel was already concluded with a registered state, that
+// registration is returned, marked as Concluded.
+//
+// The deployed contracts cannot pay out sub-allocations, so an error is
+// returned if the request contains sub-channels.
+func (a *Adjudicator) Register(ctx context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	if len(req.SubChannels) > 0 {
+		return nil, errSubChannels
+	} else if req.Tx.IsFinal {
+		return a.registerFinal(ctx, req)
+	}
+
+	reg, err := a.latestRegistration(ctx, req.Params)
+	if err != nil {
+		return nil, err
+	} else if reg != nil && reg.Version >= req.Tx.Version {
+		return &reg.Registered, nil
+	}
+
+	if err := a.registerOrRefute(ctx, req, reg); err != nil {
+		// A peer might have registered concurrently.
+		if reg, lerr := a.latestRegistration(ctx, req.Params); lerr == nil && reg != nil && reg.Version >= req.Tx.Version {
+			return &reg.Registered, nil
+		}
+		return nil, err
+	}
+
+	if reg, err = a.latestRegistration(ctx, req.Params); err != nil {
+		return nil, err
+	} else if reg == nil {
+		return nil, errors.New("no registration found after registering")
+	}
+	reg.Idx = req.Idx
+	return &reg.Registered, nil
+}
+
+// registerFinal concludes the final state of the request on-chain, unless the
+// channel is already concluded, and returns the conclusion as registration.
+func (a *Adjudicator) registerFinal(ctx context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	if err := a.ensureConcluded(ctx, req); err != nil {
+		return nil, errors.WithMessage(err, "concluding final state")
+	}
+	concluded, final, err := a.isConcluded(ctx, req.Params.ID())
+	if err != nil {
+		return nil, err
+	} else if !concluded {
+		return nil, errors.New("no conclusion found after concluding")
+	} else if final {
+		return &channel.Registered{ID: req.Params.ID(), Idx: req.Idx, Version: req.Tx.Version, Concluded: true}, nil
+	}
+
+	reg, err := a.latestRegistration(ctx, req.Params)
+	if err != nil {
+		return nil, err
+	} else if reg == nil {
+		return nil, errors.New("channel concluded without registration")
+	}
+	reg.Concluded = true
+	return &reg.Registered, nil
+}
+
+// registerOrRefute registers the state of the request or, if there is an older
+// registration reg, refutes it.
+func (a *Adjudicator) registerOrRefute(ctx context.Context, req channel.AdjudicatorReq, reg *registration) error {
+	params := channelParamsToEthParams(req.Params)
+	state := channelStateToEthState(req.Tx.State)
+	var old adjudicator.ChannelState
+	if reg != nil {
+		var err error
+		if old, err = a.registeredState(ctx, req.Params, reg); err != nil {
+			return errors.WithMessage(err, "retrieving registered state")
+		}
+	}
+
+	tx, err := a.transact(ctx, big.NewInt(0), 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		if reg == nil {
+			a.log.WithField("channel", req.Params.ID()).Debugf("Registering version %d.", req.Tx.Version)
+			return a.contract.Register(auth, params, state, req.Tx.Sigs)
+		}
+		a.log.WithField("channel", req.Params.ID()).Debugf(
+			"Refuting version %d with version %d.", reg.Version, req.Tx.Version)
+		return a.contract.Refute(auth, params, old, reg.timeout, state, req.Tx.Sigs)
+	})
+	if err != nil {
+		return err
+	}
+	if err := execSuccessful(ctx, a.ContractBackend, tx); err != nil {
+		return errors.WithMessage(err, "mining transaction")
+	}
+	a.setOwnState(req.Params.ID(), state)
+	return nil
+}
+
+// latestRegistration returns the registration of the channel with the highest
+// version or nil if the channel is not registered. Only the blocks since the
+// first registration of the channel are filtered, or, if it was not
+// registered before, the blocks since the last query.
+func (a *Adjudicator) latestRegistration(ctx context.Context, params *channel.Params) (*registration, error) {
+	id := [][32]byte{params.ID()}
+	opts, err := a.filterOpts(ctx, a.regStart, params.ID())
+	if err != nil {
+		return nil, err
+	}
+	var latest *types.Log
+	var version uint64
+	first := *opts.End + 1 // block of the first registration
+
+	registered, err := a.contract.FilterRegistered(opts, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "filtering Registered events")
+	}
+	defer registered.Close()
+	for registered.Next() {
+		if ev := registered.Event; latest == nil || ev.Version.Uint64() > version {
+			latest, version = &ev.Raw, ev.Version.Uint64()
+		}
+		first = minBlock(first, registered.Event.Raw.BlockNumber)
+	}
+	if err := registered.Error(); err != nil {
+		return nil, errors.Wrap(err, "iterating Registered events")
+	}
+
+	refuted, err := a.contract.FilterRefuted(opts, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "filtering Refuted events")
+	}
+	defer refuted.Close()
+	for refuted.Next() {
+		if ev := refuted.Event; latest == nil || ev.Version.Uint64() > version {
+			latest, version = &ev.Raw, ev.Version.Uint64()
+		}
+		first = minBlock(first, refuted.Event.Raw.BlockNumber)
+	}
+	if err := refuted.Error(); err != nil {
+		return nil, errors.Wrap(err, "iterating Refuted events")
+	}
+	a.setFilterStart(a.regStart, params.ID(), first)
+
+	if latest == nil {
+		return nil, nil
+	}
+	return a.newRegistration(ctx, params, version, *latest)
+}
+
+// filterOpts returns the options to filter the events of the channel with the
+// given ID from the start block in starts up to the latest block. The first
+// query of a channel starts at the first block.
+func (a *Adjudicator) filterOpts(ctx context.Context, starts map[channel.ID]uint64, id channel.ID) (*bind.FilterOpts, error) {
+	latest, err := a.BlockByNumber(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving latest block")
+	}
+	end := latest.NumberU64()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	start, ok := starts[id]
+	if !ok {
+		start = 1
+	}
+	return &bind.FilterOpts{Start: start, End: &end, Context: ctx}, nil
+}
+
+// setFilterStart sets the block from which the events of the channel with the
+// given ID are filtered in the future.
+func (a *Adjudicator) setFilterStart(starts map[channel.ID]uint64, id channel.ID, block uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	starts[id] = block
+}
+
+func minBlock(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// newRegistration creates the registration of the given version from the log
+// of its Registered or Refuted event. The timeout is calculated the same way
+// as by the contract, i.e., by adding the challenge duration to the timestamp
+// of the block.
+func (a *Adjudicator) newRegistration(ctx context.Context, params *channel.Params, version uint64, raw types.Log) (*registration, error) {
+	block, err := a.BlockByNumber(ctx, new(big.Int).SetUint64(raw.BlockNumber))
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving block %d", raw.BlockNumber)
+	}
+	timeout := new(big.Int).SetUint64(block.Time() + params.ChallengeDuration)
+	return &registration{
+		Registered: channel.Registered{
+			ID:      params.ID(),
+			Version: version,
+			Timeout: time.Unix(timeout.Int64(), 0),
+		},
+		timeout: timeout,
+		txHash:  raw.TxHash,
+	}, nil
+}
+
+// registeredState returns the state of the registration reg. The contract
+// only stores the hash of the dispute, i.e., of the registered state together
+// with its timeout and dispute phase, so candidate states are checked against
+// the stored hash. The candidates are the state that we registered last and
+// the states of all register, refute and progress calls that are contained in
+// the input of the registering transaction. Thereby, registrations through
+// proxies or contract wallets that forward the call are found as well.
+func (a *Adjudicator) registeredState(ctx context.Context, params *channel.Params, reg *registration) (state adjudicator.ChannelState, err error) {
+	stored, err := a.contract.Disputes(&bind.CallOpts{Context: ctx}, params.ID())
+	if err != nil {
+		return state, errors.Wrap(err, "querying dispute")
+	}
+
+	candidates := make([]adjudicator.ChannelState, 0, 1)
+	if own, ok := a.ownState(params.ID()); ok {
+		candidates = append(candidates, own)
+	}
+	tx, _, err := a.TransactionByHash(ctx, reg.txHash)
+	if err != nil {
+		return state, errors.Wrap(err, "retrieving transaction")
+	}
+	candidates = append(candidates, a.calldataStates(tx.Data())...)
+
+	for _, cand := range candidates {
+		if cand.Version != reg.Version {
+			continue
+		}
+		if hash, err := a.hashDispute(cand, reg.timeout); err != nil {
+			return state, err
+		} else if hash == stored {
+			return cand, nil
+		}
+	}
+	return state, errors.Errorf("registered state of version %d not found", reg.Version)
+}
+
+// calldataStates returns the states of all register, refute and progress calls
+// that are contained in the calldata, also if they are embedded in the
+// calldata of another call. Embedded calls that cannot be decoded are skipped.
+func (a *Adjudicator) calldataStates(data []byte) (states []adjudicator.ChannelState) {
+	for _, name := range []string{"register", "refute", "progress"} {
+		method := a.abi.Methods[name]
+		stateIdx := -1
+		for i, in := range method.Inputs {
+			if in.Name == "state" {
+				stateIdx = i
+			}
+		}
+		if stateIdx < 0 {
+			continue
+		}
+		for off := bytes.Index(data, method.Id()); off >= 0; {
+			if args, err := method.Inputs.UnpackValues(data[off+4:]); err == nil {
+				var state adjudicator.ChannelState
+				if err := copyTuple(&state, args[stateIdx]); err == nil {
+					states = append(states, state)
+				}
+			}
+			next := bytes.Index(data[off+1:], method.Id())
+			if next < 0 {
+				break
+			}
+			off += next + 1
+		}
+	}
+	return states
+}
+
+// hashDispute hashes the state with its timeout and the dispute phase like the
+// Adjudicator contract, which stores this hash for the registered state.
+func (a *Adjudicator) hashDispute(state adjudicator.ChannelState, timeout *big.Int) (common.Hash, error) {
+	args := abi.Arguments{
+		{Type: a.abi.Methods["register"].Inputs[1].Type},
+		{Type: abiUint256},
+		{Type: abiUint256},
+	}
+	enc, err := args.Pack(state, timeout, big.NewInt(int64(phaseDispute)))
+	if err != nil {
+		return common.Hash{}, errors.Wrap(err, "packing dispute")
+	}
+	return crypto.Keccak256Hash(enc), nil
+}
+
+// ownState returns the state that we registered last for the channel with the
+// given ID.
+func (a *Adjudicator) ownState(id channel.ID) (adjudicator.ChannelState, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	state, ok := a.states[id]
+	return state, ok
+}
+
+// setOwnState sets the state that we registered last for the channel with the
+// given ID.
+func (a *Adjudicator) setOwnState(id channel.ID, state adjudicator.ChannelState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.states[id] = state
+}
+
+// copyTuple copies the tuple src, as unpacked by the abi package, to dst, which
+// must be a pointer to a struct with the same field names. The abi package
+// unpacks tuples into anonymous structs, which cannot be converted to the
+// struct types of the bindings directly.
+func copyTuple(dst interface{}, src interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("copying tuple: %v", r)
+		}
+	}()
+	copyValue(reflect.ValueOf(dst).Elem(), reflect.ValueOf(src))
+	return nil
+}
+
+// copyValue copies src to dst recursively. It panics if the types don't match.
+func copyValue(dst, src reflect.Value) {
+	if src.Type().AssignableTo(dst.Type()) {
+		dst.Set(src)
+		return
+	}
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			field := src.FieldByName(dst.Type().Field(i).Name)
+			if !field.IsValid() {
+				panic("missing field " + dst.Type().Field(i).Name)
+			}
+			copyValue(dst.Field(i), field)
+		}
+	case reflect.Slice:
+		dst.Set(reflect.MakeSlice(dst.Type(), src.Len(), src.Len()))
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	default:
+		dst.Set(src.Convert(dst.Type()))
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/backend/ethereum/bindings/assets"
+	"perun.network/go-perun/backend/ethereum/channel/test"
+	"perun.network/go-perun/backend/ethereum/wallet"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	"perun.network/go-perun/channel"
+	perunwallet "perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+const challengeDuration = 60 // seconds
+
+type adjudicatorSetup struct {
+	sim         *test.SimulatedBackend
+	accs        []*wallet.Account
+	adjs        []*Adjudicator
+	params      *channel.Params
+	allocation  *channel.Allocation
+	assetHolder common.Address
+}
+
+// newAdjudicatorSetup deploys the contracts and funds a two-party channel.
+func newAdjudicatorSetup(t *testing.T, rng *rand.Rand) *adjudicatorSetup {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := &adjudicatorSetup{sim: test.NewSimulatedBackend()}
+	ks := ethwallettest.GetKeystore()
+
+	deployAcc := wallettest.NewRandomAccount(rng).(*wallet.Account).Account
+	s.sim.FundAddress(ctx, deployAcc.Address)
+	cb := NewContractBackend(s.sim, ks, deployAcc)
+	adjAddr, err := DeployAdjudicator(ctx, cb)
+	require.NoError(t, err)
+	s.assetHolder, err = DeployETHAssetholder(ctx, cb, adjAddr)
+	require.NoError(t, err)
+
+	parts := make([]perunwallet.Address, 2)
+	funders := make([]*Funder, 2)
+	for i := range parts {
+		acc := wallettest.NewRandomAccount(rng).(*wallet.Account)
+		s.sim.FundAddress(ctx, acc.Account.Address)
+		cb := NewContractBackend(s.sim, ks, acc.Account)
+		s.accs = append(s.accs, acc)
+		s.adjs = append(s.adjs, NewAdjudicator(cb, adjAddr))
+		funders[i] = NewETHFunder(cb, s.assetHolder)
+		parts[i] = acc.Address()
+	}
+	s.params = channel.NewParamsUnsafe(challengeDuration, parts, wallettest.NewRandomAddress(rng), big.NewInt(rng.Int63()))
+	s.allocation = newValidAllocation(parts, s.assetHolder)
+
+	var wg sync.WaitGroup
+	wg.Add(len(funders))
+	for i, f := range funders {
+		go func(i int, f *Funder) {
+			defer wg.Done()
+			req := channel.FundingReq{Params: s.params, Allocation: s.allocation, Idx: channel.Index(i)}
+			assert.NoError(t, f.Fund(ctx, req))
+		}(i, f)
+	}
+	wg.Wait()
+	return s
+}
+
+// newReq returns the adjudicator request of participant idx for a state of
+// the given version, signed by all participants.
+func (s *adjudicatorSetup) newReq(t *testing.T, idx int, version uint64, final bool) channel.AdjudicatorReq {
+	state := &channel.State{
+		ID:         s.params.ID(),
+		Version:    version,
+		App:        s.params.App,
+		Allocation: s.allocation.Clone(),
+		Data:       channel.NewMockOp(channel.OpValid),
+		IsFinal:    final,
+	}
+	sigs := make([]perunwallet.Sig, len(s.accs))
+	for i, acc := range s.accs {
+		var err error
+		sigs[i], err = Sign(acc, s.params, state)
+		require.NoError(t, err)
+	}
+	return channel.AdjudicatorReq{
+		Params: s.params,
+		Acc:    s.accs[idx],
+		Tx:     channel.Transaction{State: state, Sigs: sigs},
+		Idx:    channel.Index(idx),
+	}
+}
+
+// requireSettled checks that the outcome of the channel is set on the asset
+// holder.
+func (s *adjudicatorSetup) requireSettled(t *testing.T) {
+	contract, err := assets.NewAssetHolder(s.assetHolder, s.sim)
+	require.NoError(t, err)
+	settled, err := contract.Settled(&bind.CallOpts{}, s.params.ID())
+	require.NoError(t, err)
+	require.True(t, settled)
+}
+
+func TestAdjudicator_RegisterRefuteConclude(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(1)))
+
+	reg, err := s.adjs[0].Register(ctx, s.newReq(t, 0, 1, false))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), reg.Version)
+	assert.Equal(t, s.params.ID(), reg.ID)
+	// Later queries only filter the blocks since the registration.
+	assert.Greater(t, s.adjs[0].regStart[s.params.ID()], uint64(1))
+
+	sub, err := s.adjs[1].SubscribeRegistered(ctx, s.params)
+	require.NoError(t, err)
+	defer sub.Close()
+	past := sub.Next()
+	require.NotNil(t, past)
+	assert.Equal(t, uint64(1), past.Version)
+	assert.Equal(t, reg.Timeout, past.Timeout)
+
+	// Bob refutes with a newer version.
+	req := s.newReq(t, 1, 2, false)
+	refuted, err := s.adjs[1].Register(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), refuted.Version)
+	next := sub.Next()
+	require.NotNil(t, next)
+	assert.Equal(t, uint64(2), next.Version)
+
+	// Registering an older version returns the newer registration.
+	reg, err = s.adjs[0].Register(ctx, s.newReq(t, 0, 1, false))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), reg.Version)
+
+	// Concluding fails before the timeout and with an older version.
+	assert.Error(t, s.adjs[1].ensureConcluded(ctx, req))
+	require.NoError(t, s.sim.AdvanceTime(challengeDuration*time.Second))
+	assert.Error(t, s.adjs[0].ensureConcluded(ctx, s.newReq(t, 0, 1, false)))
+
+	require.NoError(t, s.adjs[1].ensureConcluded(ctx, req))
+	s.requireSettled(t)
+	// Concluding an already concluded channel is a no-op.
+	require.NoError(t, s.adjs[0].ensureConcluded(ctx, s.newReq(t, 0, 2, false)))
+}
+
+func TestAdjudicator_ConcludeFinal(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(2)))
+
+	// The first registration concludes the final state on-chain.
+	for i, adj := range s.adjs {
+		req := s.newReq(t, i, 3, true)
+		reg, err := adj.Register(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), reg.Version)
+		assert.True(t, reg.Concluded)
+		assert.True(t, reg.Timeout.IsZero())
+		s.requireSettled(t)
+		require.NoError(t, adj.ensureConcluded(ctx, req))
+	}
+}
+
+func TestAdjudicator_SubChannels(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(3)))
+	req := s.newReq(t, 0, 1, false)
+	req.SubChannels = []channel.SubChannel{{
+		SignedState: channel.SignedState{Params: s.params, State: req.Tx.State},
+		Idxs:        []channel.Index{0, 1},
+	}}
+
+	_, err := s.adjs[0].Register(ctx, req)
+	assert.Error(t, err)
+	assert.Error(t, s.adjs[0].Withdraw(ctx, req))
+}
+
+func TestAdjudicator_registeredState(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(4)))
+	req := s.newReq(t, 0, 1, false)
+	_, err := s.adjs[0].Register(ctx, req)
+	require.NoError(t, err)
+	reg, err := s.adjs[1].latestRegistration(ctx, s.params)
+	require.NoError(t, err)
+
+	// Bob did not register the state, so it is taken from the calldata.
+	state, err := s.adjs[1].registeredState(ctx, s.params, reg)
+	require.NoError(t, err)
+	assert.Equal(t, channelStateToEthState(req.Tx.State), state)
+
+	// Calls are also found if they are embedded in the calldata of another
+	// call, e.g., of a contract wallet.
+	a := s.adjs[1]
+	call, err := a.abi.Pack("register", channelParamsToEthParams(s.params), state, req.Tx.Sigs)
+	require.NoError(t, err)
+	wrapped, err := abi.Arguments{{Type: abiAddress}, {Type: abiBytes}}.Pack(s.assetHolder, call)
+	require.NoError(t, err)
+	states := a.calldataStates(append([]byte{0xc0, 0xff, 0xee, 0x00}, wrapped...))
+	require.Len(t, states, 1)
+	assert.Equal(t, state, states[0])
+
+	// Only states that match the stored dispute are returned.
+	reg.Version++
+	_, err = a.registeredState(ctx, s.params, reg)
+	assert.Error(t, err)
+}
+
+func TestAdjudicator_Withdraw(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(4)))
+	// Alice receives all funds of the channel.
+	bals := s.allocation.OfParts
+	bals[0][0], bals[1][0] = new(big.Int).Add(bals[0][0], bals[1][0]), big.NewInt(0)
+
+	reg, err := s.adjs[1].Register(ctx, s.newReq(t, 1, 1, false))
+	require.NoError(t, err)
+	assert.False(t, reg.Concluded)
+	require.NoError(t, s.sim.AdvanceTime(challengeDuration*time.Second))
+
+	for i, adj := range s.adjs {
+		// The transactions are free, so that the balances only change by
+		// the withdrawn amounts.
+		adj.SetGasConfig(GasConfig{Margin: DefaultGasMargin, Pricer: StaticGasPrice{Price: big.NewInt(0)}})
+		addr := s.accs[i].Account.Address
+		before, err := s.sim.BalanceAt(ctx, addr, nil)
+		require.NoError(t, err)
+		require.NoError(t, adj.Withdraw(ctx, s.newReq(t, i, 1, false)))
+		after, err := s.sim.BalanceAt(ctx, addr, nil)
+		require.NoError(t, err)
+		withdrawn := new(big.Int).Sub(after, before)
+		assert.Zero(t, bals[i][0].Cmp(withdrawn), "participant %d withdrew %v instead of %v", i, withdrawn, bals[i][0])
+	}
+	s.requireSettled(t)
+
+	// Withdrawing again is a no-op.
+	addr := s.accs[0].Account.Address
+	before, err := s.sim.BalanceAt(ctx, addr, nil)
+	require.NoError(t, err)
+	require.NoError(t, s.adjs[0].Withdraw(ctx, s.newReq(t, 0, 1, false)))
+	after, err := s.sim.BalanceAt(ctx, addr, nil)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestAdjudicator_SubscribeRegistered(t *testing.T) {
+	s := newAdjudicatorSetup(t, rand.New(rand.NewSource(3)))
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		sub, err := s.adjs[0].SubscribeRegistered(ctx, s.params)
+		require.NoError(t, err)
+		cancel()
+		assert.Nil(t, sub.Next())
+		assert.Equal(t, context.Canceled, sub.Err())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		sub, err := s.adjs[0].SubscribeRegistered(context.Background(), s.params)
+		require.NoError(t, err)
+		require.NoError(t, sub.Close())
+		assert.Nil(t, sub.Next())
+		assert.NoError(t, sub.Err())
+	})
+}
@@ -7,11 +7,9 @@ package channel
 
 import (
 	"context"
-	"log"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/accounts"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
@@ -26,31 +24,37 @@ import (
 // How many blocks we query into the past for events.
 const startBlockOffset = 100
 
-// GasLimit is the max amount of gas we want to send per transaction.
-const GasLimit = 500000
-
 // ContractInterface provides all functions needed by an ethereum backend.
 // Both test.SimulatedBackend and ethclient.Client implement this interface.
 type ContractInterface interface {
 	bind.ContractBackend
 	BlockByNumber(context.Context, *big.Int) (*types.Block, error)
 	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	TransactionByHash(ctx context.Context, txHash common.Hash) (tx *types.Transaction, isPending bool, err error)
 }
 
 // ContractBackend adds a keystore and an on-chain account to the ContractInterface.
 // This is needed to send on-chain transaction to interact with the smart contracts.
+// The nonces of the transactions are managed by the ContractBackend, so all
+// transactions of the account should be sent through the same ContractBackend
+// or its copies.
 type ContractBackend struct {
 	ContractInterface
 	ks      *keystore.KeyStore
 	account *accounts.Account
+	gas     GasConfig
+	nonces  *nonceManager
 }
 
-// NewContractBackend creates a new ContractBackend with the given parameters.
+// NewContractBackend creates a new ContractBackend with the given parameters
+// and the DefaultGasConfig.
 func NewContractBackend(cf ContractInterface, ks *keystore.KeyStore, acc *accounts.Account) ContractBackend {
 	return ContractBackend{
 		ContractInterface: cf,
 		ks:                ks,
 		account:           acc,
+		gas:               DefaultGasConfig(),
+		nonces:            new(nonceManager),
 	}
 }
 
@@ -73,13 +77,16 @@ func (c *ContractBackend) newWatchOpts(ctx context.Context) (*bind.WatchOpts, er
 	}, nil
 }
 
+// newTransactor creates a transactor with the gas price of the gas config. If
+// gasLimit is zero, the bindings estimate the gas limit of the transaction with
+// EstimateGas.
 func (c *ContractBackend) newTransactor(ctx context.Context, valueWei *big.Int, gasLimit uint64) (*bind.TransactOpts, error) {
 	nonce, err := c.PendingNonceAt(ctx, c.account.Address)
 	if err != nil {
 		return nil, err
 	}
 
-	gasPrice, err := c.SuggestGasPrice(ctx)
+	gasPrice, err := c.gasPrice(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -97,16 +104,13 @@ func (c *ContractBackend) newTransactor(ctx context.Context, valueWei *big.Int,
 	return auth, nil
 }
 
+// calcFundingIDs calculates the funding IDs of the participants like the asset
+// holder contracts, i.e., as keccak256(abi.encodePacked(channelID, participant)).
 func calcFundingIDs(participants []perunwallet.Address, channelID channel.ID) [][32]byte {
 	partIDs := make([][32]byte, len(participants))
-	args := abi.Arguments{{Type: abibytes32}, {Type: abiaddress}}
 	for idx, pID := range participants {
 		address := pID.(*wallet.Address)
-		bytes, err := args.Pack(channelID, address.Address)
-		if err != nil {
-			log.Panicf("error packing values: %v", err)
-		}
-		partIDs[idx] = crypto.Keccak256Hash(bytes)
+		partIDs[idx] = crypto.Keccak256Hash(channelID[:], address.Address.Bytes())
 	}
 	return partIDs
 }
@@ -28,11 +28,11 @@ func Test_calcFundingIDs(t *testing.T) {
 		{"Test empty array, non-empty channelID", []perunwallet.Address{}, [32]byte{1}, make([][32]byte, 0)},
 		// Tests based on actual data from contracts.
 		{"Test non-empty array, empty channelID", []perunwallet.Address{&wallet.Address{}},
-			[32]byte{}, [][32]byte{{173, 50, 40, 182, 118, 247, 211, 205, 66, 132, 165, 68, 63, 23, 241, 150, 43, 54, 228, 145, 179, 10, 64, 178, 64, 88, 73, 229, 151, 186, 95, 181}}},
+			[32]byte{}, [][32]byte{{168, 109, 84, 233, 170, 180, 26, 229, 229, 32, 255, 0, 98, 255, 27, 76, 189, 11, 33, 146, 187, 1, 8, 10, 5, 139, 177, 112, 216, 78, 100, 87}}},
 		{"Test non-empty array, non-empty channelID", []perunwallet.Address{&wallet.Address{}},
-			[32]byte{1}, [][32]byte{{130, 172, 39, 157, 178, 106, 32, 109, 155, 165, 169, 76, 7, 255, 148, 10, 234, 75, 59, 253, 232, 130, 14, 201, 95, 78, 250, 10, 207, 208, 213, 188}}},
+			[32]byte{1}, [][32]byte{{197, 235, 110, 136, 77, 87, 149, 211, 32, 2, 235, 174, 133, 239, 122, 90, 129, 250, 136, 168, 20, 213, 223, 151, 82, 82, 248, 206, 148, 192, 251, 150}}},
 		{"Test non-empty array, non-empty channelID", []perunwallet.Address{&wallet.Address{Address: common.BytesToAddress([]byte{})}},
-			[32]byte{1}, [][32]byte{{130, 172, 39, 157, 178, 106, 32, 109, 155, 165, 169, 76, 7, 255, 148, 10, 234, 75, 59, 253, 232, 130, 14, 201, 95, 78, 250, 10, 207, 208, 213, 188}}},
+			[32]byte{1}, [][32]byte{{197, 235, 110, 136, 77, 87, 149, 211, 32, 2, 235, 174, 133, 239, 122, 90, 129, 250, 136, 168, 20, 213, 223, 151, 82, 82, 248, 206, 148, 192, 251, 150}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -76,7 +76,7 @@ func Test_NewWatchOpts(t *testing.T) {
 	f := &ContractBackend{}
 	assert.Panics(t, func() { f.newWatchOpts(context.Background()) }, "Creating watchopts on invalid backend should panic")
 	sf := newSimulatedFunder(t)
-	f = &ContractBackend{sf.ContractBackend, sf.ks, sf.account}
+	f = &ContractBackend{ContractInterface: sf.ContractBackend, ks: sf.ks, account: sf.account}
 	watchOpts, err := f.newWatchOpts(context.Background())
 	assert.NoError(t, err, "Creating watchopts on valid ContractBackend should succeed")
 	assert.Equal(t, context.Background(), watchOpts.Context, "context should be set")
@@ -18,15 +18,13 @@ import (
 	"perun.network/go-perun/log"
 )
 
-const deployGasLimit = 6600000
-
 // DeployETHAssetholder deploys a new ETHAssetHolder contract.
 func DeployETHAssetholder(ctx context.Context, backend ContractBackend, adjudicatorAddr common.Address) (common.Address, error) {
-	auth, err := backend.newTransactor(ctx, big.NewInt(0), deployGasLimit)
-	if err != nil {
-		return common.Address{}, errors.WithMessage(err, "could not create transactor")
-	}
-	addr, tx, _, err := assets.DeployAssetHolderETH(auth, backend, adjudicatorAddr)
+	var addr common.Address
+	tx, err := backend.transact(ctx, big.NewInt(0), 0, func(auth *bind.TransactOpts) (tx *types.Transaction, err error) {
+		addr, tx, _, err = assets.DeployAssetHolderETH(auth, backend, adjudicatorAddr)
+		return tx, err
+	})
 	if err != nil {
 		return common.Address{}, errors.WithMessage(err, "could not create transaction")
 	}
@@ -39,11 +37,11 @@ func DeployETHAssetholder(ctx context.Context, backend ContractBackend, adjudica
 
 // DeployAdjudicator deploys a new Adjudicator contract.
 func DeployAdjudicator(ctx context.Context, backend ContractBackend) (common.Address, error) {
-	auth, err := backend.newTransactor(ctx, big.NewInt(0), deployGasLimit)
-	if err != nil {
-		return common.Address{}, errors.WithMessage(err, "could not create transactor")
-	}
-	addr, tx, _, err := adjudicator.DeployAdjudicator(auth, backend)
+	var addr common.Address
+	tx, err := backend.transact(ctx, big.NewInt(0), 0, func(auth *bind.TransactOpts) (tx *types.Transaction, err error) {
+		addr, tx, _, err = adjudicator.DeployAdjudicator(auth, backend)
+		return tx, err
+	})
 	if err != nil {
 		return common.Address{}, errors.WithMessage(err, "could not create transaction")
 	}
@@ -11,49 +11,66 @@ import (
 	"math/big"
 	"sync"
 
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/pkg/errors"
 
 	"perun.network/go-perun/backend/ethereum/bindings/assets"
+	"perun.network/go-perun/backend/ethereum/bindings/erc20"
 	"perun.network/go-perun/channel"
 	"perun.network/go-perun/log"
 )
 
-var (
-	// Declaration for abi-encoding.
-	abibytes32, _ = abi.NewType("bytes32", nil)
-	abiaddress, _ = abi.NewType("address", nil)
-)
-
 type assetHolder struct {
 	*assets.AssetHolder
 	*common.Address
 	assetIndex int
+	token      *erc20.IERC20 // nil for the ETH asset holder
+}
+
+// ERC20AssetHolder is an asset holder contract for ERC20 tokens. The funder
+// approves the asset holder to transfer the deposited tokens from the funder's
+// account before each deposit.
+type ERC20AssetHolder struct {
+	AssetHolder common.Address // on-chain address of the asset holder
+	Token       common.Address // on-chain address of the ERC20 token
 }
 
 // Funder implements the channel.Funder interface for Ethereum.
 type Funder struct {
 	ContractBackend
-	mu  sync.Mutex
+	mu  sync.Mutex // lock for ERC20 allowances
 	log log.Logger // structured logger
 	// ETHAssetHolder is the on-chain address of the ETH asset holder.
 	// This is needed to distinguish between ETH and ERC-20 transactions.
 	ethAssetHolder common.Address
+	// tokens maps the ERC-20 asset holders to their tokens.
+	tokens map[common.Address]common.Address
 }
 
 // compile time check that we implement the perun funder interface
 var _ channel.Funder = (*Funder)(nil)
 
-// NewETHFunder creates a new ethereum funder.
+// NewETHFunder creates a new ethereum funder that can only fund ether.
 func NewETHFunder(backend ContractBackend, ethAssetHolder common.Address) *Funder {
-	return &Funder{
+	return NewFunder(backend, ethAssetHolder)
+}
+
+// NewFunder creates a new ethereum funder for ether and the given ERC20 tokens.
+// Each asset of a funding request must be either the ETH asset holder or one
+// of the ERC20 asset holders, so that one channel can hold ether and tokens.
+func NewFunder(backend ContractBackend, ethAssetHolder common.Address, tokens ...ERC20AssetHolder) *Funder {
+	f := &Funder{
 		ContractBackend: backend,
 		ethAssetHolder:  ethAssetHolder,
+		tokens:          make(map[common.Address]common.Address, len(tokens)),
 		log:             log.WithField("account", backend.account.Address),
 	}
+	for _, t := range tokens {
+		f.tokens[t.AssetHolder] = t.Token
+	}
+	return f
 }
 
 // Fund implements the funder interface.
@@ -121,10 +138,32 @@ func (f *Funder) connectToContract(asset channel.Asset, assetIndex int) (assetHo
 	if err != nil {
 		return assetHolder{}, errors.Wrapf(err, "connecting to assetholder")
 	}
-	return assetHolder{ctr, &assetAddr, assetIndex}, nil
+	holder := assetHolder{AssetHolder: ctr, Address: &assetAddr, assetIndex: assetIndex}
+	if assetAddr == f.ethAssetHolder {
+		return holder, nil
+	}
+	tokenAddr, ok := f.tokens[assetAddr]
+	if !ok {
+		return assetHolder{}, errors.Errorf("unknown asset holder %v", assetAddr.Hex())
+	}
+	if holder.token, err = erc20.NewIERC20(tokenAddr, f); err != nil {
+		return assetHolder{}, errors.Wrapf(err, "connecting to token")
+	}
+	return holder, nil
 }
 
 func (f *Funder) sendFundingTransaction(ctx context.Context, request channel.FundingReq, asset assetHolder, partIDs [][32]byte) error {
+	if asset.token != nil {
+		// Tokens are transferred by the asset holder, which needs an allowance.
+		// The funder stays locked until the deposit is mined and has used up the
+		// allowance, so that concurrent deposits don't use the same allowance.
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		balance := request.Allocation.OfParts[request.Idx][asset.assetIndex]
+		if err := f.ensureAllowance(ctx, asset, balance); err != nil {
+			return errors.WithMessagef(err, "approving asset %d", asset.assetIndex)
+		}
+	}
 	tx, err := f.createFundingTx(ctx, request, asset, partIDs)
 	if err != nil {
 		return errors.WithMessagef(err, "depositing asset %d", asset.assetIndex)
@@ -140,24 +179,55 @@ func (f *Funder) createFundingTx(ctx context.Context, request channel.FundingReq
 	// Create a new transaction (needs to be cloned because of go-ethereum bug).
 	// See https://github.com/ethereum/go-ethereum/pull/20412
 	balance := new(big.Int).Set(request.Allocation.OfParts[request.Idx][asset.assetIndex])
-	// Lock the funder for correct nonce usage.
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	var auth *bind.TransactOpts
-	var errI error
+	value := big.NewInt(0)
 	if bytes.Equal(asset.Bytes(), f.ethAssetHolder.Bytes()) {
 		// If we want to fund the channel with ether, send eth in transaction.
-		auth, errI = f.newTransactor(ctx, balance, GasLimit)
-	} else {
-		auth, errI = f.newTransactor(ctx, big.NewInt(0), GasLimit)
-	}
-	if errI != nil {
-		return nil, errors.Wrapf(errI, "creating transactor for asset %d", asset.assetIndex)
+		value = balance
 	}
 	// Call the asset holder contract.
-	tx, err := asset.Deposit(auth, partIDs[request.Idx], balance)
+	tx, err := f.transact(ctx, value, 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return asset.Deposit(auth, partIDs[request.Idx], balance)
+	})
+	if err != nil {
+		return nil, err
+	}
 	f.log.Debugf("peer[%d] Created funding transaction with txHash: %v, amount %d", request.Idx, tx.Hash().Hex(), balance)
-	return tx, errors.WithStack(err)
+	return tx, nil
+}
+
+// ensureAllowance approves the asset holder to transfer amount tokens from our
+// account, unless the allowance already suffices. A non-zero allowance is
+// first reset to zero, as some tokens, e.g., Tether USD, reject changing a
+// non-zero allowance to another non-zero value. It waits until the approvals
+// are mined, as the deposit fails otherwise. The funder must be locked until
+// the deposit is mined.
+func (f *Funder) ensureAllowance(ctx context.Context, asset assetHolder, amount *big.Int) error {
+	allowance, err := asset.token.Allowance(&bind.CallOpts{Context: ctx}, f.account.Address, *asset.Address)
+	if err != nil {
+		return errors.Wrap(err, "querying allowance")
+	} else if allowance.Cmp(amount) >= 0 {
+		return nil
+	}
+
+	if allowance.Sign() != 0 {
+		if err := f.approve(ctx, asset, big.NewInt(0)); err != nil {
+			return errors.WithMessage(err, "resetting allowance")
+		}
+	}
+	return f.approve(ctx, asset, amount)
+}
+
+// approve sets the allowance of the asset holder to amount tokens and waits
+// until the approval is mined.
+func (f *Funder) approve(ctx context.Context, asset assetHolder, amount *big.Int) error {
+	tx, err := f.transact(ctx, big.NewInt(0), 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return asset.token.Approve(auth, *asset.Address, amount)
+	})
+	if err != nil {
+		return errors.WithMessage(err, "sending approve transaction")
+	}
+	f.log.Debugf("Approving %d tokens for asset holder %v with txHash: %v", amount, asset.Hex(), tx.Hash().Hex())
+	return errors.WithMessage(execSuccessful(ctx, f.ContractBackend, tx), "mining approve transaction")
 }
 
 func filterOldEvents(ctx context.Context, asset assetHolder, deposited chan *assets.AssetHolderDeposited, partIDs [][32]byte) error {
@@ -9,17 +9,23 @@ import (
 	"context"
 	"math/big"
 	"math/rand"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"perun.network/go-perun/backend/ethereum/wallet"
 	"perun.network/go-perun/channel"
 	wallettest "perun.network/go-perun/wallet/test"
 
+	"perun.network/go-perun/backend/ethereum/bindings/erc20"
 	"perun.network/go-perun/backend/ethereum/channel/test"
 	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
 	channeltest "perun.network/go-perun/channel/test"
@@ -129,7 +135,7 @@ func newSimulatedFunder(t *testing.T) *Funder {
 	ks := wall.Ks
 	simBackend := test.NewSimulatedBackend()
 	simBackend.FundAddress(context.Background(), acc.Account.Address)
-	cb := ContractBackend{simBackend, ks, acc.Account}
+	cb := NewContractBackend(simBackend, ks, acc.Account)
 	// Deploy Assetholder
 	assetETH, err := DeployETHAssetholder(context.Background(), cb, acc.Account.Address)
 	if err != nil {
@@ -157,3 +163,107 @@ func newValidAllocation(parts []perunwallet.Address, assetETH common.Address) *c
 		OfParts: ofparts,
 	}
 }
+
+// tokenBackend is a ContractInterface that simulates the allowance and the
+// approvals of an ERC20 token at the address token. All other calls and
+// transactions are passed to the wrapped ContractInterface.
+type tokenBackend struct {
+	ContractInterface
+	token     common.Address
+	allowance *big.Int
+	approvals []int64 // approved amounts in order
+}
+
+func (b *tokenBackend) PendingCodeAt(ctx context.Context, addr common.Address) ([]byte, error) {
+	if addr == b.token {
+		return []byte{0}, nil // the bindings check that the token is a contract
+	}
+	return b.ContractInterface.PendingCodeAt(ctx, addr)
+}
+
+func (b *tokenBackend) CallContract(ctx context.Context, call ethereum.CallMsg, block *big.Int) ([]byte, error) {
+	if call.To == nil || *call.To != b.token {
+		return b.ContractInterface.CallContract(ctx, call, block)
+	}
+	method, err := erc20ABI.MethodById(call.Data[:4])
+	if err != nil || method.Name != "allowance" {
+		return nil, errors.Errorf("unexpected token call %x", call.Data)
+	}
+	return method.Outputs.Pack(b.allowance)
+}
+
+// SendTransaction sends the approvals to the token, which has no code on the
+// wrapped chain, so that they are mined.
+func (b *tokenBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if tx.To() != nil && *tx.To() == b.token {
+		method, err := erc20ABI.MethodById(tx.Data()[:4])
+		if err != nil || method.Name != "approve" {
+			return errors.Errorf("unexpected token transaction %x", tx.Data())
+		}
+		args, err := method.Inputs.UnpackValues(tx.Data()[4:])
+		if err != nil {
+			return err
+		}
+		b.allowance = args[1].(*big.Int)
+		b.approvals = append(b.approvals, b.allowance.Int64())
+	}
+	return b.ContractInterface.SendTransaction(ctx, tx)
+}
+
+var erc20ABI = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(erc20.IERC20ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+func TestFunder_ensureAllowance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	rng := rand.New(rand.NewSource(42))
+	acc := wallettest.NewRandomAccount(rng).(*wallet.Account).Account
+	sim.FundAddress(ctx, acc.Address)
+	backend := &tokenBackend{ContractInterface: sim, token: wallettest.NewRandomAddress(rng).(*wallet.Address).Address}
+	holderAddr := wallettest.NewRandomAddress(rng).(*wallet.Address).Address
+	f := NewFunder(NewContractBackend(backend, ethwallettest.GetKeystore(), acc), common.Address{},
+		ERC20AssetHolder{AssetHolder: holderAddr, Token: backend.token})
+	holder, err := f.connectToContract(&Asset{Address: holderAddr}, 0)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		allowance int64
+		amount    int64
+		approvals []int64
+	}{
+		{"zero allowance", 0, 5, []int64{5}},
+		{"sufficient allowance", 5, 3, nil},
+		// Some tokens reject changing a non-zero allowance, so it is reset.
+		{"insufficient allowance", 1, 5, []int64{0, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend.allowance, backend.approvals = big.NewInt(tt.allowance), nil
+			require.NoError(t, f.ensureAllowance(ctx, holder, big.NewInt(tt.amount)))
+			assert.Equal(t, tt.approvals, backend.approvals)
+		})
+	}
+}
+
+func TestFunder_Fund_unknownAssetHolder(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	f := newSimulatedFunder(t)
+	rng := rand.New(rand.NewSource(43))
+	parts := []perunwallet.Address{&wallet.Address{Address: f.account.Address}}
+	params := channel.NewParamsUnsafe(0, parts, channeltest.NewRandomApp(rng).Def(), big.NewInt(rng.Int63()))
+	allocation := newValidAllocation(parts, f.ethAssetHolder)
+	allocation.Assets = append(allocation.Assets, &Asset{Address: wallettest.NewRandomAddress(rng).(*wallet.Address).Address})
+	allocation.OfParts[0] = append(allocation.OfParts[0], big.NewInt(1))
+	req := channel.FundingReq{Params: params, Allocation: allocation, Idx: 0}
+	err := f.Fund(ctx, req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown asset holder")
+}
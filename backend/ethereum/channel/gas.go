@@ -0,0 +1,149 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+)
+
+// DefaultGasMargin is the default percentage that is added to the estimated
+// gas of a transaction, in case the state changes between the estimation and
+// the execution.
+const DefaultGasMargin = 20
+
+type (
+	// GasConfig configures the gas limits and prices of the transactions that
+	// are sent by a ContractBackend.
+	GasConfig struct {
+		// Margin is the percentage that is added to the estimated gas.
+		Margin uint64
+		// Pricer determines the gas price. The node's suggestion is used if nil.
+		Pricer GasPricer
+		// Budget is the maximal fee of a single transaction in wei, i.e., its
+		// gas limit times its gas price. There is no budget if nil.
+		Budget *big.Int
+	}
+
+	// GasPricer determines the gas price of transactions.
+	GasPricer interface {
+		GasPrice(ctx context.Context, backend ContractInterface) (*big.Int, error)
+	}
+
+	// StaticGasPrice always uses the same gas price.
+	StaticGasPrice struct {
+		Price *big.Int // in wei
+	}
+
+	// SuggestedGasPrice uses the gas price suggested by the node.
+	SuggestedGasPrice struct{}
+
+	// CappedGasPrice uses the gas price suggested by the node, but at most
+	// Max.
+	CappedGasPrice struct {
+		Max *big.Int // in wei
+	}
+
+	// FeeBudgetError happens if the fee of a transaction would exceed the fee
+	// budget of the ContractBackend.
+	FeeBudgetError struct {
+		Fee    *big.Int // fee of the transaction in wei
+		Budget *big.Int // budget in wei
+	}
+)
+
+// DefaultGasConfig returns the gas config that is used by NewContractBackend.
+// It adds the DefaultGasMargin to the estimated gas, uses the node's suggested
+// gas price and has no fee budget.
+func DefaultGasConfig() GasConfig {
+	return GasConfig{Margin: DefaultGasMargin, Pricer: SuggestedGasPrice{}}
+}
+
+// GasPrice returns the static gas price.
+func (p StaticGasPrice) GasPrice(context.Context, ContractInterface) (*big.Int, error) {
+	return new(big.Int).Set(p.Price), nil
+}
+
+// GasPrice returns the gas price suggested by the node.
+func (SuggestedGasPrice) GasPrice(ctx context.Context, backend ContractInterface) (*big.Int, error) {
+	price, err := backend.SuggestGasPrice(ctx)
+	return price, errors.Wrap(err, "suggesting gas price")
+}
+
+// GasPrice returns the gas price suggested by the node, capped at Max.
+func (p CappedGasPrice) GasPrice(ctx context.Context, backend ContractInterface) (*big.Int, error) {
+	price, err := SuggestedGasPrice{}.GasPrice(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	if price.Cmp(p.Max) > 0 {
+		return new(big.Int).Set(p.Max), nil
+	}
+	return price, nil
+}
+
+func (e *FeeBudgetError) Error() string {
+	return fmt.Sprintf("transaction fee %v exceeds fee budget %v", e.Fee, e.Budget)
+}
+
+// IsFeeBudgetError returns true if the error was a FeeBudgetError.
+func IsFeeBudgetError(err error) bool {
+	cause := errors.Cause(err)
+	_, ok := cause.(*FeeBudgetError)
+	return ok
+}
+
+// SetGasConfig sets the gas config of the contract backend. It must be called
+// before the backend is passed to a Funder or Adjudicator, as they copy it.
+func (c *ContractBackend) SetGasConfig(cfg GasConfig) {
+	c.gas = cfg
+}
+
+// EstimateGas estimates the gas needed by the call and adds the margin of
+// the gas config. The bindings use it to determine the gas limit of
+// transactions whose transactor has no gas limit.
+func (c ContractBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	gas, err := c.ContractInterface.EstimateGas(ctx, call)
+	if err != nil {
+		return 0, errors.Wrap(err, "estimating gas")
+	}
+	return gas + gas*c.gas.Margin/100, nil
+}
+
+// SendTransaction sends the transaction, unless its fee exceeds the fee budget
+// of the gas config, in which case a FeeBudgetError is returned.
+func (c ContractBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if err := c.checkFee(tx.Gas(), tx.GasPrice()); err != nil {
+		return err
+	}
+	return c.ContractInterface.SendTransaction(ctx, tx)
+}
+
+// checkFee returns a FeeBudgetError if the fee of a transaction with the given
+// gas limit and gas price exceeds the fee budget of the gas config.
+func (c *ContractBackend) checkFee(gas uint64, price *big.Int) error {
+	if c.gas.Budget == nil {
+		return nil
+	}
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(gas), price)
+	if fee.Cmp(c.gas.Budget) > 0 {
+		return errors.WithStack(&FeeBudgetError{Fee: fee, Budget: new(big.Int).Set(c.gas.Budget)})
+	}
+	return nil
+}
+
+// gasPrice returns the gas price of the gas config's pricer.
+func (c *ContractBackend) gasPrice(ctx context.Context) (*big.Int, error) {
+	if c.gas.Pricer == nil {
+		return SuggestedGasPrice{}.GasPrice(ctx, c.ContractInterface)
+	}
+	return c.gas.Pricer.GasPrice(ctx, c.ContractInterface)
+}
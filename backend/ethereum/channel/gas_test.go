@@ -0,0 +1,86 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	perunwallet "perun.network/go-perun/wallet"
+)
+
+// suggestingBackend is a ContractInterface that suggests a fixed gas price.
+type suggestingBackend struct {
+	ContractInterface
+	price *big.Int
+}
+
+func (b *suggestingBackend) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return b.price, nil
+}
+
+func TestGasPricer(t *testing.T) {
+	ctx := context.Background()
+	backend := &suggestingBackend{price: big.NewInt(100)}
+	tests := []struct {
+		name   string
+		pricer GasPricer
+		want   int64
+	}{
+		{"static", StaticGasPrice{Price: big.NewInt(42)}, 42},
+		{"suggested", SuggestedGasPrice{}, 100},
+		{"capped below suggestion", CappedGasPrice{Max: big.NewInt(50)}, 50},
+		{"capped above suggestion", CappedGasPrice{Max: big.NewInt(150)}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			price, err := tt.pricer.GasPrice(ctx, backend)
+			require.NoError(t, err)
+			assert.Equal(t, big.NewInt(tt.want), price)
+		})
+	}
+}
+
+func TestContractBackend_Gas(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	f := newSimulatedFunder(t)
+	cb := f.ContractBackend
+
+	t.Run("estimation margin", func(t *testing.T) {
+		call := ethereum.CallMsg{From: cb.account.Address, To: &cb.account.Address}
+		gas, err := cb.EstimateGas(ctx, call)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(21000*(100+DefaultGasMargin)/100), gas)
+	})
+
+	t.Run("gas price", func(t *testing.T) {
+		cb.SetGasConfig(GasConfig{Pricer: StaticGasPrice{Price: big.NewInt(7)}})
+		auth, err := cb.newTransactor(ctx, big.NewInt(0), 0)
+		require.NoError(t, err)
+		assert.Equal(t, big.NewInt(7), auth.GasPrice)
+	})
+
+	t.Run("fee budget", func(t *testing.T) {
+		cb.SetGasConfig(GasConfig{Budget: big.NewInt(1000)})
+		funder := NewETHFunder(cb, f.ethAssetHolder)
+		parts := []perunwallet.Address{&wallet.Address{Address: funder.account.Address}}
+		rng := rand.New(rand.NewSource(1))
+		params := channel.NewParamsUnsafe(0, parts, channeltest.NewRandomApp(rng).Def(), big.NewInt(rng.Int63()))
+		req := channel.FundingReq{Params: params, Allocation: newValidAllocation(parts, f.ethAssetHolder), Idx: 0}
+		err := funder.Fund(ctx, req)
+		assert.True(t, IsFeeBudgetError(err), "funding should exceed the fee budget: %v", err)
+	})
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/log"
+)
+
+const (
+	// ReplacementBump is the percentage by which BumpFee increases the gas
+	// price of a transaction. Nodes reject replacements with a smaller
+	// increase, by default 10%.
+	ReplacementBump = 10
+
+	// maxNonceRetries is how often a transaction is resent with a new nonce
+	// after the node rejected its nonce as too low.
+	maxNonceRetries = 3
+)
+
+// nonceManager assigns the nonces of the transactions of an account. It is
+// shared by all copies of a ContractBackend, so that the Funders and
+// Adjudicators of one ContractBackend can send transactions concurrently.
+type nonceManager struct {
+	mu    sync.Mutex
+	next  uint64 // next nonce, if valid
+	valid bool
+}
+
+// transact sends the transaction that is created by send with the next nonce
+// of our account. The nonce manager is locked until the transaction is sent,
+// so that nonces are assigned in order. If the node rejects the nonce as too
+// low, e.g., because the account was used by another program, the transaction
+// is retried with a new nonce.
+func (c *ContractBackend) transact(
+	ctx context.Context,
+	valueWei *big.Int,
+	gasLimit uint64,
+	send func(*bind.TransactOpts) (*types.Transaction, error),
+) (*types.Transaction, error) {
+	c.nonces.mu.Lock()
+	defer c.nonces.mu.Unlock()
+
+	for retry := 0; ; retry++ {
+		auth, err := c.newTransactor(ctx, valueWei, gasLimit)
+		if err != nil {
+			return nil, errors.WithMessage(err, "creating transactor")
+		}
+		// The pending nonce of the node might lag behind our sent transactions.
+		nonce := auth.Nonce.Uint64()
+		if c.nonces.valid && c.nonces.next > nonce {
+			nonce = c.nonces.next
+			auth.Nonce.SetUint64(nonce)
+		}
+
+		tx, err := send(auth)
+		if err == nil {
+			c.nonces.next, c.nonces.valid = nonce+1, true
+			return tx, nil
+		}
+		if !isNonceTooLow(err) || retry == maxNonceRetries {
+			// We don't know whether the nonce was used, so it is queried from
+			// the node again.
+			c.nonces.valid = false
+			return nil, errors.Wrap(err, "sending transaction")
+		}
+		log.WithField("account", c.account.Address).Warnf("Nonce %d too low, retrying.", nonce)
+		c.nonces.next, c.nonces.valid = nonce+1, true
+	}
+}
+
+// BumpFee replaces the pending transaction tx by a copy with the same nonce
+// and a gas price that is increased by ReplacementBump percent, or the current
+// gas price of the gas config if that is higher. It can be used to replace
+// transactions that are stuck because of a too low gas price. Only one of both
+// transactions is mined, so the caller should wait for either.
+//
+// The replacement is signed like tx, i.e., with replay protection if tx has
+// it. If the fee of the replacement would exceed the fee budget of the gas
+// config, nothing is sent and a FeeBudgetError is returned; tx then stays
+// pending with its old gas price.
+func (c *ContractBackend) BumpFee(ctx context.Context, tx *types.Transaction) (*types.Transaction, error) {
+	// The nonce manager is locked like in transact, so that the replacement is
+	// not sent concurrently with a transaction that gets the same nonce.
+	c.nonces.mu.Lock()
+	defer c.nonces.mu.Unlock()
+
+	price := new(big.Int).Mul(tx.GasPrice(), big.NewInt(100+ReplacementBump))
+	price.Div(price, big.NewInt(100))
+	if price.Cmp(tx.GasPrice()) <= 0 {
+		price.Add(tx.GasPrice(), big.NewInt(1))
+	}
+	current, err := c.gasPrice(ctx)
+	if err != nil {
+		return nil, err
+	} else if current.Cmp(price) > 0 {
+		price = current
+	}
+	if err := c.checkFee(tx.Gas(), price); err != nil {
+		return nil, errors.WithMessagef(err, "bumping fee of pending transaction %s", tx.Hash().Hex())
+	}
+
+	var replacement *types.Transaction
+	if tx.To() == nil {
+		replacement = types.NewContractCreation(tx.Nonce(), tx.Value(), tx.Gas(), price, tx.Data())
+	} else {
+		replacement = types.NewTransaction(tx.Nonce(), *tx.To(), tx.Value(), tx.Gas(), price, tx.Data())
+	}
+	var chainID *big.Int // the keystore signs without replay protection if nil
+	if tx.Protected() {
+		chainID = tx.ChainId()
+	}
+	signed, err := c.ks.SignTx(*c.account, replacement, chainID)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing replacement")
+	}
+	if err := c.ContractInterface.SendTransaction(ctx, signed); err != nil {
+		return nil, errors.Wrap(err, "sending replacement")
+	}
+	return signed, nil
+}
+
+// isNonceTooLow returns whether the node rejected a transaction because its
+// nonce was already used. The error is only available as string from RPC
+// nodes.
+func isNonceTooLow(err error) bool {
+	return strings.Contains(errors.Cause(err).Error(), "nonce too low")
+}
@@ -0,0 +1,247 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/backend/ethereum/channel/test"
+	"perun.network/go-perun/backend/ethereum/wallet"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	perunwallet "perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+// laggingBackend is a ContractInterface whose pending nonces are always zero,
+// like a node that did not see our transactions yet.
+type laggingBackend struct {
+	ContractInterface
+}
+
+func (laggingBackend) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	return 0, nil
+}
+
+// recordingBackend is a ContractInterface that records sent transactions
+// instead of sending them.
+type recordingBackend struct {
+	ContractInterface
+	sent []*types.Transaction
+}
+
+func (b *recordingBackend) SendTransaction(_ context.Context, tx *types.Transaction) error {
+	b.sent = append(b.sent, tx)
+	return nil
+}
+
+// nonceTooLowBackend is a ContractInterface that rejects all transactions
+// because of a too low nonce, like an RPC node.
+type nonceTooLowBackend struct {
+	ContractInterface
+	sends int
+}
+
+func (b *nonceTooLowBackend) SendTransaction(context.Context, *types.Transaction) error {
+	b.sends++
+	return errors.New("nonce too low")
+}
+
+func newFundedAccount(ctx context.Context, sim *test.SimulatedBackend, rng *rand.Rand) *accounts.Account {
+	acc := wallettest.NewRandomAccount(rng).(*wallet.Account).Account
+	sim.FundAddress(ctx, acc.Address)
+	return acc
+}
+
+// sendEther sends one wei to ourselves with the contract backend.
+func sendEther(ctx context.Context, cb *ContractBackend) (*types.Transaction, error) {
+	return cb.transact(ctx, big.NewInt(1), 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		tx, err := etherTx(auth)
+		if err != nil {
+			return nil, err
+		}
+		return tx, cb.SendTransaction(ctx, tx)
+	})
+}
+
+// etherTx returns the signed transaction of the transactor that sends its
+// value to ourselves.
+func etherTx(auth *bind.TransactOpts) (*types.Transaction, error) {
+	tx := types.NewTransaction(auth.Nonce.Uint64(), auth.From, auth.Value, 21000, auth.GasPrice, nil)
+	return auth.Signer(types.HomesteadSigner{}, auth.From, tx)
+}
+
+func TestContractBackend_ConcurrentTransactions(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	rng := rand.New(rand.NewSource(1))
+	acc := newFundedAccount(ctx, sim, rng)
+	cb := NewContractBackend(sim, ethwallettest.GetKeystore(), acc)
+	assetETH, err := DeployETHAssetholder(ctx, cb, acc.Address)
+	require.NoError(t, err)
+
+	// Funders and adjudicators of the same backend share its nonces.
+	parts := []perunwallet.Address{&wallet.Address{Address: acc.Address}}
+	const n = 10
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		params := channel.NewParamsUnsafe(0, parts, channeltest.NewRandomApp(rng).Def(), big.NewInt(rng.Int63()))
+		go func(params *channel.Params) {
+			defer wg.Done()
+			req := channel.FundingReq{Params: params, Allocation: newValidAllocation(parts, assetETH), Idx: 0}
+			assert.NoError(t, NewETHFunder(cb, assetETH).Fund(ctx, req))
+		}(params)
+	}
+	wg.Wait()
+}
+
+func TestContractBackend_NonceTooLow(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	acc := newFundedAccount(ctx, sim, rand.New(rand.NewSource(2)))
+	ks := ethwallettest.GetKeystore()
+	lagging := NewContractBackend(laggingBackend{sim}, ks, acc)
+	other := NewContractBackend(sim, ks, acc)
+
+	tx, err := sendEther(ctx, &lagging)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), tx.Nonce())
+	// The account is used by another backend, so the next nonce of the
+	// lagging backend is too low.
+	for i := 0; i < 2; i++ {
+		_, err := sendEther(ctx, &other)
+		require.NoError(t, err)
+	}
+	tx, err = sendEther(ctx, &lagging)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), tx.Nonce())
+	nonce, err := sim.PendingNonceAt(ctx, acc.Address)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), nonce)
+}
+
+func TestContractBackend_NonceUsedExternally(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	acc := newFundedAccount(ctx, sim, rand.New(rand.NewSource(4)))
+	cb := NewContractBackend(sim, ethwallettest.GetKeystore(), acc)
+
+	attempts := 0
+	tx, err := cb.transact(ctx, big.NewInt(1), 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		attempts++
+		tx, err := etherTx(auth)
+		if err != nil {
+			return nil, err
+		}
+		if attempts == 1 {
+			// Another program uses the nonce after it was queried, so the
+			// node reports it as too low.
+			require.NoError(t, sim.SendTransaction(ctx, tx))
+		}
+		return tx, cb.SendTransaction(ctx, tx)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, uint64(1), tx.Nonce())
+	nonce, err := sim.PendingNonceAt(ctx, acc.Address)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), nonce)
+
+	// The next transaction gets the next nonce.
+	tx, err = sendEther(ctx, &cb)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), tx.Nonce())
+}
+
+func TestContractBackend_NonceRetriesExceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	acc := newFundedAccount(ctx, sim, rand.New(rand.NewSource(5)))
+	backend := &nonceTooLowBackend{ContractInterface: sim}
+	cb := NewContractBackend(backend, ethwallettest.GetKeystore(), acc)
+
+	_, err := sendEther(ctx, &cb)
+	require.Error(t, err)
+	assert.True(t, isNonceTooLow(err))
+	assert.Equal(t, maxNonceRetries+1, backend.sends)
+	// The nonce is queried from the node again.
+	assert.False(t, cb.nonces.valid)
+}
+
+func TestContractBackend_BumpFee(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	sim := test.NewSimulatedBackend()
+	acc := newFundedAccount(ctx, sim, rand.New(rand.NewSource(3)))
+	backend := &recordingBackend{ContractInterface: sim}
+	cb := NewContractBackend(backend, ethwallettest.GetKeystore(), acc)
+	tx, err := sendEther(ctx, &cb)
+	require.NoError(t, err)
+
+	requireReplacement := func(old, tx *types.Transaction, price int64) {
+		assert.Equal(t, old.Nonce(), tx.Nonce())
+		assert.Equal(t, old.To(), tx.To())
+		assert.Equal(t, old.Value(), tx.Value())
+		assert.Equal(t, old.Gas(), tx.Gas())
+		assert.Equal(t, big.NewInt(price), tx.GasPrice())
+		sender, err := types.Sender(types.HomesteadSigner{}, tx)
+		require.NoError(t, err)
+		assert.Equal(t, acc.Address, sender)
+		assert.Equal(t, tx, backend.sent[len(backend.sent)-1])
+	}
+
+	// The gas price of the simulated backend is 1, which is bumped to 2.
+	bumped, err := cb.BumpFee(ctx, tx)
+	require.NoError(t, err)
+	requireReplacement(tx, bumped, 2)
+
+	bumped, err = cb.BumpFee(ctx, bumped)
+	require.NoError(t, err)
+	requireReplacement(tx, bumped, 3)
+
+	// The gas price of the pricer is used if it is higher.
+	cb.SetGasConfig(GasConfig{Pricer: StaticGasPrice{Price: big.NewInt(100)}})
+	bumped, err = cb.BumpFee(ctx, bumped)
+	require.NoError(t, err)
+	requireReplacement(tx, bumped, 100)
+
+	// A replay protected transaction is replaced by a replay protected one.
+	chainID := big.NewInt(1337)
+	protected, err := cb.ks.SignTx(*acc, types.NewTransaction(tx.Nonce(), acc.Address, tx.Value(), tx.Gas(), tx.GasPrice(), nil), chainID)
+	require.NoError(t, err)
+	bumped, err = cb.BumpFee(ctx, protected)
+	require.NoError(t, err)
+	assert.True(t, bumped.Protected())
+	sender, err := types.Sender(types.NewEIP155Signer(chainID), bumped)
+	require.NoError(t, err)
+	assert.Equal(t, acc.Address, sender)
+
+	// A replacement whose fee exceeds the budget is not sent.
+	sent := len(backend.sent)
+	cb.SetGasConfig(GasConfig{Pricer: StaticGasPrice{Price: big.NewInt(1)}, Budget: new(big.Int).Mul(big.NewInt(100), new(big.Int).SetUint64(tx.Gas()))})
+	_, err = cb.BumpFee(ctx, bumped)
+	assert.True(t, IsFeeBudgetError(err))
+	assert.Contains(t, err.Error(), bumped.Hash().Hex())
+	assert.Len(t, backend.sent, sent)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/backend/ethereum/bindings/adjudicator"
+	"perun.network/go-perun/channel"
+)
+
+// RegisteredSub is a subscription to the registrations of a channel on the
+// Adjudicator contract, i.e., to its Registered and Refuted events. The
+// participant who registered a state is not part of the events, so the Idx of
+// the subscribed registrations is always zero.
+type RegisteredSub struct {
+	events chan *channel.Registered
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+var _ channel.RegisteredSubscription = (*RegisteredSub)(nil)
+
+// SubscribeRegistered returns a subscription to the registrations of the
+// channel. The newest past registration, if any, is returned first by Next.
+// The subscription is closed when the context is done.
+func (a *Adjudicator) SubscribeRegistered(ctx context.Context, params *channel.Params) (channel.RegisteredSubscription, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	id := [][32]byte{params.ID()}
+	opts := &bind.WatchOpts{Context: ctx}
+
+	registered := make(chan *adjudicator.AdjudicatorRegistered)
+	regSub, err := a.contract.WatchRegistered(opts, registered, id)
+	if err != nil {
+		cancel()
+		return nil, errors.Wrap(err, "watching Registered events")
+	}
+	refuted := make(chan *adjudicator.AdjudicatorRefuted)
+	refSub, err := a.contract.WatchRefuted(opts, refuted, id)
+	if err != nil {
+		regSub.Unsubscribe()
+		cancel()
+		return nil, errors.Wrap(err, "watching Refuted events")
+	}
+	// Past events are filtered after watching new events, so that no event is
+	// missed.
+	past, err := a.latestRegistration(ctx, params)
+	if err != nil {
+		regSub.Unsubscribe()
+		refSub.Unsubscribe()
+		cancel()
+		return nil, err
+	}
+
+	sub := &RegisteredSub{
+		events: make(chan *channel.Registered),
+		cancel: cancel,
+	}
+	go func() {
+		defer close(sub.events)
+		defer regSub.Unsubscribe()
+		defer refSub.Unsubscribe()
+		sub.run(ctx, a, params, past, registered, refuted, regSub, refSub)
+	}()
+	return sub, nil
+}
+
+// run forwards the past registration and all new registrations to the events
+// of the subscription until the context is done or an error occurs.
+func (s *RegisteredSub) run(
+	ctx context.Context,
+	a *Adjudicator,
+	params *channel.Params,
+	past *registration,
+	registered <-chan *adjudicator.AdjudicatorRegistered,
+	refuted <-chan *adjudicator.AdjudicatorRefuted,
+	regSub, refSub event.Subscription,
+) {
+	var latest uint64
+	if past != nil {
+		latest = past.Version
+		if !s.send(ctx, &past.Registered) {
+			return
+		}
+	}
+
+	for {
+		var version *big.Int
+		var raw types.Log
+		select {
+		case ev := <-registered:
+			version, raw = ev.Version, ev.Raw
+		case ev := <-refuted:
+			version, raw = ev.Version, ev.Raw
+		case err := <-regSub.Err():
+			s.fail(ctx, errors.Wrap(err, "Registered subscription"))
+			return
+		case err := <-refSub.Err():
+			s.fail(ctx, errors.Wrap(err, "Refuted subscription"))
+			return
+		case <-ctx.Done():
+			s.fail(ctx, nil)
+			return
+		}
+
+		if past != nil && version.Uint64() <= latest {
+			continue // already sent as past registration
+		}
+		reg, err := a.newRegistration(ctx, params, version.Uint64(), raw)
+		if err != nil {
+			s.fail(ctx, err)
+			return
+		}
+		if !s.send(ctx, &reg.Registered) {
+			return
+		}
+	}
+}
+
+// send sends the registration on the events. It returns false if the context
+// is done before the registration is read.
+func (s *RegisteredSub) send(ctx context.Context, reg *channel.Registered) bool {
+	select {
+	case s.events <- reg:
+		return true
+	case <-ctx.Done():
+		s.fail(ctx, nil)
+		return false
+	}
+}
+
+// fail sets the error of the subscription, unless it was closed. If err is
+// nil, the context's error is used.
+func (s *RegisteredSub) fail(ctx context.Context, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	s.err = err
+}
+
+// Next returns the newest past registration or the next new registration. It
+// returns nil if the subscription is closed or failed.
+func (s *RegisteredSub) Next() *channel.Registered {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil
+	}
+	return <-s.events
+}
+
+// Err returns the error of the subscription after Next returned nil. It is nil
+// if the subscription was closed.
+func (s *RegisteredSub) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close closes the subscription.
+func (s *RegisteredSub) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	return nil
+}
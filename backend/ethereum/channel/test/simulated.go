@@ -9,7 +9,10 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"math/big"
+	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
 	"github.com/ethereum/go-ethereum/common"
@@ -27,6 +30,10 @@ type SimulatedBackend struct {
 	backends.SimulatedBackend
 	faucetKey  *ecdsa.PrivateKey
 	faucetAddr common.Address
+
+	// mu makes sending and mining a transaction atomic for gas estimations,
+	// which run on the pending state.
+	mu sync.Mutex
 }
 
 // NewSimulatedBackend creates a new Simulated Backend.
@@ -48,7 +55,28 @@ func NewSimulatedBackend() *SimulatedBackend {
 		faucetAddr:                       {Balance: new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(9))},
 	}
 	alloc := core.GenesisAlloc(addr)
-	return &SimulatedBackend{*backends.NewSimulatedBackend(alloc, 8000000), sk, faucetAddr}
+	return &SimulatedBackend{
+		SimulatedBackend: *backends.NewSimulatedBackend(alloc, 8000000),
+		faucetKey:        sk,
+		faucetAddr:       faucetAddr,
+	}
+}
+
+// MineBlocks mines n empty blocks.
+func (s *SimulatedBackend) MineBlocks(n int) {
+	for i := 0; i < n; i++ {
+		s.Commit()
+	}
+}
+
+// AdvanceTime mines a block whose timestamp is d after the timestamp of the
+// latest block. Note that every mined block advances the time by ten seconds.
+func (s *SimulatedBackend) AdvanceTime(d time.Duration) error {
+	if err := s.AdjustTime(d); err != nil {
+		return errors.WithStack(err)
+	}
+	s.Commit()
+	return nil
 }
 
 // BlockByNumber queries a block by its number.
@@ -63,8 +91,33 @@ func (s *SimulatedBackend) BlockByNumber(_ context.Context, number *big.Int) (*t
 	return block, nil
 }
 
-// SendTransaction executes a transaction.
+// EstimateGas estimates the gas of the call on the pending state. Pending
+// transactions are always mined, so that the estimation sees the same state as
+// the logs of the mined blocks.
+func (s *SimulatedBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.SimulatedBackend.EstimateGas(ctx, call)
+}
+
+// SendTransaction executes a transaction and mines it. Like a node, it rejects
+// transactions whose nonce is not the next nonce of the sender, instead of
+// panicking like the go-ethereum simulated backend.
 func (s *SimulatedBackend) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sender, err := types.Sender(types.NewEIP155Signer(big.NewInt(1337)), tx)
+	if err != nil {
+		return errors.Wrap(err, "invalid transaction")
+	}
+	nonce, err := s.SimulatedBackend.PendingNonceAt(ctx, sender)
+	if err != nil {
+		return errors.WithStack(err)
+	} else if tx.Nonce() < nonce {
+		return errors.Errorf("nonce too low: got %d, want %d", tx.Nonce(), nonce)
+	} else if tx.Nonce() > nonce {
+		return errors.Errorf("nonce too high: got %d, want %d", tx.Nonce(), nonce)
+	}
 	if err := s.SimulatedBackend.SendTransaction(ctx, tx); err != nil {
 		return errors.WithStack(err)
 	}
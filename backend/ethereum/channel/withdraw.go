@@ -0,0 +1,181 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/backend/ethereum/bindings/assets"
+	"perun.network/go-perun/backend/ethereum/wallet"
+	"perun.network/go-perun/channel"
+)
+
+// Withdraw concludes the registered state of the request, so that its outcome
+// is set on the asset holders, and then withdraws our funds from all asset
+// holders. Final states are concluded directly without registration. If the
+// channel was already concluded, e.g., by a peer, only the funds are
+// withdrawn. Like Register, Withdraw does not support sub-channels.
+func (a *Adjudicator) Withdraw(ctx context.Context, req channel.AdjudicatorReq) error {
+	if len(req.SubChannels) > 0 {
+		return errSubChannels
+	}
+	if err := a.ensureConcluded(ctx, req); err != nil {
+		return errors.WithMessage(err, "concluding channel")
+	}
+	for i, asset := range req.Tx.Allocation.Assets {
+		if err := a.withdraw(ctx, req, asset.(*Asset)); err != nil {
+			return errors.WithMessagef(err, "withdrawing asset %d", i)
+		}
+	}
+	return nil
+}
+
+// ensureConcluded concludes the channel unless it is already concluded.
+func (a *Adjudicator) ensureConcluded(ctx context.Context, req channel.AdjudicatorReq) error {
+	if concluded, _, err := a.isConcluded(ctx, req.Params.ID()); err != nil {
+		return err
+	} else if concluded {
+		return nil
+	}
+
+	if err := a.conclude(ctx, req); err != nil {
+		// A peer might have concluded concurrently.
+		if concluded, _, cerr := a.isConcluded(ctx, req.Params.ID()); cerr == nil && concluded {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// isConcluded returns whether there is a FinalConcluded or Concluded event for
+// the channel and whether it is a FinalConcluded event. Only the blocks since
+// the last query are filtered.
+func (a *Adjudicator) isConcluded(ctx context.Context, id channel.ID) (concluded, final bool, err error) {
+	opts, err := a.filterOpts(ctx, a.concStart, id)
+	if err != nil {
+		return false, false, err
+	}
+	finalConc, err := a.contract.FilterFinalConcluded(opts, [][32]byte{id})
+	if err != nil {
+		return false, false, errors.Wrap(err, "filtering FinalConcluded events")
+	}
+	defer finalConc.Close()
+	if finalConc.Next() {
+		a.setFilterStart(a.concStart, id, finalConc.Event.Raw.BlockNumber)
+		return true, true, nil
+	} else if err := finalConc.Error(); err != nil {
+		return false, false, errors.Wrap(err, "iterating FinalConcluded events")
+	}
+
+	conc, err := a.contract.FilterConcluded(opts, [][32]byte{id})
+	if err != nil {
+		return false, false, errors.Wrap(err, "filtering Concluded events")
+	}
+	defer conc.Close()
+	if conc.Next() {
+		a.setFilterStart(a.concStart, id, conc.Event.Raw.BlockNumber)
+		return true, false, nil
+	} else if err := conc.Error(); err != nil {
+		return false, false, errors.Wrap(err, "iterating Concluded events")
+	}
+	a.setFilterStart(a.concStart, id, *opts.End+1)
+	return false, false, nil
+}
+
+// conclude concludes the state of the request. A final state is concluded
+// with ConcludeFinal, any other state must be the registered state, whose
+// timeout must have passed.
+func (a *Adjudicator) conclude(ctx context.Context, req channel.AdjudicatorReq) error {
+	params := channelParamsToEthParams(req.Params)
+	state := channelStateToEthState(req.Tx.State)
+	var reg *registration
+	if !req.Tx.IsFinal {
+		var err error
+		if reg, err = a.latestRegistration(ctx, req.Params); err != nil {
+			return err
+		} else if reg == nil {
+			return errors.New("channel not registered")
+		} else if reg.Version != req.Tx.Version {
+			return errors.Errorf("version %d registered, but concluding version %d", reg.Version, req.Tx.Version)
+		}
+	}
+
+	tx, err := a.transact(ctx, big.NewInt(0), 0, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		if req.Tx.IsFinal {
+			return a.contract.ConcludeFinal(auth, params, state, req.Tx.Sigs)
+		}
+		return a.contract.Conclude(auth, params, state, reg.timeout, phaseDispute)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.WithMessage(execSuccessful(ctx, a.ContractBackend, tx), "mining transaction")
+}
+
+// withdraw withdraws our holdings of the channel from the given asset holder to
+// the on-chain account of the adjudicator. Nothing is withdrawn if the
+// holdings are zero, e.g., because they were already withdrawn.
+func (a *Adjudicator) withdraw(ctx context.Context, req channel.AdjudicatorReq, asset *Asset) error {
+	contract, err := assets.NewAssetHolder(asset.Address, a)
+	if err != nil {
+		return errors.Wrap(err, "connecting to asset holder")
+	}
+	fundingID := calcFundingIDs(req.Params.Parts, req.Params.ID())[req.Idx]
+	amount, err := contract.Holdings(&bind.CallOpts{Context: ctx}, fundingID)
+	if err != nil {
+		return errors.Wrap(err, "querying holdings")
+	} else if amount.Sign() == 0 {
+		return nil
+	}
+
+	auth := assets.AssetHolderWithdrawalAuth{
+		ChannelID:   req.Params.ID(),
+		Participant: req.Params.Parts[req.Idx].(*wallet.Address).Address,
+		Receiver:    a.account.Address,
+		Amount:      amount,
+	}
+	enc, err := encodeWithdrawalAuth(&auth)
+	if err != nil {
+		return err
+	}
+	sig, err := req.Acc.SignData(enc)
+	if err != nil {
+		return errors.WithMessage(err, "signing withdrawal authorization")
+	}
+
+	tx, err := a.transact(ctx, big.NewInt(0), 0, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return contract.Withdraw(opts, auth, sig)
+	})
+	if err != nil {
+		return err
+	}
+	return errors.WithMessage(execSuccessful(ctx, a.ContractBackend, tx), "mining transaction")
+}
+
+// encodeWithdrawalAuth encodes the withdrawal authorization as with abi.encode()
+// in the smart contracts.
+func encodeWithdrawalAuth(auth *assets.AssetHolderWithdrawalAuth) ([]byte, error) {
+	args := abi.Arguments{
+		{Type: abiBytes32},
+		{Type: abiAddress},
+		{Type: abiAddress},
+		{Type: abiUint256},
+	}
+	enc, err := args.Pack(
+		auth.ChannelID,
+		auth.Participant,
+		auth.Receiver,
+		auth.Amount,
+	)
+	return enc, errors.WithStack(err)
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	ethchannel "perun.network/go-perun/backend/ethereum/channel"
+	ethclienttest "perun.network/go-perun/backend/ethereum/client/test"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+const challengeDuration = 60 // seconds
+
+// TestDisputeETH settles a channel in a dispute through the Ethereum
+// adjudicators of the setup: a non-final state is registered, the challenge
+// duration passes on the simulated chain and then the channel is concluded
+// and both participants withdraw their funds.
+func TestDisputeETH(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	rng := rand.New(rand.NewSource(0xd15b07e))
+	s := ethclienttest.NewSetup(t, rng, 2)
+
+	parts := []wallet.Address{s.Accs[0].Address(), s.Accs[1].Address()}
+	params := channel.NewParamsUnsafe(challengeDuration, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	initAlloc := &channel.Allocation{
+		Assets:  []channel.Asset{&ethchannel.Asset{Address: s.AssetHolder}},
+		OfParts: [][]channel.Bal{{big.NewInt(100)}, {big.NewInt(100)}},
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(s.Funders))
+	for i, f := range s.Funders {
+		go func(i int, f *ethchannel.Funder) {
+			defer wg.Done()
+			req := channel.FundingReq{Params: params, Allocation: initAlloc, Idx: channel.Index(i)}
+			assert.NoError(t, f.Fund(ctx, req), "funding of participant %d", i)
+		}(i, f)
+	}
+	wg.Wait()
+
+	// In the disputed state, Alice sent 30 to Bob.
+	state := &channel.State{
+		ID:      params.ID(),
+		Version: 3,
+		App:     params.App,
+		Allocation: channel.Allocation{
+			Assets:  initAlloc.Assets,
+			OfParts: [][]channel.Bal{{big.NewInt(70)}, {big.NewInt(130)}},
+		},
+		Data: new(payment.NoData),
+	}
+	tx := channel.Transaction{State: state, Sigs: make([]wallet.Sig, len(s.Accs))}
+	for i, acc := range s.Accs {
+		var err error
+		tx.Sigs[i], err = ethchannel.Sign(acc, params, state)
+		require.NoError(t, err)
+	}
+	req := func(i int) channel.AdjudicatorReq {
+		return channel.AdjudicatorReq{Params: params, Acc: s.Accs[i], Idx: channel.Index(i), Tx: tx}
+	}
+
+	reg, err := s.Adjs[0].Register(ctx, req(0))
+	require.NoError(t, err)
+	assert.Equal(t, state.Version, reg.Version)
+	assert.False(t, reg.Concluded)
+
+	// The channel cannot be concluded before the timeout passed on-chain.
+	assert.Error(t, s.Adjs[1].Withdraw(ctx, req(1)))
+	require.NoError(t, s.SimBackend.AdvanceTime(challengeDuration*time.Second))
+
+	for i, adj := range s.Adjs {
+		// The transactions are free, so that the balances only change by the
+		// withdrawn amounts.
+		adj.SetGasConfig(ethchannel.GasConfig{Margin: ethchannel.DefaultGasMargin, Pricer: ethchannel.StaticGasPrice{Price: big.NewInt(0)}})
+		addr := s.Accs[i].Account.Address
+		before, err := s.SimBackend.BalanceAt(ctx, addr, nil)
+		require.NoError(t, err)
+		require.NoError(t, adj.Withdraw(ctx, req(i)), "withdrawal of participant %d", i)
+		after, err := s.SimBackend.BalanceAt(ctx, addr, nil)
+		require.NoError(t, err)
+		withdrawn := new(big.Int).Sub(after, before)
+		bal := state.Allocation.OfParts[i][0]
+		assert.Zero(t, bal.Cmp(withdrawn), "participant %d withdrew %v instead of %v", i, withdrawn, bal)
+	}
+}
@@ -6,24 +6,18 @@
 package client_test
 
 import (
-	"context"
 	"math/big"
 	"math/rand"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/stretchr/testify/require"
-
-	"perun.network/go-perun/backend/ethereum/channel"
-	"perun.network/go-perun/backend/ethereum/channel/test"
+	ethclienttest "perun.network/go-perun/backend/ethereum/client/test"
 	"perun.network/go-perun/backend/ethereum/wallet"
-	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
 	clienttest "perun.network/go-perun/client/test"
 	"perun.network/go-perun/log"
 	"perun.network/go-perun/peer"
 	peertest "perun.network/go-perun/peer/test"
-	wallettest "perun.network/go-perun/wallet/test"
 )
 
 var defaultTimeout = 5 * time.Second
@@ -32,43 +26,19 @@ func TestHappyAliceBobETH(t *testing.T) {
 	log.Info("Starting happy test")
 	var hub peertest.ConnHub
 	rng := rand.New(rand.NewSource(0x1337))
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
-
-	// Create a new KeyStore
-	ks := ethwallettest.GetKeystore()
-	// Create alice and bobs account
-	aliceAcc := wallettest.NewRandomAccount(rng)
-	bobAcc := wallettest.NewRandomAccount(rng)
-	aliceAccETH := aliceAcc.(*wallet.Account).Account
-	bobAccETH := bobAcc.(*wallet.Account).Account
-	// Create SimulatedBackend
-	backend := test.NewSimulatedBackend()
-	// Fund both accounts
-	backend.FundAddress(ctx, aliceAccETH.Address)
-	backend.FundAddress(ctx, bobAccETH.Address)
-	// Create contract backends
-	cbAlice := channel.NewContractBackend(backend, ks, aliceAccETH)
-	cbBob := channel.NewContractBackend(backend, ks, bobAccETH)
-	// Deploy the contracts
-	adjAddr, err := channel.DeployAdjudicator(ctx, cbAlice)
-	require.NoError(t, err, "Adjudicator should deploy successful")
-	assetAddr, err := channel.DeployETHAssetholder(ctx, cbAlice, adjAddr)
-	require.NoError(t, err, "ETHAssetholder should deploy successful")
-	// Create the funders
-	funderAlice := channel.NewETHFunder(cbAlice, assetAddr)
-	funderBob := channel.NewETHFunder(cbBob, assetAddr)
-	// Create the settlers
-	adjudicatorAlice := &DummyAdjudicator{t}
-	adjudicatorBob := &DummyAdjudicator{t}
 
+	// Create the simulated chain with the contracts and alice and bobs funded
+	// accounts.
+	s := ethclienttest.NewSetup(t, rng, 2)
+	aliceAcc, bobAcc := s.Accs[0], s.Accs[1]
+	assetAddr := s.AssetHolder
 	setupAlice := clienttest.RoleSetup{
 		Name:        "Alice",
 		Identity:    aliceAcc,
 		Dialer:      hub.NewDialer(),
 		Listener:    hub.NewListener(aliceAcc.Address()),
-		Funder:      funderAlice,
-		Adjudicator: adjudicatorAlice,
+		Funder:      s.Funders[0],
+		Adjudicator: s.Adjs[0],
 		Timeout:     defaultTimeout,
 	}
 
@@ -77,8 +47,8 @@ func TestHappyAliceBobETH(t *testing.T) {
 		Identity:    bobAcc,
 		Dialer:      hub.NewDialer(),
 		Listener:    hub.NewListener(bobAcc.Address()),
-		Funder:      funderBob,
-		Adjudicator: adjudicatorBob,
+		Funder:      s.Funders[1],
+		Adjudicator: s.Adjs[1],
 		Timeout:     defaultTimeout,
 	}
 
@@ -0,0 +1,72 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package test contains an offline Ethereum environment for client tests.
+package test // import "perun.network/go-perun/backend/ethereum/client/test"
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/backend/ethereum/channel"
+	channeltest "perun.network/go-perun/backend/ethereum/channel/test"
+	"perun.network/go-perun/backend/ethereum/wallet"
+	ethwallettest "perun.network/go-perun/backend/ethereum/wallet/test"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+// compile time check that the simulated backend can be used by the contract
+// backends
+var _ channel.ContractInterface = (*channeltest.SimulatedBackend)(nil)
+
+const setupTimeout = 10 * time.Second
+
+// Setup is an Ethereum environment on an in-process simulated blockchain, so
+// that tests run without network access. The Adjudicator and AssetHolderETH
+// contracts are deployed and every participant has a funded account with its
+// own funder and adjudicator.
+type Setup struct {
+	SimBackend  *channeltest.SimulatedBackend
+	Adjudicator common.Address // address of the Adjudicator contract
+	AssetHolder common.Address // address of the AssetHolderETH contract
+
+	Accs    []*wallet.Account
+	CBs     []channel.ContractBackend
+	Funders []*channel.Funder
+	Adjs    []*channel.Adjudicator
+}
+
+// NewSetup creates a new Setup with n participants. The contracts are
+// deployed by the first participant.
+func NewSetup(t *testing.T, rng *rand.Rand, n int) *Setup {
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	ks := ethwallettest.GetKeystore()
+	s := &Setup{SimBackend: channeltest.NewSimulatedBackend()}
+
+	for i := 0; i < n; i++ {
+		acc := wallettest.NewRandomAccount(rng).(*wallet.Account)
+		s.SimBackend.FundAddress(ctx, acc.Account.Address)
+		s.Accs = append(s.Accs, acc)
+		s.CBs = append(s.CBs, channel.NewContractBackend(s.SimBackend, ks, acc.Account))
+	}
+
+	var err error
+	s.Adjudicator, err = channel.DeployAdjudicator(ctx, s.CBs[0])
+	require.NoError(t, err, "deploying Adjudicator")
+	s.AssetHolder, err = channel.DeployETHAssetholder(ctx, s.CBs[0], s.Adjudicator)
+	require.NoError(t, err, "deploying AssetHolderETH")
+
+	for _, cb := range s.CBs {
+		s.Funders = append(s.Funders, channel.NewETHFunder(cb, s.AssetHolder))
+		s.Adjs = append(s.Adjs, channel.NewAdjudicator(cb, s.Adjudicator))
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+pragma solidity ^0.5.13;
+pragma experimental ABIEncoderV2;
+
+import "../vendor/openzeppelin-contracts/contracts/cryptography/ECDSA.sol";
+import "../vendor/openzeppelin-contracts/contracts/math/SafeMath.sol";
+import "../vendor/openzeppelin-contracts/contracts/token/ERC20/IERC20.sol";
+import "../vendor/openzeppelin-contracts/contracts/token/ERC20/SafeERC20.sol";
+import "../vendor/openzeppelin-contracts/contracts/utils/Address.sol";
+import "./AssetHolder.sol";
+
+/**
+ * @title The Perun AssetHolderERC20
+ * @notice AssetHolderERC20 is a concrete implementation of the abstract
+ * AssetHolder which holds ERC20 tokens. Outcomes, including sub-allocations,
+ * are set by the AssetHolder like for AssetHolderETH.
+ */
+contract AssetHolderERC20 is AssetHolder {
+    using SafeMath for uint256;
+    using SafeERC20 for IERC20;
+
+    IERC20 public token;
+
+    /**
+     * @notice Sets the adjudicator contract and the token that is held.
+     * @param _adjudicator Address of the adjudicator contract.
+     * @param _token Address of the ERC20 token contract.
+     */
+    constructor(address _adjudicator, address _token) public {
+        require(Address.isContract(_token), "token is not a contract");
+        adjudicator = _adjudicator;
+        token = IERC20(_token);
+    }
+
+    /**
+     * @notice Deposits tokens for the given funding ID. The tokens are
+     * transferred from the sender, who must have approved the transfer.
+     * @param fundingID Unique identifier for a participant in a channel.
+     * Calculated as the hash of the channel id and the participant address.
+     * @param amount Amount of tokens that should be deposited.
+     */
+    function deposit(bytes32 fundingID, uint256 amount) external payable {
+        require(msg.value == 0, "ether not accepted");
+        holdings[fundingID] = holdings[fundingID].add(amount);
+        token.safeTransferFrom(msg.sender, address(this), amount);
+        emit Deposited(fundingID, amount);
+    }
+
+    /**
+     * @notice Sends the tokens of a participant of a settled channel to the
+     * receiver of the authorization.
+     * @param authorization WithdrawalAuth that specifies the amount and the
+     * receiver of the withdrawal.
+     * @param signature Signature of the participant over the authorization.
+     */
+    function withdraw(WithdrawalAuth memory authorization, bytes memory signature) public {
+        require(settled[authorization.channelID], "channel not settled");
+        bytes32 hash = ECDSA.toEthSignedMessageHash(keccak256(abi.encode(authorization)));
+        require(ECDSA.recover(hash, signature) == authorization.participant, "signature verification failed");
+        bytes32 id = keccak256(abi.encodePacked(authorization.channelID, authorization.participant));
+        require(holdings[id] >= authorization.amount, "insufficient tokens");
+        holdings[id] = holdings[id].sub(authorization.amount);
+        token.safeTransfer(authorization.receiver, authorization.amount);
+    }
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+pragma solidity ^0.5.13;
+
+import "../vendor/openzeppelin-contracts/contracts/token/ERC20/ERC20.sol";
+
+/**
+ * @title The Perun test token
+ * @notice PerunToken is an ERC20 token for tests. Like some deployed tokens,
+ * e.g., Tether USD, it rejects approvals that change a non-zero allowance to
+ * another non-zero value.
+ */
+contract PerunToken is ERC20 {
+    /**
+     * @notice Mints initBals tokens for each of the initAccs.
+     */
+    constructor(address[] memory initAccs, uint256 initBals) public {
+        for (uint256 i = 0; i < initAccs.length; i++) {
+            _mint(initAccs[i], initBals);
+        }
+    }
+
+    function approve(address spender, uint256 amount) public returns (bool) {
+        require(amount == 0 || allowance(msg.sender, spender) == 0, "approve from non-zero allowance");
+        return super.approve(spender, amount);
+    }
+}
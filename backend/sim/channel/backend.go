@@ -19,13 +19,15 @@ import (
 	"perun.network/go-perun/wire"
 )
 
-// backend implements the utility interface defined in the channel package.
-type backend struct{}
+// Backend implements the utility interface defined in the channel package.
+// It is set as global channel backend on import, but can also be passed to
+// clients explicitly, see channel.Backends.
+type Backend struct{}
 
-var _ channel.Backend = new(backend)
+var _ channel.Backend = new(Backend)
 
 // CalcID calculates a channel's ID by hashing all fields of its parameters
-func (*backend) CalcID(p *channel.Params) channel.ID {
+func (*Backend) CalcID(p *channel.Params) channel.ID {
 	w := sha256.New()
 
 	// Write ChallengeDuration
@@ -58,7 +60,7 @@ func (*backend) CalcID(p *channel.Params) channel.ID {
 }
 
 // Sign signs `state`
-func (b *backend) Sign(addr wallet.Account, params *channel.Params, state *channel.State) ([]byte, error) {
+func (b *Backend) Sign(addr wallet.Account, params *channel.Params, state *channel.State) ([]byte, error) {
 	log.Tracef("Signing state %s version %d", string(state.ID[:]), state.Version)
 
 	buff := new(bytes.Buffer)
@@ -76,7 +78,7 @@ func (b *backend) Sign(addr wallet.Account, params *channel.Params, state *chann
 }
 
 // Verify verifies the signature for `state`
-func (b *backend) Verify(addr wallet.Address, params *channel.Params, state *channel.State, sig []byte) (bool, error) {
+func (b *Backend) Verify(addr wallet.Address, params *channel.Params, state *channel.State, sig []byte) (bool, error) {
 	if err := state.Valid(); err != nil {
 		return false, errors.Wrap(err, "Cannot verify invalid state")
 	}
@@ -97,7 +99,7 @@ func (b *backend) Verify(addr wallet.Address, params *channel.Params, state *cha
 }
 
 // encodeState packs all fields of a State into a []byte
-func (b *backend) encodeState(s channel.State, w io.Writer) error {
+func (b *Backend) encodeState(s channel.State, w io.Writer) error {
 	// Write ID
 	if err := wire.ByteSlice(s.ID[:]).Encode(w); err != nil {
 		return errors.WithMessage(err, "state id encode")
@@ -125,7 +127,7 @@ func (b *backend) encodeState(s channel.State, w io.Writer) error {
 }
 
 // encodeAllocation Writes all fields of `a` to `w`
-func (b *backend) encodeAllocation(w io.Writer, a channel.Allocation) error {
+func (b *Backend) encodeAllocation(w io.Writer, a channel.Allocation) error {
 	// Write Assets
 	for _, asset := range a.Assets {
 		if err := asset.Encode(w); err != nil {
@@ -149,7 +151,7 @@ func (b *backend) encodeAllocation(w io.Writer, a channel.Allocation) error {
 }
 
 // encodeSubAlloc Writes all fields of `s` to `w`
-func (b *backend) encodeSubAlloc(w io.Writer, s channel.SubAlloc) error {
+func (b *Backend) encodeSubAlloc(w io.Writer, s channel.SubAlloc) error {
 	// Write ID
 	if err := wire.ByteSlice(s.ID[:]).Encode(w); err != nil {
 		return errors.WithMessage(err, "ID encode")
@@ -162,7 +164,7 @@ func (b *backend) encodeSubAlloc(w io.Writer, s channel.SubAlloc) error {
 	return nil
 }
 
-func (*backend) encodeBals(w io.Writer, bals []channel.Bal) error {
+func (*Backend) encodeBals(w io.Writer, bals []channel.Bal) error {
 	for _, bal := range bals {
 		if err := wire.Encode(w, bal); err != nil {
 			return errors.WithMessage(err, "bal encode")
@@ -172,7 +174,7 @@ func (*backend) encodeBals(w io.Writer, bals []channel.Bal) error {
 	return nil
 }
 
-func (*backend) DecodeAsset(r io.Reader) (channel.Asset, error) {
+func (*Backend) DecodeAsset(r io.Reader) (channel.Asset, error) {
 	var asset Asset
 	return &asset, asset.Decode(r)
 }
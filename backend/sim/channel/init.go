@@ -13,6 +13,6 @@ import (
 )
 
 func init() {
-	channel.SetBackend(new(backend))
+	channel.SetBackend(new(Backend))
 	test.SetRandomizer(new(randomizer))
 }
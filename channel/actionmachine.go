@@ -6,6 +6,8 @@
 package channel
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 
 	"perun.network/go-perun/wallet"
@@ -40,6 +42,27 @@ func NewActionMachine(acc wallet.Account, params Params) (*ActionMachine, error)
 	}, nil
 }
 
+// RestoreActionMachine restores an action machine to the data given by
+// Source. It is used to restore channels from persistent storage after a
+// restart.
+func RestoreActionMachine(acc wallet.Account, source Source) (*ActionMachine, error) {
+	app, ok := source.Params().App.(ActionApp)
+	if !ok {
+		return nil, errors.New("app must be ActionApp")
+	}
+
+	m, err := restoreMachine(acc, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ActionMachine{
+		machine:        m,
+		app:            app,
+		stagingActions: make([]Action, m.N()),
+	}, nil
+}
+
 var actionPhases = []Phase{InitActing, Acting}
 
 // AddAction adds the action of participant idx to the staging actions.
@@ -68,6 +91,17 @@ func (m *ActionMachine) AddAction(idx Index, a Action) error {
 	return nil
 }
 
+// DiscardActions clears all staged actions. It is used if collecting the
+// actions of a round failed, e.g., because a participant's action was invalid.
+func (m *ActionMachine) DiscardActions() error {
+	if !inPhase(m.phase, actionPhases) {
+		return m.phaseErrorf(m.selfTransition(), "can only discard actions in an action phase")
+	}
+
+	m.stagingActions = make([]Action, m.N())
+	return nil
+}
+
 // Init creates the initial state as the combination of all initial actions.
 func (m *ActionMachine) Init() error {
 	if err := m.expect(PhaseTransition{InitActing, InitSigning}); err != nil {
@@ -87,22 +121,58 @@ func (m *ActionMachine) Init() error {
 	return nil
 }
 
+// InitFromState sets the initial staging state to the given balances and
+// data instead of creating it from the initial actions. It is used if all
+// participants already agreed on the initial state, e.g., in the channel
+// proposal protocol.
+func (m *ActionMachine) InitFromState(initBals Allocation, initData Data) error {
+	if err := m.expect(PhaseTransition{InitActing, InitSigning}); err != nil {
+		return err
+	}
+
+	initState, err := newState(&m.params, initBals, initData)
+	if err != nil {
+		return err
+	}
+
+	m.setStaging(InitSigning, initState)
+	return nil
+}
+
 // Update applies all staged actions to the current state to create the new
-// staging state for signing.
-func (m *ActionMachine) Update() error {
+// staging state for signing. The new state gets the given version, which must
+// be at least NextVersion. All participants must agree on the version, since it
+// may be higher than the current version plus one if an earlier update failed.
+func (m *ActionMachine) Update(version uint64) error {
 	if err := m.expect(PhaseTransition{Acting, Signing}); err != nil {
 		return err
 	}
+	if version < m.NextVersion() {
+		return NewStateTransitionError(m.params.id,
+			fmt.Sprintf("version must be at least %d", m.NextVersion()))
+	}
 
 	stagingState, err := m.app.ApplyActions(&m.params, m.currentTX.State, m.stagingActions)
 	if err != nil {
 		return err
 	}
+	stagingState.Version = version
 
 	m.setStaging(Signing, stagingState)
 	return nil
 }
 
+// SyncCurrentTX promotes the given transaction to the current transaction as
+// described for the StateMachine. Additionally, all staged actions are
+// cleared, as they refer to an outdated state.
+func (m *ActionMachine) SyncCurrentTX(tx Transaction) error {
+	if err := m.machine.SyncCurrentTX(tx); err != nil {
+		return err
+	}
+	m.stagingActions = make([]Action, m.N())
+	return nil
+}
+
 // setStaging sets the current staging phase and state and additionally clears
 // the staging actions
 func (m *ActionMachine) setStaging(phase Phase, state *State) {
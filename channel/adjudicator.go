@@ -21,6 +21,11 @@ type (
 	// Furthermore, it has a method for subscribing to Registered events. Those
 	// events might be triggered by a Register call on the adjudicator from any
 	// channel participant.
+	//
+	// Sub-channels and virtual channels, which are funded from the locked
+	// sub-allocation of a parent channel, are never withdrawn themselves. They
+	// are settled off-chain into their parent channel or registered together
+	// with it, see AdjudicatorReq.SubChannels.
 	Adjudicator interface {
 		// Register should register the given channel state on-chain. It must be
 		// taken into account that a peer might already have registered the same or
@@ -45,20 +50,55 @@ type (
 
 	// An AdjudicatorReq collects all necessary information to make calls to the
 	// adjudicator.
+	//
+	// SubChannels are the signed states of the sub-channels and virtual
+	// channels that are funded by the locked sub-allocations of Tx.State,
+	// including their own sub-channels. They are registered together with the
+	// channel, so that the locked sub-allocations are paid out according to
+	// them. Adjudicators that cannot register sub-channels must return an error
+	// if SubChannels is not empty.
 	AdjudicatorReq struct {
+		Params      *Params
+		Acc         wallet.Account
+		Tx          Transaction
+		Idx         Index
+		SubChannels []SubChannel
+	}
+
+	// A SignedState is a state of a channel together with the parameters of
+	// the channel and the signatures of all participants on the state.
+	SignedState struct {
 		Params *Params
-		Acc    wallet.Account
-		Tx     Transaction
-		Idx    Index
+		State  *State
+		Sigs   []wallet.Sig
+	}
+
+	// A SubChannel is the signed state of a sub-channel or virtual channel
+	// that is funded by the locked sub-allocation with its channel ID in the
+	// state of the parent channel. Idxs are the indices of the sub-channel
+	// participants in the parent channel, to which their balances are paid out.
+	//
+	// A virtual channel is funded by two parent channels, which are registered
+	// separately. Its states are registered by its channel ID, so that the
+	// newest registered version is paid out by both parents.
+	SubChannel struct {
+		SignedState
+		Parent ID
+		Idxs   []Index
 	}
 
 	// Registered is the abstract event that signals a successful state
 	// registration on the blockchain.
+	//
+	// Concluded is set by adjudicators that conclude final states directly
+	// instead of registering them, and for registrations of channels that are
+	// already concluded. The Timeout is not set for concluded final states.
 	Registered struct {
-		ID      ID        // Channel ID
-		Idx     Index     // Index of the participant who registered the event.
-		Version uint64    // Registered version.
-		Timeout time.Time // Timeout when the event can be concluded or progressed
+		ID        ID        // Channel ID
+		Idx       Index     // Index of the participant who registered the event.
+		Version   uint64    // Registered version.
+		Timeout   time.Time // Timeout when the event can be concluded or progressed
+		Concluded bool      // Whether the state can be concluded without timeout
 	}
 
 	// A RegisteredSubscription is a subscription to Registered events for a
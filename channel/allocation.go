@@ -146,8 +146,14 @@ func (a Allocation) Encode(w io.Writer) error {
 	return nil
 }
 
-// Decode decodes an allocation from an io.Reader.
+// Decode decodes an allocation from an io.Reader, using the backends of the
+// decoding context of the reader, see DecodingBackends.
 func (a *Allocation) Decode(r io.Reader) error {
+	return a.decode(r, DecodingBackends(r))
+}
+
+// decode decodes an allocation using the backends b for its assets.
+func (a *Allocation) decode(r io.Reader, b Backends) error {
 	// decode dimensions
 	var numAssets, numParts, numLocked Index
 	if err := wire.Decode(r, &numAssets, &numParts, &numLocked); err != nil {
@@ -159,7 +165,7 @@ func (a *Allocation) Decode(r io.Reader) error {
 	// decode assets
 	a.Assets = make([]Asset, numAssets)
 	for i := 0; i < len(a.Assets); i++ {
-		asset, err := DecodeAsset(r)
+		asset, err := b.DecodeAsset(r)
 		if err != nil {
 			return errors.WithMessagef(err, "decoding error for asset %d", i)
 		}
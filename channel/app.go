@@ -48,6 +48,18 @@ type (
 		ValidInit(*Params, *State) error
 	}
 
+	// A LockingApp is a StateApp that locks funds of the participants in its
+	// own sub-allocations of State.Locked, e.g., for conditional payments.
+	// Unlike the sub-allocations of sub-channels, they may be changed by any
+	// update, so ValidTransition has to check them.
+	LockingApp interface {
+		StateApp
+
+		// OwnsSubAlloc returns whether the locked sub-allocation with the given
+		// ID is managed by the app instead of funding a sub-channel.
+		OwnsSubAlloc(ID) bool
+	}
+
 	// An ActionApp is advanced by first collecting actions from the participants
 	// and then applying those actions to the state. In a sense it is a more
 	// fine-grained version of a StateApp and allows for more optimized
@@ -64,7 +76,9 @@ type (
 
 		// ApplyAction applies the given actions to the provided channel state and
 		// returns the resulting new state.
-		// The version counter should be increased by one.
+		// The version counter should be increased by one. The machine may set a
+		// higher version afterwards if an earlier update failed, see
+		// ActionMachine.Update.
 		// The implementation should return an ActionError describing the invalidity
 		// of the action. It should return a normal error (with attached stacktrace
 		// from pkg/errors) if there was any other runtime error, not related to the
@@ -0,0 +1,156 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"io"
+	"log"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// Backends bundles the channel, wallet and app backend that are used for a
+// channel. It is passed to the Params of a channel, which use it for the
+// channel ID and for signing and verifying states, and can be used to decode
+// states.
+//
+// Nil backends fall back to the global backends that are set by SetBackend,
+// wallet.SetBackend and SetAppBackend. So the zero value uses the global
+// backends only.
+type Backends struct {
+	Channel Backend
+	Wallet  wallet.Backend
+	App     AppBackend
+}
+
+// DecodingBackends returns the backends that are the decoding context of r,
+// see wire.DecodingContext. This way, wire messages are decoded with the
+// backends of the receiving client. If r carries no backends, the zero
+// Backends are returned, which use the global backends.
+func DecodingBackends(r io.Reader) Backends {
+	b, _ := wire.DecodingContext(r).(Backends)
+	return b
+}
+
+func (b Backends) channel() Backend {
+	if b.Channel != nil {
+		return b.Channel
+	}
+	return backend
+}
+
+// CalcID calculates the channel ID of the parameters.
+func (b Backends) CalcID(p *Params) ID {
+	return b.channel().CalcID(p)
+}
+
+// Sign creates a signature from the account a on state s.
+func (b Backends) Sign(a wallet.Account, p *Params, s *State) (wallet.Sig, error) {
+	return b.channel().Sign(a, p, s)
+}
+
+// Verify verifies that a signature was a valid signature from addr on a state.
+func (b Backends) Verify(addr wallet.Address, params *Params, state *State, sig wallet.Sig) (bool, error) {
+	return b.channel().Verify(addr, params, state, sig)
+}
+
+// DecodeAsset decodes an Asset from an io.Reader.
+func (b Backends) DecodeAsset(r io.Reader) (Asset, error) {
+	return b.channel().DecodeAsset(r)
+}
+
+// DecodeAddress decodes a wallet address from an io.Reader.
+func (b Backends) DecodeAddress(r io.Reader) (wallet.Address, error) {
+	if b.Wallet != nil {
+		return b.Wallet.DecodeAddress(r)
+	}
+	return wallet.DecodeAddress(r)
+}
+
+// DecodeSig decodes a signature from an io.Reader.
+func (b Backends) DecodeSig(r io.Reader) (wallet.Sig, error) {
+	if b.Wallet != nil {
+		return b.Wallet.DecodeSig(r)
+	}
+	return wallet.DecodeSig(r)
+}
+
+// AppFromDefinition creates the app with the given definition.
+func (b Backends) AppFromDefinition(def wallet.Address) (App, error) {
+	if b.App != nil {
+		return b.App.AppFromDefinition(def)
+	}
+	return AppFromDefinition(def)
+}
+
+// NewParams is like the global NewParams but uses the backends b.
+func (b Backends) NewParams(challengeDuration uint64, parts []wallet.Address, appDef wallet.Address, nonce *big.Int) (*Params, error) {
+	if err := b.ValidateParameters(challengeDuration, len(parts), appDef, nonce); err != nil {
+		return nil, errors.WithMessage(err, "invalid parameter for NewParams")
+	}
+	return b.NewParamsUnsafe(challengeDuration, parts, appDef, nonce), nil
+}
+
+// NewParamsUnsafe is like the global NewParamsUnsafe but uses the backends b.
+func (b Backends) NewParamsUnsafe(challengeDuration uint64, parts []wallet.Address, appDef wallet.Address, nonce *big.Int) *Params {
+	app, err := b.AppFromDefinition(appDef)
+	if err != nil {
+		log.Panic("AppFromDefinition on validated parameters returned error")
+	}
+	p := &Params{
+		ChallengeDuration: challengeDuration,
+		Parts:             parts,
+		App:               app,
+		Nonce:             nonce,
+		backends:          b,
+	}
+	// probably an expensive hash operation, do it only once during creation.
+	p.id = b.CalcID(p)
+	return p
+}
+
+// ValidateParameters is like the global ValidateParameters but uses the app
+// backend of b.
+func (b Backends) ValidateParameters(challengeDuration uint64, numParts int, appDef wallet.Address, nonce *big.Int) error {
+	if challengeDuration == 0 {
+		return errors.New("challengeDuration must be != 0")
+	}
+	if nonce == nil {
+		return errors.New("nonce must not be nil")
+	}
+	if numParts < 2 {
+		return errors.New("need at least two participants")
+	}
+	if numParts > MaxNumParts {
+		return errors.Errorf("too many participants, got: %d max: %d", numParts, MaxNumParts)
+	}
+	app, err := b.AppFromDefinition(appDef)
+	if err != nil {
+		return errors.WithMessage(err, "app from definition")
+	}
+	if !IsStateApp(app) && !IsActionApp(app) {
+		return errors.New("app must be either an Action- or StateApp")
+	}
+	return nil
+}
+
+// DecodeState decodes a state from an io.Reader, using the backends b for its
+// assets and app.
+func (b Backends) DecodeState(r io.Reader) (*State, error) {
+	s := new(State)
+	return s, s.decode(r, b)
+}
+
+// DecodeTransaction decodes a transaction from an io.Reader, using the
+// backends b for its state and signatures.
+func (b Backends) DecodeTransaction(r io.Reader) (*Transaction, error) {
+	t := new(Transaction)
+	return t, t.decode(r, b)
+}
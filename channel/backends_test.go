@@ -0,0 +1,122 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel_test
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "perun.network/go-perun/backend/sim" // backend init
+	simchannel "perun.network/go-perun/backend/sim/channel"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+	"perun.network/go-perun/wire"
+)
+
+// countingBackend is a channel backend that counts the calls to the sim
+// backend.
+type countingBackend struct {
+	simchannel.Backend
+	calcID, sign, verify, decodeAsset int
+}
+
+func (b *countingBackend) CalcID(p *channel.Params) channel.ID {
+	b.calcID++
+	return b.Backend.CalcID(p)
+}
+
+func (b *countingBackend) Sign(a wallet.Account, p *channel.Params, s *channel.State) (wallet.Sig, error) {
+	b.sign++
+	return b.Backend.Sign(a, p, s)
+}
+
+func (b *countingBackend) Verify(addr wallet.Address, p *channel.Params, s *channel.State, sig wallet.Sig) (bool, error) {
+	b.verify++
+	return b.Backend.Verify(addr, p, s, sig)
+}
+
+func (b *countingBackend) DecodeAsset(r io.Reader) (channel.Asset, error) {
+	b.decodeAsset++
+	return b.Backend.DecodeAsset(r)
+}
+
+type errAppBackend struct{}
+
+func (errAppBackend) AppFromDefinition(wallet.Address) (channel.App, error) {
+	return nil, errors.New("unknown app")
+}
+
+func TestBackends(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xBAC))
+	cb := new(countingBackend)
+	b := channel.Backends{Channel: cb}
+
+	acc, peer := wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)
+	parts := []wallet.Address{acc.Address(), peer.Address()}
+	appDef := test.NewRandomApp(rng).Def()
+	params, err := b.NewParams(60, parts, appDef, big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, cb.calcID)
+	assert.Equal(t, b, params.Backends())
+	assert.Equal(t, channel.Backends{}, test.NewRandomParams(rng, appDef).Backends())
+
+	t.Run("signing", func(t *testing.T) {
+		m, err := channel.NewStateMachine(acc, *params)
+		require.NoError(t, err)
+		alloc := test.NewRandomAllocation(rng, 2)
+		alloc.Locked = nil
+		require.NoError(t, m.Init(*alloc, channel.NewMockOp(channel.OpValid)))
+		_, err = m.Sig()
+		require.NoError(t, err)
+		assert.Equal(t, 1, cb.sign)
+		sig, err := b.Sign(peer, params, m.StagingState())
+		require.NoError(t, err)
+		require.NoError(t, m.AddSig(1, sig))
+		assert.Equal(t, 1, cb.verify)
+	})
+
+	t.Run("decoding", func(t *testing.T) {
+		state := test.NewRandomState(rng, params)
+		var buf bytes.Buffer
+		require.NoError(t, state.Encode(&buf))
+		decodeAsset := cb.decodeAsset
+		decoded, err := b.DecodeState(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, state, decoded)
+		assert.Equal(t, decodeAsset+len(state.Assets), cb.decodeAsset)
+
+		tx := channel.Transaction{State: state, Sigs: []wallet.Sig{nil, nil}}
+		buf.Reset()
+		require.NoError(t, tx.Encode(&buf))
+		decodedTX, err := b.DecodeTransaction(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, &tx, decodedTX)
+		assert.Equal(t, decodeAsset+2*len(state.Assets), cb.decodeAsset)
+
+		// Decode uses the backends of the decoding context of the reader.
+		buf.Reset()
+		require.NoError(t, tx.Encode(&buf))
+		decodedTX = new(channel.Transaction)
+		require.NoError(t, decodedTX.Decode(wire.WithDecodingContext(&buf, b)))
+		assert.Equal(t, &tx, decodedTX)
+		assert.Equal(t, decodeAsset+3*len(state.Assets), cb.decodeAsset)
+	})
+
+	t.Run("app", func(t *testing.T) {
+		b := channel.Backends{App: errAppBackend{}}
+		_, err := b.NewParams(60, parts, appDef, big.NewInt(1))
+		assert.Error(t, err)
+	})
+}
@@ -35,11 +35,14 @@ const (
 	Acting
 	Signing
 	Final
+	Registering
+	Withdrawing
 	Settled
 )
 
 func (p Phase) String() string {
-	return [...]string{"InitActing", "InitSigning", "Funding", "Acting", "Signing", "Final", "Settled"}[p]
+	return [...]string{"InitActing", "InitSigning", "Funding", "Acting", "Signing", "Final",
+		"Registering", "Withdrawing", "Settled"}[p]
 }
 
 func (t PhaseTransition) String() string {
@@ -48,6 +51,21 @@ func (t PhaseTransition) String() string {
 
 var signingPhases = []Phase{InitSigning, Signing}
 
+// A Source is a source of channel data. It allows access to all information
+// needed for persistence. The ID, Idx and Params only need to be persisted
+// once per channel as they stay constant during a channel's lifetime.
+type Source interface {
+	ID() ID
+	Idx() Index
+	Params() *Params
+	StagingTX() Transaction
+	CurrentTX() Transaction
+	PendingTX() Transaction
+	Phase() Phase
+}
+
+var _ Source = (*machine)(nil)
+
 // A machine is the channel pushdown automaton that handles phase transitions.
 // It checks for correct signatures and valid state transitions.
 // machine only contains implementations for the state transitions common to
@@ -62,6 +80,7 @@ type machine struct {
 	params    Params
 	stagingTX Transaction
 	currentTX Transaction
+	pendingTX Transaction // see RetainUpdate
 	prevTXs   []Transaction
 
 	// log is a fields logger for this machine
@@ -85,6 +104,23 @@ func newMachine(acc wallet.Account, params Params) (*machine, error) {
 
 }
 
+// restoreMachine restores a machine to the data given by Source.
+func restoreMachine(acc wallet.Account, source Source) (*machine, error) {
+	m, err := newMachine(acc, *source.Params())
+	if err != nil {
+		return nil, err
+	}
+	if m.idx != source.Idx() {
+		return nil, errors.Errorf("restored machine index %d differs from account index %d", source.Idx(), m.idx)
+	}
+
+	m.phase = source.Phase()
+	m.currentTX = source.CurrentTX()
+	m.stagingTX = source.StagingTX()
+	m.pendingTX = source.PendingTX()
+	return m, nil
+}
+
 // ID returns the channel id
 func (m *machine) ID() ID {
 	return m.params.ID()
@@ -141,7 +177,7 @@ func (m *machine) Sig() (sig wallet.Sig, err error) {
 	}
 
 	if m.stagingTX.Sigs[m.idx] == nil {
-		sig, err = Sign(m.acc, &m.params, m.stagingTX.State)
+		sig, err = m.params.backends.Sign(m.acc, &m.params, m.stagingTX.State)
 		if err != nil {
 			return
 		}
@@ -158,6 +194,38 @@ func (m *machine) State() *State {
 	return m.currentTX.State
 }
 
+// CurrentTX returns the current transaction, that is, the current state
+// together with all participants' signatures on it.
+// Clone the state first if you need to modify it.
+func (m *machine) CurrentTX() Transaction {
+	return m.currentTX
+}
+
+// StagingTX returns the staging transaction. Its signature slice is only
+// partially filled during a signing phase and its state is nil outside of a
+// signing phase.
+// Clone the state first if you need to modify it.
+func (m *machine) StagingTX() Transaction {
+	return m.stagingTX
+}
+
+// PendingTX returns the pending transaction, that is, the staging transaction
+// of the last failed update that might have been signed by all participants.
+// Its state is nil if there is no pending transaction. See RetainUpdate.
+// Clone the state first if you need to modify it.
+func (m *machine) PendingTX() Transaction {
+	return m.pendingTX
+}
+
+// NextVersion returns the version of the next update. It is one higher than
+// the version of the current or, if it is newer, the pending transaction.
+func (m *machine) NextVersion() uint64 {
+	if m.pendingTX.State != nil && m.pendingTX.Version > m.currentTX.Version {
+		return m.pendingTX.Version + 1
+	}
+	return m.currentTX.Version + 1
+}
+
 // SettleReq returns the settlement request for the current channel transaction
 // (the current state together with all participants' signatures on it).
 func (m *machine) AdjudicatorReq() AdjudicatorReq {
@@ -193,7 +261,7 @@ func (m *machine) AddSig(idx Index, sig wallet.Sig) error {
 		return errors.Errorf("signature for idx %d already present (ID: %x)", idx, m.params.id)
 	}
 
-	if ok, err := Verify(m.params.Parts[idx], &m.params, m.stagingTX.State, sig); err != nil {
+	if ok, err := m.params.backends.Verify(m.params.Parts[idx], &m.params, m.stagingTX.State, sig); err != nil {
 		return err
 	} else if !ok {
 		return errors.Errorf("invalid signature for idx %d (ID: %x)", idx, m.params.id)
@@ -226,6 +294,26 @@ func (m *machine) DiscardUpdate() error {
 	return nil
 }
 
+// RetainUpdate sets the machine's phase back to Acting like DiscardUpdate, but
+// keeps the staging transaction as the pending transaction. It must be used
+// instead of DiscardUpdate if the update failed after our signature on the
+// staging state was sent, as the other participants may already be able to
+// enforce the staging state then. The pending transaction is kept as a
+// possible on-chain state until it is superseded by a current state of at
+// least the same version. Until then, new states must have a higher version
+// than the pending transaction, so that no conflicting state of the same
+// version is ever signed.
+func (m *machine) RetainUpdate() error {
+	if err := m.expect(PhaseTransition{Signing, Acting}); err != nil {
+		return err
+	}
+
+	m.pendingTX = m.stagingTX   // retain staging tx
+	m.stagingTX = Transaction{} // clear staging tx
+	m.setPhase(Acting)
+	return nil
+}
+
 // EnableInit promotes the initial staging state to the current funding state.
 // A valid phase transition and the existence of all signatures is checked.
 func (m *machine) EnableInit() error {
@@ -266,11 +354,74 @@ func (m *machine) enableStaged(expected PhaseTransition) error {
 	m.prevTXs = append(m.prevTXs, m.currentTX) // push current to previous
 	m.currentTX = m.stagingTX                  // promote staging to current
 	m.stagingTX = Transaction{}                // clear staging
+	m.supersedePending()
 
 	m.setPhase(expected.To)
 	return nil
 }
 
+// SyncCurrentTX promotes the given transaction to the current transaction. It
+// is used in the resynchronization protocol after a peer reconnected, if the
+// peer presents a newer fully signed transaction than our current one, e.g.,
+// because the connection dropped before we received the last signature of an
+// update.
+// It is checked that the transaction is newer than the current transaction
+// and that it is signed by all participants. A staging transaction is
+// discarded. Afterwards, the machine is in the Acting phase or, if the synced
+// state is final, in the Final phase.
+func (m *machine) SyncCurrentTX(tx Transaction) error {
+	to := Acting
+	if tx.State != nil && tx.IsFinal {
+		to = Final
+	}
+	tr := PhaseTransition{m.phase, to}
+	if !inPhase(m.phase, []Phase{Acting, Signing}) {
+		return m.phaseErrorf(tr, "can only sync current transaction in Acting or Signing phase")
+	}
+
+	if tx.State == nil {
+		return errors.New("synced transaction has no state")
+	} else if tx.ID != m.params.id {
+		return errors.New("synced state's ID doesn't match")
+	} else if !m.params.App.Def().Equals(tx.App.Def()) {
+		return errors.New("synced state's App doesn't match")
+	} else if tx.Version <= m.currentTX.Version {
+		return errors.Errorf("synced version %d is not newer than current version %d",
+			tx.Version, m.currentTX.Version)
+	} else if err := tx.Allocation.Valid(); err != nil {
+		return errors.WithMessage(err, "invalid allocation")
+	} else if len(tx.Sigs) != len(m.params.Parts) {
+		return errors.Errorf("synced transaction has %d signatures, expected %d",
+			len(tx.Sigs), len(m.params.Parts))
+	}
+	for i, sig := range tx.Sigs {
+		if sig == nil {
+			return errors.Errorf("signature %d missing from synced transaction", i)
+		}
+		if ok, err := m.params.backends.Verify(m.params.Parts[i], &m.params, tx.State, sig); err != nil {
+			return errors.WithMessagef(err, "verifying signature %d", i)
+		} else if !ok {
+			return errors.Errorf("invalid signature %d", i)
+		}
+	}
+
+	m.prevTXs = append(m.prevTXs, m.currentTX) // push current to previous
+	m.currentTX = tx
+	m.stagingTX = Transaction{} // clear staging
+	m.supersedePending()
+
+	m.setPhase(to)
+	return nil
+}
+
+// supersedePending clears the pending transaction if the current transaction
+// has at least the same version.
+func (m *machine) supersedePending() {
+	if m.pendingTX.State != nil && m.currentTX.Version >= m.pendingTX.Version {
+		m.pendingTX = Transaction{}
+	}
+}
+
 // SetFunded tells the state machine that the channel got funded and progresses
 // to the Acting phase.
 func (m *machine) SetFunded() error {
@@ -282,10 +433,47 @@ func (m *machine) SetFunded() error {
 	return nil
 }
 
-// SetSettled tells the state machine that the final state was settled on the
-// blockchain or funding channel and progresses to the Settled state.
+// SetRegistering tells the state machine that the current state is being
+// registered on the blockchain. No more updates are possible afterwards. It
+// may be called again in the Registering or Withdrawing phase to retry a
+// failed settlement.
+//
+// If an update is aborted by registering in the Signing phase, the staging
+// transaction is kept as the pending transaction like in RetainUpdate, as the
+// other participants may be able to enforce it if we signed it.
+func (m *machine) SetRegistering() error {
+	if err := m.expect(PhaseTransition{m.phase, Registering}); err != nil {
+		return err
+	}
+
+	if m.phase == Signing {
+		if m.stagingTX.Sigs[m.idx] != nil {
+			m.pendingTX = m.stagingTX // retain staging tx
+		}
+		m.stagingTX = Transaction{} // clear staging tx
+	}
+	m.setPhase(Registering)
+	return nil
+}
+
+// SetWithdrawing tells the state machine that the current state was
+// successfully registered on the blockchain and that the funds are about to be
+// withdrawn after the registration timeout. It may be called again in the
+// Withdrawing phase to check that the channel was not settled meanwhile.
+func (m *machine) SetWithdrawing() error {
+	if err := m.expect(PhaseTransition{m.phase, Withdrawing}); err != nil {
+		return err
+	}
+
+	m.setPhase(Withdrawing)
+	return nil
+}
+
+// SetSettled tells the state machine that the final or registered state was
+// settled on the blockchain or funding channel and progresses to the Settled
+// state.
 func (m *machine) SetSettled() error {
-	if err := m.expect(PhaseTransition{Final, Settled}); err != nil {
+	if err := m.expect(PhaseTransition{m.phase, Settled}); err != nil {
 		return err
 	}
 
@@ -294,13 +482,23 @@ func (m *machine) SetSettled() error {
 }
 
 var validPhaseTransitions = map[PhaseTransition]bool{
-	{InitActing, InitSigning}: true,
-	{InitSigning, Funding}:    true,
-	{Funding, Acting}:         true,
-	{Acting, Signing}:         true,
-	{Signing, Acting}:         true,
-	{Signing, Final}:          true,
-	{Final, Settled}:          true,
+	{InitActing, InitSigning}:  true,
+	{InitSigning, Funding}:     true,
+	{Funding, Acting}:          true,
+	{Acting, Signing}:          true,
+	{Acting, Final}:            true,
+	{Signing, Acting}:          true,
+	{Signing, Final}:           true,
+	{Final, Settled}:           true,
+	{Funding, Registering}:     true,
+	{Acting, Registering}:      true,
+	{Signing, Registering}:     true,
+	{Final, Registering}:       true,
+	{Registering, Registering}: true,
+	{Registering, Withdrawing}: true,
+	{Withdrawing, Registering}: true,
+	{Withdrawing, Withdrawing}: true,
+	{Withdrawing, Settled}:     true,
 }
 
 func (m *machine) expect(tr PhaseTransition) error {
@@ -317,7 +515,7 @@ func (m *machine) expect(tr PhaseTransition) error {
 // state is valid. The following checks are run:
 // * matching channel ids
 // * no transition from final state
-// * version increase by 1
+// * version is the next version, see NextVersion
 // * preservation of balances
 // A StateMachine will additionally check the validity of the app-specific
 // transition whereas an ActionMachine checks each Action as being valid.
@@ -335,8 +533,8 @@ func (m *machine) validTransition(to *State) error {
 		return newError("cannot advance final state")
 	}
 
-	if m.currentTX.Version+1 != to.Version {
-		return newError("version must increase by one")
+	if to.Version != m.NextVersion() {
+		return newError(fmt.Sprintf("version must be %d", m.NextVersion()))
 	}
 
 	if err := to.Allocation.Valid(); err != nil {
@@ -6,11 +6,8 @@
 package channel
 
 import (
-	"log"
 	"math/big"
 
-	"github.com/pkg/errors"
-
 	"perun.network/go-perun/wallet"
 )
 
@@ -38,6 +35,8 @@ type Params struct {
 	App App
 	// Nonce is a randomness to make the channel id unique
 	Nonce *big.Int
+	// backends are the backends of the channel
+	backends Backends
 }
 
 // ID returns the channelID of this channel.
@@ -45,14 +44,17 @@ func (p *Params) ID() ID {
 	return p.id
 }
 
+// Backends returns the backends that are used for the channel.
+func (p *Params) Backends() Backends {
+	return p.backends
+}
+
 // NewParams creates Params from the given data and performs sanity checks. The
 // channel id is also calculated here and persisted because it probably is an
 // expensive hash operation.
+// The global backends are used, see Backends.NewParams.
 func NewParams(challengeDuration uint64, parts []wallet.Address, appDef wallet.Address, nonce *big.Int) (*Params, error) {
-	if err := ValidateParameters(challengeDuration, len(parts), appDef, nonce); err != nil {
-		return nil, errors.WithMessage(err, "invalid parameter for NewParams")
-	}
-	return NewParamsUnsafe(challengeDuration, parts, appDef, nonce), nil
+	return Backends{}.NewParams(challengeDuration, parts, appDef, nonce)
 }
 
 // ValidateParameters checks that the arguments form valid Params:
@@ -61,43 +63,13 @@ func NewParams(challengeDuration uint64, parts []wallet.Address, appDef wallet.A
 // * at least two and at most MaxNumParts parts
 // * appDef belongs to either a StateApp or ActionApp
 func ValidateParameters(challengeDuration uint64, numParts int, appDef wallet.Address, nonce *big.Int) error {
-	if challengeDuration == 0 {
-		return errors.New("challengeDuration must be != 0")
-	}
-	if nonce == nil {
-		return errors.New("nonce must not be nil")
-	}
-	if numParts < 2 {
-		return errors.New("need at least two participants")
-	}
-	if numParts > MaxNumParts {
-		return errors.Errorf("too many participants, got: %d max: %d", numParts, MaxNumParts)
-	}
-	app, err := AppFromDefinition(appDef)
-	if err != nil {
-		return errors.WithMessage(err, "app from definition")
-	}
-	if !IsStateApp(app) && !IsActionApp(app) {
-		return errors.New("app must be either an Action- or StateApp")
-	}
-	return nil
+	return Backends{}.ValidateParameters(challengeDuration, numParts, appDef, nonce)
 }
 
 // NewParamsUnsafe creates Params from the given data and does NOT perform sanity checks.
 // The channel id is also calculated here and persisted because it probably is an
 // expensive hash operation.
+// The global backends are used, see Backends.NewParamsUnsafe.
 func NewParamsUnsafe(challengeDuration uint64, parts []wallet.Address, appDef wallet.Address, nonce *big.Int) *Params {
-	app, err := AppFromDefinition(appDef)
-	if err != nil {
-		log.Panic("AppFromDefinition on validated parameters returned error")
-	}
-	p := &Params{
-		ChallengeDuration: challengeDuration,
-		Parts:             parts,
-		App:               app,
-		Nonce:             nonce,
-	}
-	// probably an expensive hash operation, do it only once during creation.
-	p.id = CalcID(p)
-	return p
+	return Backends{}.NewParamsUnsafe(challengeDuration, parts, appDef, nonce)
 }
@@ -0,0 +1,71 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// An ActionMachine is a wrapper around a channel.ActionMachine that forwards
+// calls to it and, if successful, persists changed data using a Persister.
+// Staged actions are not persisted, only the states resulting from them.
+// Like for the StateMachine, the caller must not act upon the new machine
+// state if the Persister returns an error.
+type ActionMachine struct {
+	*channel.ActionMachine
+	machine
+}
+
+var _ Machine = (*ActionMachine)(nil)
+
+// FromActionMachine creates a persisting ActionMachine wrapper around the
+// passed ActionMachine using the Persister pr.
+func FromActionMachine(m *channel.ActionMachine, pr Persister) *ActionMachine {
+	return &ActionMachine{
+		ActionMachine: m,
+		machine:       machine{m: m, pr: pr},
+	}
+}
+
+// Init calls Init on the ActionMachine and then persists the new staging
+// state.
+func (m *ActionMachine) Init(ctx context.Context) error {
+	if err := m.ActionMachine.Init(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.ActionMachine), "Persister.Staged")
+}
+
+// InitFromState calls InitFromState on the ActionMachine and then persists the
+// new staging state.
+func (m *ActionMachine) InitFromState(ctx context.Context, initBals channel.Allocation, initData channel.Data) error {
+	if err := m.ActionMachine.InitFromState(initBals, initData); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.ActionMachine), "Persister.Staged")
+}
+
+// Update calls Update on the ActionMachine and then persists the new staging
+// state.
+func (m *ActionMachine) Update(ctx context.Context, version uint64) error {
+	if err := m.ActionMachine.Update(version); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.ActionMachine), "Persister.Staged")
+}
+
+// SyncCurrentTX calls SyncCurrentTX on the ActionMachine, which also clears
+// the staged actions, and then persists the new current transaction.
+func (m *ActionMachine) SyncCurrentTX(ctx context.Context, tx channel.Transaction) error {
+	if err := m.ActionMachine.SyncCurrentTX(tx); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Enabled(ctx, m.ActionMachine), "Persister.Enabled")
+}
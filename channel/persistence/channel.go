@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// A Channel holds all data of a channel that was restored from persistent
+// storage. It implements channel.Source, so that a state machine can be
+// restored from it using channel.RestoreStateMachine.
+type Channel struct {
+	IdxV       channel.Index
+	ParamsV    *channel.Params
+	StagingTXV channel.Transaction
+	CurrentTXV channel.Transaction
+	PendingTXV channel.Transaction
+	PhaseV     channel.Phase
+	PeersV     []wallet.Address
+}
+
+var _ channel.Source = (*Channel)(nil)
+
+// ID returns the channel ID.
+func (c *Channel) ID() channel.ID {
+	return c.ParamsV.ID()
+}
+
+// Idx returns our index in the channel.
+func (c *Channel) Idx() channel.Index {
+	return c.IdxV
+}
+
+// Params returns the channel parameters.
+func (c *Channel) Params() *channel.Params {
+	return c.ParamsV
+}
+
+// StagingTX returns the staging transaction.
+func (c *Channel) StagingTX() channel.Transaction {
+	return c.StagingTXV
+}
+
+// CurrentTX returns the current transaction.
+func (c *Channel) CurrentTX() channel.Transaction {
+	return c.CurrentTXV
+}
+
+// PendingTX returns the pending transaction.
+func (c *Channel) PendingTX() channel.Transaction {
+	return c.PendingTXV
+}
+
+// Phase returns the phase of the channel state machine.
+func (c *Channel) Phase() channel.Phase {
+	return c.PhaseV
+}
+
+// Peers returns the Perun network addresses of the channel peers.
+func (c *Channel) Peers() []wallet.Address {
+	return c.PeersV
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package keyvalue
+
+import (
+	"bytes"
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// encodeParams encodes the channel parameters. The channel ID is not encoded
+// as it is recalculated from the parameters during decoding.
+func encodeParams(w io.Writer, p *channel.Params) error {
+	if err := wire.Encode(w, p.ChallengeDuration, p.Nonce, p.App.Def()); err != nil {
+		return err
+	}
+	if err := wire.Encode(w, int32(len(p.Parts))); err != nil {
+		return err
+	}
+	for i, part := range p.Parts {
+		if err := part.Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding participant %d", i)
+		}
+	}
+	return nil
+}
+
+// decodeParams decodes channel parameters that were encoded with
+// encodeParams, using the backends b.
+func decodeParams(r io.Reader, b channel.Backends) (*channel.Params, error) {
+	var (
+		challengeDuration uint64
+		nonce             *big.Int
+	)
+	if err := wire.Decode(r, &challengeDuration, &nonce); err != nil {
+		return nil, err
+	}
+	appDef, err := b.DecodeAddress(r)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decoding app definition")
+	}
+
+	var numParts int32
+	if err := wire.Decode(r, &numParts); err != nil {
+		return nil, err
+	}
+	if numParts < 0 || numParts > channel.MaxNumParts {
+		return nil, errors.Errorf("invalid number of participants: %d", numParts)
+	}
+	parts := make([]wallet.Address, numParts)
+	for i := range parts {
+		if parts[i], err = b.DecodeAddress(r); err != nil {
+			return nil, errors.WithMessagef(err, "decoding participant %d", i)
+		}
+	}
+
+	return b.NewParams(challengeDuration, parts, appDef, nonce)
+}
+
+// encodeVirtual encodes the record of a virtual channel. Each half is
+// preceded by a flag whether it is set.
+func encodeVirtual(w io.Writer, vc *persistence.VirtualChannel) error {
+	if err := encodeParams(w, vc.Params); err != nil {
+		return errors.WithMessage(err, "encoding params")
+	}
+	if err := vc.TX.Encode(w); err != nil {
+		return errors.WithMessage(err, "encoding transaction")
+	}
+	for i, peer := range vc.Peers {
+		if err := wire.Encode(w, peer != nil); err != nil {
+			return err
+		} else if peer == nil {
+			continue
+		}
+		if err := peer.Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding peer %d", i)
+		}
+		if err := wire.Encode(w, vc.Parents[i], vc.Settling[i], int32(len(vc.Idxs[i]))); err != nil {
+			return err
+		}
+		for _, idx := range vc.Idxs[i] {
+			if err := wire.Encode(w, idx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// decodeVirtual decodes the record of a virtual channel that was encoded with
+// encodeVirtual, using the backends b.
+func decodeVirtual(r io.Reader, b channel.Backends) (*persistence.VirtualChannel, error) {
+	var (
+		vc  persistence.VirtualChannel
+		err error
+	)
+	if vc.Params, err = decodeParams(r, b); err != nil {
+		return nil, errors.WithMessage(err, "decoding params")
+	}
+	tx, err := b.DecodeTransaction(r)
+	if err != nil {
+		return nil, errors.WithMessage(err, "decoding transaction")
+	}
+	vc.TX = *tx
+
+	for i := range vc.Peers {
+		var set bool
+		if err := wire.Decode(r, &set); err != nil {
+			return nil, err
+		} else if !set {
+			continue
+		}
+		if vc.Peers[i], err = b.DecodeAddress(r); err != nil {
+			return nil, errors.WithMessagef(err, "decoding peer %d", i)
+		}
+		var numIdxs int32
+		if err := wire.Decode(r, &vc.Parents[i], &vc.Settling[i], &numIdxs); err != nil {
+			return nil, err
+		}
+		if numIdxs < 0 || numIdxs > channel.MaxNumParts {
+			return nil, errors.Errorf("invalid number of indices: %d", numIdxs)
+		}
+		vc.Idxs[i] = make([]channel.Index, numIdxs)
+		for j := range vc.Idxs[i] {
+			if err := wire.Decode(r, &vc.Idxs[i][j]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &vc, nil
+}
+
+// encodeToBytes is a helper that encodes using enc into a fresh byte slice.
+func encodeToBytes(enc func(io.Writer) error) ([]byte, error) {
+	var buf bytes.Buffer
+	err := enc(&buf)
+	return buf.Bytes(), err
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package keyvalue implements a channel persister on top of a key-value store
+// as defined in package db.
+//
+// The channel data is laid out as follows:
+//
+//	Chan:<id>:params   channel parameters
+//	Chan:<id>:idx      our index in the channel
+//	Chan:<id>:phase    current phase of the channel machine
+//	Chan:<id>:current  current transaction
+//	Chan:<id>:staging  staging transaction
+//	Chan:<id>:pending  pending transaction of a failed update
+//	Chan:<id>:peers    Perun addresses of the channel peers
+//	Peer:<addr>:<id>   empty marker, indexing channels by peer
+//	Virt:<id>          record of a virtual channel of which we are the intermediary
+//
+// where <id> is the hex-encoded channel ID and <addr> the hex-encoded bytes
+// of a peer's Perun address.
+package keyvalue // import "perun.network/go-perun/channel/persistence/keyvalue"
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/db"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+const (
+	prefixChannel = "Chan:"
+	prefixPeer    = "Peer:"
+	prefixVirtual = "Virt:"
+
+	keyParams  = "params"
+	keyIdx     = "idx"
+	keyPhase   = "phase"
+	keyCurrent = "current"
+	keyStaging = "staging"
+	keyPending = "pending"
+	keyPeers   = "peers"
+)
+
+// Persister implements the persistence.Persister interface using a
+// db.Database. All changes belonging to a single persistence request are
+// written atomically in a single db.Batch.
+type Persister struct {
+	db       db.Database
+	backends channel.Backends
+}
+
+var _ persistence.Persister = (*Persister)(nil)
+
+// NewPersister creates a new Persister that writes to the given database.
+func NewPersister(database db.Database) *Persister {
+	return &Persister{db: database}
+}
+
+// SetBackends sets the backends that are used to decode the parameters,
+// transactions and peers of restored channels. It must be called before any
+// channels are restored and should match the backends of the client, see
+// client.SetBackends. By default, the global backends are used.
+func (p *Persister) SetBackends(b channel.Backends) {
+	p.backends = b
+}
+
+// ChannelCreated persists the parameters, our index, phase, transactions and
+// peers of a new channel.
+func (p *Persister) ChannelCreated(_ context.Context, s channel.Source, peers []wallet.Address) error {
+	id := s.ID()
+	batch := p.db.NewBatch()
+
+	if err := putEncoded(batch, channelKey(id, keyParams), func(w io.Writer) error {
+		return encodeParams(w, s.Params())
+	}); err != nil {
+		return errors.WithMessage(err, "putting params")
+	}
+	if err := putEncoded(batch, channelKey(id, keyIdx), func(w io.Writer) error {
+		return wire.Encode(w, s.Idx())
+	}); err != nil {
+		return errors.WithMessage(err, "putting idx")
+	}
+	if err := putEncoded(batch, channelKey(id, keyPeers), func(w io.Writer) error {
+		return encodePeers(w, peers)
+	}); err != nil {
+		return errors.WithMessage(err, "putting peers")
+	}
+	if err := putPhaseAndTXs(batch, s, true); err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		if err := batch.Put(peerChannelKey(peer, id), ""); err != nil {
+			return errors.WithMessage(err, "putting peer index")
+		}
+	}
+	return errors.WithMessage(batch.Apply(), "applying batch")
+}
+
+// ChannelRemoved deletes all data of the channel from the database.
+func (p *Persister) ChannelRemoved(_ context.Context, id channel.ID) error {
+	peers, err := p.channelPeers(id)
+	if err != nil {
+		return errors.WithMessage(err, "reading peers")
+	}
+
+	batch := p.db.NewBatch()
+	for _, peer := range peers {
+		if err := batch.Delete(peerChannelKey(peer, id)); err != nil {
+			return errors.WithMessage(err, "deleting peer index")
+		}
+	}
+	for _, key := range []string{keyParams, keyIdx, keyPhase, keyCurrent, keyStaging, keyPending, keyPeers} {
+		if err := batch.Delete(channelKey(id, key)); err != nil {
+			return errors.WithMessagef(err, "deleting %s", key)
+		}
+	}
+	return errors.WithMessage(batch.Apply(), "applying batch")
+}
+
+// Staged persists the phase, staging and pending transaction.
+func (p *Persister) Staged(_ context.Context, s channel.Source) error {
+	batch := p.db.NewBatch()
+	if err := putPhaseAndTXs(batch, s, false); err != nil {
+		return err
+	}
+	return errors.WithMessage(batch.Apply(), "applying batch")
+}
+
+// SigAdded persists the staging transaction, which includes the new signature.
+func (p *Persister) SigAdded(ctx context.Context, s channel.Source, _ channel.Index) error {
+	return p.Staged(ctx, s)
+}
+
+// Enabled persists the phase, current, (cleared) staging and pending
+// transaction.
+func (p *Persister) Enabled(_ context.Context, s channel.Source) error {
+	batch := p.db.NewBatch()
+	if err := putPhaseAndTXs(batch, s, true); err != nil {
+		return err
+	}
+	return errors.WithMessage(batch.Apply(), "applying batch")
+}
+
+// PhaseChanged persists the phase.
+func (p *Persister) PhaseChanged(_ context.Context, s channel.Source) error {
+	return errors.WithMessage(
+		putEncoded(p.db, channelKey(s.ID(), keyPhase), func(w io.Writer) error {
+			return wire.Encode(w, uint8(s.Phase()))
+		}), "putting phase")
+}
+
+// VirtualChanged persists the record of the virtual channel.
+func (p *Persister) VirtualChanged(_ context.Context, vc *persistence.VirtualChannel) error {
+	return errors.WithMessage(
+		putEncoded(p.db, virtualKey(vc.ID()), func(w io.Writer) error {
+			return encodeVirtual(w, vc)
+		}), "putting virtual channel")
+}
+
+// VirtualRemoved deletes the record of the virtual channel.
+func (p *Persister) VirtualRemoved(_ context.Context, id channel.ID) error {
+	return errors.WithMessage(p.db.Delete(virtualKey(id)), "deleting virtual channel")
+}
+
+// Close does nothing as the database is owned by the caller.
+func (p *Persister) Close() error {
+	return nil
+}
+
+// channelPeers reads the peers of the given channel.
+func (p *Persister) channelPeers(id channel.ID) ([]wallet.Address, error) {
+	data, err := p.db.GetBytes(channelKey(id, keyPeers))
+	if err != nil {
+		return nil, err
+	}
+	return decodePeers(bytes.NewReader(data), p.backends)
+}
+
+// putPhaseAndTXs puts the phase, staging and pending transaction of the source
+// into the writer. If withCurrent is true, the current transaction is also put.
+func putPhaseAndTXs(w db.Writer, s channel.Source, withCurrent bool) error {
+	id := s.ID()
+	if err := putEncoded(w, channelKey(id, keyPhase), func(w io.Writer) error {
+		return wire.Encode(w, uint8(s.Phase()))
+	}); err != nil {
+		return errors.WithMessage(err, "putting phase")
+	}
+	if withCurrent {
+		if err := putEncoded(w, channelKey(id, keyCurrent), func(w io.Writer) error {
+			return s.CurrentTX().Encode(w)
+		}); err != nil {
+			return errors.WithMessage(err, "putting current transaction")
+		}
+	}
+	if err := putEncoded(w, channelKey(id, keyStaging), func(w io.Writer) error {
+		return s.StagingTX().Encode(w)
+	}); err != nil {
+		return errors.WithMessage(err, "putting staging transaction")
+	}
+	return errors.WithMessage(
+		putEncoded(w, channelKey(id, keyPending), func(w io.Writer) error {
+			return s.PendingTX().Encode(w)
+		}), "putting pending transaction")
+}
+
+// putEncoded encodes a value with enc and puts it into w under key.
+func putEncoded(w db.Writer, key string, enc func(io.Writer) error) error {
+	data, err := encodeToBytes(enc)
+	if err != nil {
+		return err
+	}
+	return w.PutBytes(key, data)
+}
+
+func encodePeers(w io.Writer, peers []wallet.Address) error {
+	if err := wire.Encode(w, int32(len(peers))); err != nil {
+		return err
+	}
+	for i, peer := range peers {
+		if err := peer.Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding peer %d", i)
+		}
+	}
+	return nil
+}
+
+// decodePeers decodes the peer addresses that were encoded with encodePeers,
+// using the backends b.
+func decodePeers(r io.Reader, b channel.Backends) ([]wallet.Address, error) {
+	var numPeers int32
+	if err := wire.Decode(r, &numPeers); err != nil {
+		return nil, err
+	}
+	if numPeers < 0 || numPeers > channel.MaxNumParts {
+		return nil, errors.Errorf("invalid number of peers: %d", numPeers)
+	}
+	peers := make([]wallet.Address, numPeers)
+	for i := range peers {
+		var err error
+		if peers[i], err = b.DecodeAddress(r); err != nil {
+			return nil, errors.WithMessagef(err, "decoding peer %d", i)
+		}
+	}
+	return peers, nil
+}
+
+func channelPrefix(id channel.ID) string {
+	return prefixChannel + hex.EncodeToString(id[:]) + ":"
+}
+
+func channelKey(id channel.ID, key string) string {
+	return channelPrefix(id) + key
+}
+
+func peerPrefix(addr wallet.Address) string {
+	return prefixPeer + hex.EncodeToString(addr.Bytes()) + ":"
+}
+
+func peerChannelKey(addr wallet.Address, id channel.ID) string {
+	return peerPrefix(addr) + hex.EncodeToString(id[:])
+}
+
+func virtualKey(id channel.ID) string {
+	return prefixVirtual + hex.EncodeToString(id[:])
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package keyvalue
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "perun.network/go-perun/backend/sim" // backend init
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/db"
+	"perun.network/go-perun/db/memorydb"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+	"perun.network/go-perun/wire"
+)
+
+func TestParamsCodec(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xC0DEC))
+	params := test.NewRandomParams(rng, test.NewRandomApp(rng).Def())
+
+	data, err := encodeToBytes(func(w io.Writer) error { return encodeParams(w, params) })
+	require.NoError(t, err)
+	decoded, err := decodeParams(bytes.NewReader(data), channel.Backends{})
+	require.NoError(t, err)
+	assert.Equal(t, params, decoded)
+
+	// The decoded params use the given backends.
+	b := channel.Backends{App: new(channel.MockAppBackend)}
+	decoded, err = decodeParams(bytes.NewReader(data), b)
+	require.NoError(t, err)
+	assert.Equal(t, params.ID(), decoded.ID())
+	assert.Equal(t, b, decoded.Backends())
+}
+
+func TestPersister(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xDB))
+	ctx := context.Background()
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	app := channel.NewMockApp(wallettest.NewRandomAddress(rng))
+	params, err := channel.NewParams(60, parts, app.Def(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	peers := []wallet.Address{parts[1]}
+
+	database := memorydb.NewDatabase()
+	pr := NewPersister(database)
+	sm, err := channel.NewStateMachine(accs[0], *params)
+	require.NoError(t, err)
+	m := persistence.FromStateMachine(sm, pr)
+
+	require.NoError(t, pr.ChannelCreated(ctx, sm, peers))
+	assertPersisted(t, database, sm)
+	peerIdx, err := database.Has(peerChannelKey(parts[1], params.ID()))
+	require.NoError(t, err)
+	assert.True(t, peerIdx)
+	readPeers, err := pr.channelPeers(params.ID())
+	require.NoError(t, err)
+	assert.Equal(t, peers, readPeers)
+
+	initBals := test.NewRandomAllocation(rng, len(parts))
+	require.NoError(t, m.Init(ctx, *initBals, channel.NewMockOp(channel.OpValid)))
+	assertPersisted(t, database, sm)
+
+	_, err = m.Sig(ctx)
+	require.NoError(t, err)
+	assertPersisted(t, database, sm)
+
+	sig, err := channel.Sign(accs[1], params, sm.StagingState())
+	require.NoError(t, err)
+	require.NoError(t, m.AddSig(ctx, 1, sig))
+	assertPersisted(t, database, sm)
+
+	require.NoError(t, m.EnableInit(ctx))
+	assertPersisted(t, database, sm)
+
+	require.NoError(t, m.SetFunded(ctx))
+	assertPersisted(t, database, sm)
+
+	state := sm.State().Clone()
+	state.Version++
+	require.NoError(t, m.Update(ctx, state, 0))
+	_, err = m.Sig(ctx)
+	require.NoError(t, err)
+	require.NoError(t, m.RetainUpdate(ctx))
+	require.NotNil(t, sm.PendingTX().State)
+	assertPersisted(t, database, sm)
+
+	// Registering during a signed update keeps the staging state as pending.
+	state = sm.State().Clone()
+	state.Version = sm.NextVersion()
+	require.NoError(t, m.Update(ctx, state, 0))
+	_, err = m.Sig(ctx)
+	require.NoError(t, err)
+	staging := sm.StagingTX()
+	require.NoError(t, m.SetRegistering(ctx))
+	assert.Equal(t, staging, sm.PendingTX())
+	assert.Nil(t, sm.StagingTX().State)
+	assertPersisted(t, database, sm)
+
+	require.NoError(t, pr.ChannelRemoved(ctx, params.ID()))
+	it := database.NewIterator()
+	assert.False(t, it.Next(), "database should be empty after removing the channel")
+	require.NoError(t, it.Close())
+}
+
+// assertPersisted asserts that the phase and transactions of the source are
+// stored in the database.
+func assertPersisted(t *testing.T, database db.Database, s channel.Source) {
+	t.Helper()
+	id := s.ID()
+
+	data, err := database.GetBytes(channelKey(id, keyPhase))
+	require.NoError(t, err)
+	var phase uint8
+	require.NoError(t, wire.Decode(bytes.NewReader(data), &phase))
+	assert.Equal(t, s.Phase(), channel.Phase(phase))
+
+	for key, tx := range map[string]channel.Transaction{
+		keyCurrent: s.CurrentTX(),
+		keyStaging: s.StagingTX(),
+		keyPending: s.PendingTX(),
+	} {
+		data, err := database.GetBytes(channelKey(id, key))
+		require.NoError(t, err)
+		var decoded channel.Transaction
+		require.NoError(t, decoded.Decode(bytes.NewReader(data)))
+		assert.Equal(t, tx, decoded, key)
+	}
+
+	data, err = database.GetBytes(channelKey(id, keyParams))
+	require.NoError(t, err)
+	params, err := decodeParams(bytes.NewReader(data), channel.Backends{})
+	require.NoError(t, err)
+	assert.Equal(t, s.Params(), params)
+}
+
+func TestPersister_Virtual(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x7117))
+	ctx := context.Background()
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	params, err := channel.NewParams(60, parts, test.NewRandomApp(rng).Def(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	state := test.NewRandomState(rng, params)
+	sigs := make([]wallet.Sig, len(accs))
+	for i, acc := range accs {
+		sigs[i], err = channel.Sign(acc, params, state)
+		require.NoError(t, err)
+	}
+
+	vc := &persistence.VirtualChannel{
+		Params:  params,
+		TX:      channel.Transaction{State: state, Sigs: sigs},
+		Parents: [2]channel.ID{test.NewRandomChannelID(rng)},
+		Peers:   [2]wallet.Address{wallettest.NewRandomAddress(rng)},
+		Idxs:    [2][]channel.Index{{1, 0}},
+	}
+
+	database := memorydb.NewDatabase()
+	pr := NewPersister(database)
+	assertVirtuals := func(expected ...*persistence.VirtualChannel) {
+		t.Helper()
+		vcs, err := pr.RestoreVirtuals(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, expected, vcs)
+	}
+
+	// Half-funded record.
+	require.NoError(t, pr.VirtualChanged(ctx, vc))
+	assertVirtuals(vc)
+
+	vc.Parents[1] = test.NewRandomChannelID(rng)
+	vc.Peers[1] = wallettest.NewRandomAddress(rng)
+	vc.Idxs[1] = []channel.Index{0, 1}
+	vc.Settling[1] = true
+	require.NoError(t, pr.VirtualChanged(ctx, vc))
+	assertVirtuals(vc)
+
+	require.NoError(t, pr.VirtualRemoved(ctx, vc.ID()))
+	assertVirtuals()
+	assert.Error(t, pr.VirtualRemoved(ctx, vc.ID()))
+}
@@ -0,0 +1,166 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package keyvalue
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+var _ persistence.PersistRestorer = (*Persister)(nil)
+
+// ActivePeers returns the addresses of all peers with which at least one
+// channel is persisted.
+func (p *Persister) ActivePeers(context.Context) ([]wallet.Address, error) {
+	it := p.db.NewIteratorWithPrefix(prefixPeer)
+	defer it.Close()
+
+	var (
+		peers []wallet.Address
+		seen  = make(map[string]bool)
+	)
+	for it.Next() {
+		hexAddr := strings.SplitN(strings.TrimPrefix(it.Key(), prefixPeer), ":", 2)[0]
+		if seen[hexAddr] {
+			continue
+		}
+		seen[hexAddr] = true
+
+		rawAddr, err := hex.DecodeString(hexAddr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding peer key %s", it.Key())
+		}
+		addr, err := p.backends.DecodeAddress(bytes.NewReader(rawAddr))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "decoding peer address of key %s", it.Key())
+		}
+		peers = append(peers, addr)
+	}
+	return peers, errors.WithMessage(it.Close(), "iterating peers")
+}
+
+// RestorePeer restores all channels that are persisted with the given peer.
+func (p *Persister) RestorePeer(_ context.Context, peer wallet.Address) ([]*persistence.Channel, error) {
+	prefix := peerPrefix(peer)
+	it := p.db.NewIteratorWithPrefix(prefix)
+	defer it.Close()
+
+	var chs []*persistence.Channel
+	for it.Next() {
+		rawID, err := hex.DecodeString(strings.TrimPrefix(it.Key(), prefix))
+		if err != nil || len(rawID) != len(channel.ID{}) {
+			return nil, errors.Errorf("invalid peer channel key %s", it.Key())
+		}
+		var id channel.ID
+		copy(id[:], rawID)
+
+		ch, err := p.restoreChannel(id)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "restoring channel %x", id)
+		}
+		chs = append(chs, ch)
+	}
+	return chs, errors.WithMessage(it.Close(), "iterating channels")
+}
+
+// RestoreVirtuals restores the records of all virtual channels of which we
+// are the intermediary.
+func (p *Persister) RestoreVirtuals(context.Context) ([]*persistence.VirtualChannel, error) {
+	it := p.db.NewIteratorWithPrefix(prefixVirtual)
+	defer it.Close()
+
+	var vcs []*persistence.VirtualChannel
+	for it.Next() {
+		vc, err := decodeVirtual(bytes.NewReader(it.ValueBytes()), p.backends)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "decoding virtual channel of key %s", it.Key())
+		} else if virtualKey(vc.ID()) != it.Key() {
+			return nil, errors.Errorf("restored params do not match key %s", it.Key())
+		}
+		vcs = append(vcs, vc)
+	}
+	return vcs, errors.WithMessage(it.Close(), "iterating virtual channels")
+}
+
+// restoreChannel reads all data of the given channel from the database.
+func (p *Persister) restoreChannel(id channel.ID) (*persistence.Channel, error) {
+	var (
+		ch    persistence.Channel
+		phase uint8
+		err   error
+	)
+
+	if err = p.decodeKey(channelKey(id, keyParams), func(r *bytes.Reader) (err error) {
+		ch.ParamsV, err = decodeParams(r, p.backends)
+		return
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring params")
+	}
+	if ch.ParamsV.ID() != id {
+		return nil, errors.New("restored params do not match channel ID")
+	}
+	if err = p.decodeKey(channelKey(id, keyIdx), func(r *bytes.Reader) error {
+		return wire.Decode(r, &ch.IdxV)
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring idx")
+	}
+	if err = p.decodeKey(channelKey(id, keyPhase), func(r *bytes.Reader) error {
+		return wire.Decode(r, &phase)
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring phase")
+	}
+	ch.PhaseV = channel.Phase(phase)
+	if err = p.decodeKey(channelKey(id, keyCurrent), func(r *bytes.Reader) error {
+		return p.decodeTX(r, &ch.CurrentTXV)
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring current transaction")
+	}
+	if err = p.decodeKey(channelKey(id, keyStaging), func(r *bytes.Reader) error {
+		return p.decodeTX(r, &ch.StagingTXV)
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring staging transaction")
+	}
+	if err = p.decodeKey(channelKey(id, keyPending), func(r *bytes.Reader) error {
+		return p.decodeTX(r, &ch.PendingTXV)
+	}); err != nil {
+		return nil, errors.WithMessage(err, "restoring pending transaction")
+	}
+	if ch.PeersV, err = p.channelPeers(id); err != nil {
+		return nil, errors.WithMessage(err, "restoring peers")
+	}
+
+	return &ch, nil
+}
+
+// decodeTX decodes a transaction into tx using the backends of the persister.
+func (p *Persister) decodeTX(r io.Reader, tx *channel.Transaction) error {
+	decoded, err := p.backends.DecodeTransaction(r)
+	if err != nil {
+		return err
+	}
+	*tx = *decoded
+	return nil
+}
+
+// decodeKey reads the value of key from the database and decodes it using
+// dec.
+func (p *Persister) decodeKey(key string, dec func(*bytes.Reader) error) error {
+	data, err := p.db.GetBytes(key)
+	if err != nil {
+		return err
+	}
+	return dec(bytes.NewReader(data))
+}
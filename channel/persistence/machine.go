@@ -0,0 +1,184 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+type (
+	// A Machine is the common interface of the persisting StateMachine and
+	// ActionMachine wrappers. It contains all transitions that are independent
+	// of the type of the channel's app.
+	Machine interface {
+		channel.Source
+		Account() wallet.Account
+		N() channel.Index
+		State() *channel.State
+		StagingState() *channel.State
+		AdjudicatorReq() channel.AdjudicatorReq
+		NextVersion() uint64
+
+		Sig(context.Context) (wallet.Sig, error)
+		AddSig(context.Context, channel.Index, wallet.Sig) error
+		EnableInit(context.Context) error
+		EnableUpdate(context.Context) error
+		EnableFinal(context.Context) error
+		DiscardUpdate(context.Context) error
+		RetainUpdate(context.Context) error
+		SyncCurrentTX(context.Context, channel.Transaction) error
+		SetFunded(context.Context) error
+		SetRegistering(context.Context) error
+		SetWithdrawing(context.Context) error
+		SetSettled(context.Context) error
+	}
+
+	// channelMachine contains the transitions that channel.StateMachine and
+	// channel.ActionMachine have in common.
+	channelMachine interface {
+		channel.Source
+		Sig() (wallet.Sig, error)
+		AddSig(channel.Index, wallet.Sig) error
+		EnableInit() error
+		EnableUpdate() error
+		EnableFinal() error
+		DiscardUpdate() error
+		RetainUpdate() error
+		SyncCurrentTX(channel.Transaction) error
+		SetFunded() error
+		SetRegistering() error
+		SetWithdrawing() error
+		SetSettled() error
+	}
+
+	// machine implements the common transitions of StateMachine and
+	// ActionMachine. It forwards calls to the wrapped machine and, if
+	// successful, persists changed data using the Persister.
+	machine struct {
+		m  channelMachine
+		pr Persister
+	}
+)
+
+// Sig calls Sig on the machine and then persists the added signature.
+// The signature is only returned if it was persisted successfully.
+func (m *machine) Sig(ctx context.Context) (sig wallet.Sig, err error) {
+	if sig, err = m.m.Sig(); err != nil {
+		return
+	}
+	if err = m.pr.SigAdded(ctx, m.m, m.m.Idx()); err != nil {
+		return nil, errors.WithMessage(err, "Persister.SigAdded")
+	}
+	return sig, nil
+}
+
+// AddSig calls AddSig on the machine and then persists the added signature.
+func (m *machine) AddSig(ctx context.Context, idx channel.Index, sig wallet.Sig) error {
+	if err := m.m.AddSig(idx, sig); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.SigAdded(ctx, m.m, idx), "Persister.SigAdded")
+}
+
+// EnableInit calls EnableInit on the machine and then persists the enabled
+// transaction.
+func (m *machine) EnableInit(ctx context.Context) error {
+	if err := m.m.EnableInit(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Enabled(ctx, m.m), "Persister.Enabled")
+}
+
+// EnableUpdate calls EnableUpdate on the machine and then persists the
+// enabled transaction.
+func (m *machine) EnableUpdate(ctx context.Context) error {
+	if err := m.m.EnableUpdate(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Enabled(ctx, m.m), "Persister.Enabled")
+}
+
+// EnableFinal calls EnableFinal on the machine and then persists the enabled
+// transaction.
+func (m *machine) EnableFinal(ctx context.Context) error {
+	if err := m.m.EnableFinal(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Enabled(ctx, m.m), "Persister.Enabled")
+}
+
+// DiscardUpdate calls DiscardUpdate on the machine and then persists the
+// change to the staging transaction.
+func (m *machine) DiscardUpdate(ctx context.Context) error {
+	if err := m.m.DiscardUpdate(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.m), "Persister.Staged")
+}
+
+// RetainUpdate calls RetainUpdate on the machine and then persists the change
+// to the staging and pending transaction.
+func (m *machine) RetainUpdate(ctx context.Context) error {
+	if err := m.m.RetainUpdate(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.m), "Persister.Staged")
+}
+
+// SyncCurrentTX calls SyncCurrentTX on the machine and then persists the new
+// current transaction.
+func (m *machine) SyncCurrentTX(ctx context.Context, tx channel.Transaction) error {
+	if err := m.m.SyncCurrentTX(tx); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Enabled(ctx, m.m), "Persister.Enabled")
+}
+
+// SetFunded calls SetFunded on the machine and then persists the changed
+// phase.
+func (m *machine) SetFunded(ctx context.Context) error {
+	if err := m.m.SetFunded(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.PhaseChanged(ctx, m.m), "Persister.PhaseChanged")
+}
+
+// SetRegistering calls SetRegistering on the machine and then persists the
+// changed phase. If the machine was in the Signing phase, the changes to the
+// staging and pending transaction are persisted, too.
+func (m *machine) SetRegistering(ctx context.Context) error {
+	signing := m.m.Phase() == channel.Signing
+	if err := m.m.SetRegistering(); err != nil {
+		return err
+	}
+	if signing {
+		return errors.WithMessage(m.pr.Staged(ctx, m.m), "Persister.Staged")
+	}
+	return errors.WithMessage(m.pr.PhaseChanged(ctx, m.m), "Persister.PhaseChanged")
+}
+
+// SetWithdrawing calls SetWithdrawing on the machine and then persists the
+// changed phase.
+func (m *machine) SetWithdrawing(ctx context.Context) error {
+	if err := m.m.SetWithdrawing(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.PhaseChanged(ctx, m.m), "Persister.PhaseChanged")
+}
+
+// SetSettled calls SetSettled on the machine and then persists the changed
+// phase.
+func (m *machine) SetSettled(ctx context.Context) error {
+	if err := m.m.SetSettled(); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.PhaseChanged(ctx, m.m), "Persister.PhaseChanged")
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"context"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// NonPersister is a Persister that doesn't do anything. It is used by the
+// client if no persistence is enabled.
+var NonPersister Persister = nonPersister{}
+
+type nonPersister struct{}
+
+func (nonPersister) ChannelCreated(context.Context, channel.Source, []wallet.Address) error {
+	return nil
+}
+func (nonPersister) ChannelRemoved(context.Context, channel.ID) error              { return nil }
+func (nonPersister) Staged(context.Context, channel.Source) error                  { return nil }
+func (nonPersister) SigAdded(context.Context, channel.Source, channel.Index) error { return nil }
+func (nonPersister) Enabled(context.Context, channel.Source) error                 { return nil }
+func (nonPersister) PhaseChanged(context.Context, channel.Source) error            { return nil }
+func (nonPersister) VirtualChanged(context.Context, *VirtualChannel) error         { return nil }
+func (nonPersister) VirtualRemoved(context.Context, channel.ID) error              { return nil }
+func (nonPersister) Close() error                                                  { return nil }
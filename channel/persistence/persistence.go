@@ -0,0 +1,88 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+// Package persistence specifies how the framework interacts with a persistence
+// backend. Every change of the channel state machine is reported to a
+// Persister before the change is acted upon, e.g., before our signature on a
+// new state is sent to the other channel participants.
+package persistence // import "perun.network/go-perun/channel/persistence"
+
+import (
+	"context"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// A Persister is notified about all changes to the channel state machines of
+// a client so that it can persist them. All methods must only return after the
+// data has been written atomically to the persistent storage.
+type Persister interface {
+	// ChannelCreated is called by the client when a new channel is created,
+	// before the initial state is signed. The peers are the Perun network
+	// addresses of all other channel participants.
+	ChannelCreated(ctx context.Context, source channel.Source, peers []wallet.Address) error
+
+	// ChannelRemoved is called by the client when a channel is removed because
+	// it has been successfully settled and its data is no longer needed.
+	ChannelRemoved(ctx context.Context, id channel.ID) error
+
+	// Staged is called when a new valid state got set as the new staging state.
+	// It may already contain one valid signature, either by a remote peer or us
+	// locally. Hence, the signatures must also be persisted.
+	// Staged is also called when the staging state is discarded or retained as
+	// the pending transaction, so the pending transaction must also be
+	// persisted.
+	Staged(ctx context.Context, source channel.Source) error
+
+	// SigAdded is called when a new signature is added to the current staging
+	// state. Only the signature for the given index needs to be persisted.
+	SigAdded(ctx context.Context, source channel.Source, idx channel.Index) error
+
+	// Enabled is called when the current staging state is promoted to the
+	// current state. The old current state can be discarded. The pending
+	// transaction may have been cleared.
+	Enabled(ctx context.Context, source channel.Source) error
+
+	// PhaseChanged is called when a phase change occurred that did not change
+	// the current or staging transaction. Only the phase needs to be persisted.
+	PhaseChanged(ctx context.Context, source channel.Source) error
+
+	// VirtualChanged is called by the client when it received the funding or
+	// settlement request of a participant of a virtual channel of which it is
+	// the intermediary. The whole record must be persisted.
+	VirtualChanged(ctx context.Context, vc *VirtualChannel) error
+
+	// VirtualRemoved is called by the client when a virtual channel of which it
+	// is the intermediary has been settled into both ledger channels.
+	VirtualRemoved(ctx context.Context, id channel.ID) error
+
+	// Close is called by the client when it shuts down. No more persistence
+	// requests will be made after this call and the Persister should free up
+	// all resources.
+	Close() error
+}
+
+// A Restorer allows a Client to restore its channels from persistent storage,
+// e.g., after a restart.
+type Restorer interface {
+	// ActivePeers returns the Perun addresses of all peers with which channels
+	// are persisted.
+	ActivePeers(ctx context.Context) ([]wallet.Address, error)
+
+	// RestorePeer returns all persisted channels with the given peer.
+	RestorePeer(ctx context.Context, peer wallet.Address) ([]*Channel, error)
+
+	// RestoreVirtuals returns all persisted records of virtual channels of
+	// which the client is the intermediary.
+	RestoreVirtuals(ctx context.Context) ([]*VirtualChannel, error)
+}
+
+// A PersistRestorer is a Persister that can also restore its persisted
+// channels.
+type PersistRestorer interface {
+	Persister
+	Restorer
+}
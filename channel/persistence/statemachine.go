@@ -0,0 +1,52 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// A StateMachine is a wrapper around a channel.StateMachine that forwards
+// calls to it and, if successful, persists changed data using a Persister.
+// The in-memory machine is advanced first, so if the Persister returns an
+// error, the caller must not act upon the new machine state, e.g., it must not
+// send a signature that could not be persisted.
+type StateMachine struct {
+	*channel.StateMachine
+	machine
+}
+
+var _ Machine = (*StateMachine)(nil)
+
+// FromStateMachine creates a persisting StateMachine wrapper around the passed
+// StateMachine using the Persister pr.
+func FromStateMachine(m *channel.StateMachine, pr Persister) *StateMachine {
+	return &StateMachine{
+		StateMachine: m,
+		machine:      machine{m: m, pr: pr},
+	}
+}
+
+// Init calls Init on the StateMachine and then persists the new staging state.
+func (m *StateMachine) Init(ctx context.Context, initBals channel.Allocation, initData channel.Data) error {
+	if err := m.StateMachine.Init(initBals, initData); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.StateMachine), "Persister.Staged")
+}
+
+// Update calls Update on the StateMachine and then persists the new staging
+// state.
+func (m *StateMachine) Update(ctx context.Context, stagingState *channel.State, actor channel.Index) error {
+	if err := m.StateMachine.Update(stagingState, actor); err != nil {
+		return err
+	}
+	return errors.WithMessage(m.pr.Staged(ctx, m.StateMachine), "Persister.Staged")
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package persistence
+
+import (
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// A VirtualChannel is the record of a virtual channel of which the client is
+// the intermediary. The virtual channel is funded from the two ledger channels
+// of the intermediary with the participants. Half i of the record belongs to
+// the participant with index i in the virtual channel and is only set after
+// the participant requested the funding, i.e., if Peers[i] is not nil.
+type VirtualChannel struct {
+	Params   *channel.Params
+	TX       channel.Transaction // latest fully signed transaction
+	Parents  [2]channel.ID       // ledger channels with the participants
+	Peers    [2]wallet.Address   // Perun addresses of the participants
+	Idxs     [2][]channel.Index  // indices of the participants in the ledger channels
+	Settling [2]bool             // whether a participant requested the settlement
+}
+
+// ID returns the channel ID of the virtual channel.
+func (vc *VirtualChannel) ID() channel.ID {
+	return vc.Params.ID()
+}
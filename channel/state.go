@@ -109,19 +109,32 @@ func (s State) Encode(w io.Writer) error {
 	return errors.WithMessage(err, "state encode")
 }
 
-// Decode decodes a state from an `io.Reader` or returns an `error`
+// Decode decodes a state from an `io.Reader` or returns an `error`. The
+// backends of the decoding context of the reader are used, see
+// DecodingBackends.
 func (s *State) Decode(r io.Reader) error {
+	return s.decode(r, DecodingBackends(r))
+}
+
+// decode decodes a state using the backends b.
+func (s *State) decode(r io.Reader, b Backends) error {
 	// Decode ID, Version, Allocation, IsFinal
-	if err := wire.Decode(r, &s.ID, &s.Version, &s.Allocation, &s.IsFinal); err != nil {
+	if err := wire.Decode(r, &s.ID, &s.Version); err != nil {
 		return errors.WithMessage(err, "id or version decode")
 	}
+	if err := s.Allocation.decode(r, b); err != nil {
+		return errors.WithMessage(err, "allocation decode")
+	}
+	if err := wire.Decode(r, &s.IsFinal); err != nil {
+		return errors.WithMessage(err, "isfinal decode")
+	}
 	// Decode app
 	var err error
-	def, err := wallet.DecodeAddress(r)
+	def, err := b.DecodeAddress(r)
 	if err != nil {
 		return errors.WithMessage(err, "app definition decode")
 	}
-	s.App, err = AppFromDefinition(def)
+	s.App, err = b.AppFromDefinition(def)
 	if err != nil {
 		return errors.WithMessage(err, "app from definition")
 	}
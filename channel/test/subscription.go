@@ -0,0 +1,47 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package test
+
+import (
+	"context"
+
+	"perun.network/go-perun/channel"
+)
+
+// IdleRegisteredSub is a RegisteredSubscription without any events. It can be
+// returned by the SubscribeRegistered method of test adjudicators. Next blocks
+// until the subscription is closed or its context is done.
+type IdleRegisteredSub struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var _ channel.RegisteredSubscription = (*IdleRegisteredSub)(nil)
+
+// NewIdleRegisteredSub creates a new IdleRegisteredSub that is valid within
+// the given context.
+func NewIdleRegisteredSub(ctx context.Context) *IdleRegisteredSub {
+	ctx, cancel := context.WithCancel(ctx)
+	return &IdleRegisteredSub{ctx: ctx, cancel: cancel}
+}
+
+// Next blocks until the subscription is closed or its context is done and
+// then returns nil.
+func (s *IdleRegisteredSub) Next() *channel.Registered {
+	<-s.ctx.Done()
+	return nil
+}
+
+// Err returns the error of the subscription's context.
+func (s *IdleRegisteredSub) Err() error {
+	return s.ctx.Err()
+}
+
+// Close closes the subscription.
+func (s *IdleRegisteredSub) Close() error {
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+)
+
+// Encode encodes a transaction into an io.Writer. The state of a transaction
+// may be nil and signatures may be missing, which is encoded with preceding
+// flags.
+func (t Transaction) Encode(w io.Writer) error {
+	if err := wire.Encode(w, t.State != nil); err != nil {
+		return err
+	}
+	if t.State == nil {
+		return nil
+	}
+	if err := wire.Encode(w, t.State, Index(len(t.Sigs))); err != nil {
+		return err
+	}
+	for i, sig := range t.Sigs {
+		if err := encodeSig(w, sig); err != nil {
+			return errors.WithMessagef(err, "encoding signature %d", i)
+		}
+	}
+	return nil
+}
+
+// Decode decodes a transaction from an io.Reader, using the backends of the
+// decoding context of the reader, see DecodingBackends.
+func (t *Transaction) Decode(r io.Reader) error {
+	return t.decode(r, DecodingBackends(r))
+}
+
+// decode decodes a transaction using the backends b.
+func (t *Transaction) decode(r io.Reader, b Backends) error {
+	*t = Transaction{}
+	var hasState bool
+	if err := wire.Decode(r, &hasState); err != nil || !hasState {
+		return err
+	}
+
+	state := new(State)
+	var numSigs Index
+	if err := state.decode(r, b); err != nil {
+		return err
+	}
+	if err := wire.Decode(r, &numSigs); err != nil {
+		return err
+	}
+	if numSigs > MaxNumParts {
+		return errors.Errorf("invalid number of signatures: %d", numSigs)
+	}
+	sigs := make([]wallet.Sig, numSigs)
+	for i := range sigs {
+		var err error
+		if sigs[i], err = decodeSig(r, b); err != nil {
+			return errors.WithMessagef(err, "decoding signature %d", i)
+		}
+	}
+	t.State, t.Sigs = state, sigs
+	return nil
+}
+
+// encodeSig encodes a possibly nil signature.
+func encodeSig(w io.Writer, sig wallet.Sig) error {
+	if err := wire.Encode(w, sig != nil); err != nil || sig == nil {
+		return err
+	}
+	return wire.Encode(w, sig)
+}
+
+// decodeSig decodes a signature that was encoded with encodeSig.
+func decodeSig(r io.Reader, b Backends) (wallet.Sig, error) {
+	var hasSig bool
+	if err := wire.Decode(r, &hasSig); err != nil || !hasSig {
+		return nil, err
+	}
+	return b.DecodeSig(r)
+}
@@ -0,0 +1,41 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package channel_test
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestTransactionSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xC0DEC))
+	params := test.NewRandomParams(rng, test.NewRandomApp(rng).Def())
+	state := test.NewRandomState(rng, params)
+	acc := wallettest.NewRandomAccount(rng)
+	sig, err := channel.Sign(acc, params, state)
+	require.NoError(t, err)
+
+	for _, tx := range []channel.Transaction{
+		{},
+		{State: state, Sigs: make([]wallet.Sig, 2)},
+		{State: state, Sigs: []wallet.Sig{nil, sig}},
+	} {
+		var buf bytes.Buffer
+		require.NoError(t, tx.Encode(&buf))
+		var decoded channel.Transaction
+		require.NoError(t, decoded.Decode(&buf))
+		assert.Equal(t, tx, decoded)
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+)
+
+// Act submits our action for the next update of a channel whose app is an
+// ActionApp. The action is broadcast to all other participants, which must
+// also call Act for the same update. Once the actions of all participants are
+// received, they are applied to the current state and the resulting state is
+// signed by all participants.
+//
+// Act blocks until the new state is enabled. If our own action is invalid, it
+// is not sent and an error is returned. If the action of any other participant
+// is invalid or the actions cannot be applied, the update is rejected by the
+// participants that detect it and an error is returned. The channel then
+// stays in its current state, but participants that already sent their
+// signature keep the resulting state as the pending state, like for Update.
+//
+// Every participant proposes the version of the resulting state together with
+// its action and the highest proposed version is used. This way, the
+// participants agree on a version that is higher than all their pending
+// states.
+func (c *Channel) Act(ctx context.Context, action channel.Action) (err error) {
+	if ctx == nil {
+		return errors.New("context must not be nil")
+	}
+	if c.actionMachine == nil {
+		return errors.New("actions are only possible for ActionApps, use Update for StateApps")
+	}
+
+	c.machMtx.Lock() // lock machine while update is in progress
+	defer c.machMtx.Unlock()
+
+	version := c.machine.NextVersion()
+	// The action receiver is created before sending our action, so that the
+	// actions of fast peers are not missed.
+	actRecv, err := c.conn.NewActionRecv()
+	if err != nil {
+		return errors.WithMessage(err, "creating action receiver")
+	}
+	defer actRecv.Close()
+
+	if err = c.actionMachine.AddAction(c.machine.Idx(), action); err != nil {
+		return errors.WithMessage(err, "adding own action")
+	}
+	// if anything goes wrong from now on, we abort the actions or update.
+	var sigSent bool
+	defer func() {
+		if err != nil {
+			if aerr := c.abortActionUpdate(ctx, sigSent); aerr != nil {
+				// aborting should never fail
+				err = errors.WithMessagef(aerr,
+					"progressing action update failed: %v, then aborting failed", err)
+			}
+		}
+	}()
+
+	var buf bytes.Buffer
+	if err = action.Encode(&buf); err != nil {
+		return errors.WithMessage(err, "encoding action")
+	}
+	msgAction := &msgChannelAction{
+		ChannelID: c.ID(),
+		Version:   version,
+		Action:    buf.Bytes(),
+	}
+	if err = c.conn.Send(ctx, msgAction); err != nil {
+		return errors.WithMessage(err, "sending action")
+	}
+
+	// The update response receiver can only be created once the version is
+	// known. Responses of fast peers are cached by the channel connection.
+	version, sig, err := c.applyActions(ctx, actRecv, version)
+	if version == 0 {
+		return err // not all actions received
+	}
+	resRecv, rerr := c.conn.NewUpdateResRecv(version)
+	if rerr != nil {
+		return errors.WithMessage(rerr, "creating update response receiver")
+	}
+	defer resRecv.Close()
+	if err != nil {
+		c.rejectActionUpdate(ctx, version, resRecv, err)
+		return err
+	}
+
+	msgUpAcc := &msgChannelUpdateAcc{
+		ChannelID: c.ID(),
+		Version:   version,
+		Sig:       sig,
+	}
+	sigSent = true // even if sending fails, some peers may have received it
+	if err = c.conn.Send(ctx, msgUpAcc); err != nil {
+		return errors.WithMessage(err, "sending accept message")
+	}
+
+	// all other participants respond
+	if err = c.receiveUpdateRes(ctx, resRecv, int(c.machine.N())-1); err != nil {
+		return err
+	}
+
+	return c.enableNotifyUpdate(ctx)
+}
+
+// applyActions receives the actions of all other participants on actRecv and
+// adds them to the machine. Then, the actions are applied to the current state
+// and the resulting staging state is signed. All actions are received even if
+// an earlier one is invalid, so that no actions are left over in the channel
+// connection.
+//
+// The version of the resulting state is the highest version proposed by any
+// participant, including our own proposal version. It is returned once all
+// actions were received, even if an action is invalid. If not all actions
+// could be received, version 0 is returned.
+func (c *Channel) applyActions(
+	ctx context.Context,
+	actRecv *channelMsgRecv,
+	version uint64,
+) (uint64, wallet.Sig, error) {
+	app := c.Params().App.(channel.ActionApp) // safe since we have an action machine
+	var err error
+	for i := 0; i < int(c.machine.N())-1; i++ {
+		pidx, m := actRecv.Next(ctx)
+		if m == nil {
+			return 0, nil, errors.New("timeout when waiting for actions")
+		}
+		msgAction := m.(*msgChannelAction) // safe by predicate of the actRecv
+		if msgAction.Version > version {
+			version = msgAction.Version
+		}
+		if err != nil {
+			continue // update already failed
+		}
+
+		var action channel.Action
+		if action, err = app.DecodeAction(bytes.NewReader(msgAction.Action)); err != nil {
+			err = errors.WithMessagef(err, "decoding action of peer[%d]", pidx)
+		} else if err = c.actionMachine.AddAction(pidx, action); err != nil {
+			err = errors.WithMessagef(err, "adding action of peer[%d]", pidx)
+		}
+	}
+	if err != nil {
+		return version, nil, err
+	}
+
+	if err := c.actionMachine.Update(ctx, version); err != nil {
+		return version, nil, errors.WithMessage(err, "applying actions")
+	}
+	sig, err := c.machine.Sig(ctx)
+	return version, sig, errors.WithMessage(err, "signing updated state")
+}
+
+// rejectActionUpdate rejects the update to the given version because of the
+// given reason. Afterwards, the responses of all other participants are
+// received and discarded. Errors are only logged, as the update failed anyways.
+func (c *Channel) rejectActionUpdate(
+	ctx context.Context,
+	version uint64,
+	resRecv *channelMsgRecv,
+	reason error,
+) {
+	msgUpRej := &msgChannelUpdateRej{
+		ChannelID: c.ID(),
+		Version:   version,
+		Reason:    reason.Error(),
+	}
+	if err := c.conn.Send(ctx, msgUpRej); err != nil {
+		c.log.Warnf("error sending reject message: %v", err)
+		return
+	}
+	c.discardUpdateRes(ctx, resRecv, int(c.machine.N())-1)
+}
+
+// abortActionUpdate aborts the staging state like abortUpdate if the actions
+// were already applied and discards the staged actions otherwise.
+func (c *Channel) abortActionUpdate(ctx context.Context, sigSent bool) error {
+	if c.machine.Phase() == channel.Signing {
+		return c.abortUpdate(ctx, sigSent)
+	}
+	return c.actionMachine.DiscardActions()
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"io"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+	"perun.network/go-perun/wire"
+)
+
+func TestChannel_Act(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xAC7))
+
+	t.Run("valid", func(t *testing.T) {
+		chs, cleanup := newActionTestChannels(t, rng, 10, 10)
+		defer cleanup()
+
+		errs := actConcurrently(chs, 3, 4)
+		for i, err := range errs {
+			require.NoError(t, err, "peer[%d] acting", i)
+		}
+		for i, ch := range chs {
+			assert.Equal(t, uint64(1), ch.State().Version, "peer[%d] state version", i)
+			assert.Equal(t, counter(7), *ch.State().Data.(*counter), "peer[%d] counter", i)
+			assert.Equal(t, channel.Acting, ch.Phase())
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		// peer[0] only accepts increments up to 5, so it rejects the action of
+		// peer[1], which accepts increments up to 10.
+		chs, cleanup := newActionTestChannels(t, rng, 5, 10)
+		defer cleanup()
+
+		errs := actConcurrently(chs, 3, 7)
+		for i, err := range errs {
+			assert.Error(t, err, "peer[%d] acting", i)
+		}
+		for i, ch := range chs {
+			assert.Equal(t, uint64(0), ch.State().Version, "peer[%d] state version", i)
+			assert.Equal(t, channel.Acting, ch.Phase())
+		}
+
+		// the channel can still be advanced afterwards
+		errs = actConcurrently(chs, 1, 2)
+		for i, err := range errs {
+			require.NoError(t, err, "peer[%d] acting", i)
+		}
+		for i, ch := range chs {
+			assert.Equal(t, counter(3), *ch.State().Data.(*counter), "peer[%d] counter", i)
+		}
+	})
+
+	t.Run("state app", func(t *testing.T) {
+		ch := newTestChannel(t, rng, newMockAdjudicator(), 0)
+		assert.Error(t, ch.Act(context.Background(), new(counter)))
+	})
+}
+
+// actConcurrently lets all channels act with the given increments
+// concurrently and returns the results.
+func actConcurrently(chs []*Channel, incs ...counter) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	errs := make([]error, len(chs))
+	done := make(chan struct{}, len(chs))
+	for i, ch := range chs {
+		go func(i int, ch *Channel) {
+			inc := incs[i]
+			errs[i] = ch.Act(ctx, &inc)
+			done <- struct{}{}
+		}(i, ch)
+	}
+	for range chs {
+		<-done
+	}
+	return errs
+}
+
+// newActionTestChannels creates two connected and funded channel controllers
+// of a channel with a counterApp. The participants' apps accept increments up
+// to max0 and max1, respectively.
+func newActionTestChannels(t *testing.T, rng *rand.Rand, max0, max1 counter) ([]*Channel, func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var hub peertest.ConnHub
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	newPeers := make(chan *peer.Peer, 1)
+	regs := []*peer.Registry{
+		peer.NewRegistry(accs[0], func(*peer.Peer) {}, hub.NewDialer()),
+		peer.NewRegistry(accs[1], func(p *peer.Peer) { newPeers <- p }, hub.NewDialer()),
+	}
+	go regs[1].Listen(hub.NewListener(parts[1]))
+	p1, err := regs[0].Get(ctx, parts[1])
+	require.NoError(t, err)
+	p0 := <-newPeers
+
+	// Params can only be created for the payment app in this package's tests,
+	// so the app is replaced afterwards.
+	params, err := channel.NewParams(60, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	initBals := channeltest.NewRandomAllocation(rng, len(parts))
+	chs := make([]*Channel, len(parts))
+	for i, max := range []counter{max0, max1} {
+		ps := *params
+		ps.App = &counterApp{max: max}
+		peers := []*peer.Peer{p1, p0}[i : i+1]
+		chs[i], err = newChannel(accs[i], peers, ps, newMockAdjudicator(), persistence.NonPersister)
+		require.NoError(t, err)
+		require.NotNil(t, chs[i].actionMachine)
+		require.NoError(t, chs[i].init(ctx, initBals, new(counter)))
+	}
+
+	sigs := make([]wallet.Sig, len(chs))
+	for i, ch := range chs {
+		sigs[i], err = ch.machine.Sig(ctx)
+		require.NoError(t, err)
+	}
+	for i, ch := range chs {
+		require.NoError(t, ch.machine.AddSig(ctx, channel.Index(i^1), sigs[i^1]))
+		require.NoError(t, ch.machine.EnableInit(ctx))
+		require.NoError(t, ch.machine.SetFunded(ctx))
+	}
+
+	return chs, func() {
+		for _, ch := range chs {
+			assert.NoError(t, ch.Close())
+		}
+		for _, reg := range regs {
+			assert.NoError(t, reg.Close())
+		}
+		assert.NoError(t, hub.Close())
+	}
+}
+
+type (
+	// counterApp is an ActionApp whose data is a counter. The action of every
+	// participant is an increment of the counter, which must not be larger
+	// than max.
+	counterApp struct {
+		max counter
+	}
+
+	// counter is the data and action type of the counterApp.
+	counter uint64
+)
+
+var _ channel.ActionApp = (*counterApp)(nil)
+
+func (a *counterApp) Def() wallet.Address {
+	return payment.AppDef()
+}
+
+func (a *counterApp) DecodeData(r io.Reader) (channel.Data, error) {
+	var c counter
+	return &c, wire.Decode(r, (*uint64)(&c))
+}
+
+func (a *counterApp) DecodeAction(r io.Reader) (channel.Action, error) {
+	var c counter
+	return &c, wire.Decode(r, (*uint64)(&c))
+}
+
+func (a *counterApp) ValidAction(_ *channel.Params, s *channel.State, _ channel.Index, act channel.Action) error {
+	if *act.(*counter) > a.max {
+		return channel.NewActionError(s.ID, "increment too large")
+	}
+	return nil
+}
+
+func (a *counterApp) ApplyActions(_ *channel.Params, s *channel.State, acts []channel.Action) (*channel.State, error) {
+	next := s.Clone()
+	next.Version++
+	c := next.Data.(*counter)
+	for _, act := range acts {
+		*c += *act.(*counter)
+	}
+	return next, nil
+}
+
+func (a *counterApp) InitState(*channel.Params, []channel.Action) (channel.Allocation, channel.Data, error) {
+	return channel.Allocation{}, nil, errors.New("initial actions not supported")
+}
+
+func (c counter) Encode(w io.Writer) error {
+	return wire.Encode(w, uint64(c))
+}
+
+func (c counter) Clone() channel.Data {
+	return &c
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/msg"
+)
+
+func init() {
+	msg.RegisterDecoder(msg.ChannelAction,
+		func(r io.Reader) (msg.Msg, error) {
+			var m msgChannelAction
+			return &m, m.Decode(r)
+		})
+}
+
+// msgChannelAction is the wire message that carries a participant's action for
+// the next update of a channel with an ActionApp. The action is sent in its
+// encoded form because it can only be decoded by the channel's app.
+type msgChannelAction struct {
+	// ChannelID is the channel ID.
+	ChannelID channel.ID
+	// Version of the state that results from applying the actions, as
+	// proposed by the sender. The highest proposed version is used.
+	Version uint64
+	// Action is the encoded action of the sender.
+	Action []byte
+}
+
+var _ ChannelMsg = (*msgChannelAction)(nil)
+
+// Type returns this message's type: ChannelAction
+func (*msgChannelAction) Type() msg.Type {
+	return msg.ChannelAction
+}
+
+func (c msgChannelAction) Encode(w io.Writer) error {
+	if len(c.Action) > math.MaxUint16 {
+		return errors.Errorf("action too long: %d bytes", len(c.Action))
+	}
+	return wire.Encode(w, c.ChannelID, c.Version, uint16(len(c.Action)), c.Action)
+}
+
+func (c *msgChannelAction) Decode(r io.Reader) error {
+	var l uint16
+	if err := wire.Decode(r, &c.ChannelID, &c.Version, &l); err != nil {
+		return err
+	}
+	c.Action = make([]byte, l)
+	return wire.Decode(r, &c.Action)
+}
+
+// ID returns the id of the channel this action refers to.
+func (c *msgChannelAction) ID() channel.ID {
+	return c.ChannelID
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"math/rand"
+	"testing"
+
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wire/msg"
+)
+
+func TestChannelActionSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xAC7104))
+	for i := 0; i < 4; i++ {
+		action := make([]byte, rng.Intn(64))
+		rng.Read(action)
+		m := &msgChannelAction{
+			ChannelID: test.NewRandomChannelID(rng),
+			Version:   uint64(rng.Int63()),
+			Action:    action,
+		}
+		msg.TestMsg(t, m)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+)
+
+// Watch starts the channel watcher routine. It subscribes to Registered events
+// on the adjudicator for this channel. If a peer registers a state with an
+// older version than our current state, the watcher refutes it by registering
+// our current state before the registered timeout runs out. A registered
+// pending state cannot be refuted, as it is newer than our current state.
+//
+// The client starts the watcher of every channel that it opens or restores, so
+// Watch only needs to be called if that watcher returned with an error. Errors
+// while refuting are logged and emitted as ChannelError events, after which
+// the watcher continues.
+//
+// Watch blocks until the channel is closed or the subscription fails, so it
+// should be started in its own go routine, e.g., as `go ch.Watch()`. The
+// returned error is also emitted as a ChannelError event. If the channel is
+// already watched, an error is returned immediately.
+func (c *Channel) Watch() (err error) {
+	if !c.watching.TrySet() {
+		return errors.New("channel is already watched")
+	}
+	defer c.watching.Unset()
+
+	log := c.log.WithField("proc", "watcher")
+	defer log.Info("Watcher returned.")
+	defer func() {
+		if err != nil {
+			c.emit(Event{Type: ChannelError, Err: err})
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.OnCloseAlways(cancel)
+
+	sub, err := c.adjudicator.SubscribeRegistered(ctx, c.Params())
+	if err != nil {
+		return errors.WithMessage(err, "subscribing to Registered events")
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			log.Warnf("closing Registered subscription: %v", err)
+		}
+	}()
+
+	for reg := sub.Next(); reg != nil; reg = sub.Next() {
+		log.Infof("Received Registered event: %v", reg)
+		if err := c.refuteIfStale(ctx, reg); err != nil {
+			err = errors.WithMessage(err, "refuting stale state")
+			log.Error(err)
+			c.emit(Event{Type: ChannelError, Err: err})
+		}
+	}
+
+	if c.IsClosed() {
+		return nil
+	}
+	return errors.WithMessage(sub.Err(), "Registered subscription")
+}
+
+// watch starts the watcher of the channel in a new go routine. If it returns
+// with an error, the error is logged.
+func watch(ch *Channel) {
+	go func() {
+		if err := ch.Watch(); err != nil {
+			ch.log.Errorf("Watcher failed: %v", err)
+		}
+	}()
+}
+
+// refuteIfStale registers our current state if the Registered event is for an
+// older version. The registration must succeed before the event's timeout, so
+// it is used as the deadline of the registration context.
+func (c *Channel) refuteIfStale(ctx context.Context, reg *channel.Registered) error {
+	c.machMtx.RLock()
+	req := c.adjudicatorReq()
+	pending := c.machine.PendingTX()
+	c.machMtx.RUnlock()
+
+	if reg.Version != req.Tx.Version {
+		c.emit(Event{Type: ChannelRegistered, Registered: reg})
+	}
+	if isPendingVersion(pending, reg.Version) {
+		// A peer completed and registered the state of a failed update that we
+		// signed.
+		c.log.Warnf("Pending version %d registered, cannot be refuted", reg.Version)
+		return nil
+	} else if reg.Version > req.Tx.Version {
+		// Either our peer is malicious and registered a state that we never
+		// signed or our persistence lost updates.
+		c.log.Errorf("Registered version %d is newer than our current version %d",
+			reg.Version, req.Tx.Version)
+		return nil
+	} else if reg.Version == req.Tx.Version {
+		return nil
+	}
+
+	if !reg.Timeout.After(time.Now()) {
+		return errors.Errorf(
+			"stale version %d registered, but timeout %v already passed", reg.Version, reg.Timeout)
+	}
+
+	c.log.Warnf("Stale version %d registered, refuting with version %d", reg.Version, req.Tx.Version)
+	ctx, cancel := context.WithDeadline(ctx, reg.Timeout)
+	defer cancel()
+	refuted, err := c.adjudicator.Register(ctx, req)
+	if err != nil {
+		return errors.WithMessage(err, "calling Register")
+	} else if refuted.Version != req.Tx.Version {
+		return errors.Errorf(
+			"unexpected version %d registered, expected %d", refuted.Version, req.Tx.Version)
+	}
+	c.emit(Event{Type: ChannelRefuted, Registered: refuted})
+	return nil
+}
+
+// waitRegisteredTimeout waits until the timeout of our registration reg has
+// passed. Meanwhile, it watches for refutations by other participants. If a
+// newer state is registered, its timeout is awaited instead. The finally
+// registered event is returned. A Concluded registration is returned
+// immediately. An error is returned if a state is registered that is newer
+// than our current state, unless it is our pending state, as we don't know
+// that state and thus cannot withdraw it.
+// The machine must not be locked by the caller, so that it is not locked for
+// the whole challenge duration.
+func (c *Channel) waitRegisteredTimeout(ctx context.Context, reg *channel.Registered) (*channel.Registered, error) {
+	if reg.Concluded || !reg.Timeout.After(time.Now()) {
+		return reg, nil
+	}
+	c.machMtx.RLock()
+	pending := c.machine.PendingTX()
+	c.machMtx.RUnlock()
+	c.log.Infof("Waiting until registration timeout %v.", reg.Timeout)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub, err := c.adjudicator.SubscribeRegistered(ctx, c.Params())
+	if err != nil {
+		return nil, errors.WithMessage(err, "subscribing to Registered events")
+	}
+	defer func() {
+		if err := sub.Close(); err != nil {
+			c.log.Warnf("closing Registered subscription: %v", err)
+		}
+	}()
+
+	events := make(chan *channel.Registered)
+	go func() {
+		defer close(events)
+		for ev := sub.Next(); ev != nil; ev = sub.Next() {
+			select {
+			case events <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	timeout := time.NewTimer(time.Until(reg.Timeout))
+	defer timeout.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil, errors.WithMessage(sub.Err(), "Registered subscription closed")
+			}
+			if isPendingVersion(pending, ev.Version) && ev.Version > reg.Version {
+				c.log.Warnf("Pending version %d registered, awaiting its timeout %v.", ev.Version, ev.Timeout)
+				c.emit(Event{Type: ChannelRefuted, Registered: ev})
+				reg = ev
+				resetTimer(timeout, time.Until(reg.Timeout))
+				continue
+			} else if ev.Version > reg.Version {
+				return nil, errors.Errorf(
+					"newer version %d registered, ours is %d", ev.Version, reg.Version)
+			}
+			if ev.Timeout.After(reg.Timeout) {
+				c.log.Infof("Registration timeout extended to %v.", ev.Timeout)
+				reg = ev
+				resetTimer(timeout, time.Until(reg.Timeout))
+			}
+		case <-timeout.C:
+			return reg, nil
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "ctx done")
+		}
+	}
+}
+
+// resetTimer resets the timer t to d. The timer is stopped and its channel is
+// drained first, so that an expiration before the reset is not received
+// afterwards. The caller must be the only receiver of t.C.
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// isPendingVersion returns whether the given version is the version of the
+// pending transaction.
+func isPendingVersion(pending channel.Transaction, version uint64) bool {
+	return pending.State != nil && pending.Version == version
+}
+
+// recoverFunding settles the channel after some peers failed to fund it in
+// time, so that our own deposit is withdrawn. The funding error is returned
+// with a message about the outcome of the recovery. If the recovery fails, the
+// channel stays persisted and Settle can be called again to retry it.
+func (c *Channel) recoverFunding(ctx context.Context, fundingErr error) error {
+	c.log.Info("Recovering own funds after funding timeout.")
+	if err := c.Settle(ctx); err != nil {
+		return errors.WithMessagef(fundingErr,
+			"recovering own funds failed, retry with Settle: %v; funding timed out", err)
+	}
+	return errors.WithMessage(fundingErr, "own funds recovered; funding timed out")
+}
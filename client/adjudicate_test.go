@@ -0,0 +1,336 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestChannel_Watch(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x3A7C4))
+	adj := newMockAdjudicator()
+	ch := newTestChannel(t, rng, adj, 2)
+
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- ch.Watch() }()
+
+	// current version is not refuted
+	adj.events <- &channel.Registered{ID: ch.ID(), Idx: 1, Version: 2, Timeout: time.Now().Add(timeout)}
+	// stale version whose timeout passed cannot be refuted, but the watcher continues
+	adj.events <- &channel.Registered{ID: ch.ID(), Idx: 1, Version: 1, Timeout: time.Now().Add(-timeout)}
+	assert.Error(t, ch.Watch(), "channel is already watched")
+	// stale version is refuted
+	adj.events <- &channel.Registered{ID: ch.ID(), Idx: 1, Version: 1, Timeout: time.Now().Add(timeout)}
+	select {
+	case req := <-adj.registered:
+		assert.Equal(t, uint64(2), req.Tx.Version)
+		assert.Equal(t, ch.machine.CurrentTX(), req.Tx)
+	case <-time.After(timeout):
+		t.Fatal("stale state was not refuted")
+	}
+
+	require.NoError(t, ch.Close())
+	select {
+	case err := <-watchErr:
+		assert.NoError(t, err)
+	case <-time.After(timeout):
+		t.Fatal("watcher did not return after closing the channel")
+	}
+	assert.Len(t, adj.registered, 0, "current version must not be refuted")
+}
+
+func TestChannel_Watch_Pending(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x3A7C5))
+	adj := newMockAdjudicator()
+	ch := newTestChannel(t, rng, adj, 2)
+	stageTestUpdate(t, ch)
+	require.NoError(t, ch.machine.RetainUpdate(context.Background()))
+
+	watchErr := make(chan error, 1)
+	go func() { watchErr <- ch.Watch() }()
+
+	// pending version cannot be refuted
+	adj.events <- &channel.Registered{ID: ch.ID(), Idx: 1, Version: 3, Timeout: time.Now().Add(timeout)}
+	// the watcher continues
+	adj.events <- &channel.Registered{ID: ch.ID(), Idx: 1, Version: 1, Timeout: time.Now().Add(timeout)}
+	select {
+	case req := <-adj.registered:
+		assert.Equal(t, uint64(2), req.Tx.Version)
+	case <-time.After(timeout):
+		t.Fatal("stale state was not refuted")
+	}
+
+	require.NoError(t, ch.Close())
+	select {
+	case err := <-watchErr:
+		assert.NoError(t, err)
+	case <-time.After(timeout):
+		t.Fatal("watcher did not return after closing the channel")
+	}
+}
+
+func TestChannel_Settle(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5E771E))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t.Run("non-final", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.regTimeout = 100 * time.Millisecond
+		ch := newTestChannel(t, rng, adj, 3)
+		require.False(t, ch.State().IsFinal)
+
+		go func() {
+			// extend the timeout with a registration of the same version
+			adj.events <- &channel.Registered{ID: ch.ID(), Version: 3, Timeout: time.Now().Add(2 * adj.regTimeout)}
+			// the channel is not locked while waiting
+			assert.Equal(t, channel.Withdrawing, ch.Phase())
+		}()
+		start := time.Now()
+		require.NoError(t, ch.Settle(ctx))
+		assert.True(t, time.Since(start) >= 2*adj.regTimeout, "timeout must be waited out")
+		assert.Equal(t, uint64(3), (<-adj.registered).Tx.Version)
+		assert.Equal(t, uint64(3), (<-adj.withdrawn).Tx.Version)
+		assert.Equal(t, channel.Settled, ch.Phase())
+	})
+
+	t.Run("refuted", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.regTimeout = timeout
+		ch := newTestChannel(t, rng, adj, 3)
+
+		go func() {
+			adj.events <- &channel.Registered{ID: ch.ID(), Version: 4, Timeout: time.Now().Add(timeout)}
+		}()
+		assert.Error(t, ch.Settle(ctx))
+		assert.Len(t, adj.withdrawn, 0)
+		assert.Equal(t, channel.Withdrawing, ch.Phase())
+	})
+
+	t.Run("refuted with pending", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.regTimeout = timeout
+		ch := newTestChannel(t, rng, adj, 3)
+		stageTestUpdate(t, ch)
+		require.NoError(t, ch.machine.RetainUpdate(ctx))
+
+		go func() {
+			adj.events <- &channel.Registered{ID: ch.ID(), Version: 4, Timeout: time.Now().Add(100 * time.Millisecond)}
+		}()
+		require.NoError(t, ch.Settle(ctx))
+		assert.Equal(t, uint64(3), (<-adj.registered).Tx.Version)
+		assert.Equal(t, ch.machine.PendingTX(), (<-adj.withdrawn).Tx)
+		assert.Equal(t, channel.Settled, ch.Phase())
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.withdrawErr = errors.New("withdrawal failed")
+		ch := newTestChannel(t, rng, adj, 3)
+
+		assert.Error(t, ch.Settle(ctx))
+		assert.Equal(t, channel.Withdrawing, ch.Phase())
+
+		adj.withdrawErr = nil
+		require.NoError(t, ch.Settle(ctx))
+		assert.Equal(t, channel.Settled, ch.Phase())
+		assert.Len(t, adj.registered, 2)
+		assert.Len(t, adj.withdrawn, 2)
+	})
+
+	t.Run("resumed by Finalize", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.withdrawErr = errors.New("withdrawal failed")
+		ch := newTestChannel(t, rng, adj, 3)
+		assert.Error(t, ch.Settle(ctx))
+
+		// no final state is proposed, as the channel is already being settled
+		adj.withdrawErr = nil
+		require.NoError(t, ch.Finalize(ctx))
+		assert.Equal(t, channel.Settled, ch.Phase())
+		assert.Equal(t, uint64(3), (<-adj.withdrawn).Tx.Version)
+	})
+}
+
+func TestChannel_recoverFunding(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xF0D))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	adj := newMockAdjudicator()
+	ch, _ := newFundingTestChannel(t, rng, adj)
+
+	fundingErr := channel.NewFundingTimeoutError(
+		[]*channel.AssetFundingError{{Asset: 0, TimedOutPeers: []channel.Index{1}}})
+	err := ch.recoverFunding(ctx, fundingErr)
+	assert.True(t, channel.IsFundingTimeoutError(err))
+	assert.Equal(t, uint64(0), (<-adj.registered).Tx.Version)
+	assert.Equal(t, uint64(0), (<-adj.withdrawn).Tx.Version)
+	assert.Equal(t, channel.Settled, ch.Phase())
+
+	t.Run("retried", func(t *testing.T) {
+		adj := newMockAdjudicator()
+		adj.withdrawErr = errors.New("withdrawal failed")
+		ch, _ := newFundingTestChannel(t, rng, adj)
+
+		err := ch.recoverFunding(ctx, fundingErr)
+		assert.True(t, channel.IsFundingTimeoutError(err))
+		assert.Equal(t, channel.Withdrawing, ch.Phase())
+
+		adj.withdrawErr = nil
+		require.NoError(t, ch.Settle(ctx))
+		assert.Equal(t, channel.Settled, ch.Phase())
+	})
+}
+
+// newTestChannel creates a funded two-party payment channel without peer
+// connections, in which we are the first participant. The channel is updated
+// to the given version.
+func TestResetTimer(t *testing.T) {
+	timer := time.NewTimer(0)
+	time.Sleep(10 * time.Millisecond) // the timer expires before the reset
+	resetTimer(timer, time.Hour)
+	select {
+	case <-timer.C:
+		t.Error("received the expiration from before the reset")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	resetTimer(timer, 0)
+	select {
+	case <-timer.C:
+	case <-time.After(timeout):
+		t.Error("reset timer did not expire")
+	}
+}
+
+func newTestChannel(t *testing.T, rng *rand.Rand, adj channel.Adjudicator, version uint64) *Channel {
+	ctx := context.Background()
+	ch, peerAcc := newFundingTestChannel(t, rng, adj)
+	require.NoError(t, ch.machine.SetFunded(ctx))
+	for ch.machine.State().Version < version {
+		state := ch.machine.State().Clone()
+		state.Version++
+		require.NoError(t, ch.stateMachine.Update(ctx, state, 0))
+		signTestChannel(t, ch, peerAcc)
+		require.NoError(t, ch.machine.EnableUpdate(ctx))
+	}
+	return ch
+}
+
+// newFundingTestChannel creates a two-party payment channel without peer
+// connections in the Funding phase, in which we are the first participant. The
+// account of the peer is also returned.
+func newFundingTestChannel(t *testing.T, rng *rand.Rand, adj channel.Adjudicator) (*Channel, wallet.Account) {
+	return newFundingTestChannelWithAlloc(t, rng, adj, channeltest.NewRandomAllocation(rng, 2))
+}
+
+// newFundingTestChannelWithAlloc creates a test channel like
+// newFundingTestChannel with the given initial allocation.
+func newFundingTestChannelWithAlloc(
+	t *testing.T,
+	rng *rand.Rand,
+	adj channel.Adjudicator,
+	alloc *channel.Allocation,
+) (*Channel, wallet.Account) {
+	ctx := context.Background()
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	params, err := channel.NewParams(60, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	ch, err := newChannel(accs[0], nil, *params, adj, persistence.NonPersister)
+	require.NoError(t, err)
+
+	require.NoError(t, ch.init(ctx, alloc, new(payment.NoData)))
+	signTestChannel(t, ch, accs[1])
+	require.NoError(t, ch.machine.EnableInit(ctx))
+	return ch, accs[1]
+}
+
+// signTestChannel signs the staging state of a test channel by us and the peer.
+func signTestChannel(t *testing.T, ch *Channel, peerAcc wallet.Account) {
+	ctx := context.Background()
+	_, err := ch.machine.Sig(ctx)
+	require.NoError(t, err)
+	sig, err := channel.Sign(peerAcc, ch.Params(), ch.machine.StagingState())
+	require.NoError(t, err)
+	require.NoError(t, ch.machine.AddSig(ctx, 1, sig))
+}
+
+type (
+	// mockAdjudicator emits the Registered events sent on events and records
+	// all calls to Register and Withdraw.
+	mockAdjudicator struct {
+		events      chan *channel.Registered
+		registered  chan channel.AdjudicatorReq
+		withdrawn   chan channel.AdjudicatorReq
+		regTimeout  time.Duration // challenge duration after Register
+		withdrawErr error         // error returned by Withdraw
+	}
+
+	mockRegisteredSub struct {
+		ctx    context.Context
+		events <-chan *channel.Registered
+	}
+)
+
+func newMockAdjudicator() *mockAdjudicator {
+	return &mockAdjudicator{
+		events:     make(chan *channel.Registered),
+		registered: make(chan channel.AdjudicatorReq, 10),
+		withdrawn:  make(chan channel.AdjudicatorReq, 10),
+	}
+}
+
+func (a *mockAdjudicator) Register(_ context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	a.registered <- req
+	return &channel.Registered{
+		ID:      req.Params.ID(),
+		Idx:     req.Idx,
+		Version: req.Tx.Version,
+		Timeout: time.Now().Add(a.regTimeout),
+	}, nil
+}
+
+func (a *mockAdjudicator) Withdraw(_ context.Context, req channel.AdjudicatorReq) error {
+	a.withdrawn <- req
+	return a.withdrawErr
+}
+
+func (a *mockAdjudicator) SubscribeRegistered(ctx context.Context, _ *channel.Params) (channel.RegisteredSubscription, error) {
+	return &mockRegisteredSub{ctx: ctx, events: a.events}, nil
+}
+
+func (s *mockRegisteredSub) Next() *channel.Registered {
+	select {
+	case reg := <-s.events:
+		return reg
+	case <-s.ctx.Done():
+		return nil
+	}
+}
+
+func (s *mockRegisteredSub) Err() error {
+	return s.ctx.Err()
+}
+
+func (s *mockRegisteredSub) Close() error {
+	return nil
+}
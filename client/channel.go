@@ -13,54 +13,109 @@ import (
 	"github.com/pkg/errors"
 
 	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
 	"perun.network/go-perun/log"
 	"perun.network/go-perun/peer"
 	perunsync "perun.network/go-perun/pkg/sync"
+	"perun.network/go-perun/pkg/sync/atomic"
 	"perun.network/go-perun/wallet"
 )
 
+// DefaultFinalizeTimeout is the default maximum time that Finalize waits for
+// the other participants to accept the final state before falling back to a
+// dispute, see Client.SetFinalizeTimeout.
+const DefaultFinalizeTimeout = 10 * time.Second
+
 // Channel is the channel controller, progressing the channel state machine and
 // executing the channel update and dispute protocols.
-//
-// Currently, only the two-party protocol is fully implemented.
 type Channel struct {
 	perunsync.Closer
 	log log.Logger
 
-	conn        *channelConn
-	machine     channel.StateMachine
-	machMtx     sync.RWMutex
-	updateSub   chan<- *channel.State
-	adjudicator channel.Adjudicator
+	conn    *channelConn
+	machine persistence.Machine
+	// Exactly one of stateMachine and actionMachine is set, depending on the
+	// type of the channel's app. It is the same machine as machine.
+	stateMachine  *persistence.StateMachine
+	actionMachine *persistence.ActionMachine
+	machMtx       sync.RWMutex
+	updateSub     chan<- *channel.State
+	adjudicator   channel.Adjudicator
+	pr            persistence.Persister
+
+	events *eventEmitter // lifecycle events of the client, may be nil
+
+	watching atomic.Bool // whether the watcher is running, see Watch
+
+	parent *Channel // parent channel of a sub-channel or virtual channel, nil otherwise
+	subMtx sync.Mutex
+	subs   map[channel.ID]*subChannel
+
+	finalizeTimeout time.Duration // see Client.SetFinalizeTimeout
 }
 
 // newChannel is internally used by the Client to create a new channel
-// controller after the channel proposal protocol ran successfully.
+// controller after the channel proposal protocol ran successfully. If the
+// channel's app is a StateApp, the channel is driven by a StateMachine,
+// otherwise by an ActionMachine.
 func newChannel(
 	acc wallet.Account,
 	peers []*peer.Peer,
 	params channel.Params,
 	adjudicator channel.Adjudicator,
+	pr persistence.Persister,
 ) (*Channel, error) {
+	if !channel.IsStateApp(params.App) {
+		machine, err := channel.NewActionMachine(acc, params)
+		if err != nil {
+			return nil, errors.WithMessage(err, "creating action machine")
+		}
+		return newChannelFromMachine(persistence.FromActionMachine(machine, pr), peers, adjudicator, pr)
+	}
+
 	machine, err := channel.NewStateMachine(acc, params)
 	if err != nil {
 		return nil, errors.WithMessage(err, "creating state machine")
 	}
+	return newChannelFromMachine(persistence.FromStateMachine(machine, pr), peers, adjudicator, pr)
+}
+
+// newChannelFromMachine creates a new channel controller around the given
+// persisting machine, which must either be a *persistence.StateMachine or a
+// *persistence.ActionMachine. It is used for new channels as well as for
+// channels that are restored from persistence.
+func newChannelFromMachine(
+	machine persistence.Machine,
+	peers []*peer.Peer,
+	adjudicator channel.Adjudicator,
+	pr persistence.Persister,
+) (*Channel, error) {
+	ch := &Channel{
+		machine:         machine,
+		adjudicator:     adjudicator,
+		pr:              pr,
+		finalizeTimeout: DefaultFinalizeTimeout,
+	}
+	switch m := machine.(type) {
+	case *persistence.StateMachine:
+		ch.stateMachine = m
+	case *persistence.ActionMachine:
+		ch.actionMachine = m
+	default:
+		log.Panicf("unknown machine type %T", machine)
+	}
 
 	// bundle peers into channel connection
-	conn, err := newChannelConn(params.ID(), peers, machine.Idx())
+	conn, err := newChannelConn(machine.ID(), peers, machine.Idx())
 	if err != nil {
 		return nil, errors.WithMessagef(err, "setting up channel connection")
 	}
 
-	logger := log.WithFields(log.Fields{"channel": params.ID(), "id": acc.Address()})
-	conn.SetLogger(logger)
-	return &Channel{
-		log:         logger,
-		conn:        conn,
-		machine:     *machine,
-		adjudicator: adjudicator,
-	}, nil
+	ch.log = log.WithFields(log.Fields{"channel": machine.ID(), "id": machine.Account().Address()})
+	conn.SetLogger(ch.log)
+	ch.conn = conn
+	go ch.handleSyncMsgs()
+	return ch, nil
 }
 
 // Close closes the channel and all associated peer subscriptions.
@@ -75,6 +130,12 @@ func (c *Channel) setLogger(l log.Logger) {
 	c.log = l
 }
 
+// emit emits a lifecycle event of this channel.
+func (c *Channel) emit(ev Event) {
+	ev.ChannelID = c.ID()
+	c.events.emit(ev)
+}
+
 func (c *Channel) logPeer(idx channel.Index) log.Logger {
 	return c.log.WithField("peerIdx", idx)
 }
@@ -89,6 +150,12 @@ func (c *Channel) Idx() channel.Index {
 	return c.machine.Idx()
 }
 
+// Peers returns the Perun addresses of the other participants, ordered by
+// their index in the channel.
+func (c *Channel) Peers() []peer.Address {
+	return append([]peer.Address(nil), c.conn.peerAddrs...)
+}
+
 // Params returns the channel parameters.
 func (c *Channel) Params() *channel.Params {
 	return c.machine.Params()
@@ -115,15 +182,20 @@ func (c *Channel) Phase() channel.Phase {
 // by the user since the Client initializes the channel controller.
 // The state machine is not locked as this function is expected to be called
 // during the initialization phase of the channel controller.
-func (c *Channel) init(initBals *channel.Allocation, initData channel.Data) error {
-	return c.machine.Init(*initBals, initData)
+// The initial state of channels with an ActionApp is set directly, as all
+// participants already agreed on it in the channel proposal protocol.
+func (c *Channel) init(ctx context.Context, initBals *channel.Allocation, initData channel.Data) error {
+	if c.actionMachine != nil {
+		return c.actionMachine.InitFromState(ctx, *initBals, initData)
+	}
+	return c.stateMachine.Init(ctx, *initBals, initData)
 }
 
 // initExchangeSigsAndEnable exchanges signatures on the initial state.
 // The state machine is not locked as this function is expected to be called
 // during the initialization phase of the channel controller.
 func (c *Channel) initExchangeSigsAndEnable(ctx context.Context) error {
-	sig, err := c.machine.Sig()
+	sig, err := c.machine.Sig(ctx)
 	if err != nil {
 		return err
 	}
@@ -143,55 +215,180 @@ func (c *Channel) initExchangeSigsAndEnable(ctx context.Context) error {
 		})
 	}()
 
-	pidx, cm := resRecv.Next(ctx)
-	acc, ok := cm.(*msgChannelUpdateAcc)
-	if !ok {
-		return errors.Errorf(
-			"received unexpected message of type (%T) from peer[%d]: %v",
-			cm, pidx, cm)
-	}
+	for n := c.machine.N() - 1; n > 0; n-- {
+		pidx, cm := resRecv.Next(ctx)
+		acc, ok := cm.(*msgChannelUpdateAcc)
+		if !ok {
+			return errors.Errorf(
+				"received unexpected message of type (%T) from peer[%d]: %v",
+				cm, pidx, cm)
+		}
 
-	if err := c.machine.AddSig(pidx, acc.Sig); err != nil {
-		return err
+		if err := c.machine.AddSig(ctx, pidx, acc.Sig); err != nil {
+			return err
+		}
 	}
-	if err := c.machine.EnableInit(); err != nil {
+	if err := c.machine.EnableInit(ctx); err != nil {
 		return err
 	}
 
 	return errors.WithMessage(<-send, "sending initial signature")
 }
 
-// Settle settles the channel using the Settler. The channel must be in a
-// final state.
+// Settle settles the channel using the Adjudicator. The current state, which
+// is fully signed by all participants, is registered on the blockchain. If it
+// is not final, the challenge duration is waited out while watching for
+// refutations by the other participants. Finally, the funds are withdrawn.
+//
+// Settle can be used to close a channel with unresponsive participants. No
+// more updates are possible after Settle was called. If Settle fails, e.g.,
+// because the context expired while waiting out the challenge duration, it can
+// be called again to resume the settlement. The channel is not locked while
+// the challenge duration is waited out.
+//
+// If a peer refutes with the pending state of a failed update, the pending
+// state is withdrawn instead.
+//
+// The states of the sub-channels of the channel are registered and withdrawn
+// together with it, after which the sub-channels are settled, too.
+//
+// A final sub-channel or virtual channel is settled off-chain into its parent
+// channel instead, for which all participants have to call Settle. If a
+// sub-channel or virtual channel is not final, its parent channel is settled
+// by dispute.
 func (c *Channel) Settle(ctx context.Context) error {
+	if c.parent != nil {
+		return c.settleIntoParent(ctx)
+	}
+
+	reg, err := c.registerDispute(ctx)
+	if err != nil {
+		return err
+	}
+	if reg, err = c.waitRegisteredTimeout(ctx, reg); err != nil {
+		return err
+	}
+	c.emit(Event{Type: ChannelConcluded, Registered: reg})
+
 	c.machMtx.Lock()
 	defer c.machMtx.Unlock()
 
-	// check final state
-	if c.machine.Phase() != channel.Final || !c.machine.State().IsFinal {
-		return errors.New("currently, only channels in a final state can be settled")
+	// The machine was unlocked while waiting, so the phase is checked again.
+	if err := c.machine.SetWithdrawing(ctx); err != nil {
+		return err
+	}
+	req := c.machine.AdjudicatorReq()
+	if req.Tx, err = c.registeredTX(reg); err != nil {
+		return err
+	}
+	req.SubChannels = c.subStates(req.Tx.State)
+	if err := c.adjudicator.Withdraw(ctx, req); err != nil {
+		return errors.WithMessage(err, "calling Withdraw")
 	}
 
+	if err := c.machine.SetSettled(ctx); err != nil {
+		return err
+	}
+	c.emit(Event{Type: ChannelSettled})
+	if err := c.settleSubs(ctx, req.Tx.State); err != nil {
+		return err
+	}
+	return errors.WithMessage(c.pr.ChannelRemoved(ctx, c.ID()), "removing channel from persistence")
+}
+
+// registerDispute registers the current state with the adjudicator and
+// progresses the machine to the Withdrawing phase. If an earlier settlement
+// failed in the Registering or Withdrawing phase, the state is registered
+// again, which returns the existing registration. The registration of a newer
+// state is accepted if it is our pending state.
+func (c *Channel) registerDispute(ctx context.Context) (*channel.Registered, error) {
+	c.machMtx.Lock()
+	defer c.machMtx.Unlock()
+
+	if err := c.machine.SetRegistering(ctx); err != nil {
+		return nil, errors.WithMessage(err, "channel cannot be settled")
+	}
+	reg, err := c.adjudicator.Register(ctx, c.adjudicatorReq())
+	if err != nil {
+		return nil, errors.WithMessage(err, "calling Register")
+	} else if _, err := c.registeredTX(reg); err != nil {
+		return nil, err
+	}
+	c.emit(Event{Type: ChannelRegistered, Registered: reg})
+	return reg, c.machine.SetWithdrawing(ctx)
+}
+
+// adjudicatorReq returns the adjudicator request of the current state together
+// with the signed states of its sub-channels. The machine must be locked by the
+// caller.
+func (c *Channel) adjudicatorReq() channel.AdjudicatorReq {
 	req := c.machine.AdjudicatorReq()
+	req.SubChannels = c.subStates(req.Tx.State)
+	return req
+}
 
-	if reg, err := c.adjudicator.Register(ctx, req); err != nil {
-		return errors.WithMessage(err, "calling Register")
-	} else if reg.Version != req.Tx.Version {
-		return errors.Errorf(
-			"unexpected version %d registered, expected %d", reg.Version, req.Tx.Version)
-	} else if reg.Timeout.After(time.Now()) {
-		c.log.Warnf("Unexpected withdrawal timeout during Settle(). Waiting until %v.", reg.Timeout)
-		timeout := time.After(time.Until(reg.Timeout))
-		select {
-		case <-timeout: // proceed normally with withdrawal
-		case <-ctx.Done():
-			return errors.Wrap(ctx.Err(), "ctx done")
-		}
+// registeredTX returns our transaction of the registered version, which is
+// either the current or the pending transaction. The machine must be locked by
+// the caller.
+func (c *Channel) registeredTX(reg *channel.Registered) (channel.Transaction, error) {
+	current := c.machine.CurrentTX()
+	if reg.Version == current.Version {
+		return current, nil
+	} else if pending := c.machine.PendingTX(); isPendingVersion(pending, reg.Version) {
+		return pending, nil
 	}
+	return channel.Transaction{}, errors.Errorf(
+		"unexpected version %d registered, ours is %d", reg.Version, current.Version)
+}
 
-	if err := c.adjudicator.Withdraw(ctx, req); err != nil {
-		return errors.WithMessage(err, "calling Withdraw")
+// Finalize closes the channel cooperatively. A final state with the current
+// balances is proposed to all other participants, after which no more updates
+// are possible, and the channel is then settled like with Settle. If the other
+// participants reject the final state or don't respond within the finalize
+// timeout of the client, see Client.SetFinalizeTimeout, or before ctx expires,
+// the current state is settled by dispute instead. Finalize returns once the
+// funds are withdrawn. Like Settle, it can be called again to resume a failed
+// settlement.
+//
+// Only one participant should call Finalize. The other participants are
+// notified of the final state by their UpdateHandler and should then call
+// Settle. For sub-channels and virtual channels, Finalize returns once all
+// participants have called Settle. If their final state cannot be negotiated,
+// their parent channel is settled by dispute, see Settle.
+//
+// The final state can only be negotiated for channels with a StateApp whose
+// ValidTransition accepts the unchanged balances with our index as the actor.
+// Otherwise, the channel is always settled by dispute.
+func (c *Channel) Finalize(ctx context.Context) error {
+	if ctx == nil {
+		return errors.New("context must not be nil")
 	}
+	fctx, cancel := context.WithTimeout(ctx, c.finalizeTimeout)
+	err := c.finalize(fctx)
+	cancel()
+	if err != nil {
+		c.log.Warnf("finalizing channel failed, settling by dispute: %v", err)
+	}
+	return c.Settle(ctx)
+}
 
-	return c.machine.SetSettled()
+// finalize proposes a final state with the current balances to all other
+// participants. It returns nil if the channel is already final or being
+// settled or if all peers accept the final state.
+func (c *Channel) finalize(ctx context.Context) error {
+	if err := c.validUpdate(ChannelUpdate{ActorIdx: c.Idx()}, c.Idx()); err != nil {
+		return err
+	}
+
+	c.machMtx.Lock() // lock machine while update is in progress
+	defer c.machMtx.Unlock()
+
+	switch c.machine.Phase() {
+	case channel.Final, channel.Registering, channel.Withdrawing:
+		return nil // nothing to negotiate, Settle resumes the settlement
+	}
+	state := c.machine.State().Clone()
+	state.Version = c.machine.NextVersion()
+	state.IsFinal = true
+	return c.update(ctx, ChannelUpdate{State: state, ActorIdx: c.Idx()})
 }
@@ -7,6 +7,7 @@ package client
 
 import (
 	"context"
+	"sync"
 
 	"github.com/pkg/errors"
 
@@ -19,11 +20,20 @@ import (
 // A channelConn bundles the message sending and receiving infrastructure for a
 // channel. It is an abstraction over a set of peers. Peers are translated into
 // their index in the channel.
+//
+// Peers can be replaced by reconnected peers with ReplacePeer. Hence, peers are
+// identified by their Perun address when translating them into their index.
 type channelConn struct {
-	b         *peer.Broadcaster
+	mtx   sync.RWMutex // protects peers and b
+	peers []*peer.Peer
+	b     *peer.Broadcaster
+
+	id        channel.ID
+	idx       channel.Index // our index
+	peerAddrs []peer.Address
 	r         *peer.Relay
 	upReqRecv *channelMsgRecv
-	peerIdx   map[*peer.Peer]channel.Index
+	syncRecv  *channelMsgRecv
 
 	log log.Logger
 }
@@ -36,7 +46,7 @@ type channelConn struct {
 func newChannelConn(id channel.ID, peers []*peer.Peer, idx channel.Index) (_ *channelConn, err error) {
 	// setup receiving infrastructure:
 	// 1. one relay to combine all channel messages from all peers
-	// 2. two receivers for update requests and update responses
+	// 2. receivers for update requests, sync messages and update responses
 	relay := peer.NewRelay()
 	// we cache all channel messsages for the lifetime of the relay
 	relay.Cache(context.Background(), func(wire.Msg) bool { return true })
@@ -51,64 +61,141 @@ func newChannelConn(id channel.ID, peers []*peer.Peer, idx channel.Index) (_ *ch
 		}
 	}()
 
-	forThisChannel := func(m wire.Msg) bool {
-		cm, ok := m.(ChannelMsg)
-		return ok && cm.ID() == id
+	conn := &channelConn{
+		peers:     peers,
+		b:         peer.NewBroadcaster(peers),
+		id:        id,
+		idx:       idx,
+		peerAddrs: peerAddresses(peers),
+		r:         relay,
+		log:       log.WithField("channel", id),
 	}
-	peerIdx := make(map[*peer.Peer]channel.Index)
 	for i, peer := range peers {
-		i := channel.Index(i)
-		peerIdx[peer] = i
-		// We are not in the peer list, so the peer index is increased after our index.
-		if i >= idx {
-			peerIdx[peer]++
-		}
-		if err = peer.Subscribe(relay, forThisChannel); err != nil {
+		if err = conn.subscribe(peer); err != nil {
 			return nil, errors.WithMessagef(err,
 				"subscribing relay to peer[%d] (%v)", i, peer)
 		}
 	}
 
-	logger := log.WithField("channel", id)
-	upReqRecv := &channelMsgRecv{
-		Receiver: peer.NewReceiver(),
-		peerIdx:  peerIdx,
-		log:      logger,
-	}
-	if err = relay.Subscribe(upReqRecv, func(m wire.Msg) bool {
+	conn.upReqRecv = conn.newRecv(conn.log)
+	if err = relay.Subscribe(conn.upReqRecv, func(m wire.Msg) bool {
 		return m.Type() == wire.ChannelUpdate
 	}); err != nil {
 		return nil, errors.WithMessagef(err, "subscribing update request receiver")
 	}
+	conn.syncRecv = conn.newRecv(conn.log)
+	if err = relay.Subscribe(conn.syncRecv, func(m wire.Msg) bool {
+		return m.Type() == wire.ChannelSync
+	}); err != nil {
+		return nil, errors.WithMessagef(err, "subscribing sync receiver")
+	}
 
-	return &channelConn{
-		b:         peer.NewBroadcaster(peers),
-		r:         relay,
-		upReqRecv: upReqRecv,
-		peerIdx:   peerIdx,
-		log:       logger,
-	}, nil
+	return conn, nil
+}
+
+// subscribe subscribes the relay to all messages of the given peer regarding
+// this channel.
+func (c *channelConn) subscribe(p *peer.Peer) error {
+	return p.Subscribe(c.r, func(m wire.Msg) bool {
+		cm, ok := m.(ChannelMsg)
+		return ok && cm.ID() == c.id
+	})
+}
+
+// newRecv creates a new channel message receiver that translates peers into
+// their channel index using this channel connection.
+func (c *channelConn) newRecv(l log.Logger) *channelMsgRecv {
+	return &channelMsgRecv{
+		Receiver: peer.NewReceiver(),
+		conn:     c,
+		log:      l,
+	}
+}
+
+// ReplacePeer replaces the peer with the same Perun address as p by p, e.g.,
+// after the connection to the peer was lost and the peer reconnected. The
+// relay is subscribed to p and all further messages are sent to p.
+// It returns false if p is not a peer of this channel or already used by the
+// channel connection.
+func (c *channelConn) ReplacePeer(p *peer.Peer) (bool, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for i, old := range c.peers {
+		if old == p {
+			return false, nil
+		} else if !old.PerunAddress.Equals(p.PerunAddress) {
+			continue
+		}
+
+		if err := c.subscribe(p); err != nil {
+			return false, errors.WithMessagef(err, "subscribing relay to peer[%d] (%v)", i, p)
+		}
+		peers := make([]*peer.Peer, len(c.peers))
+		copy(peers, c.peers)
+		peers[i] = p
+		c.peers = peers
+		c.b = peer.NewBroadcaster(peers)
+		return true, nil
+	}
+	return false, nil
+}
+
+// peerIndex returns the channel index of the peer with the given Perun
+// address. The second return value is false if there is no such peer.
+func (c *channelConn) peerIndex(addr peer.Address) (channel.Index, bool) {
+	for i, a := range c.peerAddrs {
+		if a.Equals(addr) {
+			idx := channel.Index(i)
+			// We are not in the peer list, so the peer index is increased after our index.
+			if idx >= c.idx {
+				idx++
+			}
+			return idx, true
+		}
+	}
+	return 0, false
 }
 
 // SetLogger sets the logger of the channel connection. It is assumed to be
 // called once before usage of the connection, so it isn't thread-safe.
 func (c *channelConn) SetLogger(l log.Logger) {
 	c.upReqRecv.log = l
+	c.syncRecv.log = l
 	c.log = l
 }
 
-// Close closes the broadcaster and update request receiver.
+// Close closes the relay and the update request and sync receivers.
 func (c *channelConn) Close() error {
 	err := c.r.Close()
 	if rerr := c.upReqRecv.Close(); err == nil && rerr != nil {
 		err = rerr
 	}
+	if rerr := c.syncRecv.Close(); err == nil && rerr != nil {
+		err = rerr
+	}
 	return err
 }
 
 // send broadcasts the message to all channel participants.
 func (c *channelConn) Send(ctx context.Context, msg wire.Msg) error {
-	return c.b.Send(ctx, msg)
+	c.mtx.RLock()
+	b := c.b
+	c.mtx.RUnlock()
+	return b.Send(ctx, msg)
+}
+
+// SendTo sends the message to the channel participant with the given index.
+func (c *channelConn) SendTo(ctx context.Context, idx channel.Index, msg wire.Msg) error {
+	if idx == c.idx || int(idx) > len(c.peerAddrs) {
+		return errors.Errorf("invalid peer index %d", idx)
+	} else if idx > c.idx {
+		idx-- // we are not in the peer list
+	}
+	c.mtx.RLock()
+	p := c.peers[idx]
+	c.mtx.RUnlock()
+	return p.Send(ctx, msg)
 }
 
 // NextUpdateReq returns the next channel update request that the channel
@@ -121,23 +208,49 @@ func (c *channelConn) NextUpdateReq(ctx context.Context) (channel.Index, *msgCha
 	return idx, m.(*msgChannelUpdate) // safe by the predicate
 }
 
+// NextSync returns the next sync message that the channel connection
+// receives.
+func (c *channelConn) NextSync(ctx context.Context) (channel.Index, *msgChannelSync) {
+	idx, m := c.syncRecv.Next(ctx)
+	if m == nil {
+		return idx, nil // nil conversion doesn't work...
+	}
+	return idx, m.(*msgChannelSync) // safe by the predicate
+}
+
 // newUpdateResRecv creates a new update response receiver for the given version.
 // The receiver should be closed after all expected responses are received.
 // The receiver is also closed when the channel connection is closed.
+//
+// Closed receivers are only unsubscribed asynchronously, so the predicate
+// excludes them explicitly. Otherwise, the messages of a retried update of
+// the same version could be swallowed by the receiver of a failed attempt.
 func (c *channelConn) NewUpdateResRecv(version uint64) (*channelMsgRecv, error) {
-	recv := peer.NewReceiver()
+	recv := c.newRecv(c.log.WithField("version", version))
 	if err := c.r.Subscribe(recv, func(m wire.Msg) bool {
 		resMsg, ok := m.(channelUpdateResMsg)
-		return ok && resMsg.Ver() == version
+		return ok && resMsg.Ver() == version && !recv.IsClosed()
 	}); err != nil {
 		return nil, errors.WithMessagef(err, "subscribing update response receiver")
 	}
+	return recv, nil
+}
 
-	return &channelMsgRecv{
-		Receiver: recv,
-		peerIdx:  c.peerIdx,
-		log:      c.log.WithField("version", version),
-	}, nil
+// NewActionRecv creates a new receiver for the actions of the other channel
+// participants. The actions of all versions are received, since the
+// participants may propose different versions for the next update.
+// The receiver should be closed after all expected actions are received.
+// The receiver is also closed when the channel connection is closed. Like for
+// NewUpdateResRecv, the predicate excludes the receiver once it is closed.
+func (c *channelConn) NewActionRecv() (*channelMsgRecv, error) {
+	recv := c.newRecv(c.log)
+	if err := c.r.Subscribe(recv, func(m wire.Msg) bool {
+		_, ok := m.(*msgChannelAction)
+		return ok && !recv.IsClosed()
+	}); err != nil {
+		return nil, errors.WithMessagef(err, "subscribing action receiver")
+	}
+	return recv, nil
 }
 
 type (
@@ -145,8 +258,8 @@ type (
 	// with Next(), which returns the peer's channel index and the message.
 	channelMsgRecv struct {
 		*peer.Receiver
-		peerIdx map[*peer.Peer]channel.Index
-		log     log.Logger
+		conn *channelConn
+		log  log.Logger
 	}
 )
 
@@ -157,7 +270,7 @@ func (r *channelMsgRecv) Next(ctx context.Context) (channel.Index, ChannelMsg) {
 	if peer == nil || msg == nil {
 		return 0, nil // receiver was closed or context is done
 	}
-	idx, ok := r.peerIdx[peer]
+	idx, ok := r.conn.peerIndex(peer.PerunAddress)
 	if !ok {
 		r.log.Panicf("channel connection received message from unknown peer %v", peer)
 	}
@@ -73,6 +73,18 @@ func (r *chanRegistry) Delete(id channel.ID) (deleted bool) {
 	return
 }
 
+// Values returns a snapshot of all registered channels.
+func (r *chanRegistry) Values() []*Channel {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	chs := make([]*Channel, 0, len(r.values))
+	for _, ch := range r.values {
+		chs = append(chs, ch)
+	}
+	return chs
+}
+
 func (r *chanRegistry) CloseAll() (err error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -7,10 +7,13 @@ package client // import "perun.network/go-perun/client"
 
 import (
 	"context"
+	stdsync "sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
 	"perun.network/go-perun/log"
 	"perun.network/go-perun/peer"
 	"perun.network/go-perun/pkg/sync"
@@ -21,8 +24,6 @@ import (
 // Client is a state channel client. It is the central controller to interact
 // with a state channel network. It can be used to propose channels to other
 // channel network peers.
-//
-// Currently, only the two-party protocol is fully implemented.
 type Client struct {
 	id          peer.Identity
 	peers       *peer.Registry
@@ -30,8 +31,23 @@ type Client struct {
 	propHandler ProposalHandler
 	funder      channel.Funder
 	adjudicator channel.Adjudicator
+	pr          persistence.Persister
+	backends    channel.Backends
 	log         log.Logger // structured logger for this client
 
+	ver0CacheMtx stdsync.Mutex
+	ver0Caches   map[*ver0CacheReq]struct{}
+
+	events eventEmitter
+
+	virtualMtx stdsync.Mutex
+	virtuals   map[channel.ID]*virtualChannel // virtual channels we are the intermediary of
+
+	finalizeTimeout time.Duration // see SetFinalizeTimeout
+
+	extMsgMtx      stdsync.Mutex
+	extMsgHandlers []extMsgHandler
+
 	sync.Closer
 }
 
@@ -78,8 +94,13 @@ func New(
 		propHandler: propHandler,
 		funder:      funder,
 		adjudicator: adjudicator,
+		pr:          persistence.NonPersister,
 		log:         log.WithField("id", id.Address()),
 		channels:    makeChanRegistry(),
+		ver0Caches:  make(map[*ver0CacheReq]struct{}),
+		virtuals:    make(map[channel.ID]*virtualChannel),
+
+		finalizeTimeout: DefaultFinalizeTimeout,
 	}
 	c.peers = peer.NewRegistry(id, c.subscribePeer, dialer)
 	return c
@@ -96,9 +117,51 @@ func (c *Client) Close() error {
 	if cerr := c.peers.Close(); err == nil {
 		err = errors.WithMessage(cerr, "closing registry")
 	}
+	if cerr := c.pr.Close(); err == nil {
+		err = errors.WithMessage(cerr, "closing persister")
+	}
 	return err
 }
 
+// EnablePersistence sets the Persister that the client uses to persist all
+// changes to its channels. It must be called before any channels are opened.
+// By default, channels are not persisted.
+//
+// The client takes ownership of the Persister and closes it when the client is
+// closed.
+func (c *Client) EnablePersistence(pr persistence.Persister) {
+	if pr == nil {
+		c.log.Panic("persister must not be nil")
+	}
+	c.pr = pr
+}
+
+// SetBackends sets the channel, wallet and app backends that the client uses
+// for the parameters of new and restored channels and for signing and
+// verifying their states. Wire messages from peers are decoded with the same
+// backends if their connections are peer.ContextConns, like the connections
+// created by peer.NewIoConn. SetBackends must be called before any peers
+// connect and before any channels are opened or restored. By default, and for
+// nil backends, the global backends are used. The persister must decode
+// restored channels with the same backends, see
+// keyvalue.Persister.SetBackends.
+func (c *Client) SetBackends(b channel.Backends) {
+	c.backends = b
+	c.peers.SetDecodingContext(b)
+}
+
+// SetFinalizeTimeout sets the maximum time that Channel.Finalize waits for the
+// other participants to accept the final state before the channel is settled
+// by dispute. It applies to all channels that are opened or restored
+// afterwards, so it should be called before any channels are opened. By
+// default, DefaultFinalizeTimeout is used.
+func (c *Client) SetFinalizeTimeout(d time.Duration) {
+	if d <= 0 {
+		c.log.Panic("finalize timeout must be positive")
+	}
+	c.finalizeTimeout = d
+}
+
 // Channel queries a channel by its ID.
 func (c *Client) Channel(id channel.ID) (*Channel, error) {
 	if ch, ok := c.channels.Get(id); ok {
@@ -125,6 +188,14 @@ func (c *Client) subscribePeer(p *peer.Peer) {
 
 	// handle incoming channel proposals
 	c.subChannelProposals(p)
+	// handle requests of virtual channels we are the intermediary of
+	c.subVirtualChannelMsgs(p)
+	// handle external messages, see HandleMsgs
+	c.subExtMsgs(p)
+	// cache version 0 signatures of pending channel proposals
+	c.enablePendingVer0Caches(p)
+	// resync channels with the peer if it reconnected
+	c.syncChannels(p)
 
 	log := c.logPeer(p)
 	p.SetDefaultMsgHandler(func(m wire.Msg) {
@@ -145,6 +216,15 @@ func (c *Client) logChan(id channel.ID) log.Logger {
 	return c.log.WithField("channel", id)
 }
 
+// peerAddresses returns the Perun addresses of the given peers.
+func peerAddresses(peers []*peer.Peer) []peer.Address {
+	addrs := make([]peer.Address, len(peers))
+	for i, p := range peers {
+		addrs[i] = p.PerunAddress
+	}
+	return addrs
+}
+
 // getPeers gets all peers from the registry for the provided addresses,
 // skipping the own peer, if present in the list.
 func (c *Client) getPeers(
@@ -19,6 +19,7 @@ import (
 
 	simwallet "perun.network/go-perun/backend/sim/wallet"
 	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
 	"perun.network/go-perun/peer"
 	peertest "perun.network/go-perun/peer/test"
 	perunsync "perun.network/go-perun/pkg/sync"
@@ -86,9 +87,10 @@ func (d *DummyAdjudicator) Withdraw(context.Context, channel.AdjudicatorReq) err
 	return errors.New("DummyAdjudicator.Withdraw called")
 }
 
-func (d *DummyAdjudicator) SubscribeRegistered(context.Context, *channel.Params) (channel.RegisteredSubscription, error) {
-	d.t.Error("DummyAdjudicator.SubscribeRegistered called")
-	return nil, errors.New("DummyAdjudicator.SubscribeRegistered called")
+// SubscribeRegistered returns a subscription without events, as the watcher of
+// every channel subscribes.
+func (d *DummyAdjudicator) SubscribeRegistered(ctx context.Context, _ *channel.Params) (channel.RegisteredSubscription, error) {
+	return channeltest.NewIdleRegisteredSub(ctx), nil
 }
 
 func TestClient_New_NilHandlerPanic(t *testing.T) {
@@ -0,0 +1,170 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"fmt"
+	stdsync "sync"
+
+	"perun.network/go-perun/channel"
+)
+
+// EventType is the type of a channel lifecycle Event.
+type EventType uint8
+
+// The types of channel lifecycle events.
+const (
+	// ProposalReceived is emitted when a valid channel proposal is received,
+	// before the ProposalHandler is called.
+	ProposalReceived EventType = iota
+	// ProposalAccepted is emitted when all participants accepted a channel
+	// proposal, before the channel is funded.
+	ProposalAccepted
+	// ProposalRejected is emitted when we rejected a channel proposal or a
+	// peer rejected our proposal. Err contains the reason.
+	ProposalRejected
+	// ChannelFunded is emitted when a channel is funded and ready for updates.
+	ChannelFunded
+	// ChannelUpdated is emitted for every new state of a channel.
+	ChannelUpdated
+	// ChannelRegistered is emitted when we registered a state on-chain or the
+	// channel watcher observed the registration of a different state than our
+	// current state.
+	ChannelRegistered
+	// ChannelRefuted is emitted when a registered state was refuted by a newer
+	// state, either by us or by a peer.
+	ChannelRefuted
+	// ChannelConcluded is emitted when the challenge duration of the registered
+	// state has passed, so that its outcome can be withdrawn.
+	ChannelConcluded
+	// ChannelSettled is emitted when a channel is settled, i.e., our funds are
+	// withdrawn or settled into the parent channel.
+	ChannelSettled
+	// ChannelError is emitted for errors in background routines like the
+	// channel watcher, which are otherwise only logged. Err contains the error.
+	ChannelError
+)
+
+var eventTypeNames = [...]string{
+	"ProposalReceived", "ProposalAccepted", "ProposalRejected", "ChannelFunded",
+	"ChannelUpdated", "ChannelRegistered", "ChannelRefuted", "ChannelConcluded",
+	"ChannelSettled", "ChannelError",
+}
+
+func (t EventType) String() string {
+	if int(t) >= len(eventTypeNames) {
+		return fmt.Sprintf("%d", t)
+	}
+	return eventTypeNames[t]
+}
+
+// Event is a channel lifecycle event. Only the fields that are relevant for
+// its type are set.
+type Event struct {
+	Type EventType
+	// ChannelID is the channel ID. It is channel.Zero for proposal events
+	// that happen before the channel ID is known.
+	ChannelID channel.ID
+	// Proposal is the channel proposal of proposal events.
+	Proposal *ChannelProposalReq
+	// State is the new state of ChannelFunded and ChannelUpdated events.
+	State *channel.State
+	// Registered is the registered state of ChannelRegistered, ChannelRefuted
+	// and ChannelConcluded events.
+	Registered *channel.Registered
+	// Err is the reason of ProposalRejected and ChannelError events.
+	Err error
+}
+
+// SubEvents subscribes events to the lifecycle events of all channels of the
+// client. Events are emitted in order and never block the client, as they are
+// queued until they are read from events. Only one subscription is possible.
+//
+// ChannelUpdated events are emitted in addition to the states that are sent
+// on the SubUpdates subscription of a channel.
+func (c *Client) SubEvents(events chan<- Event) {
+	if events == nil {
+		c.log.Panic("events must not be nil")
+	}
+	if !c.events.subscribe(events) {
+		c.log.Panic("events already subscribed")
+	}
+	go c.events.forward(c.Closed())
+}
+
+// eventEmitter queues emitted events and forwards them to the subscribed event
+// channel.
+type eventEmitter struct {
+	mtx    stdsync.Mutex
+	sub    chan<- Event
+	queue  []Event
+	notify chan struct{}
+}
+
+// subscribe sets the subscribed event channel. It returns false if there
+// already is a subscription.
+func (e *eventEmitter) subscribe(sub chan<- Event) bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if e.sub != nil {
+		return false
+	}
+	e.sub = sub
+	e.notify = make(chan struct{}, 1)
+	return true
+}
+
+// emit queues the event if there is a subscription. It is safe to call emit
+// on a nil emitter, which drops the event.
+func (e *eventEmitter) emit(ev Event) {
+	if e == nil {
+		return
+	}
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if e.sub == nil {
+		return
+	}
+	e.queue = append(e.queue, ev)
+	select {
+	case e.notify <- struct{}{}:
+	default: // forwarder already notified
+	}
+}
+
+// forward forwards queued events to the subscribed event channel until closed
+// is closed.
+func (e *eventEmitter) forward(closed <-chan struct{}) {
+	for {
+		select {
+		case <-e.notify:
+		case <-closed:
+			return
+		}
+		for ev, ok := e.next(); ok; ev, ok = e.next() {
+			select {
+			case e.sub <- ev:
+			case <-closed:
+				return
+			}
+		}
+	}
+}
+
+// next dequeues the next event. It returns false if the queue is empty.
+func (e *eventEmitter) next() (Event, bool) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if len(e.queue) == 0 {
+		return Event{}, false
+	}
+	ev := e.queue[0]
+	e.queue = e.queue[1:]
+	return ev, true
+}
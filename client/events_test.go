@@ -0,0 +1,94 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+)
+
+func TestClient_SubEvents(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xE7E27))
+
+	t.Run("lifecycle", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		peerChs, errs := make(chan *client.Channel, 1), make(chan error, 1)
+		clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2, func(i int) client.ProposalHandler {
+			return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: peerChs, errs: errs}
+		})
+		defer closeAll()
+		events := []chan client.Event{make(chan client.Event), make(chan client.Event)}
+		for i, c := range clients {
+			c.SubEvents(events[i])
+		}
+
+		ch, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+		require.NoError(t, err)
+		peerCh := <-peerChs
+		updateErrs := make(chan error, 1)
+		go peerCh.ListenUpdates(&updateHandler{errs: updateErrs})
+		require.NoError(t, updateMultiPartyChannel(ch))
+		require.NoError(t, <-updateErrs)
+		require.NoError(t, ch.Finalize(ctx))
+		require.NoError(t, <-updateErrs)
+
+		assertEvents(t, events[0], ch.ID(),
+			client.ProposalAccepted, client.ChannelFunded, client.ChannelUpdated, client.ChannelUpdated,
+			client.ChannelRegistered, client.ChannelConcluded, client.ChannelSettled)
+		assertEvents(t, events[1], ch.ID(),
+			client.ProposalReceived, client.ProposalAccepted, client.ChannelFunded,
+			client.ChannelUpdated, client.ChannelUpdated)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2, func(int) client.ProposalHandler {
+			return rejectAllHandler{}
+		})
+		defer closeAll()
+		events := []chan client.Event{make(chan client.Event), make(chan client.Event)}
+		for i, c := range clients {
+			c.SubEvents(events[i])
+		}
+
+		_, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+		require.Error(t, err)
+		assertEvents(t, events[0], channel.Zero, client.ProposalRejected)
+		assertEvents(t, events[1], channel.Zero, client.ProposalReceived, client.ProposalRejected)
+	})
+}
+
+// assertEvents asserts that the next events have the given types. Proposal
+// events must carry a proposal and all other events must belong to the given
+// channel.
+func assertEvents(t *testing.T, events <-chan client.Event, id channel.ID, types ...client.EventType) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	for i, typ := range types {
+		select {
+		case ev := <-events:
+			assert.Equal(t, typ, ev.Type, "event %d", i)
+			switch ev.Type {
+			case client.ProposalReceived, client.ProposalRejected:
+				assert.NotNil(t, ev.Proposal, "event %d", i)
+			case client.ProposalAccepted:
+				assert.NotNil(t, ev.Proposal, "event %d", i)
+				assert.Equal(t, id, ev.ChannelID, "event %d", i)
+			default:
+				assert.Equal(t, id, ev.ChannelID, "event %d", i)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timeout waiting for event %d (%v)", i, typ)
+		}
+	}
+}
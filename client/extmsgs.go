@@ -0,0 +1,79 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/peer"
+	wire "perun.network/go-perun/wire/msg"
+)
+
+// extMsgHandler is a handler of external messages, registered by HandleMsgs.
+type extMsgHandler struct {
+	predicate wire.Predicate
+	handle    func(peer.Address, wire.Msg)
+}
+
+// SendMsg sends the message m to the peer with the given Perun address. The
+// peer is dialed if it is not connected yet. Together with HandleMsgs, it
+// enables protocols on top of channels that need additional message types,
+// which are registered with wire.RegisterExternalDecoder.
+func (c *Client) SendMsg(ctx context.Context, addr peer.Address, m wire.Msg) error {
+	p, err := c.peers.Get(ctx, addr)
+	if err != nil {
+		return errors.WithMessage(err, "getting peer")
+	}
+	return errors.WithMessage(p.Send(ctx, m), "sending message")
+}
+
+// HandleMsgs calls handle in a new go routine for every message that matches
+// the predicate and is received from a peer that connects after the call.
+// Handlers should be registered before the client starts listening or
+// connects to any peers.
+func (c *Client) HandleMsgs(predicate wire.Predicate, handle func(peer.Address, wire.Msg)) {
+	if predicate == nil || handle == nil {
+		c.log.Panic("predicate and handler must not be nil")
+	}
+	c.extMsgMtx.Lock()
+	defer c.extMsgMtx.Unlock()
+	c.extMsgHandlers = append(c.extMsgHandlers, extMsgHandler{predicate, handle})
+}
+
+// subExtMsgs subscribes the registered external message handlers to the peer.
+func (c *Client) subExtMsgs(p *peer.Peer) {
+	c.extMsgMtx.Lock()
+	handlers := append([]extMsgHandler(nil), c.extMsgHandlers...)
+	c.extMsgMtx.Unlock()
+
+	for _, h := range handlers {
+		recv := peer.NewReceiver()
+		if err := p.Subscribe(recv, h.predicate); err != nil {
+			c.logPeer(p).Errorf("failed to subscribe to external messages on new peer: %v", err)
+			recv.Close()
+			continue
+		}
+
+		p.OnCloseAlways(func() {
+			if err := recv.Close(); err != nil {
+				c.logPeer(p).Errorf("failed to close external message receiver: %v", err)
+			}
+		})
+
+		go func(h extMsgHandler) {
+			for {
+				_p, m := recv.Next(context.Background())
+				if _p == nil {
+					c.logPeer(p).Debug("external message subscription closed")
+					return
+				}
+				go h.handle(p.PerunAddress, m)
+			}
+		}(h)
+	}
+}
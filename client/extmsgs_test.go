@@ -0,0 +1,64 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/msg"
+)
+
+const extMsgType = msg.Type(0xF0)
+
+func init() {
+	msg.RegisterExternalDecoder(extMsgType, func(r io.Reader) (msg.Msg, error) {
+		var m extMsg
+		return &m, wire.Decode(r, &m.N)
+	}, "ExtMsg")
+}
+
+type extMsg struct{ N uint64 }
+
+func (*extMsg) Type() msg.Type { return extMsgType }
+
+func (m extMsg) Encode(w io.Writer) error { return wire.Encode(w, m.N) }
+
+func TestClient_SendMsg(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xE7))
+	clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2,
+		func(int) client.ProposalHandler { return rejectAllHandler{} })
+	defer closeAll()
+
+	type recvd struct {
+		from peer.Address
+		m    msg.Msg
+	}
+	received := make(chan recvd, 1)
+	clients[1].HandleMsgs(func(m msg.Msg) bool { return m.Type() == extMsgType },
+		func(from peer.Address, m msg.Msg) { received <- recvd{from, m} })
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	require.NoError(t, clients[0].SendMsg(ctx, addrs[1], &extMsg{N: 42}))
+	select {
+	case r := <-received:
+		assert.True(t, r.from.Equals(addrs[0]))
+		assert.Equal(t, &extMsg{N: 42}, r.m)
+	case <-ctx.Done():
+		t.Fatal("message not received")
+	}
+
+	assert.Panics(t, func() { clients[0].HandleMsgs(nil, nil) })
+}
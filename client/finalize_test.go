@@ -0,0 +1,99 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+)
+
+func TestChannel_Finalize(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xC105E))
+
+	t.Run("accepted", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		chs, closeAll := openMultiPartyChannels(t, rng, 2)
+		defer closeAll()
+		errs := listenMultiPartyUpdates(chs, -1)
+
+		require.NoError(t, chs[0].Finalize(ctx))
+		require.NoError(t, <-errs[1])
+		assert.Equal(t, channel.Settled, chs[0].Phase())
+		assert.Equal(t, channel.Final, chs[1].Phase())
+		assert.True(t, chs[1].State().IsFinal)
+		assert.Equal(t, uint64(1), chs[1].State().Version)
+
+		require.NoError(t, chs[1].Settle(ctx))
+		assert.Equal(t, channel.Settled, chs[1].Phase())
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		chs, closeAll := openMultiPartyChannels(t, rng, 2)
+		defer closeAll()
+		errs := listenMultiPartyUpdates(chs, 1)
+
+		// settled by dispute
+		require.NoError(t, chs[0].Finalize(ctx))
+		require.NoError(t, <-errs[1])
+		assert.Equal(t, channel.Settled, chs[0].Phase())
+		assert.False(t, chs[0].State().IsFinal)
+		assert.Equal(t, channel.Acting, chs[1].Phase())
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		peerChs, errs := make(chan *client.Channel, 1), make(chan error, 1)
+		clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2, func(i int) client.ProposalHandler {
+			return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: peerChs, errs: errs}
+		})
+		defer closeAll()
+		clients[0].SetFinalizeTimeout(defaultTimeout / 10)
+		ch, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+		require.NoError(t, err)
+		var peerCh *client.Channel
+		select {
+		case peerCh = <-peerChs:
+		case err := <-errs:
+			t.Fatalf("peer failed to accept: %v", err)
+		}
+
+		// The peer doesn't handle updates, so the channel is settled by dispute
+		// once the finalize timeout passed.
+		require.NoError(t, ch.Finalize(ctx))
+		assert.Equal(t, channel.Settled, ch.Phase())
+		assert.False(t, ch.State().IsFinal)
+		assert.Equal(t, channel.Acting, peerCh.Phase())
+	})
+
+	t.Run("final", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		chs, closeAll := openMultiPartyChannels(t, rng, 2)
+		defer closeAll()
+		errs := listenMultiPartyUpdates(chs, -1)
+
+		final := chs[0].State().Clone()
+		final.Version++
+		final.IsFinal = true
+		require.NoError(t, chs[0].Update(ctx, client.ChannelUpdate{State: final, ActorIdx: 0}))
+		require.NoError(t, <-errs[1])
+		// already final, so no update is proposed
+		require.NoError(t, chs[0].Finalize(ctx))
+		assert.Equal(t, channel.Settled, chs[0].Phase())
+		assert.Equal(t, uint64(1), chs[0].State().Version)
+	})
+}
@@ -119,5 +119,5 @@ func (a *logAdjudicator) Withdraw(ctx context.Context, req channel.AdjudicatorRe
 
 func (a *logAdjudicator) SubscribeRegistered(ctx context.Context, params *channel.Params) (channel.RegisteredSubscription, error) {
 	a.log.Infof("SubscribeRegistered: %v", params)
-	return nil, nil
+	return channeltest.NewIdleRegisteredSub(ctx), nil
 }
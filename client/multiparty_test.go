@@ -0,0 +1,300 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+	wire "perun.network/go-perun/wire/msg"
+)
+
+// acceptAllHandler accepts all proposals with a new random participant and
+// sends the resulting channels or errors on chs and errs, respectively.
+type acceptAllHandler struct {
+	rng  *rand.Rand
+	chs  chan *client.Channel
+	errs chan error
+}
+
+func (h *acceptAllHandler) Handle(_ *client.ChannelProposalReq, res *client.ProposalResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	ch, err := res.Accept(ctx, client.ProposalAcc{Participant: wallettest.NewRandomAccount(h.rng)})
+	if err != nil {
+		h.errs <- err
+		return
+	}
+	h.chs <- ch
+}
+
+// rejectAllHandler rejects all proposals.
+type rejectAllHandler struct{}
+
+func (rejectAllHandler) Handle(_ *client.ChannelProposalReq, res *client.ProposalResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	res.Reject(ctx, "not in the mood")
+}
+
+func TestMultiPartyProposal(t *testing.T) {
+	const numParts = 3
+	rng := rand.New(rand.NewSource(0x3A27))
+
+	t.Run("accepted", func(t *testing.T) {
+		chs, errs := make(chan *client.Channel, numParts), make(chan error, numParts)
+		clients, addrs, closeAll := setupMultiPartyClients(t, rng, numParts, func(i int) client.ProposalHandler {
+			return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: chs, errs: errs}
+		})
+		defer closeAll()
+
+		ch, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+		require.NoError(t, err)
+		for i := 1; i < numParts; i++ {
+			select {
+			case peerCh := <-chs:
+				assert.Equal(t, ch.ID(), peerCh.ID())
+				assert.Equal(t, ch.Params().Parts, peerCh.Params().Parts)
+				assert.Equal(t, channel.Acting, peerCh.Phase())
+			case err := <-errs:
+				t.Fatalf("peer failed to accept: %v", err)
+			}
+		}
+		assert.Equal(t, channel.Acting, ch.Phase())
+		assert.Len(t, ch.Params().Parts, numParts)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		chs, errs := make(chan *client.Channel, numParts), make(chan error, numParts)
+		clients, addrs, closeAll := setupMultiPartyClients(t, rng, numParts, func(i int) client.ProposalHandler {
+			if i == numParts-1 {
+				return rejectAllHandler{}
+			}
+			return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: chs, errs: errs}
+		})
+		defer closeAll()
+
+		_, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+		assert.Error(t, err)
+		// the accepting peer is notified about the rejection
+		assert.Error(t, <-errs)
+	})
+}
+
+// silentHandler never responds to proposals. Handle blocks until done is
+// closed.
+type silentHandler struct{ done chan struct{} }
+
+func (h silentHandler) Handle(*client.ChannelProposalReq, *client.ProposalResponder) {
+	<-h.done
+}
+
+func TestMultiPartyProposal_DuplicateResponse(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x3A28))
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	var hub peertest.ConnHub
+	defer hub.Close()
+
+	proposerAcc := wallettest.NewRandomAccount(rng)
+	proposer := client.New(proposerAcc, hub.NewDialer(), rejectAllHandler{},
+		&logFunder{log.Get()}, &logAdjudicator{log.Get()})
+	defer proposer.Close()
+	// The second peer never responds, so that the proposer only receives the
+	// responses of the malicious peer.
+	done := make(chan struct{})
+	defer close(done)
+	silentAcc := wallettest.NewRandomAccount(rng)
+	silent := client.New(silentAcc, hub.NewDialer(), silentHandler{done},
+		&logFunder{log.Get()}, &logAdjudicator{log.Get()})
+	defer silent.Close()
+	go silent.Listen(hub.NewListener(silentAcc.Address()))
+
+	// The malicious peer accepts the proposal twice.
+	malAcc := wallettest.NewRandomAccount(rng)
+	fakeParts := []wallet.Address{wallettest.NewRandomAddress(rng), wallettest.NewRandomAddress(rng)}
+	mal := peer.NewRegistry(malAcc, func(p *peer.Peer) {
+		recv := peer.NewReceiver()
+		if err := p.Subscribe(recv, func(m wire.Msg) bool { return m.Type() == wire.ChannelProposal }); err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			defer recv.Close()
+			_, m := recv.Next(ctx)
+			if m == nil {
+				return
+			}
+			sessID := m.(*client.ChannelProposalReq).SessID()
+			for _, part := range fakeParts {
+				assert.NoError(t, p.Send(ctx, &client.ChannelProposalAcc{SessID: sessID, ParticipantAddr: part}))
+			}
+		}()
+	}, hub.NewDialer())
+	defer mal.Close()
+	go mal.Listen(hub.NewListener(malAcc.Address()))
+
+	_, err := proposeMultiPartyChannel(rng, proposer,
+		[]peer.Address{proposerAcc.Address(), malAcc.Address(), silentAcc.Address()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate proposal response")
+}
+
+// updateHandler accepts or rejects all updates and sends the result of the
+// response on errs.
+type updateHandler struct {
+	reject bool
+	errs   chan error
+}
+
+func (h *updateHandler) Handle(_ client.ChannelUpdate, res *client.UpdateResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+	if h.reject {
+		h.errs <- res.Reject(ctx, "not in the mood")
+		return
+	}
+	h.errs <- res.Accept(ctx)
+}
+
+func TestMultiPartyUpdate(t *testing.T) {
+	const numParts = 3
+	rng := rand.New(rand.NewSource(0x3A28))
+
+	t.Run("accepted", func(t *testing.T) {
+		chs, closeAll := openMultiPartyChannels(t, rng, numParts)
+		defer closeAll()
+		errs := listenMultiPartyUpdates(chs, -1)
+
+		require.NoError(t, updateMultiPartyChannel(chs[0]))
+		for i := 1; i < numParts; i++ {
+			assert.NoError(t, <-errs[i], "peer[%d] accepting", i)
+		}
+		for i, ch := range chs {
+			assert.Equal(t, uint64(1), ch.State().Version, "peer[%d] state version", i)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		chs, closeAll := openMultiPartyChannels(t, rng, numParts)
+		defer closeAll()
+		errs := listenMultiPartyUpdates(chs, numParts-1)
+
+		assert.Error(t, updateMultiPartyChannel(chs[0]))
+		// the accepting peer is notified about the rejection
+		assert.Error(t, <-errs[1])
+		assert.NoError(t, <-errs[numParts-1])
+		for i, ch := range chs {
+			assert.Equal(t, uint64(0), ch.State().Version, "peer[%d] state version", i)
+		}
+	})
+}
+
+// openMultiPartyChannels opens a channel between numParts clients and returns
+// the channel controllers, ordered by their index in the channel.
+func openMultiPartyChannels(t *testing.T, rng *rand.Rand, numParts int) ([]*client.Channel, func()) {
+	peerChs, errs := make(chan *client.Channel, numParts), make(chan error, numParts)
+	clients, addrs, closeAll := setupMultiPartyClients(t, rng, numParts, func(i int) client.ProposalHandler {
+		return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: peerChs, errs: errs}
+	})
+
+	ch, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+	require.NoError(t, err)
+	chs := make([]*client.Channel, numParts)
+	chs[0] = ch
+	for i := 1; i < numParts; i++ {
+		select {
+		case peerCh := <-peerChs:
+			chs[peerCh.Idx()] = peerCh
+		case err := <-errs:
+			t.Fatalf("peer failed to accept: %v", err)
+		}
+	}
+	return chs, closeAll
+}
+
+// listenMultiPartyUpdates starts the update handling of all channels except
+// the first. The channel with index rejecter rejects all updates. The results
+// of the update responses are sent on the returned error channels.
+func listenMultiPartyUpdates(chs []*client.Channel, rejecter int) []chan error {
+	errs := make([]chan error, len(chs))
+	for i, ch := range chs[1:] {
+		i := i + 1
+		errs[i] = make(chan error, 1)
+		go ch.ListenUpdates(&updateHandler{reject: i == rejecter, errs: errs[i]})
+	}
+	return errs
+}
+
+// updateMultiPartyChannel proposes an update of the state version without
+// changing the balances, in which the proposer is the actor.
+func updateMultiPartyChannel(ch *client.Channel) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	state := ch.State().Clone()
+	state.Version++
+	return ch.Update(ctx, client.ChannelUpdate{State: state, ActorIdx: ch.Idx()})
+}
+
+// setupMultiPartyClients creates numParts clients that are connected via a
+// ConnHub. newHandler is called for every client index to create its
+// proposal handler.
+func setupMultiPartyClients(
+	t *testing.T,
+	rng *rand.Rand,
+	numParts int,
+	newHandler func(int) client.ProposalHandler,
+) ([]*client.Client, []peer.Address, func()) {
+	var hub peertest.ConnHub
+	clients := make([]*client.Client, numParts)
+	addrs := make([]peer.Address, numParts)
+	for i := range clients {
+		id := wallettest.NewRandomAccount(rng)
+		addrs[i] = id.Address()
+		logger := log.WithField("role", i)
+		clients[i] = client.New(id, hub.NewDialer(), newHandler(i),
+			&logFunder{logger}, &logAdjudicator{logger})
+		go clients[i].Listen(hub.NewListener(id.Address()))
+	}
+	return clients, addrs, func() {
+		for _, c := range clients {
+			assert.NoError(t, c.Close())
+		}
+		assert.NoError(t, hub.Close())
+	}
+}
+
+func proposeMultiPartyChannel(rng *rand.Rand, c *client.Client, addrs []peer.Address) (*client.Channel, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	initBals := channeltest.NewRandomAllocation(rng, len(addrs))
+	initBals.Locked = nil
+	return c.ProposeChannel(ctx, &client.ChannelProposal{
+		ChallengeDuration: 10,
+		Nonce:             big.NewInt(rng.Int63()),
+		Account:           wallettest.NewRandomAccount(rng),
+		AppDef:            payment.AppDef(),
+		InitData:          new(payment.NoData),
+		InitBals:          initBals,
+		PeerAddrs:         append([]wallet.Address(nil), addrs...),
+	})
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	stdsync "sync"
+	"time"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wallet"
+)
+
+// policyResponseTimeout is the timeout of the PolicyHandler for responding to
+// a proposal. The setup and funding of accepted channels is not included.
+const policyResponseTimeout = 30 * time.Second
+
+// PolicyViolation is the machine-readable reason for the rejection of a
+// proposal by a PolicyHandler. The rejection reason that is sent to the
+// proposer starts with the violation, followed by a colon and a human-readable
+// description. Use ParsePolicyViolation to extract it.
+type PolicyViolation string
+
+// The policy violations of a PolicyHandler.
+const (
+	ViolationPeer              PolicyViolation = "policy/peer"
+	ViolationApp               PolicyViolation = "policy/app"
+	ViolationChallengeDuration PolicyViolation = "policy/challenge-duration"
+	ViolationAsset             PolicyViolation = "policy/asset"
+	ViolationBalance           PolicyViolation = "policy/balance"
+	ViolationChannelLimit      PolicyViolation = "policy/channel-limit"
+)
+
+// ParsePolicyViolation extracts the policy violation from a rejection reason
+// or the error returned by ProposeChannel for a rejected proposal. The second
+// return value is false if the reason contains no policy violation.
+func ParsePolicyViolation(reason string) (PolicyViolation, bool) {
+	for _, v := range []PolicyViolation{
+		ViolationPeer, ViolationApp, ViolationChallengeDuration,
+		ViolationAsset, ViolationBalance, ViolationChannelLimit,
+	} {
+		if strings.Contains(reason, string(v)+":") {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+type (
+	// ProposalPolicy contains the rules by which a PolicyHandler accepts or
+	// rejects channel proposals. Empty rules don't restrict proposals.
+	ProposalPolicy struct {
+		// Peers are the Perun addresses of the peers whose proposals may be
+		// accepted. If empty, proposals of all peers may be accepted.
+		Peers []peer.Address
+		// AppDefs are the allowed app definitions. If empty, all apps are
+		// allowed.
+		AppDefs []wallet.Address
+		// MinChallengeDuration and MaxChallengeDuration bound the challenge
+		// duration. A zero MaxChallengeDuration means no upper bound.
+		MinChallengeDuration, MaxChallengeDuration uint64
+		// Assets are the allowed assets together with the bounds of our own
+		// initial balance. If empty, all assets and balances are allowed.
+		Assets []AssetLimit
+		// MaxChannelsPerPeer is the maximum number of open channels with the
+		// proposer, including the proposed channel. Zero means no limit.
+		MaxChannelsPerPeer int
+		// FundingTimeout is the timeout for setting up and funding accepted
+		// channels. If it runs out before all peers funded the channel, our
+		// funds have to be recovered by calling Settle on the channel that is
+		// passed to the accepted callback. Zero means no timeout.
+		FundingTimeout time.Duration
+	}
+
+	// AssetLimit bounds our own initial balance of an asset. Nil bounds are
+	// ignored.
+	AssetLimit struct {
+		Asset          channel.Asset
+		MinBal, MaxBal *big.Int
+	}
+
+	// PolicyHandler is a ProposalHandler that accepts or rejects proposals
+	// automatically by a ProposalPolicy.
+	PolicyHandler struct {
+		policy   ProposalPolicy
+		account  func(*ChannelProposalReq) wallet.Account
+		accepted func(*Channel, error)
+
+		mtx     stdsync.Mutex
+		pending map[string]int // number of accepted proposals that are set up per proposer
+	}
+)
+
+var _ ProposalHandler = (*PolicyHandler)(nil)
+
+// NewPolicyHandler creates a new PolicyHandler with the given policy. For
+// every accepted proposal, account is called to get the account of our
+// participant and accepted is called with the resulting channel, or with the
+// error if setting up the channel failed. accepted is called by Handle and
+// should start the update handler of the channel.
+//
+// If any argument is nil, NewPolicyHandler panics.
+func NewPolicyHandler(
+	policy ProposalPolicy,
+	account func(*ChannelProposalReq) wallet.Account,
+	accepted func(*Channel, error),
+) *PolicyHandler {
+	if account == nil {
+		log.Panic("account must not be nil")
+	}
+	if accepted == nil {
+		log.Panic("accepted must not be nil")
+	}
+	return &PolicyHandler{
+		policy:   policy,
+		account:  account,
+		accepted: accepted,
+		pending:  make(map[string]int),
+	}
+}
+
+// Handle accepts the proposal if it satisfies the policy and rejects it with
+// the policy violation as reason otherwise. The response is bounded by a fixed
+// timeout, whereas accepted channels are set up and funded within the
+// FundingTimeout of the policy.
+func (h *PolicyHandler) Handle(req *ChannelProposalReq, res *ProposalResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), policyResponseTimeout)
+	defer cancel()
+
+	proposer := req.PeerAddrs[0].String()
+	h.mtx.Lock()
+	v, reason := h.check(res.client, req)
+	if v == "" {
+		h.pending[proposer]++
+	}
+	h.mtx.Unlock()
+
+	if v != "" {
+		if err := res.Reject(ctx, string(v)+": "+reason); err != nil {
+			res.client.log.Warnf("rejecting proposal: %v", err)
+		}
+		return
+	}
+
+	setupCtx, cancelSetup := context.Background(), func() {}
+	if h.policy.FundingTimeout > 0 {
+		setupCtx, cancelSetup = context.WithTimeout(setupCtx, h.policy.FundingTimeout)
+	}
+	defer cancelSetup()
+	ch, err := res.accept(ctx, setupCtx, ProposalAcc{Participant: h.account(req)})
+	h.mtx.Lock()
+	if h.pending[proposer]--; h.pending[proposer] == 0 {
+		delete(h.pending, proposer)
+	}
+	h.mtx.Unlock()
+	h.accepted(ch, err)
+}
+
+// check checks the proposal against the policy. It returns the first
+// violation and its description, or an empty violation if the proposal
+// satisfies the policy. The handler must be locked by the caller.
+func (h *PolicyHandler) check(c *Client, req *ChannelProposalReq) (PolicyViolation, string) {
+	p := &h.policy
+	proposer := req.PeerAddrs[0]
+	if len(p.Peers) > 0 && wallet.IndexOfAddr(p.Peers, proposer) < 0 {
+		return ViolationPeer, "proposer not allowed"
+	}
+	if len(p.AppDefs) > 0 && wallet.IndexOfAddr(p.AppDefs, req.AppDef) < 0 {
+		return ViolationApp, "app not allowed"
+	}
+	if req.ChallengeDuration < p.MinChallengeDuration ||
+		(p.MaxChallengeDuration > 0 && req.ChallengeDuration > p.MaxChallengeDuration) {
+		return ViolationChallengeDuration, fmt.Sprintf(
+			"challenge duration %d not in [%d, %d]",
+			req.ChallengeDuration, p.MinChallengeDuration, p.MaxChallengeDuration)
+	}
+	if v, reason := p.checkBals(req, wallet.IndexOfAddr(req.PeerAddrs, c.id.Address())); v != "" {
+		return v, reason
+	}
+	if p.MaxChannelsPerPeer > 0 {
+		if n := c.numOpenChannels(proposer) + h.pending[proposer.String()]; n >= p.MaxChannelsPerPeer {
+			return ViolationChannelLimit, fmt.Sprintf("%d channels open with proposer", n)
+		}
+	}
+	return "", ""
+}
+
+// checkBals checks that all assets are allowed and that our initial balances
+// are within their bounds.
+func (p *ProposalPolicy) checkBals(req *ChannelProposalReq, ourIdx int) (PolicyViolation, string) {
+	if len(p.Assets) == 0 {
+		return "", ""
+	}
+	for a, asset := range req.InitBals.Assets {
+		limit := p.assetLimit(asset)
+		if limit == nil {
+			return ViolationAsset, fmt.Sprintf("asset %d not allowed", a)
+		}
+		bal := req.InitBals.OfParts[ourIdx][a]
+		if limit.MinBal != nil && bal.Cmp(limit.MinBal) < 0 {
+			return ViolationBalance, fmt.Sprintf("own balance of asset %d below minimum", a)
+		} else if limit.MaxBal != nil && bal.Cmp(limit.MaxBal) > 0 {
+			return ViolationBalance, fmt.Sprintf("own balance of asset %d above maximum", a)
+		}
+	}
+	return "", ""
+}
+
+// assetLimit returns the limit of the given asset or nil if the asset is not
+// allowed.
+func (p *ProposalPolicy) assetLimit(asset channel.Asset) *AssetLimit {
+	for i, limit := range p.Assets {
+		if eq, err := equalEncoding(limit.Asset, asset); err == nil && eq {
+			return &p.Assets[i]
+		}
+	}
+	return nil
+}
+
+// numOpenChannels returns the number of channels with the given peer that are
+// not yet settled.
+func (c *Client) numOpenChannels(addr peer.Address) (n int) {
+	for _, ch := range c.channels.Values() {
+		if _, ok := ch.conn.peerIndex(addr); ok && ch.Phase() != channel.Settled {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestPolicyHandler(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x9011C7))
+	bals := channeltest.NewRandomAllocation(rng, 2)
+	bals.Locked = nil
+	ownBal := bals.OfParts[1][0]
+
+	tests := []struct {
+		name      string
+		policy    func(proposer peer.Address) client.ProposalPolicy
+		violation client.PolicyViolation // empty if accepted
+	}{
+		{
+			name:   "empty",
+			policy: func(peer.Address) client.ProposalPolicy { return client.ProposalPolicy{} },
+		},
+		{
+			name: "allowed",
+			policy: func(proposer peer.Address) client.ProposalPolicy {
+				assets := make([]client.AssetLimit, len(bals.Assets))
+				for i, asset := range bals.Assets {
+					assets[i] = client.AssetLimit{Asset: asset}
+				}
+				assets[0].MinBal, assets[0].MaxBal = ownBal, ownBal
+				return client.ProposalPolicy{
+					Peers:                []peer.Address{wallettest.NewRandomAddress(rng), proposer},
+					AppDefs:              []wallet.Address{payment.AppDef()},
+					MinChallengeDuration: 10,
+					MaxChallengeDuration: 10,
+					Assets:               assets,
+					MaxChannelsPerPeer:   1,
+					FundingTimeout:       defaultTimeout,
+				}
+			},
+		},
+		{
+			name: "peer",
+			policy: func(peer.Address) client.ProposalPolicy {
+				return client.ProposalPolicy{Peers: []peer.Address{wallettest.NewRandomAddress(rng)}}
+			},
+			violation: client.ViolationPeer,
+		},
+		{
+			name: "app",
+			policy: func(peer.Address) client.ProposalPolicy {
+				return client.ProposalPolicy{AppDefs: []wallet.Address{wallettest.NewRandomAddress(rng)}}
+			},
+			violation: client.ViolationApp,
+		},
+		{
+			name: "challenge duration",
+			policy: func(peer.Address) client.ProposalPolicy {
+				return client.ProposalPolicy{MaxChallengeDuration: 9}
+			},
+			violation: client.ViolationChallengeDuration,
+		},
+		{
+			name: "asset",
+			policy: func(peer.Address) client.ProposalPolicy {
+				return client.ProposalPolicy{Assets: []client.AssetLimit{{Asset: bals.Assets[0]}}}
+			},
+			violation: client.ViolationAsset,
+		},
+		{
+			name: "balance",
+			policy: func(peer.Address) client.ProposalPolicy {
+				assets := make([]client.AssetLimit, len(bals.Assets))
+				for i, asset := range bals.Assets {
+					assets[i] = client.AssetLimit{Asset: asset}
+				}
+				assets[0].MaxBal = new(big.Int).Sub(ownBal, big.NewInt(1))
+				return client.ProposalPolicy{Assets: assets}
+			},
+			violation: client.ViolationBalance,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+			defer cancel()
+			// The policy handler is created once the proposer's address is known.
+			var handler delegateHandler
+			clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2, func(i int) client.ProposalHandler {
+				if i == 0 {
+					return rejectAllHandler{}
+				}
+				return &handler
+			})
+			defer closeAll()
+			chs := make(chan *client.Channel, 2)
+			handler.ProposalHandler = client.NewPolicyHandler(tt.policy(addrs[0]),
+				func(*client.ChannelProposalReq) wallet.Account { return wallettest.NewRandomAccount(rng) },
+				func(ch *client.Channel, err error) {
+					assert.NoError(t, err)
+					chs <- ch
+				})
+
+			propose := func() error {
+				initBals := bals.Clone()
+				_, err := clients[0].ProposeChannel(ctx, &client.ChannelProposal{
+					ChallengeDuration: 10,
+					Nonce:             big.NewInt(rng.Int63()),
+					Account:           wallettest.NewRandomAccount(rng),
+					AppDef:            payment.AppDef(),
+					InitData:          new(payment.NoData),
+					InitBals:          &initBals,
+					PeerAddrs:         addrs,
+				})
+				return err
+			}
+
+			err := propose()
+			if tt.violation != "" {
+				require.Error(t, err)
+				v, ok := client.ParsePolicyViolation(err.Error())
+				assert.True(t, ok)
+				assert.Equal(t, tt.violation, v)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, channel.Acting, (<-chs).Phase())
+
+			if tt.name == "allowed" { // second channel exceeds the channel limit
+				err := propose()
+				require.Error(t, err)
+				v, _ := client.ParsePolicyViolation(err.Error())
+				assert.Equal(t, client.ViolationChannelLimit, v)
+			}
+		})
+	}
+}
+
+// delegateHandler delegates to the embedded ProposalHandler, which can be set
+// after the client was created.
+type delegateHandler struct {
+	client.ProposalHandler
+}
+
+func TestParsePolicyViolation(t *testing.T) {
+	v, ok := client.ParsePolicyViolation("channel proposal rejected by peer 1: policy/peer: proposer not allowed")
+	assert.True(t, ok)
+	assert.Equal(t, client.ViolationPeer, v)
+
+	_, ok = client.ParsePolicyViolation("not in the mood")
+	assert.False(t, ok)
+}
@@ -25,6 +25,12 @@ type (
 	//
 	// This is the same as ChannelProposalMsg but with an account instead of only
 	// the address of the proposer. ChannelProposal is not sent over the wire.
+	//
+	// If Parent is set to the ID of an existing ledger channel, a sub-channel is
+	// proposed, which is funded from the parent channel instead of on-chain. If
+	// Intermediary is set, a virtual channel is proposed, which is funded from
+	// the ledger channels of the participants with the intermediary. See
+	// ProposeChannel for details.
 	ChannelProposal struct {
 		ChallengeDuration uint64
 		Nonce             *big.Int
@@ -33,6 +39,8 @@ type (
 		InitData          channel.Data
 		InitBals          *channel.Allocation
 		PeerAddrs         []wallet.Address // Perun addresses of all peers, including the proposer's
+		Parent            channel.ID       // parent channel of a sub-channel, channel.Zero otherwise
+		Intermediary      wallet.Address   // Perun address of the intermediary of a virtual channel, nil otherwise
 	}
 
 	// A ProposalHandler decides how to handle incoming channel proposals from
@@ -77,7 +85,17 @@ func (r *ProposalResponder) Accept(ctx context.Context, acc ProposalAcc) (*Chann
 		log.Panic("nil context")
 	}
 
-	return r.client.handleChannelProposalAcc(ctx, r.peer, r.req, acc)
+	return r.client.handleChannelProposalAcc(ctx, ctx, r.peer, r.req, acc)
+}
+
+// accept is like Accept, but only the acceptance is sent and the final
+// proposal message is received within respCtx. The channel is set up and
+// funded within setupCtx.
+func (r *ProposalResponder) accept(respCtx, setupCtx context.Context, acc ProposalAcc) (*Channel, error) {
+	if !r.called.TrySet() {
+		log.Panic("multiple calls on proposal responder")
+	}
+	return r.client.handleChannelProposalAcc(respCtx, setupCtx, r.peer, r.req, acc)
 }
 
 // Reject lets the user signal that they reject the channel proposal.
@@ -101,6 +119,31 @@ func (r *ProposalResponder) Reject(ctx context.Context, reason string) error {
 // - the channel controller is returned.
 // The user is required to start the update handler with
 // Channel.ListenUpdates(UpdateHandler)
+//
+// If some peers fail to fund the channel in time, the initial state is
+// registered and our deposit is withdrawn, which may take up to the challenge
+// duration. The returned error then satisfies channel.IsFundingTimeoutError
+// and the returned channel is in phase channel.Settled if our funds were
+// recovered successfully.
+//
+// If prop.Parent is set, a sub-channel of the given parent channel is
+// proposed. It must have the same participants and assets as the parent
+// channel, whose app must be a StateApp. Instead of funding the sub-channel
+// on-chain, the initial balances are moved into a locked sub-allocation of the
+// parent channel by a parent update. This update is proposed by us and
+// accepted automatically by the other participants, so they must be listening
+// for updates on the parent channel. Once the sub-channel is final, Settle
+// merges its final balances back into the parent channel the same way.
+//
+// If prop.Intermediary is set, a virtual channel between two participants is
+// proposed, which is funded from their ledger channels with the intermediary.
+// Both participants must have a ledger channel with the intermediary with the
+// same assets as the virtual channel. In each ledger channel, the participant
+// locks its own initial balance and the intermediary locks the initial
+// balance of the other participant. The ledger updates are proposed by the
+// intermediary, so the participants must be listening for updates on their
+// ledger channels. The intermediary does not take part in the virtual
+// channel's updates, which never touch the chain.
 func (c *Client) ProposeChannel(ctx context.Context, prop *ChannelProposal) (*Channel, error) {
 	if ctx == nil || prop == nil {
 		c.log.Panic("invalid nil argument")
@@ -108,15 +151,16 @@ func (c *Client) ProposeChannel(ctx context.Context, prop *ChannelProposal) (*Ch
 
 	// 1. check valid proposal
 	req := prop.AsReq()
-	if err := c.validTwoPartyProposal(req, 0, req.PeerAddrs[1]); err != nil {
+	if err := c.validProposal(req, c.id.Address()); err != nil {
 		return nil, errors.WithMessage(err, "invalid channel proposal")
 	}
 
-	// 2. send proposal and wait for response
-	parts, err := c.exchangeTwoPartyProposal(ctx, req)
+	// 2. send proposal and wait for responses
+	parts, err := c.exchangeProposal(ctx, req)
 	if err != nil {
 		return nil, errors.WithMessage(err, "sending proposal")
 	}
+	c.emitProposalAccepted(req, parts)
 
 	// 3. create params, channel machine from gathered participant addresses
 	// 4. fund channel
@@ -158,22 +202,25 @@ func (c *Client) subChannelProposals(p *peer.Peer) {
 	}()
 }
 
-// handleChannelProposal implements the receiving side of the (currently)
-// two-party channel proposal protocol.
+// handleChannelProposal implements the receiving side of the multi-party
+// channel proposal protocol.
 // The proposer is expected to be the first peer in the participant list.
 func (c *Client) handleChannelProposal(p *peer.Peer, req *ChannelProposalReq) {
-	if err := c.validTwoPartyProposal(req, 1, p.PerunAddress); err != nil {
+	if err := c.validProposal(req, p.PerunAddress); err != nil {
 		c.logPeer(p).Debugf("received invalid channel proposal: %v", err)
 		return
 	}
 
+	c.events.emit(Event{Type: ProposalReceived, Proposal: req})
 	c.logPeer(p).Trace("calling proposal handler")
 	responder := &ProposalResponder{client: c, peer: p, req: req}
 	c.propHandler.Handle(req, responder)
 }
 
+// handleChannelProposalAcc sends our acceptance within ctx. The channel is
+// then set up and funded within setupCtx.
 func (c *Client) handleChannelProposalAcc(
-	ctx context.Context, p *peer.Peer,
+	ctx, setupCtx context.Context, p *peer.Peer,
 	req *ChannelProposalReq, acc ProposalAcc,
 ) (*Channel, error) {
 	if acc.Participant == nil {
@@ -184,10 +231,38 @@ func (c *Client) handleChannelProposalAcc(
 	// enables caching of incoming version 0 signatures before sending any message
 	// that might trigger a fast peer to send those. We don't know the channel id
 	// yet so the cache predicate is coarser than the later subscription.
-	enableVer0Cache(ctx, p)
+	defer c.enableVer0Caches(setupCtx, req.PeerAddrs)()
+
+	// To avoid simultaneous dials between two peers, every peer dials all peers
+	// with a higher index before sending its acceptance. Peers with a lower index
+	// have dialed us before they accepted, which is before the proposer sends the
+	// final proposal message.
+	ourIdx := wallet.IndexOfAddr(req.PeerAddrs, c.id.Address())
+	for _, addr := range req.PeerAddrs[ourIdx+1:] {
+		if _, err := c.peers.Get(ctx, addr); err != nil {
+			return nil, errors.WithMessagef(err, "dialing peer %v", addr)
+		}
+	}
+
+	// In the multi-party case, we need to receive the participant addresses of
+	// all other peers from the proposer after sending our acceptance.
+	sessID := req.SessID()
+	var finRecv *peer.Receiver
+	if len(req.PeerAddrs) > 2 {
+		finRecv = peer.NewReceiver()
+		defer finRecv.Close()
+		if err := p.Subscribe(finRecv, func(m wire.Msg) bool {
+			return (m.Type() == wire.ChannelProposalFinal &&
+				m.(*ChannelProposalFinal).SessID == sessID) ||
+				(m.Type() == wire.ChannelProposalRej &&
+					m.(*ChannelProposalRej).SessID == sessID)
+		}); err != nil {
+			return nil, errors.WithMessagef(err, "subscribing peer %v", p)
+		}
+	}
 
 	msgAccept := &ChannelProposalAcc{
-		SessID:          req.SessID(),
+		SessID:          sessID,
 		ParticipantAddr: acc.Participant.Address(),
 	}
 	if err := p.Send(ctx, msgAccept); err != nil {
@@ -196,13 +271,60 @@ func (c *Client) handleChannelProposalAcc(
 	}
 
 	parts := []wallet.Address{req.ParticipantAddr, acc.Participant.Address()}
-	return c.setupChannel(ctx, req.AsProp(acc.Participant), parts)
+	if finRecv != nil {
+		var err error
+		if parts, err = c.receiveProposalFinal(ctx, finRecv, req, acc.Participant.Address()); err != nil {
+			return nil, err
+		}
+	}
+	c.emitProposalAccepted(req, parts)
+	return c.setupChannel(setupCtx, req.AsProp(acc.Participant), parts)
+}
+
+// emitProposalAccepted emits the ProposalAccepted event for the proposal,
+// which was accepted by the participants with the given addresses.
+func (c *Client) emitProposalAccepted(req *ChannelProposalReq, parts []wallet.Address) {
+	params := c.backends.NewParamsUnsafe(req.ChallengeDuration, parts, req.AppDef, req.Nonce)
+	c.events.emit(Event{Type: ProposalAccepted, ChannelID: params.ID(), Proposal: req})
+}
+
+// receiveProposalFinal receives the participant addresses of all peers from
+// the proposer in the multi-party case. It is checked that the proposer's and
+// our participant address are at the expected positions.
+func (c *Client) receiveProposalFinal(
+	ctx context.Context,
+	finRecv *peer.Receiver,
+	req *ChannelProposalReq,
+	ourPart wallet.Address,
+) ([]wallet.Address, error) {
+	_, rawFin := finRecv.Next(ctx)
+	if rawFin == nil {
+		return nil, errors.New("timeout when waiting for final proposal message")
+	}
+	if rej, ok := rawFin.(*ChannelProposalRej); ok {
+		err := errors.Errorf("channel proposal rejected: %v", rej.Reason)
+		c.events.emit(Event{Type: ProposalRejected, Proposal: req, Err: err})
+		return nil, err
+	}
+
+	parts := rawFin.(*ChannelProposalFinal).ParticipantAddrs // safe by predicate
+	ourIdx := wallet.IndexOfAddr(req.PeerAddrs, c.id.Address())
+	if len(parts) != len(req.PeerAddrs) {
+		return nil, errors.Errorf(
+			"final proposal message has %d participants, expected %d", len(parts), len(req.PeerAddrs))
+	} else if !parts[0].Equals(req.ParticipantAddr) {
+		return nil, errors.New("final proposal message has wrong proposer participant")
+	} else if !parts[ourIdx].Equals(ourPart) {
+		return nil, errors.New("final proposal message has wrong own participant")
+	}
+	return parts, nil
 }
 
 func (c *Client) handleChannelProposalRej(
 	ctx context.Context, p *peer.Peer,
 	req *ChannelProposalReq, reason string,
 ) error {
+	c.events.emit(Event{Type: ProposalRejected, Proposal: req, Err: errors.New(reason)})
 	msgReject := &ChannelProposalRej{
 		SessID: req.SessID(),
 		Reason: reason,
@@ -214,21 +336,24 @@ func (c *Client) handleChannelProposalRej(
 	return nil
 }
 
-// exchangeTwoPartyProposal implements the multi-party channel proposal
-// protocol for the two-party case.
-func (c *Client) exchangeTwoPartyProposal(
+// exchangeProposal implements the multi-party channel proposal protocol on
+// the proposer's side. The proposal is sent to all peers and their responses
+// are collected. If any peer rejects, the rejection is forwarded to all other
+// peers. If all peers accept and there are more than two participants, the
+// collected participant addresses are sent to all peers.
+func (c *Client) exchangeProposal(
 	ctx context.Context,
 	proposal *ChannelProposalReq,
 ) ([]wallet.Address, error) {
-	p, err := c.peers.Get(ctx, proposal.PeerAddrs[1])
-	if err != nil {
-		return nil, errors.WithMessage(err, "failed to Get() participant[1]")
-	}
-
 	// enables caching of incoming version 0 signatures before sending any message
 	// that might trigger a fast peer to send those. We don't know the channel id
 	// yet so the cache predicate is coarser than the later subscription.
-	enableVer0Cache(ctx, p)
+	defer c.enableVer0Caches(ctx, proposal.PeerAddrs)()
+
+	peers, err := c.getPeers(ctx, proposal.PeerAddrs)
+	if err != nil {
+		return nil, errors.WithMessage(err, "getting peers from the registry")
+	}
 
 	sessID := proposal.SessID()
 	isResponse := func(m wire.Msg) bool {
@@ -240,54 +365,109 @@ func (c *Client) exchangeTwoPartyProposal(
 	receiver := peer.NewReceiver()
 	defer receiver.Close()
 
-	if err := p.Subscribe(receiver, isResponse); err != nil {
-		return nil, errors.WithMessagef(err, "subscribing peer %v", p)
+	for _, p := range peers {
+		if err := p.Subscribe(receiver, isResponse); err != nil {
+			return nil, errors.WithMessagef(err, "subscribing peer %v", p)
+		}
 	}
 
-	if err := p.Send(ctx, proposal); err != nil {
+	if err := peer.NewBroadcaster(peers).Send(ctx, proposal); err != nil {
 		return nil, errors.WithMessage(err, "channel proposal broadcast")
 	}
 
-	_, rawResponse := receiver.Next(ctx)
-	if rawResponse == nil {
-		return nil, errors.New("timeout when waiting for proposal response")
+	parts := make([]wallet.Address, len(proposal.PeerAddrs))
+	parts[0] = proposal.ParticipantAddr
+	for range peers {
+		p, rawResponse := receiver.Next(ctx)
+		if rawResponse == nil {
+			return nil, errors.New("timeout when waiting for proposal response")
+		}
+		// A peer may send several responses, so each peer must respond exactly
+		// once and only the other peers may respond.
+		idx := wallet.IndexOfAddr(proposal.PeerAddrs, p.PerunAddress)
+		if idx <= 0 {
+			return nil, errors.Errorf("proposal response from unexpected peer %v", p.PerunAddress)
+		} else if parts[idx] != nil {
+			return nil, errors.Errorf("duplicate proposal response from peer %d", idx)
+		}
+		if rej, ok := rawResponse.(*ChannelProposalRej); ok {
+			c.forwardProposalRej(ctx, peers, p, rej)
+			err := errors.Errorf("channel proposal rejected by peer %d: %v", idx, rej.Reason)
+			c.events.emit(Event{Type: ProposalRejected, Proposal: proposal, Err: err})
+			return nil, err
+		}
+		parts[idx] = rawResponse.(*ChannelProposalAcc).ParticipantAddr // safe by predicate
+	}
+
+	if len(parts) > 2 {
+		fin := &ChannelProposalFinal{SessID: sessID, ParticipantAddrs: parts}
+		if err := peer.NewBroadcaster(peers).Send(ctx, fin); err != nil {
+			return nil, errors.WithMessage(err, "final proposal message broadcast")
+		}
+	}
+	return parts, nil
+}
+
+// forwardProposalRej forwards the rejection of a proposal by peer rejecter to
+// all other peers. Errors are only logged, as the proposal failed anyways.
+func (c *Client) forwardProposalRej(
+	ctx context.Context,
+	peers []*peer.Peer,
+	rejecter *peer.Peer,
+	rej *ChannelProposalRej,
+) {
+	others := make([]*peer.Peer, 0, len(peers)-1)
+	for _, p := range peers {
+		if p != rejecter {
+			others = append(others, p)
+		}
 	}
-	if rej, ok := rawResponse.(*ChannelProposalRej); ok {
-		return nil, errors.Errorf("channel proposal rejected: %v", rej.Reason)
+	if len(others) == 0 {
+		return
 	}
 
-	acc := rawResponse.(*ChannelProposalAcc) // this is safe because of predicate isResponse
-	return []wallet.Address{proposal.ParticipantAddr, acc.ParticipantAddr}, nil
+	fwd := &ChannelProposalRej{
+		SessID: rej.SessID,
+		Reason: "rejected by peer " + rejecter.PerunAddress.String() + ": " + rej.Reason,
+	}
+	if err := peer.NewBroadcaster(others).Send(ctx, fwd); err != nil {
+		c.log.Warnf("error forwarding proposal rejection: %v", err)
+	}
 }
 
-// validTwoPartyProposal checks that the proposal is valid in the two-party
-// setting, where the proposer is expected to have index 0 in the peer list and
-// the receiver to have index 1. The generic validity of the proposal is also
-// checked.
-func (c *Client) validTwoPartyProposal(
+// validProposal checks that the proposal is valid in the multi-party setting,
+// where the proposer is expected to have index 0 in the peer list. We must
+// also be part of the peer list and all peers must be distinct. The generic
+// validity of the proposal is also checked, as well as the parent channels of
+// sub-channels and virtual channels.
+func (c *Client) validProposal(
 	proposal *ChannelProposalReq,
-	ourIdx int,
-	peerAddr wallet.Address,
+	proposer wallet.Address,
 ) error {
 	if err := proposal.Valid(); err != nil {
 		return err
 	}
 
-	if len(proposal.PeerAddrs) != 2 {
-		return errors.Errorf("exptected 2 peers, got %d", len(proposal.PeerAddrs))
+	// In the MPCPP, the proposer is expected to have index 0
+	if !proposal.PeerAddrs[0].Equals(proposer) {
+		return errors.New("proposer doesn't have peer index 0")
 	}
 
-	peerIdx := ourIdx ^ 1
-	// In the 2PCPP, the proposer is expected to have index 0
-	if !proposal.PeerAddrs[peerIdx].Equals(peerAddr) {
-		return errors.Errorf("remote peer doesn't have peer index %d", peerIdx)
+	if wallet.IndexOfAddr(proposal.PeerAddrs, c.id.Address()) < 0 {
+		return errors.New("we are not part of the peer list")
 	}
 
-	// In the 2PCPP, the receiver is expected to have index 1
-	if !proposal.PeerAddrs[ourIdx].Equals(c.id.Address()) {
-		return errors.Errorf("we don't have peer index %d", ourIdx)
+	for i, addr := range proposal.PeerAddrs {
+		if wallet.IndexOfAddr(proposal.PeerAddrs[:i], addr) >= 0 {
+			return errors.Errorf("peer %d is duplicated", i)
+		}
 	}
 
+	if proposal.IsSubChannel() {
+		return errors.WithMessage(c.validSubChannelProposal(proposal), "invalid sub-channel")
+	} else if proposal.IsVirtualChannel() {
+		return errors.WithMessage(c.validVirtualChannelProposal(proposal), "invalid virtual channel")
+	}
 	return nil
 }
 
@@ -296,57 +476,166 @@ func (c *Client) validTwoPartyProposal(
 // are assembled and the channel controller is started. The channel will be
 // funded and if successful, the *Channel is returned. It does not perform a
 // validity check on the proposal, so make sure to only paste valid proposals.
+//
+// On errors, the channel is closed and removed from persistence. If funding
+// failed, the channel is kept in persistence, as our deposit might have to be
+// withdrawn, which can be done by calling Settle on the returned channel.
 func (c *Client) setupChannel(
 	ctx context.Context,
 	prop *ChannelProposal,
 	parts []wallet.Address, // result of the MPCPP on prop
-) (*Channel, error) {
-	params := channel.NewParamsUnsafe(prop.ChallengeDuration, parts, prop.AppDef, prop.Nonce)
+) (_ *Channel, err error) {
+	params := c.backends.NewParamsUnsafe(prop.ChallengeDuration, parts, prop.AppDef, prop.Nonce)
 	if c.channels.Has(params.ID()) {
 		return nil, errors.New("channel already exists")
 	}
 
+	var parent *Channel
+	var parentIdxs []channel.Index
+	if prop.Parent != channel.Zero {
+		if parent, err = c.Channel(prop.Parent); err != nil {
+			return nil, errors.WithMessage(err, "getting parent channel")
+		}
+		if parentIdxs, err = parent.partIdxs(c.id.Address(), prop.PeerAddrs); err != nil {
+			return nil, err
+		}
+	} else if prop.Intermediary != nil {
+		if parent, parentIdxs, err = c.virtualParent(prop.Intermediary, prop.PeerAddrs); err != nil {
+			return nil, err
+		}
+	}
+
 	peers, err := c.getPeers(ctx, prop.PeerAddrs)
 	if err != nil {
 		return nil, errors.WithMessage(err, "getting peers from the registry")
 	}
 
-	ch, err := newChannel(prop.Account, peers, *params, c.adjudicator)
+	ch, err := newChannel(prop.Account, peers, *params, c.adjudicator, c.pr)
 	if err != nil {
 		return nil, err
 	}
 	ch.setLogger(c.logChan(params.ID()))
+	ch.events = &c.events
+	ch.finalizeTimeout = c.finalizeTimeout
+	var keep bool // whether to keep the channel in persistence on errors
+	defer func() {
+		if err != nil {
+			c.abortChannel(ctx, ch, keep)
+		}
+	}()
 
-	if err := ch.init(prop.InitBals, prop.InitData); err != nil {
+	if err := c.pr.ChannelCreated(ctx, ch.machine, peerAddresses(peers)); err != nil {
+		return ch, errors.WithMessage(err, "persisting new channel")
+	}
+	if err := ch.init(ctx, prop.InitBals, prop.InitData); err != nil {
 		return ch, errors.WithMessage(err, "setting initial bals and data")
 	}
+	// The sub-channel must be registered before we send our initial signature,
+	// so that the funding update of the proposer is accepted automatically.
+	if parent != nil {
+		ch.parent = parent
+		parent.registerSub(newSubChannel(ch, parentIdxs, prop.Intermediary != nil))
+		defer func() {
+			if err != nil {
+				parent.unregisterSub(ch.ID())
+			}
+		}()
+	}
 	if err := ch.initExchangeSigsAndEnable(ctx); err != nil {
 		return ch, errors.WithMessage(err, "exchanging initial sigs and enabling state")
 	}
 
-	if err = c.funder.Fund(ctx,
-		channel.FundingReq{
-			Params:     params,
-			Allocation: prop.InitBals,
-			Idx:        ch.machine.Idx(),
-		}); channel.IsFundingTimeoutError(err) {
-		// TODO: initiate dispute and withdrawal
+	keep = true // funds might be deposited from now on
+	if parent != nil {
+		err = ch.fundFromParent(ctx)
+	} else {
+		err = c.funder.Fund(ctx,
+			channel.FundingReq{
+				Params:     params,
+				Allocation: prop.InitBals,
+				Idx:        ch.machine.Idx(),
+			})
+	}
+	if channel.IsFundingTimeoutError(err) {
 		ch.log.Warnf("error while funding channel: %v", err)
-		return ch, errors.WithMessage(err, "error while funding channel")
+		return ch, ch.recoverFunding(ctx, err)
 	} else if err != nil { // other runtime error
 		ch.log.Warnf("error while funding channel: %v", err)
 		return ch, errors.WithMessage(err, "error while funding channel")
 	}
 
-	if err := ch.machine.SetFunded(); err != nil {
+	if err := ch.machine.SetFunded(ctx); err != nil {
 		return ch, errors.WithMessage(err, "error in SetFunded()")
 	}
+	ch.emit(Event{Type: ChannelFunded, State: ch.machine.State()})
 	if !c.channels.Put(params.ID(), ch) {
 		return ch, errors.New("channel already exists")
 	}
+	watch(ch)
 	return ch, nil
 }
 
+// abortChannel closes the channel after its setup failed, so that its go
+// routines return. Unless keep is set, the channel is also removed from
+// persistence. Errors are only logged, as the setup already failed.
+func (c *Client) abortChannel(ctx context.Context, ch *Channel, keep bool) {
+	if err := ch.Close(); err != nil {
+		ch.log.Warnf("closing aborted channel: %v", err)
+	}
+	if keep {
+		return
+	}
+	if err := c.pr.ChannelRemoved(ctx, ch.ID()); err != nil {
+		ch.log.Warnf("removing aborted channel from persistence: %v", err)
+	}
+}
+
+// A ver0CacheReq requests caching of incoming version 0 signatures on all
+// peers with the given addresses within the given context.
+type ver0CacheReq struct {
+	ctx   context.Context
+	addrs []wallet.Address
+}
+
+// enableVer0Caches enables caching of incoming version 0 signatures on all
+// peers with the given addresses. This includes peers that only connect to us
+// later on, until the returned function is called.
+func (c *Client) enableVer0Caches(ctx context.Context, addrs []wallet.Address) (done func()) {
+	req := &ver0CacheReq{ctx: ctx, addrs: addrs}
+	c.ver0CacheMtx.Lock()
+	c.ver0Caches[req] = struct{}{}
+	c.ver0CacheMtx.Unlock()
+
+	// peers that connected before the request was added
+	for _, addr := range addrs {
+		if addr.Equals(c.id.Address()) || !c.peers.Has(addr) {
+			continue
+		}
+		if p, err := c.peers.Get(ctx, addr); err == nil {
+			enableVer0Cache(ctx, p)
+		}
+	}
+
+	return func() {
+		c.ver0CacheMtx.Lock()
+		defer c.ver0CacheMtx.Unlock()
+		delete(c.ver0Caches, req)
+	}
+}
+
+// enablePendingVer0Caches enables the requested version 0 signature caches
+// on a new peer.
+func (c *Client) enablePendingVer0Caches(p *peer.Peer) {
+	c.ver0CacheMtx.Lock()
+	defer c.ver0CacheMtx.Unlock()
+
+	for req := range c.ver0Caches {
+		if wallet.IndexOfAddr(req.addrs, p.PerunAddress) >= 0 {
+			enableVer0Cache(req.ctx, p)
+		}
+	}
+}
+
 // enableVer0Cache enables caching of incoming version 0 signatures
 func enableVer0Cache(ctx context.Context, c wire.Cacher) {
 	c.Cache(ctx, func(m wire.Msg) bool {
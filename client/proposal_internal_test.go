@@ -18,7 +18,7 @@ import (
 	wallettest "perun.network/go-perun/wallet/test"
 )
 
-func TestClient_validTwoPartyProposal(t *testing.T) {
+func TestClient_validProposal(t *testing.T) {
 	rng := rand.New(rand.NewSource(0xdeadbeef))
 
 	// dummy client that only has an id
@@ -32,44 +32,55 @@ func TestClient_validTwoPartyProposal(t *testing.T) {
 	require.Len(t, validProp.PeerAddrs, 2)
 
 	validProp3Peers := *newRandomValidChannelProposalReq(rng, 3)
+	validProp3Peers.PeerAddrs[2] = c.id.Address() // we are the last receiver
+	proposer3Peers := validProp3Peers.PeerAddrs[0]
+
 	invalidProp := validProp          // shallow copy
 	invalidProp.ChallengeDuration = 0 // invalidate
 
+	notUsProp := *newRandomValidChannelProposalReq(rng, 3)
+
+	dupProp := *newRandomValidChannelProposalReq(rng, 3)
+	dupProp.PeerAddrs[1] = c.id.Address()
+	dupProp.PeerAddrs[2] = c.id.Address()
+
 	tests := []struct {
 		prop     *ChannelProposalReq
-		ourIdx   int
-		peerAddr wallet.Address
+		proposer wallet.Address
 		valid    bool
 	}{
 		{
 			&validProp,
-			0, peerAddr, true,
+			c.id.Address(), true,
 		},
-		// test all three invalid combinations of peer address, index
 		{
 			&validProp,
-			1, peerAddr, false, // wrong ourIdx
+			peerAddr, false, // proposer not at index 0
 		},
 		{
-			&validProp,
-			0, c.id.Address(), false, // wrong peerAddr (ours)
+			&validProp3Peers, // valid proposal with three peers
+			proposer3Peers, true,
 		},
 		{
-			&validProp,
-			1, c.id.Address(), false, // wrong index, wrong peer address
+			&validProp3Peers,
+			c.id.Address(), false, // proposer not at index 0
+		},
+		{
+			&notUsProp, // we are not part of the proposal
+			notUsProp.PeerAddrs[0], false,
 		},
 		{
-			&validProp3Peers, // valid proposal but three peers
-			0, peerAddr, false,
+			&dupProp, // duplicate peers
+			dupProp.PeerAddrs[0], false,
 		},
 		{
 			&invalidProp, // invalid proposal, correct other params
-			0, peerAddr, false,
+			c.id.Address(), false,
 		},
 	}
 
 	for i, tt := range tests {
-		valid := c.validTwoPartyProposal(tt.prop, tt.ourIdx, tt.peerAddr)
+		valid := c.validProposal(tt.prop, tt.proposer)
 		if tt.valid && valid != nil {
 			t.Errorf("[%d] Exptected proposal to be valid but got: %v", i, valid)
 		} else if !tt.valid && valid == nil {
@@ -36,6 +36,11 @@ func init() {
 			var m ChannelProposalRej
 			return &m, m.Decode(r)
 		})
+	msg.RegisterDecoder(msg.ChannelProposalFinal,
+		func(r io.Reader) (msg.Msg, error) {
+			var m ChannelProposalFinal
+			return &m, m.Decode(r)
+		})
 }
 
 // SessionID is a unique identifier generated for every instantiantiation of
@@ -55,6 +60,8 @@ type ChannelProposalReq struct {
 	InitData          channel.Data
 	InitBals          *channel.Allocation
 	PeerAddrs         []wallet.Address
+	Parent            channel.ID
+	Intermediary      wallet.Address
 }
 
 // AsReq returns a shallow copy of the ChannelProposal as a ChannelProposalReq,
@@ -68,6 +75,8 @@ func (c *ChannelProposal) AsReq() *ChannelProposalReq {
 		InitData:          c.InitData,
 		InitBals:          c.InitBals,
 		PeerAddrs:         c.PeerAddrs,
+		Parent:            c.Parent,
+		Intermediary:      c.Intermediary,
 	}
 }
 
@@ -81,9 +90,21 @@ func (c *ChannelProposalReq) AsProp(acc wallet.Account) *ChannelProposal {
 		InitData:          c.InitData,
 		InitBals:          c.InitBals,
 		PeerAddrs:         c.PeerAddrs,
+		Parent:            c.Parent,
+		Intermediary:      c.Intermediary,
 	}
 }
 
+// IsSubChannel returns whether the proposed channel is a sub-channel.
+func (c ChannelProposalReq) IsSubChannel() bool {
+	return c.Parent != channel.Zero
+}
+
+// IsVirtualChannel returns whether the proposed channel is a virtual channel.
+func (c ChannelProposalReq) IsVirtualChannel() bool {
+	return c.Intermediary != nil
+}
+
 // Type returns msg.ChannelProposal.
 func (ChannelProposalReq) Type() msg.Type {
 	return msg.ChannelProposal
@@ -119,27 +140,36 @@ func (c ChannelProposalReq) Encode(w io.Writer) error {
 		}
 	}
 
+	if err := wire.Encode(w, c.Parent, c.Intermediary != nil); err != nil {
+		return err
+	}
+	if c.Intermediary != nil {
+		return errors.WithMessage(c.Intermediary.Encode(w), "encoding intermediary")
+	}
 	return nil
 }
 
-// Decode decodes a ChannelProposalRequest from an io.Reader.
+// Decode decodes a ChannelProposalRequest from an io.Reader, using the
+// backends of the decoding context of the reader, see
+// channel.DecodingBackends.
 func (c *ChannelProposalReq) Decode(r io.Reader) (err error) {
 	if r == nil {
 		return errors.New("reader must not be nil")
 	}
 
+	backends := channel.DecodingBackends(r)
 	if err := wire.Decode(r, &c.ChallengeDuration, &c.Nonce); err != nil {
 		return err
 	}
 
-	if c.ParticipantAddr, err = wallet.DecodeAddress(r); err != nil {
+	if c.ParticipantAddr, err = backends.DecodeAddress(r); err != nil {
 		return err
 	}
-	if c.AppDef, err = wallet.DecodeAddress(r); err != nil {
+	if c.AppDef, err = backends.DecodeAddress(r); err != nil {
 		return err
 	}
 	var app channel.App
-	if app, err = channel.AppFromDefinition(c.AppDef); err != nil {
+	if app, err = backends.AppFromDefinition(c.AppDef); err != nil {
 		return err
 	}
 
@@ -170,11 +200,19 @@ func (c *ChannelProposalReq) Decode(r io.Reader) (err error) {
 
 	c.PeerAddrs = make([]wallet.Address, numParts)
 	for i := 0; i < len(c.PeerAddrs); i++ {
-		if c.PeerAddrs[i], err = wallet.DecodeAddress(r); err != nil {
+		if c.PeerAddrs[i], err = backends.DecodeAddress(r); err != nil {
 			return err
 		}
 	}
 
+	var virtual bool
+	if err := wire.Decode(r, &c.Parent, &virtual); err != nil {
+		return err
+	}
+	if virtual {
+		c.Intermediary, err = backends.DecodeAddress(r)
+		return errors.WithMessage(err, "decoding intermediary")
+	}
 	return nil
 }
 
@@ -197,6 +235,7 @@ func (c ChannelProposalReq) SessID() (sid SessionID) {
 		c.InitData,
 		c.InitBals,
 		c.AppDef,
+		c.Parent,
 	); err != nil {
 		log.Panicf("session ID data encoding error: %v", err)
 	}
@@ -212,6 +251,7 @@ func (c ChannelProposalReq) SessID() (sid SessionID) {
 // * No locked sub-allocations
 // * InitBals match the dimension of Parts
 // * non-zero ChallengeDuration
+// * not both a sub-channel and a virtual channel
 func (c ChannelProposalReq) Valid() error {
 	if c.InitBals == nil || c.ParticipantAddr == nil {
 		return errors.New("invalid nil fields")
@@ -224,6 +264,8 @@ func (c ChannelProposalReq) Valid() error {
 		return errors.New("initial allocation cannot have locked funds")
 	} else if len(c.InitBals.OfParts) != len(c.PeerAddrs) {
 		return errors.New("wrong dimension of initial balances")
+	} else if c.IsSubChannel() && c.IsVirtualChannel() {
+		return errors.New("channel cannot be both a sub-channel and a virtual channel")
 	}
 	return nil
 }
@@ -264,7 +306,7 @@ func (acc *ChannelProposalAcc) Decode(r io.Reader) (err error) {
 		return errors.WithMessage(err, "SID decoding")
 	}
 
-	acc.ParticipantAddr, err = wallet.DecodeAddress(r)
+	acc.ParticipantAddr, err = channel.DecodingBackends(r).DecodeAddress(r)
 	return errors.WithMessage(err, "participant address decoding")
 }
 
@@ -292,3 +334,67 @@ func (rej ChannelProposalRej) Encode(w io.Writer) error {
 func (rej *ChannelProposalRej) Decode(r io.Reader) error {
 	return wire.Decode(r, &rej.SessID, &rej.Reason)
 }
+
+// ChannelProposalFinal is sent by the proposer to all other peers after all
+// of them accepted a proposal with more than two participants. It contains the
+// participant addresses of all peers, so that every peer can create the
+// channel parameters.
+//
+// The message concludes the Multi-Party Channel Proposal Protocol (MPCPP). In
+// the two-party case, it is not needed because the only accepting peer knows
+// both participant addresses.
+type ChannelProposalFinal struct {
+	SessID           SessionID
+	ParticipantAddrs []wallet.Address
+}
+
+// Type returns msg.ChannelProposalFinal.
+func (ChannelProposalFinal) Type() msg.Type {
+	return msg.ChannelProposalFinal
+}
+
+// Encode encodes a ChannelProposalFinal into an io.Writer.
+func (fin ChannelProposalFinal) Encode(w io.Writer) error {
+	if err := wire.Encode(w, fin.SessID); err != nil {
+		return errors.WithMessage(err, "SID encoding")
+	}
+
+	if len(fin.ParticipantAddrs) > channel.MaxNumParts {
+		return errors.Errorf(
+			"expected maximum number of participants %d, got %d",
+			channel.MaxNumParts, len(fin.ParticipantAddrs))
+	}
+	if err := wire.Encode(w, int32(len(fin.ParticipantAddrs))); err != nil {
+		return err
+	}
+	for i, addr := range fin.ParticipantAddrs {
+		if err := addr.Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding participant %d", i)
+		}
+	}
+	return nil
+}
+
+// Decode decodes a ChannelProposalFinal from an io.Reader.
+func (fin *ChannelProposalFinal) Decode(r io.Reader) (err error) {
+	if err = wire.Decode(r, &fin.SessID); err != nil {
+		return errors.WithMessage(err, "SID decoding")
+	}
+
+	var numParts int32
+	if err = wire.Decode(r, &numParts); err != nil {
+		return err
+	}
+	if numParts < 2 || numParts > channel.MaxNumParts {
+		return errors.Errorf(
+			"expected between 2 and %d participants, got %d",
+			channel.MaxNumParts, numParts)
+	}
+	fin.ParticipantAddrs = make([]wallet.Address, numParts)
+	for i := range fin.ParticipantAddrs {
+		if fin.ParticipantAddrs[i], err = channel.DecodingBackends(r).DecodeAddress(r); err != nil {
+			return errors.WithMessagef(err, "decoding participant %d", i)
+		}
+	}
+	return nil
+}
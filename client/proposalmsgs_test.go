@@ -50,7 +50,7 @@ func TestChannelProposalReq_NilArgs(t *testing.T) {
 
 func TestChannelProposalReqSerialization(t *testing.T) {
 	rng := rand.New(rand.NewSource(0xdeadbeef))
-	for i := 0; i < 4; i++ {
+	for i := 0; i < 6; i++ {
 		m := &client.ChannelProposalReq{
 			ChallengeDuration: 0,
 			Nonce:             big.NewInt(rng.Int63()),
@@ -63,6 +63,12 @@ func TestChannelProposalReqSerialization(t *testing.T) {
 				wallettest.NewRandomAddress(rng),
 			},
 		}
+		switch i % 3 {
+		case 1:
+			m.Parent = test.NewRandomChannelID(rng)
+		case 2:
+			m.Intermediary = wallettest.NewRandomAddress(rng)
+		}
 		msg.TestMsg(t, m)
 	}
 }
@@ -135,6 +141,10 @@ func TestChannelProposalReqSessID(t *testing.T) {
 	c6 := original
 	c6.PeerAddrs = fake.PeerAddrs
 	assert.NotEqual(t, s, c6.SessID())
+
+	c7 := original
+	c7.Parent = test.NewRandomChannelID(rand.New(rand.NewSource(0xeeff0d)))
+	assert.NotEqual(t, s, c7.SessID())
 }
 
 func TestChannelProposal_AsReqAsProp(t *testing.T) {
@@ -186,6 +196,21 @@ func TestChannelProposalRejSerialization(t *testing.T) {
 	}
 }
 
+func TestChannelProposalFinalSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xcafecafe))
+	for i := 0; i < 16; i++ {
+		parts := make([]wallet.Address, 2+rng.Intn(8))
+		for j := range parts {
+			parts[j] = wallettest.NewRandomAddress(rng)
+		}
+		m := &client.ChannelProposalFinal{
+			SessID:           newRandomSessID(rng),
+			ParticipantAddrs: parts,
+		}
+		msg.TestMsg(t, m)
+	}
+}
+
 func newRandomSessID(rng *rand.Rand) (id client.SessionID) {
 	rng.Read(id[:])
 	return
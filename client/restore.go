@@ -0,0 +1,244 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/wallet"
+)
+
+// An AccountResolver returns the unlocked account of the given participant
+// address. It is used to restore the channels' state machines, which need an
+// account to sign new states.
+type AccountResolver func(wallet.Address) (wallet.Account, error)
+
+// Restore restores all non-settled channels from the Persister that was set
+// with EnablePersistence, which must also implement persistence.Restorer. The
+// peers of the restored channels are dialed and the channels are registered
+// in the client, so that they can be queried with Channel. The watcher of
+// every restored channel is started, see Channel.Watch. The channel
+// parameters are recreated with the backends of the client, see SetBackends.
+//
+// Sub-channels and virtual channels are linked to the restored or already
+// open parent channel that locks their funds, so that they can be settled
+// into it. A channel in phase Funding is restored as well, as our deposit
+// might have to be withdrawn with Settle. Afterwards, the virtual channels of
+// which we are the intermediary are restored and linked to their ledger
+// channels.
+//
+// Settled channels and channels whose initial state was never fully signed
+// are removed from the persistence, as no funds can be locked in them. If a
+// channel cannot be restored, e.g., because its peer cannot be reached, the
+// remaining channels are still restored and the first error is returned.
+func (c *Client) Restore(ctx context.Context, accounts AccountResolver) ([]*Channel, error) {
+	rs, ok := c.pr.(persistence.Restorer)
+	if !ok {
+		return nil, errors.New("persister does not support restoring")
+	}
+
+	peerAddrs, err := rs.ActivePeers(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, "reading active peers")
+	}
+
+	var (
+		restored []*Channel
+		ids      = make(map[channel.ID]bool)
+		firstErr error
+	)
+	setErr := func(err error) {
+		c.log.Warn(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, addr := range peerAddrs {
+		chs, err := rs.RestorePeer(ctx, addr)
+		if err != nil {
+			setErr(errors.WithMessagef(err, "restoring channels with peer %v", addr))
+			continue
+		}
+
+		for _, pch := range chs {
+			// Channels with several peers are restored via each of them.
+			if ids[pch.ID()] || c.channels.Has(pch.ID()) {
+				continue
+			}
+			ids[pch.ID()] = true
+			ch, err := c.restoreChannel(ctx, pch, accounts)
+			if err != nil {
+				setErr(errors.WithMessagef(err, "restoring channel %x", pch.ID()))
+			} else if ch != nil {
+				restored = append(restored, ch)
+			}
+		}
+	}
+
+	// The parents are linked once all channels are restored, as a parent
+	// might be restored after its sub-channels.
+	parents := append(c.channels.Values(), restored...)
+	for _, ch := range restored {
+		if err := c.restoreParent(ctx, ch, parents); err != nil {
+			setErr(errors.WithMessagef(err, "linking channel %x to its parent", ch.ID()))
+		}
+	}
+	registered := restored[:0]
+	for _, ch := range restored {
+		if err := c.registerRestored(ctx, ch); err != nil {
+			setErr(errors.WithMessagef(err, "registering channel %x", ch.ID()))
+			continue
+		}
+		registered = append(registered, ch)
+	}
+	if err := c.restoreVirtuals(ctx, rs); err != nil {
+		setErr(err)
+	}
+	return registered, firstErr
+}
+
+// restoreChannel restores a single persisted channel without registering it
+// in the client. Settled channels and channels in phase InitActing or
+// InitSigning are removed from the persistence and nil is returned.
+func (c *Client) restoreChannel(
+	ctx context.Context,
+	pch *persistence.Channel,
+	accounts AccountResolver,
+) (*Channel, error) {
+	switch pch.Phase() {
+	case channel.Settled:
+		return nil, errors.WithMessage(c.pr.ChannelRemoved(ctx, pch.ID()), "removing settled channel")
+	case channel.InitActing, channel.InitSigning:
+		return nil, errors.WithMessage(c.pr.ChannelRemoved(ctx, pch.ID()), "removing unfunded channel")
+	}
+
+	p := pch.Params()
+	params := c.backends.NewParamsUnsafe(p.ChallengeDuration, p.Parts, p.App.Def(), p.Nonce)
+	if params.ID() != pch.ID() {
+		return nil, errors.New("channel ID does not match the backends of the client")
+	}
+	pch.ParamsV = params
+
+	acc, err := accounts(pch.Params().Parts[pch.Idx()])
+	if err != nil {
+		return nil, errors.WithMessage(err, "resolving account")
+	}
+	machine, err := restoreMachine(acc, pch, c.pr)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := c.getPeers(ctx, pch.Peers())
+	if err != nil {
+		return nil, errors.WithMessage(err, "getting peers from the registry")
+	}
+
+	ch, err := newChannelFromMachine(machine, peers, c.adjudicator, c.pr)
+	if err != nil {
+		return nil, err
+	}
+	ch.setLogger(c.logChan(pch.ID()))
+	ch.events = &c.events
+	ch.finalizeTimeout = c.finalizeTimeout
+	return ch, nil
+}
+
+// restoreParent links the restored channel ch to the parent channel among
+// parents whose current state locks the funds of ch. Channels without such a
+// parent are treated as ledger channels. If a sub-channel was funded by its
+// parent before it was set to funded, it is set to funded now.
+func (c *Client) restoreParent(ctx context.Context, ch *Channel, parents []*Channel) error {
+	for _, parent := range parents {
+		if parent == ch || parent.parent != nil || parent.stateMachine == nil {
+			continue
+		}
+		idxs, virtual, ok := c.restoredSubIdxs(ch, parent)
+		if !ok {
+			continue
+		}
+
+		ch.parent = parent
+		parent.registerSub(newSubChannel(ch, idxs, virtual))
+		parent.machMtx.Lock()
+		parent.notifySubs()
+		parent.machMtx.Unlock()
+
+		if ch.machine.Phase() == channel.Funding {
+			return errors.WithMessage(ch.machine.SetFunded(ctx), "setting funded")
+		}
+		return nil
+	}
+	return nil
+}
+
+// restoredSubIdxs returns the indices of the participants of the sub-channel
+// or virtual channel ch in the parent channel, if the current state of the
+// parent locks the funds of ch.
+func (c *Client) restoredSubIdxs(ch, parent *Channel) (idxs []channel.Index, virtual, ok bool) {
+	parent.machMtx.RLock()
+	defer parent.machMtx.RUnlock()
+
+	if lockedIdx(parent.machine.State().Locked, ch.ID()) < 0 {
+		return nil, false, false
+	}
+
+	// Our address is not in the peer list of a channel.
+	peers := ch.conn.peerAddrs
+	addrs := make([]wallet.Address, 0, len(peers)+1)
+	addrs = append(addrs, peers[:ch.Idx()]...)
+	addrs = append(addrs, c.id.Address())
+	addrs = append(addrs, peers[ch.Idx():]...)
+	if idxs, err := parent.partIdxs(c.id.Address(), addrs); err == nil {
+		return idxs, false, true
+	}
+
+	// The parent of a virtual channel is the ledger channel with the
+	// intermediary, who is no participant of the virtual channel.
+	if ch.machine.N() != 2 || parent.machine.N() != 2 {
+		return nil, false, false
+	}
+	idxs = make([]channel.Index, 2)
+	idxs[ch.Idx()], idxs[1-ch.Idx()] = parent.Idx(), 1-parent.Idx()
+	return idxs, true, true
+}
+
+// registerRestored registers the restored channel in the client, starts its
+// watcher and synchronizes it with its peers.
+func (c *Client) registerRestored(ctx context.Context, ch *Channel) error {
+	if !c.channels.Put(ch.ID(), ch) {
+		if err := ch.Close(); err != nil {
+			ch.log.Warnf("closing restored channel: %v", err)
+		}
+		return errors.New("channel already exists")
+	}
+	watch(ch)
+	// The peers may have progressed the channel while we were offline.
+	if err := ch.syncPeers(ctx); err != nil {
+		ch.log.Warnf("syncing restored channel: %v", err)
+	}
+	return nil
+}
+
+// restoreMachine restores the persisting machine of a channel, depending on
+// the type of the channel's app.
+func restoreMachine(acc wallet.Account, pch *persistence.Channel, pr persistence.Persister) (persistence.Machine, error) {
+	if !channel.IsStateApp(pch.Params().App) {
+		machine, err := channel.RestoreActionMachine(acc, pch)
+		if err != nil {
+			return nil, errors.WithMessage(err, "restoring action machine")
+		}
+		return persistence.FromActionMachine(machine, pr), nil
+	}
+
+	machine, err := channel.RestoreStateMachine(acc, pch)
+	if err != nil {
+		return nil, errors.WithMessage(err, "restoring state machine")
+	}
+	return persistence.FromStateMachine(machine, pr), nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/channel/persistence/keyvalue"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/db/memorydb"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestClient_Restore(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x2E5707E))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	aliceAcc, bobAcc := wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)
+	accs := []wallet.Account{aliceAcc, bobAcc}
+	pr := keyvalue.NewPersister(memorydb.NewDatabase())
+
+	// Alice opened a sub-channel in a ledger channel with Bob before her
+	// restart. The initial state of a third channel was not signed by Bob.
+	sub := persistTestChannel(ctx, t, rng, pr, accs, channeltest.NewRandomAllocation(rng, 2), true)
+	alloc := channeltest.NewRandomAllocation(rng, 2)
+	alloc.Locked = append(alloc.Locked, *channeltest.NewRandomSubAlloc(rng, len(alloc.Assets)))
+	alloc.Locked[len(alloc.Locked)-1].ID = sub.ID()
+	sm := persistTestChannel(ctx, t, rng, pr, accs, alloc, true)
+	unsigned := persistTestChannel(ctx, t, rng, pr, accs, channeltest.NewRandomAllocation(rng, 2), false)
+
+	var hub peertest.ConnHub
+	defer hub.Close()
+	bob := New(bobAcc, hub.NewDialer(), DummyProposalHandler{t}, &DummyFunder{t}, &DummyAdjudicator{t})
+	defer bob.Close()
+	go bob.Listen(hub.NewListener(bobAcc.Address()))
+
+	alice := New(aliceAcc, hub.NewDialer(), DummyProposalHandler{t}, &DummyFunder{t}, &DummyAdjudicator{t})
+	defer alice.Close()
+	alice.EnablePersistence(pr)
+
+	chs, err := alice.Restore(ctx, func(addr wallet.Address) (wallet.Account, error) {
+		require.True(t, addr.Equals(aliceAcc.Address()))
+		return aliceAcc, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, chs, 2)
+
+	ch, err := alice.Channel(sm.ID())
+	require.NoError(t, err)
+	assert.Contains(t, chs, ch)
+	assert.Equal(t, channel.Acting, ch.Phase())
+	assert.Equal(t, sm.State(), ch.State())
+	assert.Equal(t, sm.CurrentTX(), ch.machine.CurrentTX())
+
+	subCh, err := alice.Channel(sub.ID())
+	require.NoError(t, err)
+	assert.Contains(t, chs, subCh)
+	assert.Same(t, ch, subCh.parent)
+	subRecord, err := ch.sub(sub.ID())
+	require.NoError(t, err)
+	assert.Equal(t, []channel.Index{0, 1}, subRecord.idxs)
+	select {
+	case <-subRecord.funded:
+	default:
+		t.Error("restored sub-channel must be funded")
+	}
+
+	_, err = alice.Channel(unsigned.ID())
+	assert.Error(t, err, "unsigned channel must not be restored")
+	pchs, err := pr.RestorePeer(ctx, bobAcc.Address())
+	require.NoError(t, err)
+	assert.Len(t, pchs, 2, "unsigned channel must be removed")
+}
+
+// persistTestChannel persists a channel of the first account with the second
+// account. If funded is set, the initial state is signed by both and the
+// channel is funded. Otherwise, it stays in phase InitSigning.
+func persistTestChannel(
+	ctx context.Context,
+	t *testing.T,
+	rng *rand.Rand,
+	pr persistence.Persister,
+	accs []wallet.Account,
+	alloc *channel.Allocation,
+	funded bool,
+) *channel.StateMachine {
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	params, err := channel.NewParams(60, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	sm, err := channel.NewStateMachine(accs[0], *params)
+	require.NoError(t, err)
+	m := persistence.FromStateMachine(sm, pr)
+	require.NoError(t, pr.ChannelCreated(ctx, sm, parts[1:]))
+	require.NoError(t, m.Init(ctx, *alloc, new(payment.NoData)))
+	_, err = m.Sig(ctx)
+	require.NoError(t, err)
+	if !funded {
+		return sm
+	}
+
+	sig, err := channel.Sign(accs[1], params, sm.StagingState())
+	require.NoError(t, err)
+	require.NoError(t, m.AddSig(ctx, 1, sig))
+	require.NoError(t, m.EnableInit(ctx))
+	require.NoError(t, m.SetFunded(ctx))
+	return sm
+}
+
+func TestClient_abortChannel(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xAB0))
+	ctx := context.Background()
+
+	for _, keep := range []bool{false, true} {
+		pr := keyvalue.NewPersister(memorydb.NewDatabase())
+		c := &Client{pr: pr}
+		ch, _ := newFundingTestChannel(t, rng, newMockAdjudicator())
+		peer := ch.Params().Parts[1]
+		require.NoError(t, pr.ChannelCreated(ctx, ch.machine, []wallet.Address{peer}))
+
+		c.abortChannel(ctx, ch, keep)
+		assert.True(t, ch.IsClosed())
+		restored, err := pr.RestorePeer(ctx, peer)
+		require.NoError(t, err)
+		if keep {
+			assert.Len(t, restored, 1, "channel must be kept")
+		} else {
+			assert.Len(t, restored, 0, "channel must be removed")
+		}
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	perunio "perun.network/go-perun/pkg/io"
+	"perun.network/go-perun/wallet"
+)
+
+// A sub-channel is funded from a parent ledger channel instead of on-chain.
+// The initial balances of its participants are moved into a locked
+// sub-allocation of the parent channel by the funding update. When the
+// sub-channel is final, the settlement update removes the sub-allocation and
+// adds the final balances of the participants to their balances in the parent
+// channel. Both parent updates are proposed by the participant with index 0 in
+// the sub-channel and accepted automatically by all other participants if they
+// match the expected update.
+//
+// If a sub-channel cannot be settled off-chain because it is not final, its
+// parent channel is settled by dispute instead. Then, the parent state is
+// registered together with the signed states of all its sub-channels, see
+// channel.AdjudicatorReq, so that the locked sub-allocations are paid out
+// according to the sub-channel states.
+//
+// Virtual channels are funded and settled the same way from two parent
+// channels, see virtual.go.
+
+// subUpdateTimeout is the timeout for the automatic acceptance of funding and
+// settlement updates of sub-channels.
+const subUpdateTimeout = 10 * time.Second
+
+// subChannel is a sub-channel or virtual channel that is funded from a parent
+// channel, as seen by the parent.
+type subChannel struct {
+	id       channel.ID
+	params   *channel.Params
+	ch       *Channel                   // our channel controller of the sub-channel, if any
+	tx       func() channel.Transaction // current transaction of the sub-channel
+	idxs     []channel.Index            // indices of the sub-channel participants in the parent
+	propose  bool                       // whether we propose the parent updates
+	virtual  bool                       // whether the parent updates are proposed by an intermediary
+	disputed func()                     // called if the parent is settled by dispute and ch is nil
+	funded   chan struct{}              // closed when the funding update is enabled
+	settled  chan struct{}              // closed when the settlement update is enabled
+}
+
+// newSubChannel creates the record of the sub-channel or virtual channel ch,
+// whose participants have the given indices in the parent channel.
+func newSubChannel(ch *Channel, idxs []channel.Index, virtual bool) *subChannel {
+	sub := newSubChannelWithTX(ch.Params(), ch.currentTX, idxs, !virtual && ch.Idx() == 0, virtual)
+	sub.ch = ch
+	return sub
+}
+
+// newSubChannelWithTX creates the record of a sub-channel for which we have no
+// channel controller, like the intermediary of a virtual channel.
+func newSubChannelWithTX(
+	params *channel.Params,
+	tx func() channel.Transaction,
+	idxs []channel.Index,
+	propose, virtual bool,
+) *subChannel {
+	return &subChannel{
+		id:      params.ID(),
+		params:  params,
+		tx:      tx,
+		idxs:    idxs,
+		propose: propose,
+		virtual: virtual,
+		funded:  make(chan struct{}),
+		settled: make(chan struct{}),
+	}
+}
+
+// validSubChannelProposal checks that the parent of a proposed sub-channel is
+// a known ledger channel with a StateApp and that the sub-channel has the same
+// participants and assets as its parent. The participants must be able to
+// afford their initial balances in the parent channel.
+func (c *Client) validSubChannelProposal(req *ChannelProposalReq) error {
+	parent, err := c.Channel(req.Parent)
+	if err != nil {
+		return errors.WithMessage(err, "getting parent channel")
+	}
+	if parent.stateMachine == nil {
+		return errors.New("parent channel must have a StateApp")
+	} else if parent.parent != nil {
+		return errors.New("nested sub-channels are not supported")
+	}
+
+	idxs, err := parent.partIdxs(c.id.Address(), req.PeerAddrs)
+	if err != nil {
+		return err
+	}
+	return parent.validSubAlloc(idxs, req.InitBals)
+}
+
+// partIdxs returns the indices in this channel of the peers with the given
+// Perun addresses, where ourAddr is our own Perun address. All participants of
+// this channel must be part of addrs.
+func (c *Channel) partIdxs(ourAddr wallet.Address, addrs []wallet.Address) ([]channel.Index, error) {
+	if len(addrs) != int(c.machine.N()) {
+		return nil, errors.New("sub-channel must have the same participants as its parent")
+	}
+
+	idxs := make([]channel.Index, len(addrs))
+	for i, addr := range addrs {
+		if addr.Equals(ourAddr) {
+			idxs[i] = c.Idx()
+			continue
+		}
+		idx, ok := c.conn.peerIndex(addr)
+		if !ok {
+			return nil, errors.Errorf("peer[%d] is not a participant of the parent channel", i)
+		}
+		idxs[i] = idx
+	}
+	return idxs, nil
+}
+
+// validSubAlloc checks that the initial allocation of a sub-channel has the
+// same assets as this channel and that the participants with the given
+// indices in this channel can afford their initial balances.
+func (c *Channel) validSubAlloc(idxs []channel.Index, alloc *channel.Allocation) error {
+	c.machMtx.RLock()
+	defer c.machMtx.RUnlock()
+
+	state := c.machine.State()
+	if len(alloc.Assets) != len(state.Assets) {
+		return errors.New("sub-channel must have the same assets as its parent")
+	}
+	for a, asset := range alloc.Assets {
+		if eq, err := equalEncoding(asset, state.Assets[a]); err != nil {
+			return errors.WithMessagef(err, "comparing asset %d", a)
+		} else if !eq {
+			return errors.Errorf("asset %d differs from the parent channel's asset", a)
+		}
+	}
+
+	for i, bals := range alloc.OfParts {
+		for a, bal := range bals {
+			if bal.Cmp(state.OfParts[idxs[i]][a]) > 0 {
+				return errors.Errorf("participant[%d] cannot afford asset %d in the parent channel", i, a)
+			}
+		}
+	}
+	return nil
+}
+
+// registerSub registers a sub-channel of this channel. From now on, the
+// funding and settlement updates of the sub-channel are accepted
+// automatically.
+func (c *Channel) registerSub(sub *subChannel) {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	if c.subs == nil {
+		c.subs = make(map[channel.ID]*subChannel)
+	}
+	c.subs[sub.id] = sub
+}
+
+// unregisterSub removes the sub-channel with the given ID, e.g., if its setup
+// failed.
+func (c *Channel) unregisterSub(id channel.ID) {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	delete(c.subs, id)
+}
+
+// sub returns the registered sub-channel with the given ID.
+func (c *Channel) sub(id channel.ID) (*subChannel, error) {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	sub, ok := c.subs[id]
+	if !ok {
+		return nil, errors.New("unknown sub-channel")
+	}
+	return sub, nil
+}
+
+// fundFromParent funds a sub-channel or virtual channel from its parent
+// channel. If we are the proposer of a sub-channel, the funding update is
+// proposed. For a virtual channel, the intermediary is requested to propose
+// it. In any case, fundFromParent waits until the funding update is enabled.
+func (c *Channel) fundFromParent(ctx context.Context) error {
+	sub, err := c.parent.sub(c.ID())
+	if err != nil {
+		return err
+	}
+	if sub.virtual {
+		if err := c.requestVirtualFunding(ctx, sub); err != nil {
+			return err
+		}
+	}
+	return c.parent.progressSub(ctx, sub, sub.funded)
+}
+
+// progressSub proposes the next update of the sub-channel's life cycle if we
+// are its proposer and waits until done is closed.
+func (c *Channel) progressSub(ctx context.Context, sub *subChannel, done <-chan struct{}) error {
+	if sub.propose {
+		if err := c.proposeSubUpdate(ctx, sub); err != nil {
+			return errors.WithMessage(err, "proposing parent channel update")
+		}
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "waiting for parent channel update")
+	}
+}
+
+// proposeSubUpdate proposes the funding or settlement update of the
+// sub-channel to all participants of this channel.
+func (c *Channel) proposeSubUpdate(ctx context.Context, sub *subChannel) error {
+	c.machMtx.Lock()
+	defer c.machMtx.Unlock()
+
+	state, err := c.subUpdateState(sub)
+	if err != nil {
+		return err
+	}
+	return c.update(ctx, ChannelUpdate{State: state, ActorIdx: c.Idx()})
+}
+
+// isSubUpdate returns whether the proposed state is the funding or settlement
+// state of a registered sub-channel. The machine must be locked by the caller.
+func (c *Channel) isSubUpdate(state *channel.State) bool {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	for _, sub := range c.subs {
+		expected, err := c.subUpdateState(sub)
+		if err != nil {
+			continue
+		}
+		expected.Version = state.Version
+		if eq, err := equalEncoding(expected, state); err == nil && eq {
+			return true
+		}
+	}
+	return false
+}
+
+// subUpdateState returns the next state of this channel in the life cycle of
+// the sub-channel: the funding state if the sub-channel is not yet funded and
+// the settlement state otherwise. The machine must be locked by the caller.
+func (c *Channel) subUpdateState(sub *subChannel) (*channel.State, error) {
+	if lockedIdx(c.machine.State().Locked, sub.id) < 0 {
+		return c.subFundingState(sub)
+	}
+	return c.subSettlementState(sub)
+}
+
+// subFundingState returns the state of this channel that moves the initial
+// balances of the sub-channel participants into a locked sub-allocation.
+func (c *Channel) subFundingState(sub *subChannel) (*channel.State, error) {
+	subState := sub.tx().State
+	state := c.machine.State().Clone()
+	state.Version = c.machine.NextVersion()
+
+	locked := channel.SubAlloc{ID: sub.id, Bals: make([]channel.Bal, len(state.Assets))}
+	for a := range locked.Bals {
+		locked.Bals[a] = new(big.Int)
+	}
+	for i, bals := range subState.OfParts {
+		for a, bal := range bals {
+			parentBal := state.OfParts[sub.idxs[i]][a]
+			if parentBal.Cmp(bal) < 0 {
+				return nil, errors.Errorf("participant[%d] cannot afford asset %d in the parent channel", i, a)
+			}
+			parentBal.Sub(parentBal, bal)
+			locked.Bals[a].Add(locked.Bals[a], bal)
+		}
+	}
+	state.Locked = append(state.Locked, locked)
+	return state, nil
+}
+
+// subSettlementState returns the state of this channel that removes the
+// locked sub-allocation of the sub-channel and adds the final balances of the
+// sub-channel participants to their balances.
+func (c *Channel) subSettlementState(sub *subChannel) (*channel.State, error) {
+	subState := sub.tx().State
+	if !subState.IsFinal {
+		return nil, errors.New("sub-channel is not final")
+	}
+	state := c.machine.State().Clone()
+	state.Version = c.machine.NextVersion()
+
+	idx := lockedIdx(state.Locked, sub.id)
+	state.Locked = append(state.Locked[:idx], state.Locked[idx+1:]...)
+	for i, bals := range subState.OfParts {
+		for a, bal := range bals {
+			parentBal := state.OfParts[sub.idxs[i]][a]
+			parentBal.Add(parentBal, bal)
+		}
+	}
+	return state, nil
+}
+
+// notifySubs notifies the registered sub-channels that are funded or settled
+// by the current state. Settled sub-channels are unregistered. The machine
+// must be locked by the caller.
+func (c *Channel) notifySubs() {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	state := c.machine.State()
+	for id, sub := range c.subs {
+		locked := lockedIdx(state.Locked, id) >= 0
+		select {
+		case <-sub.funded:
+			if !locked {
+				close(sub.settled)
+				delete(c.subs, id)
+			}
+		default:
+			if locked {
+				close(sub.funded)
+			}
+		}
+	}
+}
+
+// validLocked checks that the locked sub-allocations of the proposed state
+// are the same as in the current state, as they may only be changed by the
+// funding and settlement updates of sub-channels. Sub-allocations that are
+// owned by a channel.LockingApp are skipped, as they are checked by the app.
+// The machine must be locked by the caller.
+func (c *Channel) validLocked(state *channel.State) error {
+	app := c.Params().App
+	current := subChannelAllocs(app, c.machine.State().Locked)
+	proposed := subChannelAllocs(app, state.Locked)
+	if len(proposed) != len(current) {
+		return errors.New("locked sub-allocations must not be changed")
+	}
+	for i := range current {
+		if eq, err := equalEncoding(current[i], proposed[i]); err != nil {
+			return errors.WithMessagef(err, "comparing sub-allocation %d", i)
+		} else if !eq {
+			return errors.New("locked sub-allocations must not be changed")
+		}
+	}
+	return nil
+}
+
+// settleIntoParent settles the final state of a sub-channel or virtual
+// channel into its parent channel like fundFromParent. A sub-channel or
+// virtual channel that is not final is settled by settling its parent channel
+// by dispute together with the states of its sub-channels.
+func (c *Channel) settleIntoParent(ctx context.Context) error {
+	if c.Phase() != channel.Final {
+		return errors.WithMessage(c.parent.Settle(ctx), "settling parent channel by dispute")
+	}
+
+	sub, err := c.parent.sub(c.ID())
+	if err != nil {
+		return err
+	}
+	if sub.virtual {
+		if err := c.requestVirtualSettlement(ctx, sub); err != nil {
+			return err
+		}
+	}
+	if err := c.parent.progressSub(ctx, sub, sub.settled); err != nil {
+		return errors.WithMessage(err, "settling into parent channel")
+	}
+
+	c.machMtx.Lock()
+	defer c.machMtx.Unlock()
+
+	if err := c.machine.SetSettled(ctx); err != nil {
+		return err
+	}
+	c.emit(Event{Type: ChannelSettled})
+	return errors.WithMessage(c.pr.ChannelRemoved(ctx, c.ID()), "removing channel from persistence")
+}
+
+// lockedSubs returns the registered sub-channels that are funded by the
+// locked sub-allocations of state, in the order of the sub-allocations.
+func (c *Channel) lockedSubs(state *channel.State) []*subChannel {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	var subs []*subChannel
+	for _, sa := range state.Locked {
+		if sub, ok := c.subs[sa.ID]; ok {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// subStates returns the signed current states of the sub-channels that are
+// funded by the locked sub-allocations of state, including their own
+// sub-channels. For the virtual channels of an intermediary, the latest fully
+// signed state that the intermediary received is used.
+func (c *Channel) subStates(state *channel.State) []channel.SubChannel {
+	var states []channel.SubChannel
+	for _, sub := range c.lockedSubs(state) {
+		tx := sub.tx()
+		states = append(states, channel.SubChannel{
+			SignedState: channel.SignedState{
+				Params: sub.params,
+				State:  tx.State.Clone(),
+				Sigs:   append([]wallet.Sig(nil), tx.Sigs...),
+			},
+			Parent: c.ID(),
+			Idxs:   sub.idxs,
+		})
+		if sub.ch != nil {
+			states = append(states, sub.ch.subStates(tx.State)...)
+		}
+	}
+	return states
+}
+
+// settleSubs settles the sub-channels that are funded by the locked
+// sub-allocations of state after the parent channel was settled by dispute
+// with state, including their own sub-channels. Sub-channels for which we have
+// no channel controller are unregistered and their disputed callback is
+// called in a new go routine.
+func (c *Channel) settleSubs(ctx context.Context, state *channel.State) error {
+	for _, sub := range c.lockedSubs(state) {
+		if sub.ch == nil {
+			c.unregisterSettledSub(sub)
+			if sub.disputed != nil {
+				go sub.disputed()
+			}
+			continue
+		}
+		if err := sub.ch.setSettledByParent(ctx); err != nil {
+			return errors.WithMessagef(err, "settling sub-channel %x", sub.id)
+		}
+	}
+	return nil
+}
+
+// unregisterSettledSub unregisters the sub-channel after it was settled
+// together with this channel by dispute. Routines that wait for its
+// settlement are notified.
+func (c *Channel) unregisterSettledSub(sub *subChannel) {
+	c.subMtx.Lock()
+	defer c.subMtx.Unlock()
+
+	if c.subs[sub.id] != sub {
+		return
+	}
+	delete(c.subs, sub.id)
+	close(sub.settled)
+}
+
+// setSettledByParent marks the sub-channel as settled after its parent channel
+// was settled by dispute together with it.
+func (c *Channel) setSettledByParent(ctx context.Context) error {
+	c.machMtx.Lock()
+	defer c.machMtx.Unlock()
+
+	if c.machine.Phase() == channel.Settled {
+		return nil
+	} else if c.machine.Phase() != channel.Final {
+		if err := c.machine.SetRegistering(ctx); err != nil {
+			return err
+		} else if err := c.machine.SetWithdrawing(ctx); err != nil {
+			return err
+		}
+	}
+	if err := c.machine.SetSettled(ctx); err != nil {
+		return err
+	}
+	c.emit(Event{Type: ChannelSettled})
+	if err := c.settleSubs(ctx, c.machine.State()); err != nil {
+		return err
+	}
+	return errors.WithMessage(c.pr.ChannelRemoved(ctx, c.ID()), "removing channel from persistence")
+}
+
+// subChannelAllocs returns the sub-allocations in locked that are not owned by
+// app, i.e., that fund sub-channels.
+func subChannelAllocs(app channel.App, locked []channel.SubAlloc) []channel.SubAlloc {
+	la, ok := app.(channel.LockingApp)
+	if !ok {
+		return locked
+	}
+	var allocs []channel.SubAlloc
+	for _, sa := range locked {
+		if !la.OwnsSubAlloc(sa.ID) {
+			allocs = append(allocs, sa)
+		}
+	}
+	return allocs
+}
+
+// lockedIdx returns the index of the sub-allocation with the given ID in
+// locked or -1 if there is none.
+func lockedIdx(locked []channel.SubAlloc, id channel.ID) int {
+	for i, sa := range locked {
+		if sa.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalEncoding returns whether a and b have the same encoding.
+func equalEncoding(a, b perunio.Encoder) (bool, error) {
+	var bufA, bufB bytes.Buffer
+	if err := a.Encode(&bufA); err != nil {
+		return false, errors.WithMessage(err, "encoding first object")
+	}
+	if err := b.Encode(&bufB); err != nil {
+		return false, errors.WithMessage(err, "encoding second object")
+	}
+	return bytes.Equal(bufA.Bytes(), bufB.Bytes()), nil
+}
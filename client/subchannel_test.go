@@ -0,0 +1,212 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestSubChannel(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5B))
+	ctx, cancel := context.WithTimeout(context.Background(), 4*defaultTimeout)
+	defer cancel()
+
+	peerChs, errs := make(chan *client.Channel, 2), make(chan error, 2)
+	clients, addrs, closeAll := setupMultiPartyClients(t, rng, 2, func(i int) client.ProposalHandler {
+		return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: peerChs, errs: errs}
+	})
+	defer closeAll()
+	ledger, peerLedger, sub, peerSub := openSubChannel(ctx, t, rng, clients, addrs, peerChs, errs)
+	ledgerBals := ledger.State().Allocation.Clone()
+	for i, bals := range ledgerBals.OfParts {
+		for a, bal := range bals {
+			bal.Add(bal, sub.State().OfParts[i][a])
+		}
+	}
+	ledgerBals.Locked = nil
+
+	// The proposer pays its balance of the first asset in the final state.
+	subErrs := make(chan error, 1)
+	go peerSub.ListenUpdates(&updateHandler{errs: subErrs})
+	final := sub.State().Clone()
+	final.Version++
+	final.IsFinal = true
+	amount := new(big.Int).Set(final.OfParts[0][0])
+	final.OfParts[0][0].SetUint64(0)
+	final.OfParts[1][0].Add(final.OfParts[1][0], amount)
+	require.NoError(t, sub.Update(ctx, client.ChannelUpdate{State: final, ActorIdx: sub.Idx()}))
+	require.NoError(t, <-subErrs)
+
+	settleErrs := make(chan error, 2)
+	for _, ch := range []*client.Channel{sub, peerSub} {
+		go func(ch *client.Channel) { settleErrs <- ch.Settle(ctx) }(ch)
+	}
+	for range []*client.Channel{sub, peerSub} {
+		require.NoError(t, <-settleErrs)
+	}
+
+	settled := ledgerBals.Clone()
+	settled.OfParts[0][0].Sub(settled.OfParts[0][0], amount)
+	settled.OfParts[1][0].Add(settled.OfParts[1][0], amount)
+	for _, ch := range []*client.Channel{ledger, peerLedger} {
+		assert.Len(t, ch.State().Locked, 0)
+		assertEqualBals(t, settled.OfParts, ch.State().OfParts)
+	}
+	assert.Equal(t, channel.Settled, sub.Phase())
+	assert.Equal(t, channel.Settled, peerSub.Phase())
+}
+
+func TestSubChannel_Dispute(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5D))
+	ctx, cancel := context.WithTimeout(context.Background(), 4*defaultTimeout)
+	defer cancel()
+
+	var hub peertest.ConnHub
+	peerChs, errs := make(chan *client.Channel, 2), make(chan error, 2)
+	clients := make([]*client.Client, 2)
+	adjs := make([]*recordingAdjudicator, 2)
+	addrs := make([]peer.Address, 2)
+	for i := range clients {
+		id := wallettest.NewRandomAccount(rng)
+		addrs[i] = id.Address()
+		logger := log.WithField("role", i)
+		adjs[i] = &recordingAdjudicator{logAdjudicator{logger}, make(chan channel.AdjudicatorReq, 2)}
+		clients[i] = client.New(id, hub.NewDialer(),
+			&acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: peerChs, errs: errs},
+			&logFunder{logger}, adjs[i])
+		go clients[i].Listen(hub.NewListener(id.Address()))
+	}
+	defer func() {
+		for _, c := range clients {
+			assert.NoError(t, c.Close())
+		}
+		assert.NoError(t, hub.Close())
+	}()
+	clients[0].SetFinalizeTimeout(defaultTimeout / 10)
+	ledger, peerLedger, sub, peerSub := openSubChannel(ctx, t, rng, clients, addrs, peerChs, errs)
+
+	// The peer doesn't handle updates of the sub-channel, so Finalize falls
+	// back to settling the ledger channel by dispute together with the
+	// sub-channel.
+	require.NoError(t, sub.Finalize(ctx))
+	req := <-adjs[0].registered
+	assert.Equal(t, ledger.ID(), req.Params.ID())
+	require.Len(t, req.SubChannels, 1)
+	assert.Equal(t, sub.ID(), req.SubChannels[0].Params.ID())
+	assert.Equal(t, sub.State().Version, req.SubChannels[0].State.Version)
+	assert.Len(t, req.SubChannels[0].Sigs, 2)
+	assert.Equal(t, channel.Settled, ledger.Phase())
+	assert.Equal(t, channel.Settled, sub.Phase())
+
+	// The peer settles its non-final sub-channel the same way with Settle.
+	require.NoError(t, peerSub.Settle(ctx))
+	req = <-adjs[1].registered
+	assert.Equal(t, peerLedger.ID(), req.Params.ID())
+	assert.Len(t, req.SubChannels, 1)
+	assert.Equal(t, channel.Settled, peerLedger.Phase())
+	assert.Equal(t, channel.Settled, peerSub.Phase())
+}
+
+// openSubChannel opens a ledger channel between the two clients and a
+// sub-channel that is funded with half of the ledger channel's balances. It
+// returns the ledger channel and sub-channel of the first and the second
+// client.
+func openSubChannel(
+	ctx context.Context,
+	t *testing.T,
+	rng *rand.Rand,
+	clients []*client.Client,
+	addrs []peer.Address,
+	peerChs chan *client.Channel,
+	errs chan error,
+) (ledger, peerLedger, sub, peerSub *client.Channel) {
+	acceptedChannel := func() *client.Channel {
+		select {
+		case ch := <-peerChs:
+			return ch
+		case err := <-errs:
+			t.Fatalf("peer failed to accept: %v", err)
+		}
+		return nil
+	}
+
+	ledger, err := proposeMultiPartyChannel(rng, clients[0], addrs)
+	require.NoError(t, err)
+	peerLedger = acceptedChannel()
+	go peerLedger.ListenUpdates(&updateHandler{errs: make(chan error, 1)})
+	ledgerBals := ledger.State().Allocation.Clone()
+
+	subBals := ledgerBals.Clone()
+	for _, bals := range subBals.OfParts {
+		for _, bal := range bals {
+			bal.Div(bal, big.NewInt(2))
+		}
+	}
+	sub, err = clients[0].ProposeChannel(ctx, &client.ChannelProposal{
+		ChallengeDuration: 10,
+		Nonce:             big.NewInt(rng.Int63()),
+		Account:           wallettest.NewRandomAccount(rng),
+		AppDef:            payment.AppDef(),
+		InitData:          new(payment.NoData),
+		InitBals:          &subBals,
+		PeerAddrs:         addrs,
+		Parent:            ledger.ID(),
+	})
+	require.NoError(t, err)
+	peerSub = acceptedChannel()
+	assert.Equal(t, sub.ID(), peerSub.ID())
+	assert.Equal(t, channel.Acting, peerSub.Phase())
+
+	funded := ledgerBals.Clone()
+	for i, bals := range funded.OfParts {
+		for a, bal := range bals {
+			bal.Sub(bal, subBals.OfParts[i][a])
+		}
+	}
+	for _, ch := range []*client.Channel{ledger, peerLedger} {
+		require.Len(t, ch.State().Locked, 1)
+		assert.Equal(t, sub.ID(), ch.State().Locked[0].ID)
+		assertEqualBals(t, funded.OfParts, ch.State().OfParts)
+	}
+	return ledger, peerLedger, sub, peerSub
+}
+
+// recordingAdjudicator is a logAdjudicator that records the registration
+// requests.
+type recordingAdjudicator struct {
+	logAdjudicator
+	registered chan channel.AdjudicatorReq
+}
+
+func (a *recordingAdjudicator) Register(ctx context.Context, req channel.AdjudicatorReq) (*channel.Registered, error) {
+	a.registered <- req
+	return a.logAdjudicator.Register(ctx, req)
+}
+
+// assertEqualBals asserts that the balances are equal.
+func assertEqualBals(t *testing.T, expected, actual [][]channel.Bal) {
+	require.Len(t, actual, len(expected))
+	for i, bals := range expected {
+		require.Len(t, actual[i], len(bals))
+		for a, bal := range bals {
+			assert.Zero(t, bal.Cmp(actual[i][a]), "balance[%d][%d]", i, a)
+		}
+	}
+}
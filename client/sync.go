@@ -0,0 +1,236 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wallet"
+)
+
+// The channel resynchronization protocol is run whenever a peer reconnects. If
+// the connection dropped during an update, the participants may disagree about
+// the current state, e.g., if the proposer never received the last signature
+// while the responder already enabled the update. So both sides send a sync
+// message to each other, containing their current and staging transaction.
+// On receipt, a newer fully signed current transaction of the peer is adopted
+// and missing signatures on an equal staging state are added. If we are not in
+// the signing phase, our pending transaction is sent as staging transaction
+// instead, so that a failed update can be completed if the peer also holds the
+// missing signatures. Sync messages are only answered if the peer lacks our
+// current transaction or signatures.
+
+// syncChannels replaces the peer with the same Perun address as p in all
+// channels of p and starts the resynchronization protocol with p. It is
+// called when a peer reconnects.
+func (c *Client) syncChannels(p *peer.Peer) {
+	for _, ch := range c.channels.Values() {
+		replaced, err := ch.conn.ReplacePeer(p)
+		if err != nil {
+			c.logChan(ch.ID()).Warnf("replacing reconnected peer: %v", err)
+			continue
+		} else if !replaced {
+			continue
+		}
+
+		go func(ch *Channel) {
+			if err := ch.syncPeer(context.Background(), p); err != nil {
+				ch.log.Warnf("syncing with reconnected peer %v: %v", p.PerunAddress, err)
+			}
+		}(ch)
+	}
+}
+
+// syncPeers sends our sync message to all peers of the channel. It is used
+// after a channel was restored, as the channel's peers don't know that they
+// have to resync.
+func (c *Channel) syncPeers(ctx context.Context) error {
+	m, ok := c.syncMsg()
+	if !ok {
+		return nil
+	}
+	return errors.WithMessage(c.conn.Send(ctx, m), "sending sync message")
+}
+
+// syncPeer sends our sync message to the given peer.
+func (c *Channel) syncPeer(ctx context.Context, p *peer.Peer) error {
+	m, ok := c.syncMsg()
+	if !ok {
+		return nil
+	}
+	return errors.WithMessage(p.Send(ctx, m), "sending sync message")
+}
+
+// syncMsg returns our sync message. It returns false if the channel is not in
+// a phase in which it can be synced, that is, if it is not yet funded or it is
+// already being settled.
+func (c *Channel) syncMsg() (*msgChannelSync, bool) {
+	c.machMtx.RLock()
+	defer c.machMtx.RUnlock()
+
+	m := &msgChannelSync{
+		ChannelID: c.ID(),
+		CurrentTX: cloneTX(c.machine.CurrentTX()),
+	}
+	switch c.machine.Phase() {
+	case channel.Acting, channel.Final:
+		m.StagingTX = cloneTX(c.machine.PendingTX())
+	case channel.Signing:
+		m.StagingTX = cloneTX(c.machine.StagingTX())
+	default:
+		return nil, false
+	}
+	return m, true
+}
+
+// handleSyncMsgs handles incoming sync messages until the channel connection
+// is closed. It is started by the channel controller on creation.
+func (c *Channel) handleSyncMsgs() {
+	for {
+		pidx, m := c.conn.NextSync(context.Background())
+		if m == nil {
+			c.log.Debug("sync receiver closed")
+			return
+		}
+		reply, err := c.handleSyncMsg(context.Background(), m)
+		if err != nil {
+			c.logPeer(pidx).Warnf("handling sync message: %v", err)
+			c.emit(Event{Type: ChannelError, Err: errors.WithMessagef(err, "syncing with peer[%d]", pidx)})
+		}
+		if !reply {
+			continue
+		}
+		if msg, ok := c.syncMsg(); ok {
+			if err := c.conn.SendTo(context.Background(), pidx, msg); err != nil {
+				c.logPeer(pidx).Warnf("replying to sync message: %v", err)
+			}
+		}
+	}
+}
+
+// handleSyncMsg adopts the current transaction of the peer's sync message if
+// it is newer than ours. Otherwise, if both are in the signing phase of the
+// same update, the signatures of the peer's staging transaction are added to
+// ours and the update is enabled once all signatures are present. If we have a
+// pending transaction of the same version as the peer's staging transaction,
+// it is adopted as current transaction if both signature sets combined are
+// complete.
+//
+// It returns whether the peer lacks our current transaction or signatures on
+// the staging transaction, in which case we reply with our own sync message.
+// This is necessary if the peer missed our sync message, e.g., because it
+// only registered the channel after the reconnect.
+func (c *Channel) handleSyncMsg(ctx context.Context, m *msgChannelSync) (reply bool, err error) {
+	c.machMtx.Lock()
+	defer c.machMtx.Unlock()
+
+	if phase := c.machine.Phase(); phase != channel.Acting && phase != channel.Signing {
+		return false, errors.Errorf("cannot sync channel in phase %v", phase)
+	}
+	if m.CurrentTX.State == nil {
+		return false, errors.New("sync message has no current state")
+	}
+	if m.CurrentTX.Version < c.machine.State().Version {
+		return true, nil
+	} else if m.CurrentTX.Version > c.machine.State().Version {
+		if err := c.machine.SyncCurrentTX(ctx, m.CurrentTX); err != nil {
+			return false, errors.WithMessage(err, "syncing current transaction")
+		}
+		c.log.Infof("Synced current state to version %d.", m.CurrentTX.Version)
+		c.notifyUpdate()
+		return false, nil
+	}
+
+	if m.StagingTX.State == nil {
+		return false, nil // nothing to sync
+	} else if c.machine.Phase() != channel.Signing {
+		return c.syncPendingTX(ctx, m.StagingTX)
+	}
+
+	staging := c.machine.StagingTX()
+	if m.StagingTX.Version != staging.Version {
+		return false, nil // nothing to sync
+	}
+	// The signatures are verified on our staging state by AddSig, so they are
+	// only added if the peer signed the same staging state.
+	for i, sig := range staging.Sigs {
+		var peerSig wallet.Sig
+		if i < len(m.StagingTX.Sigs) {
+			peerSig = m.StagingTX.Sigs[i]
+		}
+		if sig != nil && peerSig == nil {
+			reply = true
+		} else if sig == nil && peerSig != nil {
+			if err := c.machine.AddSig(ctx, channel.Index(i), peerSig); err != nil {
+				return false, errors.WithMessagef(err, "adding synced signature of peer[%d]", i)
+			}
+		}
+	}
+	for _, sig := range c.machine.StagingTX().Sigs {
+		if sig == nil {
+			return reply, nil // still incomplete
+		}
+	}
+	c.log.Infof("Completed staging state of version %d by sync.", staging.Version)
+	return reply, c.enableNotifyUpdate(ctx)
+}
+
+// syncPendingTX completes our pending transaction with the signatures of the
+// peer's staging transaction tx and adopts it as current transaction if all
+// signatures are present then. It returns whether the peer lacks any of our
+// signatures. The peer's signatures are only used if they are valid on our
+// pending state.
+func (c *Channel) syncPendingTX(ctx context.Context, tx channel.Transaction) (reply bool, err error) {
+	pending := cloneTX(c.machine.PendingTX())
+	if pending.State == nil || pending.Version != tx.Version {
+		return false, nil // nothing to sync
+	}
+
+	complete := true
+	for i, sig := range pending.Sigs {
+		var peerSig wallet.Sig
+		if i < len(tx.Sigs) {
+			peerSig = tx.Sigs[i]
+		}
+		if sig != nil && peerSig == nil {
+			reply = true
+		} else if sig == nil && peerSig != nil {
+			addr := c.Params().Parts[i]
+			if ok, err := c.Params().Backends().Verify(addr, c.Params(), pending.State, peerSig); err != nil {
+				return false, errors.WithMessagef(err, "verifying synced signature of peer[%d]", i)
+			} else if !ok {
+				return false, errors.Errorf("invalid synced signature of peer[%d]", i)
+			}
+			pending.Sigs[i] = peerSig
+		} else if sig == nil {
+			complete = false
+		}
+	}
+	if !complete {
+		return reply, nil
+	}
+
+	if err := c.machine.SyncCurrentTX(ctx, pending); err != nil {
+		return false, errors.WithMessage(err, "syncing completed pending transaction")
+	}
+	c.log.Infof("Completed pending state of version %d by sync.", pending.Version)
+	c.notifyUpdate()
+	return reply, nil
+}
+
+// cloneTX returns a copy of the transaction with a copied signature slice, so
+// that it can be used outside of the machine lock. The state is not cloned as
+// the machine never modifies states.
+func cloneTX(tx channel.Transaction) channel.Transaction {
+	if tx.Sigs != nil {
+		tx.Sigs = append([]wallet.Sig(nil), tx.Sigs...)
+	}
+	return tx
+}
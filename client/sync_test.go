@@ -0,0 +1,222 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/peer"
+	peertest "perun.network/go-perun/peer/test"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestChannel_handleSyncMsg(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5119C))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t.Run("newer", func(t *testing.T) {
+		ch, peerAcc := newFundingTestChannel(t, rng, newMockAdjudicator())
+		require.NoError(t, ch.machine.SetFunded(ctx))
+		tx := newSignedTestTX(t, ch, peerAcc, 1)
+
+		reply, err := ch.handleSyncMsg(ctx, &msgChannelSync{ChannelID: ch.ID(), CurrentTX: tx})
+		require.NoError(t, err)
+		assert.False(t, reply)
+		assert.Equal(t, tx, ch.machine.CurrentTX())
+		assert.Equal(t, channel.Acting, ch.Phase())
+
+		// an older current transaction is answered
+		old := newSignedTestTX(t, ch, peerAcc, 0)
+		reply, err = ch.handleSyncMsg(ctx, &msgChannelSync{ChannelID: ch.ID(), CurrentTX: old})
+		require.NoError(t, err)
+		assert.True(t, reply)
+		assert.Equal(t, uint64(1), ch.State().Version)
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		ch, _ := newFundingTestChannel(t, rng, newMockAdjudicator())
+		require.NoError(t, ch.machine.SetFunded(ctx))
+		tx := newSignedTestTX(t, ch, wallettest.NewRandomAccount(rng), 1)
+
+		_, err := ch.handleSyncMsg(ctx, &msgChannelSync{ChannelID: ch.ID(), CurrentTX: tx})
+		assert.Error(t, err)
+		assert.Equal(t, uint64(0), ch.State().Version)
+	})
+
+	t.Run("staging", func(t *testing.T) {
+		ch, peerAcc := newFundingTestChannel(t, rng, newMockAdjudicator())
+		require.NoError(t, ch.machine.SetFunded(ctx))
+		state := ch.State().Clone()
+		state.Version++
+		require.NoError(t, ch.stateMachine.Update(ctx, state, 0))
+		_, err := ch.machine.Sig(ctx)
+		require.NoError(t, err)
+		peerSig, err := channel.Sign(peerAcc, ch.Params(), state)
+		require.NoError(t, err)
+
+		reply, err := ch.handleSyncMsg(ctx, &msgChannelSync{
+			ChannelID: ch.ID(),
+			CurrentTX: ch.machine.CurrentTX(),
+			StagingTX: channel.Transaction{State: state, Sigs: []wallet.Sig{nil, peerSig}},
+		})
+		require.NoError(t, err)
+		assert.True(t, reply, "peer lacks our signature")
+		assert.Equal(t, uint64(1), ch.State().Version)
+		assert.Equal(t, channel.Acting, ch.Phase())
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		ch, peerAcc := newFundingTestChannel(t, rng, newMockAdjudicator())
+		require.NoError(t, ch.machine.SetFunded(ctx))
+		state := stageTestUpdate(t, ch)
+		require.NoError(t, ch.machine.RetainUpdate(ctx))
+		peerSig, err := channel.Sign(peerAcc, ch.Params(), state)
+		require.NoError(t, err)
+
+		m, ok := ch.syncMsg()
+		require.True(t, ok)
+		assert.Equal(t, ch.machine.PendingTX(), m.StagingTX, "pending state must be synced")
+
+		reply, err := ch.handleSyncMsg(ctx, &msgChannelSync{
+			ChannelID: ch.ID(),
+			CurrentTX: ch.machine.CurrentTX(),
+			StagingTX: channel.Transaction{State: state, Sigs: []wallet.Sig{nil, peerSig}},
+		})
+		require.NoError(t, err)
+		assert.True(t, reply, "peer lacks our signature")
+		assert.Equal(t, state, ch.State())
+		assert.Nil(t, ch.machine.PendingTX().State)
+	})
+}
+
+func TestClient_syncChannels(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5119D))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var hub peertest.ConnHub
+	defer func() { assert.NoError(t, hub.Close()) }()
+	ids := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	newPeers := make(chan *peer.Peer, 2)
+	clients := make([]*Client, len(ids))
+	for i, id := range ids {
+		c := &Client{
+			id:         id,
+			channels:   makeChanRegistry(),
+			ver0Caches: make(map[*ver0CacheReq]struct{}),
+			log:        log.WithField("id", id.Address()),
+		}
+		c.peers = peer.NewRegistry(id, func(p *peer.Peer) {
+			c.subscribePeer(p)
+			newPeers <- p
+		}, hub.NewDialer())
+		go c.peers.Listen(hub.NewListener(id.Address()))
+		defer func() { assert.NoError(t, c.peers.Close()) }()
+		clients[i] = c
+	}
+
+	p1, err := clients[0].peers.Get(ctx, ids[1].Address())
+	require.NoError(t, err)
+	<-newPeers // p1
+	p0 := <-newPeers
+	chs, accs := newSyncTestChannels(t, rng, p0, p1)
+	for i, ch := range chs {
+		defer func(ch *Channel) { assert.NoError(t, ch.Close()) }(ch)
+		require.True(t, clients[i].channels.Put(ch.ID(), ch))
+	}
+
+	// The responder enabled an update whose last signature never reached the
+	// proposer.
+	state := chs[0].State().Clone()
+	state.Version++
+	require.NoError(t, chs[1].stateMachine.Update(ctx, state, 0))
+	sig, err := channel.Sign(accs[0], chs[1].Params(), state)
+	require.NoError(t, err)
+	require.NoError(t, chs[1].machine.AddSig(ctx, 0, sig))
+	_, err = chs[1].machine.Sig(ctx)
+	require.NoError(t, err)
+	require.NoError(t, chs[1].machine.EnableUpdate(ctx))
+
+	updates := make(chan *channel.State, 2)
+	chs[0].SubUpdates(updates)
+
+	// disconnect and reconnect
+	require.NoError(t, p1.Close())
+	select {
+	case <-p0.Closed():
+	case <-time.After(timeout):
+		t.Fatal("peer did not notice disconnect")
+	}
+	_, err = clients[0].peers.Get(ctx, ids[1].Address())
+	require.NoError(t, err)
+
+	select {
+	case synced := <-updates:
+		assert.Equal(t, state, synced)
+	case <-time.After(timeout):
+		t.Fatal("channel was not synced after reconnect")
+	}
+	assert.Equal(t, chs[1].machine.CurrentTX(), chs[0].machine.CurrentTX())
+	assert.Equal(t, channel.Acting, chs[0].Phase())
+}
+
+// newSignedTestTX returns a transaction on the current state of the test
+// channel with the given version, which is signed by us and the peer.
+func newSignedTestTX(t *testing.T, ch *Channel, peerAcc wallet.Account, version uint64) channel.Transaction {
+	state := ch.State().Clone()
+	state.Version = version
+	tx := channel.Transaction{State: state, Sigs: make([]wallet.Sig, 2)}
+	for i, acc := range []wallet.Account{ch.machine.Account(), peerAcc} {
+		var err error
+		tx.Sigs[i], err = channel.Sign(acc, ch.Params(), state)
+		require.NoError(t, err)
+	}
+	return tx
+}
+
+// newSyncTestChannels creates the funded two-party payment channel controllers
+// of both participants, which are connected via the given peers. The accounts
+// of the participants are also returned.
+func newSyncTestChannels(t *testing.T, rng *rand.Rand, p0, p1 *peer.Peer) ([]*Channel, []wallet.Account) {
+	ctx := context.Background()
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	params, err := channel.NewParams(60, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+	initBals := channeltest.NewRandomAllocation(rng, len(parts))
+
+	chs := make([]*Channel, len(parts))
+	for i, p := range []*peer.Peer{p1, p0} {
+		chs[i], err = newChannel(accs[i], []*peer.Peer{p}, *params, newMockAdjudicator(), persistence.NonPersister)
+		require.NoError(t, err)
+		require.NoError(t, chs[i].init(ctx, initBals, new(payment.NoData)))
+	}
+	sigs := make([]wallet.Sig, len(chs))
+	for i, ch := range chs {
+		sigs[i], err = ch.machine.Sig(ctx)
+		require.NoError(t, err)
+	}
+	for i, ch := range chs {
+		require.NoError(t, ch.machine.AddSig(ctx, channel.Index(i^1), sigs[i^1]))
+		require.NoError(t, ch.machine.EnableInit(ctx))
+		require.NoError(t, ch.machine.SetFunded(ctx))
+	}
+	return chs, accs
+}
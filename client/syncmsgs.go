@@ -0,0 +1,69 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/msg"
+)
+
+func init() {
+	msg.RegisterDecoder(msg.ChannelSync,
+		func(r io.Reader) (msg.Msg, error) {
+			var m msgChannelSync
+			return &m, m.Decode(r)
+		})
+}
+
+// msgChannelSync is the wire message of the channel resynchronization
+// protocol. It is sent to a peer after it reconnected and contains our latest
+// fully signed transaction and our staging transaction, if any.
+type msgChannelSync struct {
+	// ChannelID is the channel ID.
+	ChannelID channel.ID
+	// CurrentTX is the sender's current transaction.
+	CurrentTX channel.Transaction
+	// StagingTX is the sender's staging transaction. Its state is nil if the
+	// sender has no staging state.
+	StagingTX channel.Transaction
+}
+
+var _ ChannelMsg = (*msgChannelSync)(nil)
+
+// Type returns this message's type: ChannelSync
+func (*msgChannelSync) Type() msg.Type {
+	return msg.ChannelSync
+}
+
+func (c msgChannelSync) Encode(w io.Writer) error {
+	if err := wire.Encode(w, c.ChannelID); err != nil {
+		return err
+	}
+	if err := c.CurrentTX.Encode(w); err != nil {
+		return errors.WithMessage(err, "encoding current transaction")
+	}
+	return errors.WithMessage(c.StagingTX.Encode(w), "encoding staging transaction")
+}
+
+func (c *msgChannelSync) Decode(r io.Reader) error {
+	if err := wire.Decode(r, &c.ChannelID); err != nil {
+		return err
+	}
+	if err := c.CurrentTX.Decode(r); err != nil {
+		return errors.WithMessage(err, "decoding current transaction")
+	}
+	return errors.WithMessage(c.StagingTX.Decode(r), "decoding staging transaction")
+}
+
+// ID returns the id of the channel this sync message refers to.
+func (c *msgChannelSync) ID() channel.ID {
+	return c.ChannelID
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"math/rand"
+	"testing"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire/msg"
+)
+
+func TestChannelSyncSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5EC))
+	for i := 0; i < 4; i++ {
+		params := test.NewRandomParams(rng, test.NewRandomApp(rng).Def())
+		m := &msgChannelSync{
+			ChannelID: params.ID(),
+			CurrentTX: channel.Transaction{
+				State: test.NewRandomState(rng, params),
+				Sigs:  []wallet.Sig{newRandomSig(rng), newRandomSig(rng)},
+			},
+		}
+		if i%2 == 1 {
+			m.StagingTX = channel.Transaction{
+				State: test.NewRandomState(rng, params),
+				Sigs:  []wallet.Sig{nil, newRandomSig(rng)},
+			}
+		}
+		msg.TestMsg(t, m)
+	}
+}
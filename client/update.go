@@ -21,9 +21,14 @@ type (
 	ChannelUpdate struct {
 		// State is the proposed new state.
 		State *channel.State
-		// ActorIdx is the actor causing the new state.  It does not need to
-		// coincide with the sender of the request.
+		// ActorIdx is the actor causing the new state. It must be the proposer
+		// of the update, unless the update is a request.
 		ActorIdx uint16
+		// Request marks the update as requested by the proposer from the
+		// actor, e.g., a payment request, in which the actor pays the proposer.
+		// Only requests have another actor than the proposer. Update handlers
+		// should only accept requests that they explicitly opt in to.
+		Request bool
 	}
 
 	// An UpdateHandler decides how to handle incoming channel update requests
@@ -77,37 +82,70 @@ func (r *UpdateResponder) Reject(ctx context.Context, reason string) error {
 	return r.channel.handleUpdateRej(ctx, r.pidx, r.req, reason)
 }
 
-// Update proposes the given channel update to all channel participants.
+// ChannelID returns the ID of the channel that the update belongs to.
+func (r *UpdateResponder) ChannelID() channel.ID {
+	return r.channel.ID()
+}
+
+// Idx returns our index in the channel.
+func (r *UpdateResponder) Idx() channel.Index {
+	return r.channel.Idx()
+}
+
+// CurrentState returns the current state of the channel, which is replaced by
+// the update if it is accepted. It must only be called during
+// UpdateHandler.Handle and the returned state must not be modified.
+func (r *UpdateResponder) CurrentState() *channel.State {
+	return r.channel.machine.State() // the machine is locked during Handle
+}
+
+// Update proposes the given channel update to all channel participants. The
+// actor of the update must be us, unless the update is a request.
+//
+// The update request is broadcast to all other participants. It returns nil if
+// all peers accept the update. If any runtime error occurs or any peer rejects
+// the update, an error is returned.
 //
-// It returns nil if all peers accept the update. If any runtime error occurs or
-// any peer rejects the update, an error is returned.
-func (c *Channel) Update(ctx context.Context, up ChannelUpdate) (err error) {
+// If the update fails after our signature was sent, the proposed state is kept
+// as the pending state, since the other participants may be able to enforce
+// it. The version of the next update must then be higher than the version of
+// the pending state, see NextVersion.
+func (c *Channel) Update(ctx context.Context, up ChannelUpdate) error {
 	if ctx == nil {
 		return errors.New("context must not be nil")
 	}
-	if err := c.validTwoPartyUpdate(up, c.machine.Idx()); err != nil {
+	if err := c.validUpdate(up, c.machine.Idx()); err != nil {
 		return err
 	}
 
 	c.machMtx.Lock() // lock machine while update is in progress
 	defer c.machMtx.Unlock()
 
-	if err = c.machine.Update(up.State, up.ActorIdx); err != nil {
+	if err := c.validLocked(up.State); err != nil {
+		return err
+	}
+	return c.update(ctx, up)
+}
+
+// update runs the update protocol for the given update as its proposer. The
+// machine must be locked by the caller.
+func (c *Channel) update(ctx context.Context, up ChannelUpdate) (err error) {
+	if err = c.stateMachine.Update(ctx, up.State, up.ActorIdx); err != nil {
 		return errors.WithMessage(err, "updating machine")
 	}
-	// if anything goes wrong from now on, we discard the update.
-	// TODO: this is insecure after we sent our signature.
+	// if anything goes wrong from now on, we abort the update.
+	var sigSent bool
 	defer func() {
 		if err != nil {
-			if derr := c.machine.DiscardUpdate(); derr != nil {
-				// discarding update should never fail
-				err = errors.WithMessagef(derr,
-					"progressing update failed: %v, then discarding update failed", err)
+			if aerr := c.abortUpdate(ctx, sigSent); aerr != nil {
+				// aborting update should never fail
+				err = errors.WithMessagef(aerr,
+					"progressing update failed: %v, then aborting update failed", err)
 			}
 		}
 	}()
 
-	sig, err := c.machine.Sig()
+	sig, err := c.machine.Sig(ctx)
 	if err != nil {
 		return errors.WithMessage(err, "signing update")
 	}
@@ -122,26 +160,46 @@ func (c *Channel) Update(ctx context.Context, up ChannelUpdate) (err error) {
 		ChannelUpdate: up,
 		Sig:           sig,
 	}
+	sigSent = true // even if sending fails, some peers may have received it
 	if err = c.conn.Send(ctx, msgUpdate); err != nil {
 		return errors.WithMessage(err, "sending update")
 	}
 
-	pidx, res := resRecv.Next(ctx)
-	c.log.Tracef("Received update response (%T): %v", res, res)
-	if res == nil {
-		return errors.WithMessage(err, "receiving update response")
+	// all other participants respond
+	if err = c.receiveUpdateRes(ctx, resRecv, int(c.machine.N())-1); err != nil {
+		return err
 	}
 
-	if rej, ok := res.(*msgChannelUpdateRej); ok {
-		return errors.Errorf("update rejected: %s", rej.Reason)
-	}
+	return c.enableNotifyUpdate(ctx)
+}
 
-	acc := res.(*msgChannelUpdateAcc) // safe by predicate of the updateResRecv
-	if err := c.machine.AddSig(pidx, acc.Sig); err != nil {
-		return errors.WithMessage(err, "adding peer signature")
-	}
+// receiveUpdateRes receives n update responses on resRecv and adds the
+// signatures of accepting participants to the machine. If any participant
+// rejects the update, an error is returned. All n responses are received in
+// any case, so that no late responses are left over in the channel connection.
+func (c *Channel) receiveUpdateRes(ctx context.Context, resRecv *channelMsgRecv, n int) (err error) {
+	for i := 0; i < n; i++ {
+		pidx, res := resRecv.Next(ctx)
+		c.log.Tracef("Received update response (%T): %v", res, res)
+		if res == nil {
+			return errors.New("timeout when waiting for update response")
+		}
 
-	return c.enableNotifyUpdate()
+		if rej, ok := res.(*msgChannelUpdateRej); ok {
+			if err == nil {
+				err = errors.Errorf("update rejected by peer[%d]: %s", pidx, rej.Reason)
+			}
+			continue
+		} else if err != nil {
+			continue // update already failed
+		}
+
+		acc := res.(*msgChannelUpdateAcc) // safe by predicate of the updateResRecv
+		if err = c.machine.AddSig(ctx, pidx, acc.Sig); err != nil {
+			err = errors.WithMessagef(err, "adding signature of peer[%d]", pidx)
+		}
+	}
+	return err
 }
 
 // ListenUpdates starts the handling of incoming channel update requests. It
@@ -168,7 +226,7 @@ func (c *Channel) handleUpdateReq(
 	pidx channel.Index,
 	req *msgChannelUpdate,
 	uh UpdateHandler) {
-	if err := c.validTwoPartyUpdate(req.ChannelUpdate, pidx); err != nil {
+	if err := c.validUpdate(req.ChannelUpdate, pidx); err != nil {
 		// TODO: how to handle invalid updates? Just drop and ignore them?
 		c.logPeer(pidx).Warnf("invalid update received: %v", err)
 		return
@@ -177,16 +235,31 @@ func (c *Channel) handleUpdateReq(
 	c.machMtx.Lock() // lock machine while update is in progress
 	defer c.machMtx.Unlock()
 
-	if err := c.machine.CheckUpdate(req.State, req.ActorIdx, req.Sig, pidx); err != nil {
+	if err := c.stateMachine.CheckUpdate(req.State, req.ActorIdx, req.Sig, pidx); err != nil {
 		// TODO: how to handle invalid updates? Just drop and ignore them?
 		c.logPeer(pidx).Warnf("invalid update received: %v", err)
 		return
 	}
 
+	if c.isSubUpdate(req.State) {
+		// funding and settlement updates of sub-channels are accepted automatically
+		ctx, cancel := context.WithTimeout(context.Background(), subUpdateTimeout)
+		defer cancel()
+		c.handleUpdateAcc(ctx, pidx, req) // errors are logged by handleUpdateAcc
+		return
+	} else if err := c.validLocked(req.State); err != nil {
+		c.logPeer(pidx).Warnf("invalid update received: %v", err)
+		return
+	}
+
 	responder := &UpdateResponder{channel: c, pidx: pidx, req: req}
 	uh.Handle(req.ChannelUpdate, responder)
 }
 
+// handleUpdateAcc signs the requested update and broadcasts our signature to
+// all other participants. The update is only enabled after the signatures of
+// all remaining participants have been received. If any of them rejects the
+// update, it is discarded.
 func (c *Channel) handleUpdateAcc(
 	ctx context.Context,
 	pidx channel.Index,
@@ -199,41 +272,54 @@ func (c *Channel) handleUpdateAcc(
 	}()
 
 	// machine.Update and AddSig should never fail after CheckUpdate...
-	if err = c.machine.Update(req.State, req.ActorIdx); err != nil {
+	if err = c.stateMachine.Update(ctx, req.State, req.ActorIdx); err != nil {
 		return errors.WithMessage(err, "updating machine")
 	}
-	// if anything goes wrong from now on, we discard the update.
-	// TODO: this is insecure after we sent our signature.
+	// if anything goes wrong from now on, we abort the update.
+	var sigSent bool
 	defer func() {
 		if err != nil {
-			// we discard the update if anything went wrong
-			if derr := c.machine.DiscardUpdate(); derr != nil {
-				// discarding update should never fail at this point
-				err = errors.WithMessagef(derr,
-					"sending accept message failed: %v, then discarding update failed", err)
+			if aerr := c.abortUpdate(ctx, sigSent); aerr != nil {
+				// aborting update should never fail at this point
+				err = errors.WithMessagef(aerr,
+					"accepting update failed: %v, then aborting update failed", err)
 			}
 		}
 	}()
 
-	if err = c.machine.AddSig(pidx, req.Sig); err != nil {
+	if err = c.machine.AddSig(ctx, pidx, req.Sig); err != nil {
 		return errors.WithMessage(err, "adding peer signature")
 	}
 	var sig wallet.Sig
-	sig, err = c.machine.Sig()
+	sig, err = c.machine.Sig(ctx)
 	if err != nil {
 		return errors.WithMessage(err, "signing updated state")
 	}
 
+	// The responses of all other participants, except for the proposer, are
+	// received on resRecv. It must be created before sending our response, so
+	// that fast responses are not missed.
+	resRecv, err := c.conn.NewUpdateResRecv(req.State.Version)
+	if err != nil {
+		return errors.WithMessage(err, "creating update response receiver")
+	}
+	defer resRecv.Close()
+
 	msgUpAcc := &msgChannelUpdateAcc{
 		ChannelID: c.ID(),
 		Version:   req.State.Version,
 		Sig:       sig,
 	}
-	if err := c.conn.Send(ctx, msgUpAcc); err != nil {
+	sigSent = true // even if sending fails, some peers may have received it
+	if err = c.conn.Send(ctx, msgUpAcc); err != nil {
 		return errors.WithMessage(err, "sending accept message")
 	}
 
-	return c.enableNotifyUpdate()
+	if err = c.receiveUpdateRes(ctx, resRecv, int(c.machine.N())-2); err != nil {
+		return err
+	}
+
+	return c.enableNotifyUpdate(ctx)
 }
 
 func (c *Channel) handleUpdateRej(
@@ -248,33 +334,108 @@ func (c *Channel) handleUpdateRej(
 		}
 	}()
 
+	// The rejected state is retained as the pending state, as it was already
+	// signed by the proposer and possibly other participants. Its version must
+	// not be reused, so that all participants agree on the next version.
+	if err = c.retainRejectedUpdate(ctx, pidx, req); err != nil {
+		return err
+	}
+
+	// The responses of all other participants, except for the proposer, are
+	// received on resRecv and discarded, so that they are not left over.
+	resRecv, err := c.conn.NewUpdateResRecv(req.State.Version)
+	if err != nil {
+		return errors.WithMessage(err, "creating update response receiver")
+	}
+	defer resRecv.Close()
+
 	msgUpRej := &msgChannelUpdateRej{
 		ChannelID: c.ID(),
 		Version:   req.State.Version,
 		Reason:    reason,
 	}
-	return errors.WithMessage(c.conn.Send(ctx, msgUpRej), "sending reject message")
+	if err = c.conn.Send(ctx, msgUpRej); err != nil {
+		return errors.WithMessage(err, "sending reject message")
+	}
+
+	c.discardUpdateRes(ctx, resRecv, int(c.machine.N())-2)
+	return nil
+}
+
+// retainRejectedUpdate stages the rejected update request with the proposer's
+// signature and retains it as the pending state.
+func (c *Channel) retainRejectedUpdate(ctx context.Context, pidx channel.Index, req *msgChannelUpdate) error {
+	if err := c.stateMachine.Update(ctx, req.State, req.ActorIdx); err != nil {
+		return errors.WithMessage(err, "updating machine")
+	}
+	if err := c.machine.AddSig(ctx, pidx, req.Sig); err != nil {
+		return errors.WithMessage(err, "adding peer signature")
+	}
+	return errors.WithMessage(c.machine.RetainUpdate(ctx), "retaining rejected update")
+}
+
+// abortUpdate is called if an update fails after the staging state was set.
+// If our signature on the staging state was not sent yet, the update is
+// discarded. Otherwise, the other participants may already be able to enforce
+// the staging state, so it is retained as the pending state. If the staging
+// state is already signed by all participants, it is enabled instead.
+func (c *Channel) abortUpdate(ctx context.Context, sigSent bool) error {
+	if !sigSent {
+		return c.machine.DiscardUpdate(ctx)
+	}
+
+	staging := c.machine.StagingTX()
+	for _, sig := range staging.Sigs {
+		if sig == nil {
+			c.log.Warnf("Retaining signed state of failed update to version %d as pending state.", staging.Version)
+			return c.machine.RetainUpdate(ctx)
+		}
+	}
+	c.log.Warnf("Enabling fully signed state of failed update to version %d.", staging.Version)
+	return c.enableNotifyUpdate(ctx)
+}
+
+// discardUpdateRes receives and discards n update responses, so that they are
+// not left over in the channel connection. It is used after we successfully
+// rejected an update, so a timeout is only logged.
+func (c *Channel) discardUpdateRes(ctx context.Context, resRecv *channelMsgRecv, n int) {
+	for i := 0; i < n; i++ {
+		if _, res := resRecv.Next(ctx); res == nil {
+			c.log.Warn("timeout when waiting for remaining update responses")
+			return
+		}
+	}
 }
 
 // enableNotifyUpdate enables the current staging state of the machine. If the
 // state is final, machine.EnableFinal is called. Finally, if there is a
 // notification on channel updates, the enabled state is sent on it.
-func (c *Channel) enableNotifyUpdate() error {
-	var updater func() error
+func (c *Channel) enableNotifyUpdate(ctx context.Context) error {
+	var updater func(context.Context) error
 	if c.machine.StagingState().IsFinal {
 		updater = c.machine.EnableFinal
 	} else {
 		updater = c.machine.EnableUpdate
 	}
 
-	if err := updater(); err != nil {
-		return errors.WithMessage(c.machine.EnableUpdate(), "enabling update")
+	if err := updater(ctx); err != nil {
+		return errors.WithMessage(err, "enabling update")
 	}
 
+	c.notifyUpdate()
+	return nil
+}
+
+// notifyUpdate notifies the sub-channels that are funded or settled by the
+// current state, emits a ChannelUpdated event and sends the current state on
+// the update subscription, if any. It is called whenever a new current state
+// is enabled. The machine must be locked by the caller.
+func (c *Channel) notifyUpdate() {
+	c.notifySubs()
+	c.emit(Event{Type: ChannelUpdated, State: c.machine.State()})
 	if c.updateSub != nil {
 		c.updateSub <- c.machine.State()
 	}
-	return nil
 }
 
 // SubUpdates sets up a subscription to state updates on the provided go channel.
@@ -288,17 +449,32 @@ func (c *Channel) SubUpdates(updateSub chan<- *channel.State) {
 	c.updateSub = updateSub
 }
 
-// validTwoPartyUpdate performs additional protocol-dependent checks on the
-// proposed update that go beyond the machine's checks:
-// * actor and signer must be the same
-// * no locked sub-allocations
-func (c *Channel) validTwoPartyUpdate(up ChannelUpdate, sigIdx channel.Index) error {
-	if up.ActorIdx != sigIdx {
+// NextVersion returns the version that the state of the next update must
+// have. It is the current version plus one, unless an earlier update failed
+// after our signature was sent. Then, it is the pending version plus one.
+func (c *Channel) NextVersion() uint64 {
+	c.machMtx.RLock()
+	defer c.machMtx.RUnlock()
+
+	return c.machine.NextVersion()
+}
+
+// validUpdate performs additional protocol-dependent checks on the proposed
+// update that go beyond the machine's checks:
+// * the channel's app must be a StateApp
+// * actor and signer must be the same, unless the update is a request
+// * the actor of a request must be another participant than the signer
+// The locked sub-allocations are checked by validLocked, as this requires the
+// machine to be locked.
+func (c *Channel) validUpdate(up ChannelUpdate, sigIdx channel.Index) error {
+	if c.stateMachine == nil {
+		return errors.New("full state updates are only possible for StateApps, use Act for ActionApps")
+	}
+	if !up.Request && up.ActorIdx != sigIdx {
 		return errors.Errorf(
 			"Currently, only update proposals with the proposing peer as actor are allowed.")
-	}
-	if len(up.State.Locked) > 0 {
-		return errors.New("no locked sub-allocations allowed")
+	} else if up.Request && (up.ActorIdx == sigIdx || up.ActorIdx >= c.machine.N()) {
+		return errors.Errorf("invalid actor index %d of request", up.ActorIdx)
 	}
 	return nil
 }
@@ -6,10 +6,14 @@
 package client
 
 import (
+	"context"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/channel"
 )
 
 func TestUpdateResponder_Accept_NilArgs(t *testing.T) {
@@ -35,3 +39,66 @@ func TestChannel_Update_NilArgs(t *testing.T) {
 func TestChannel_ListenUpdates_NilArgs(t *testing.T) {
 	assert.Panics(t, func() { new(Channel).ListenUpdates(nil) })
 }
+
+func TestChannel_abortUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xAB027))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t.Run("sig not sent", func(t *testing.T) {
+		ch := newTestChannel(t, rng, newMockAdjudicator(), 1)
+		stageTestUpdate(t, ch)
+
+		require.NoError(t, ch.abortUpdate(ctx, false))
+		assert.Equal(t, channel.Acting, ch.Phase())
+		assert.Nil(t, ch.machine.PendingTX().State)
+		assert.Equal(t, uint64(2), ch.NextVersion())
+	})
+
+	t.Run("sig sent", func(t *testing.T) {
+		ch := newTestChannel(t, rng, newMockAdjudicator(), 1)
+		state := stageTestUpdate(t, ch)
+
+		require.NoError(t, ch.abortUpdate(ctx, true))
+		assert.Equal(t, channel.Acting, ch.Phase())
+		assert.Equal(t, uint64(1), ch.State().Version)
+		assert.Equal(t, state, ch.machine.PendingTX().State)
+		assert.Equal(t, uint64(3), ch.NextVersion(), "pending version must be skipped")
+	})
+
+	t.Run("fully signed", func(t *testing.T) {
+		ch, peerAcc := newFundingTestChannel(t, rng, newMockAdjudicator())
+		require.NoError(t, ch.machine.SetFunded(ctx))
+		state := stageTestUpdate(t, ch)
+		sig, err := channel.Sign(peerAcc, ch.Params(), state)
+		require.NoError(t, err)
+		require.NoError(t, ch.machine.AddSig(ctx, 1, sig))
+
+		require.NoError(t, ch.abortUpdate(ctx, true))
+		assert.Equal(t, state, ch.State())
+		assert.Nil(t, ch.machine.PendingTX().State)
+	})
+}
+
+// stageTestUpdate stages an update of the test channel to the next version and
+// signs it by us. The staged state is returned.
+func stageTestUpdate(t *testing.T, ch *Channel) *channel.State {
+	ctx := context.Background()
+	state := ch.State().Clone()
+	state.Version = ch.NextVersion()
+	require.NoError(t, ch.stateMachine.Update(ctx, state, 0))
+	_, err := ch.machine.Sig(ctx)
+	require.NoError(t, err)
+	return state
+}
+
+func TestChannel_validUpdate(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xA1D))
+	ch := newTestChannel(t, rng, newMockAdjudicator(), 1)
+
+	assert.NoError(t, ch.validUpdate(ChannelUpdate{ActorIdx: 0}, 0))
+	assert.Error(t, ch.validUpdate(ChannelUpdate{ActorIdx: 1}, 0), "actor must be signer")
+	assert.NoError(t, ch.validUpdate(ChannelUpdate{ActorIdx: 1, Request: true}, 0))
+	assert.Error(t, ch.validUpdate(ChannelUpdate{ActorIdx: 0, Request: true}, 0), "request from self")
+	assert.Error(t, ch.validUpdate(ChannelUpdate{ActorIdx: 2, Request: true}, 0), "invalid actor")
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	stdsync "sync"
+	"time"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/log"
+)
+
+// updateResponseTimeout is the timeout of the ready-made update handlers for
+// responding to an update.
+const updateResponseTimeout = 10 * time.Second
+
+type (
+	// UpdateHandlerFunc is an adapter to use an ordinary function as an
+	// UpdateHandler.
+	UpdateHandlerFunc func(ChannelUpdate, *UpdateResponder)
+
+	// UpdateMiddleware wraps an UpdateHandler. It either responds to an update
+	// itself or passes it on to the wrapped handler.
+	UpdateMiddleware func(next UpdateHandler) UpdateHandler
+)
+
+// Handle calls f.
+func (f UpdateHandlerFunc) Handle(up ChannelUpdate, res *UpdateResponder) {
+	f(up, res)
+}
+
+// ChainUpdateHandler wraps the handler h in the given middlewares. The first
+// middleware is the outermost, so it sees every update first.
+func ChainUpdateHandler(h UpdateHandler, mws ...UpdateMiddleware) UpdateHandler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// AcceptAllUpdates is an UpdateHandler that accepts all updates.
+var AcceptAllUpdates UpdateHandler = UpdateHandlerFunc(func(_ ChannelUpdate, res *UpdateResponder) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateResponseTimeout)
+	defer cancel()
+	res.Accept(ctx) // errors are logged by the channel
+})
+
+// RejectAllUpdates returns an UpdateHandler that rejects all updates with the
+// given reason.
+func RejectAllUpdates(reason string) UpdateHandler {
+	return UpdateHandlerFunc(func(_ ChannelUpdate, res *UpdateResponder) {
+		reject(res, reason)
+	})
+}
+
+// LogUpdates returns a middleware that logs every update on the given logger
+// before passing it on.
+func LogUpdates(l log.Logger) UpdateMiddleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return UpdateHandlerFunc(func(up ChannelUpdate, res *UpdateResponder) {
+			l.WithField("channel", res.ChannelID()).Infof(
+				"Update to version %d by peer[%d]: %v", up.State.Version, up.ActorIdx, up.State.Allocation)
+			next.Handle(up, res)
+		})
+	}
+}
+
+// AcceptPayments returns a middleware that accepts incoming payments of the
+// payment app and passes on all other updates. An update is an incoming
+// payment if it increases some of our balances without decreasing any of our
+// balances and doesn't change the app data or finalize the channel.
+func AcceptPayments() UpdateMiddleware {
+	return func(next UpdateHandler) UpdateHandler {
+		return UpdateHandlerFunc(func(up ChannelUpdate, res *UpdateResponder) {
+			if !isIncomingPayment(res.CurrentState(), up.State, res.Idx()) {
+				next.Handle(up, res)
+				return
+			}
+			AcceptAllUpdates.Handle(up, res)
+		})
+	}
+}
+
+// isIncomingPayment returns whether the update from cur to next is an
+// incoming payment for the participant with index idx.
+func isIncomingPayment(cur, next *channel.State, idx channel.Index) bool {
+	if next.IsFinal {
+		return false
+	} else if eq, err := equalEncoding(cur.Data, next.Data); err != nil || !eq {
+		return false
+	}
+	var increased bool
+	for a, bal := range next.OfParts[idx] {
+		switch bal.Cmp(cur.OfParts[idx][a]) {
+		case -1:
+			return false
+		case 1:
+			increased = true
+		}
+	}
+	return increased
+}
+
+// SpendingLimit returns a middleware that rejects updates that would make us
+// spend more than limit[a] of asset a in a channel, counted from the state
+// before the first update that the middleware handled for the channel. A nil
+// limit means no limit. Updates within the limits are passed on.
+func SpendingLimit(limit []*big.Int) UpdateMiddleware {
+	var mtx stdsync.Mutex
+	baselines := make(map[channel.ID][]channel.Bal)
+	return func(next UpdateHandler) UpdateHandler {
+		return UpdateHandlerFunc(func(up ChannelUpdate, res *UpdateResponder) {
+			mtx.Lock()
+			baseline, ok := baselines[res.ChannelID()]
+			if !ok {
+				baseline = cloneBals(res.CurrentState().OfParts[res.Idx()])
+				baselines[res.ChannelID()] = baseline
+			}
+			mtx.Unlock()
+
+			if a, ok := exceedsLimit(baseline, up.State.OfParts[res.Idx()], limit); ok {
+				reject(res, fmt.Sprintf("spending limit of asset %d exceeded", a))
+				return
+			}
+			next.Handle(up, res)
+		})
+	}
+}
+
+// exceedsLimit returns the index of the first asset of which more than its
+// limit would be spent by going from the baseline balances to bals.
+func exceedsLimit(baseline, bals []channel.Bal, limit []*big.Int) (int, bool) {
+	for a, bal := range bals {
+		if a >= len(limit) || limit[a] == nil {
+			continue
+		}
+		spent := new(big.Int).Sub(baseline[a], bal)
+		if spent.Cmp(limit[a]) > 0 {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// RateLimit returns a middleware that rejects updates if more than n updates
+// were handled in a channel within the given period. Updates within the rate
+// limit are passed on.
+func RateLimit(n int, period time.Duration) UpdateMiddleware {
+	var mtx stdsync.Mutex
+	recent := make(map[channel.ID][]time.Time)
+	return func(next UpdateHandler) UpdateHandler {
+		return UpdateHandlerFunc(func(up ChannelUpdate, res *UpdateResponder) {
+			now := time.Now()
+			mtx.Lock()
+			times := recent[res.ChannelID()]
+			for len(times) > 0 && now.Sub(times[0]) >= period {
+				times = times[1:]
+			}
+			limited := len(times) >= n
+			if !limited {
+				times = append(times, now)
+			}
+			recent[res.ChannelID()] = times
+			mtx.Unlock()
+
+			if limited {
+				reject(res, "rate limit exceeded")
+				return
+			}
+			next.Handle(up, res)
+		})
+	}
+}
+
+// reject rejects the update with the given reason.
+func reject(res *UpdateResponder, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), updateResponseTimeout)
+	defer cancel()
+	res.Reject(ctx, reason) // errors are logged by the channel
+}
+
+// cloneBals returns a deep copy of the balances.
+func cloneBals(bals []channel.Bal) []channel.Bal {
+	clone := make([]channel.Bal, len(bals))
+	for i, bal := range bals {
+		clone[i] = new(big.Int).Set(bal)
+	}
+	return clone
+}
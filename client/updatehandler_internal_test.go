@@ -0,0 +1,37 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"perun.network/go-perun/channel"
+)
+
+func TestExceedsLimit(t *testing.T) {
+	bals := func(bals ...int64) []channel.Bal {
+		bigBals := make([]channel.Bal, len(bals))
+		for i, bal := range bals {
+			bigBals[i] = big.NewInt(bal)
+		}
+		return bigBals
+	}
+	baseline := bals(10, 10)
+	limit := []*big.Int{big.NewInt(5), nil}
+
+	_, exceeded := exceedsLimit(baseline, bals(5, 0), limit)
+	assert.False(t, exceeded)
+	_, exceeded = exceedsLimit(baseline, bals(20, 10), limit)
+	assert.False(t, exceeded)
+	a, exceeded := exceedsLimit(baseline, bals(4, 10), limit)
+	assert.True(t, exceeded)
+	assert.Equal(t, 0, a)
+	_, exceeded = exceedsLimit(baseline, bals(4, 10), nil)
+	assert.False(t, exceeded)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/log"
+)
+
+func TestChainUpdateHandler(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x0DA7E))
+
+	t.Run("payments", func(t *testing.T) {
+		chs, closeAll := openMultiPartyChannels(t, rng, 2)
+		defer closeAll()
+		go chs[1].ListenUpdates(client.ChainUpdateHandler(client.RejectAllUpdates("not a payment"),
+			client.LogUpdates(log.Get()), client.AcceptPayments()))
+
+		require.NoError(t, pay(chs[0], big.NewInt(1)))
+		assert.Equal(t, uint64(1), chs[0].State().Version)
+		assert.Error(t, updateMultiPartyChannel(chs[0]))
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		chs, closeAll := openMultiPartyChannels(t, rng, 2)
+		defer closeAll()
+		go chs[1].ListenUpdates(client.ChainUpdateHandler(client.AcceptAllUpdates,
+			client.RateLimit(1, time.Hour)))
+
+		require.NoError(t, updateMultiPartyChannel(chs[0]))
+		assert.Error(t, updateMultiPartyChannel(chs[0]))
+	})
+}
+
+// pay proposes an update in which the proposer pays the given amount of the
+// first asset to the participant with index 1.
+func pay(ch *client.Channel, amount *big.Int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	state := ch.State().Clone()
+	state.Version++
+	state.OfParts[ch.Idx()][0].Sub(state.OfParts[ch.Idx()][0], amount)
+	state.OfParts[1][0].Add(state.OfParts[1][0], amount)
+	return ch.Update(ctx, client.ChannelUpdate{State: state, ActorIdx: ch.Idx()})
+}
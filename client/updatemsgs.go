@@ -101,17 +101,17 @@ func (*msgChannelUpdateRej) Type() msg.Type {
 }
 
 func (c msgChannelUpdate) Encode(w io.Writer) error {
-	return wire.Encode(w, c.State, c.ActorIdx, c.Sig)
+	return wire.Encode(w, c.State, c.ActorIdx, c.Request, c.Sig)
 }
 
 func (c *msgChannelUpdate) Decode(r io.Reader) (err error) {
 	if c.State == nil {
 		c.State = new(channel.State)
 	}
-	if err := wire.Decode(r, c.State, &c.ActorIdx); err != nil {
+	if err := wire.Decode(r, c.State, &c.ActorIdx, &c.Request); err != nil {
 		return err
 	}
-	c.Sig, err = wallet.DecodeSig(r)
+	c.Sig, err = channel.DecodingBackends(r).DecodeSig(r)
 	return err
 }
 
@@ -123,7 +123,7 @@ func (c *msgChannelUpdateAcc) Decode(r io.Reader) (err error) {
 	if err := wire.Decode(r, &c.ChannelID, &c.Version); err != nil {
 		return err
 	}
-	c.Sig, err = wallet.DecodeSig(r)
+	c.Sig, err = channel.DecodingBackends(r).DecodeSig(r)
 	return err
 }
 
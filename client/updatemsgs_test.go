@@ -24,6 +24,7 @@ func TestChannelUpdateSerialization(t *testing.T) {
 			ChannelUpdate: ChannelUpdate{
 				State:    test.NewRandomState(rng, params),
 				ActorIdx: uint16(rng.Int31n(int32(len(params.Parts)))),
+				Request:  rng.Intn(2) == 0,
 			},
 			Sig: sig,
 		}
@@ -0,0 +1,563 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	stdsync "sync"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wallet"
+	wire "perun.network/go-perun/wire/msg"
+)
+
+// A virtual channel between two participants is funded from their ledger
+// channels with a common intermediary, who is not a participant of the
+// virtual channel. In each ledger channel, the participant locks its own
+// initial balance and the intermediary locks the initial balance of the other
+// participant, so the intermediary's funds are balanced out.
+//
+// Once the initial state of the virtual channel is fully signed, both
+// participants send it to the intermediary. After the intermediary received
+// both, it proposes the funding updates to both ledger channels, which are
+// accepted automatically by the participants. Settlement works the same way
+// with the fully signed final state, where each participant's ledger channel
+// receives the participant's final balance and the intermediary receives the
+// final balance of the other participant. The intermediary persists its
+// records of virtual channels, see persistence.VirtualChannel.
+//
+// A virtual channel that is not final is settled by dispute through the
+// ledger channels, see channel.SubChannel. If a participant settles its
+// ledger channel by dispute, the intermediary should settle the ledger channel
+// too, as it does with any disputed channel. The intermediary then settles
+// the other ledger channel by dispute, so that it receives the balance of the
+// other participant that it paid out in the first ledger channel.
+
+// virtualChannel is a virtual channel as seen by the intermediary. Half i
+// belongs to the participant with index i in the virtual channel and is set
+// once the participant requested the funding.
+type virtualChannel struct {
+	mtx      stdsync.Mutex
+	params   *channel.Params
+	tx       channel.Transaction // latest fully signed transaction
+	parents  [2]*Channel         // ledger channels with the participants
+	peers    [2]wallet.Address   // Perun addresses of the participants
+	idxs     [2][]channel.Index  // indices of the participants in the ledger channels
+	settling [2]bool             // whether a participant requested the settlement
+	disputed [2]bool             // whether a ledger channel was settled by dispute
+}
+
+// TX returns the latest fully signed transaction of the virtual channel.
+func (vc *virtualChannel) TX() channel.Transaction {
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+
+	return vc.tx
+}
+
+// record returns the persistence record of the virtual channel. The virtual
+// channel must be locked by the caller.
+func (vc *virtualChannel) record() *persistence.VirtualChannel {
+	r := &persistence.VirtualChannel{
+		Params:   vc.params,
+		TX:       vc.tx,
+		Peers:    vc.peers,
+		Idxs:     vc.idxs,
+		Settling: vc.settling,
+	}
+	for i, parent := range vc.parents {
+		if parent != nil {
+			r.Parents[i] = parent.ID()
+		}
+	}
+	return r
+}
+
+// subVirtualChannelMsgs subscribes to the funding and settlement requests of
+// virtual channels for which we are the intermediary. Like
+// subChannelProposals, it is called during the setup of new peers.
+func (c *Client) subVirtualChannelMsgs(p *peer.Peer) {
+	recv := peer.NewReceiver()
+	if err := p.Subscribe(recv, func(m wire.Msg) bool {
+		return m.Type() == wire.VirtualChannelFunding || m.Type() == wire.VirtualChannelSettlement
+	}); err != nil {
+		c.logPeer(p).Errorf("failed to subscribe to virtual channel messages on new peer: %v", err)
+		recv.Close()
+		return
+	}
+
+	p.OnCloseAlways(func() {
+		if err := recv.Close(); err != nil {
+			c.logPeer(p).Errorf("failed to close virtual channel receiver: %v", err)
+		}
+	})
+
+	go func() {
+		for {
+			_p, m := recv.Next(context.Background())
+			if _p == nil {
+				c.logPeer(p).Debug("virtual channel subscription closed")
+				return
+			}
+			switch m := m.(type) {
+			case *msgVirtualChannelFunding:
+				go c.handleVirtualChannelFunding(p, m)
+			case *msgVirtualChannelSettlement:
+				go c.handleVirtualChannelSettlement(p, m)
+			}
+		}
+	}()
+}
+
+// handleVirtualChannelFunding handles the funding request of a participant of
+// a virtual channel. Once the requests of both participants were received,
+// the funding updates are proposed to both ledger channels.
+func (c *Client) handleVirtualChannelFunding(p *peer.Peer, req *msgVirtualChannelFunding) {
+	log := c.logPeer(p)
+	ctx, cancel := context.WithTimeout(context.Background(), subUpdateTimeout)
+	defer cancel()
+
+	vc, err := c.addVirtualFundingReq(ctx, p, req)
+	if err != nil {
+		log.Warnf("received invalid virtual channel funding request: %v", err)
+		return
+	} else if vc == nil {
+		return // waiting for the other participant
+	}
+
+	for i, parent := range vc.parents {
+		sub := c.newVirtualSub(vc, i)
+		parent.registerSub(sub)
+		if err := parent.progressSub(ctx, sub, sub.funded); err != nil {
+			parent.unregisterSub(sub.id)
+			log.Errorf("funding virtual channel from ledger channel %d: %v", i, err)
+			return
+		}
+	}
+}
+
+// newVirtualSub creates the record of the virtual channel as a sub-channel of
+// the ledger channel with its participant i.
+func (c *Client) newVirtualSub(vc *virtualChannel, i int) *subChannel {
+	sub := newSubChannelWithTX(vc.params, vc.TX, vc.idxs[i], true, true)
+	sub.disputed = func() { c.virtualDisputed(vc, i) }
+	return sub
+}
+
+// addVirtualFundingReq verifies the funding request and adds it to the virtual
+// channel. The request is bound to the ledger channel with the sender and the
+// sender's index in the virtual channel. The virtual channel is returned once
+// the requests of both participants were added and nil otherwise.
+func (c *Client) addVirtualFundingReq(
+	ctx context.Context,
+	p *peer.Peer,
+	req *msgVirtualChannelFunding,
+) (*virtualChannel, error) {
+	if len(req.Parts) != 2 || req.Idx > 1 {
+		return nil, errors.New("virtual channels must have two participants")
+	}
+	params, err := c.backends.NewParams(req.ChallengeDuration, req.Parts, req.AppDef, req.Nonce)
+	if err != nil {
+		return nil, errors.WithMessage(err, "invalid parameters")
+	}
+	state := req.Initial.State
+	if err := verifyVirtualTX(params, req.Initial); err != nil {
+		return nil, err
+	} else if state.Version != 0 || state.IsFinal || len(state.Locked) != 0 {
+		return nil, errors.New("invalid initial state")
+	}
+
+	parent, err := c.Channel(req.Parent)
+	if err != nil {
+		return nil, errors.WithMessage(err, "getting ledger channel")
+	} else if !parent.isLedgerWith(p.PerunAddress) {
+		return nil, errors.New("not a two-party ledger channel with the sender")
+	}
+	peerIdx, _ := parent.conn.peerIndex(p.PerunAddress)
+	idxs := make([]channel.Index, 2)
+	idxs[req.Idx], idxs[1-req.Idx] = peerIdx, parent.Idx()
+	if err := parent.validSubAlloc(idxs, &state.Allocation); err != nil {
+		return nil, err
+	}
+
+	c.virtualMtx.Lock()
+	defer c.virtualMtx.Unlock()
+
+	vc, ok := c.virtuals[params.ID()]
+	if !ok {
+		vc = &virtualChannel{params: params, tx: req.Initial}
+	}
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+
+	if err := vc.addFunding(req.Idx, parent, p.PerunAddress, idxs, state); err != nil {
+		return nil, err
+	}
+	if err := c.pr.VirtualChanged(ctx, vc.record()); err != nil {
+		vc.parents[req.Idx], vc.peers[req.Idx], vc.idxs[req.Idx] = nil, nil, nil
+		return nil, errors.WithMessage(err, "persisting virtual channel")
+	}
+	c.virtuals[params.ID()] = vc
+	if vc.parents[1-req.Idx] == nil {
+		return nil, nil
+	}
+	return vc, nil
+}
+
+// handleVirtualChannelSettlement handles the settlement request of a
+// participant of a virtual channel. Once the requests of both participants
+// were received, the settlement updates are proposed to both ledger channels.
+// A repeated request retries the settlement of the ledger channels into which
+// the virtual channel is not yet settled.
+func (c *Client) handleVirtualChannelSettlement(p *peer.Peer, req *msgVirtualChannelSettlement) {
+	log := c.logPeer(p)
+	ctx, cancel := context.WithTimeout(context.Background(), subUpdateTimeout)
+	defer cancel()
+
+	vc, err := c.addVirtualSettlementReq(ctx, p, req)
+	if err != nil {
+		log.Warnf("received invalid virtual channel settlement request: %v", err)
+		return
+	} else if vc == nil {
+		return // waiting for the other participant
+	}
+
+	for i, parent := range vc.parents {
+		if lockedIdx(parent.State().Locked, vc.params.ID()) < 0 {
+			continue // already settled
+		}
+		sub, err := parent.sub(vc.params.ID())
+		if err != nil {
+			log.Errorf("settling virtual channel into ledger channel %d: %v", i, err)
+			return
+		}
+		if err := parent.progressSub(ctx, sub, sub.settled); err != nil {
+			log.Errorf("settling virtual channel into ledger channel %d: %v", i, err)
+			return
+		}
+	}
+	c.removeVirtual(ctx, vc)
+}
+
+// addVirtualSettlementReq verifies the settlement request and adds its final
+// state to the virtual channel. The request must be sent by the same peer and
+// for the same index as the funding request. The virtual channel is returned
+// once the requests of both participants were added and nil otherwise.
+func (c *Client) addVirtualSettlementReq(
+	ctx context.Context,
+	p *peer.Peer,
+	req *msgVirtualChannelSettlement,
+) (*virtualChannel, error) {
+	if req.Final.State == nil || req.Idx > 1 {
+		return nil, errors.New("invalid settlement request")
+	}
+
+	c.virtualMtx.Lock()
+	vc, ok := c.virtuals[req.Final.State.ID]
+	c.virtualMtx.Unlock()
+	if !ok {
+		return nil, errors.New("unknown virtual channel")
+	}
+
+	vc.mtx.Lock()
+	defer vc.mtx.Unlock()
+
+	tx, settling := vc.tx, vc.settling[req.Idx]
+	if err := vc.addSettlement(req.Idx, p.PerunAddress, req.Final); err != nil {
+		return nil, err
+	} else if !settling {
+		if err := c.pr.VirtualChanged(ctx, vc.record()); err != nil {
+			vc.tx, vc.settling[req.Idx] = tx, false
+			return nil, errors.WithMessage(err, "persisting virtual channel")
+		}
+	}
+	if !vc.settling[1-req.Idx] {
+		return nil, nil
+	}
+	return vc, nil
+}
+
+// addFunding binds half idx of the virtual channel to the ledger channel
+// parent with the sender of the funding request, whose participants in the
+// virtual channel have the indices idxs in the ledger channel. The halves must
+// be funded from different ledger channels by different peers. The virtual
+// channel must be locked by the caller.
+func (vc *virtualChannel) addFunding(
+	idx channel.Index,
+	parent *Channel,
+	peer wallet.Address,
+	idxs []channel.Index,
+	initial *channel.State,
+) error {
+	if vc.parents[idx] != nil {
+		return errors.New("duplicate funding request")
+	} else if vc.parents[1-idx] == parent {
+		return errors.New("both participants fund from the same ledger channel")
+	} else if other := vc.peers[1-idx]; other != nil && other.Equals(peer) {
+		return errors.New("both participants are the same peer")
+	} else if eq, err := equalEncoding(vc.tx.State, initial); err != nil || !eq {
+		return errors.New("initial state differs from the other participant's")
+	}
+	vc.parents[idx], vc.peers[idx], vc.idxs[idx] = parent, peer, idxs
+	return nil
+}
+
+// addSettlement sets the final transaction of the settlement request for half
+// idx of the virtual channel. The request must be sent by the same peer that
+// requested the funding of the half. A repeated request with the same final
+// state is accepted. The virtual channel must be locked by the caller.
+func (vc *virtualChannel) addSettlement(idx channel.Index, peer wallet.Address, final channel.Transaction) error {
+	if vc.parents[0] == nil || vc.parents[1] == nil {
+		return errors.New("virtual channel not funded")
+	} else if !vc.peers[idx].Equals(peer) {
+		return errors.New("sender did not request the funding for this index")
+	} else if err := verifyVirtualTX(vc.params, final); err != nil {
+		return err
+	} else if !final.State.IsFinal {
+		return errors.New("state is not final")
+	}
+
+	if vc.tx.State.IsFinal {
+		if eq, err := equalEncoding(vc.tx.State, final.State); err != nil || !eq {
+			return errors.New("final state differs from the other participant's")
+		}
+	}
+	vc.tx, vc.settling[idx] = final, true
+	return nil
+}
+
+// virtualDisputed is called after the ledger channel with participant i of the
+// virtual channel was settled by dispute together with the state of the
+// virtual channel. As we paid out the balance of the other participant in that
+// ledger channel, the ledger channel with the other participant is settled by
+// dispute, too, unless it is already being settled or does not lock the
+// virtual channel anymore. Once both are settled, the virtual channel is
+// removed.
+func (c *Client) virtualDisputed(vc *virtualChannel, i int) {
+	vc.mtx.Lock()
+	vc.disputed[i] = true
+	other, done := vc.parents[1-i], vc.disputed[1-i]
+	vc.mtx.Unlock()
+
+	ctx := context.Background()
+	if !done && other != nil && other.Phase() < channel.Registering &&
+		lockedIdx(other.State().Locked, vc.params.ID()) >= 0 {
+		// Settle waits out the challenge duration, so no timeout is set.
+		if err := other.Settle(ctx); err != nil {
+			err = errors.WithMessage(err, "settling ledger channel of disputed virtual channel")
+			other.log.Error(err)
+			other.emit(Event{Type: ChannelError, Err: err})
+		}
+		return
+	}
+	c.removeVirtual(ctx, vc)
+}
+
+// removeVirtual removes the virtual channel after it was settled into both
+// ledger channels.
+func (c *Client) removeVirtual(ctx context.Context, vc *virtualChannel) {
+	id := vc.params.ID()
+	c.virtualMtx.Lock()
+	defer c.virtualMtx.Unlock()
+
+	if _, ok := c.virtuals[id]; !ok {
+		return
+	}
+	delete(c.virtuals, id)
+	if err := c.pr.VirtualRemoved(ctx, id); err != nil {
+		c.logChan(id).Errorf("removing virtual channel from persistence: %v", err)
+	}
+}
+
+// restoreVirtuals restores the persisted virtual channels of which we are the
+// intermediary and links them to their restored ledger channels. If one
+// ledger channel was settled by dispute while we were offline, the other one
+// is settled by dispute, too. Virtual channels that are not locked by any
+// ledger channel anymore are removed.
+func (c *Client) restoreVirtuals(ctx context.Context, rs persistence.Restorer) error {
+	records, err := rs.RestoreVirtuals(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "reading virtual channels")
+	}
+	var firstErr error
+	for _, r := range records {
+		if err := c.restoreVirtual(ctx, r); err != nil && firstErr == nil {
+			firstErr = errors.WithMessagef(err, "restoring virtual channel %x", r.ID())
+		}
+	}
+	return firstErr
+}
+
+// restoreVirtual restores a single persisted virtual channel.
+func (c *Client) restoreVirtual(ctx context.Context, r *persistence.VirtualChannel) error {
+	p := r.Params
+	params := c.backends.NewParamsUnsafe(p.ChallengeDuration, p.Parts, p.App.Def(), p.Nonce)
+	if params.ID() != r.ID() {
+		return errors.New("channel ID does not match the backends of the client")
+	}
+	vc := &virtualChannel{params: params, tx: r.TX, peers: r.Peers, idxs: r.Idxs, settling: r.Settling}
+
+	funded := r.Peers[0] != nil && r.Peers[1] != nil
+	var locked, removed []int
+	for i, peer := range r.Peers {
+		if peer == nil {
+			continue
+		}
+		parent, err := c.Channel(r.Parents[i])
+		if err != nil {
+			// Settled ledger channels are removed from the persistence.
+			removed = append(removed, i)
+			continue
+		}
+		vc.parents[i] = parent
+		if funded && lockedIdx(parent.State().Locked, params.ID()) >= 0 {
+			locked = append(locked, i)
+		}
+	}
+
+	if (funded && len(locked) == 0) || (!funded && len(removed) > 0) {
+		return errors.WithMessage(c.pr.VirtualRemoved(ctx, params.ID()), "removing settled virtual channel")
+	}
+
+	c.virtualMtx.Lock()
+	c.virtuals[params.ID()] = vc
+	c.virtualMtx.Unlock()
+	for _, i := range locked {
+		parent := vc.parents[i]
+		parent.registerSub(c.newVirtualSub(vc, i))
+		parent.machMtx.Lock()
+		parent.notifySubs()
+		parent.machMtx.Unlock()
+	}
+	for _, i := range removed {
+		go c.virtualDisputed(vc, i)
+	}
+	return nil
+}
+
+// verifyVirtualTX checks that the transaction is a state of the virtual channel
+// with the given parameters that is signed by all participants.
+func verifyVirtualTX(params *channel.Params, tx channel.Transaction) error {
+	if tx.State == nil {
+		return errors.New("missing state")
+	} else if tx.State.ID != params.ID() {
+		return errors.New("state does not belong to the channel")
+	} else if len(tx.Sigs) != len(params.Parts) {
+		return errors.New("wrong number of signatures")
+	}
+	for i, sig := range tx.Sigs {
+		if ok, err := params.Backends().Verify(params.Parts[i], params, tx.State, sig); err != nil {
+			return errors.WithMessagef(err, "verifying signature %d", i)
+		} else if !ok {
+			return errors.Errorf("invalid signature %d", i)
+		}
+	}
+	return nil
+}
+
+// validVirtualChannelProposal checks that a proposed virtual channel has two
+// participants and that we have a ledger channel with the intermediary from
+// which we can afford our initial balance.
+func (c *Client) validVirtualChannelProposal(req *ChannelProposalReq) error {
+	if len(req.PeerAddrs) != 2 {
+		return errors.New("virtual channels must have two participants")
+	} else if wallet.IndexOfAddr(req.PeerAddrs, req.Intermediary) >= 0 {
+		return errors.New("intermediary must not be a participant")
+	}
+
+	parent, idxs, err := c.virtualParent(req.Intermediary, req.PeerAddrs)
+	if err != nil {
+		return err
+	}
+	ourIdx := wallet.IndexOfAddr(req.PeerAddrs, c.id.Address())
+	ours := &channel.Allocation{Assets: req.InitBals.Assets, OfParts: req.InitBals.OfParts[ourIdx : ourIdx+1]}
+	return parent.validSubAlloc(idxs[ourIdx:ourIdx+1], ours)
+}
+
+// virtualParent returns our ledger channel with the intermediary, which is the
+// parent of a virtual channel with the given participants, and the indices of
+// the virtual channel participants in the ledger channel. The other
+// participant is represented by the intermediary.
+func (c *Client) virtualParent(intermediary wallet.Address, addrs []wallet.Address) (*Channel, []channel.Index, error) {
+	ourIdx := wallet.IndexOfAddr(addrs, c.id.Address())
+	if len(addrs) != 2 || ourIdx < 0 {
+		return nil, nil, errors.New("virtual channels must have two participants")
+	}
+	parent, err := c.ledgerWith(intermediary)
+	if err != nil {
+		return nil, nil, err
+	}
+	peerIdx, _ := parent.conn.peerIndex(intermediary)
+	idxs := make([]channel.Index, 2)
+	idxs[ourIdx], idxs[1-ourIdx] = parent.Idx(), peerIdx
+	return parent, idxs, nil
+}
+
+// ledgerWith returns a two-party ledger channel with the peer with the given
+// Perun address.
+func (c *Client) ledgerWith(addr wallet.Address) (*Channel, error) {
+	for _, ch := range c.channels.Values() {
+		if ch.isLedgerWith(addr) {
+			return ch, nil
+		}
+	}
+	return nil, errors.New("no ledger channel with the intermediary")
+}
+
+// isLedgerWith returns whether the channel is an active two-party ledger
+// channel with a StateApp, where the other participant is the peer with the
+// given Perun address.
+func (c *Channel) isLedgerWith(addr wallet.Address) bool {
+	if c.parent != nil || c.stateMachine == nil || c.machine.N() != 2 {
+		return false
+	} else if phase := c.Phase(); phase != channel.Acting && phase != channel.Signing {
+		return false
+	}
+	_, ok := c.conn.peerIndex(addr)
+	return ok
+}
+
+// requestVirtualFunding sends the fully signed initial state of the virtual
+// channel to the intermediary, who then proposes the funding update of the
+// parent channel.
+func (c *Channel) requestVirtualFunding(ctx context.Context, sub *subChannel) error {
+	params := c.Params()
+	req := &msgVirtualChannelFunding{
+		Parent:            c.parent.ID(),
+		ChallengeDuration: params.ChallengeDuration,
+		Nonce:             params.Nonce,
+		Parts:             params.Parts,
+		AppDef:            params.App.Def(),
+		Initial:           c.currentTX(),
+		Idx:               c.Idx(),
+	}
+	return errors.WithMessage(c.parent.conn.SendTo(ctx, sub.idxs[1-c.Idx()], req),
+		"sending funding request to intermediary")
+}
+
+// requestVirtualSettlement sends the fully signed final state of the virtual
+// channel to the intermediary, who then proposes the settlement update of the
+// parent channel.
+func (c *Channel) requestVirtualSettlement(ctx context.Context, sub *subChannel) error {
+	req := &msgVirtualChannelSettlement{
+		Final: c.currentTX(),
+		Idx:   c.Idx(),
+	}
+	return errors.WithMessage(c.parent.conn.SendTo(ctx, sub.idxs[1-c.Idx()], req),
+		"sending settlement request to intermediary")
+}
+
+// currentTX returns the current transaction of the channel.
+func (c *Channel) currentTX() channel.Transaction {
+	c.machMtx.RLock()
+	defer c.machMtx.RUnlock()
+
+	return c.machine.CurrentTX()
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/persistence"
+	"perun.network/go-perun/channel/persistence/keyvalue"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/db/memorydb"
+	"perun.network/go-perun/log"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestVirtualChannel_addFunding(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xF00D))
+	alloc := channeltest.NewRandomAllocation(rng, 2)
+	alloc.Locked = nil
+	vc, accs := newTestVirtualChannel(t, rng, alloc)
+	parents := []*Channel{new(Channel), new(Channel)}
+	peers := []wallet.Address{wallettest.NewRandomAddress(rng), wallettest.NewRandomAddress(rng)}
+	initial := vc.tx.State
+
+	require.NoError(t, vc.addFunding(0, parents[0], peers[0], []channel.Index{1, 0}, initial))
+	assert.Error(t, vc.addFunding(0, parents[1], peers[1], []channel.Index{0, 1}, initial),
+		"duplicate half")
+	assert.Error(t, vc.addFunding(1, parents[0], peers[1], []channel.Index{0, 1}, initial),
+		"same ledger channel")
+	assert.Error(t, vc.addFunding(1, parents[1], peers[0], []channel.Index{0, 1}, initial),
+		"same peer")
+	other := initial.Clone()
+	other.Version++
+	assert.Error(t, vc.addFunding(1, parents[1], peers[1], []channel.Index{0, 1}, other),
+		"different initial state")
+	require.NoError(t, vc.addFunding(1, parents[1], peers[1], []channel.Index{0, 1}, initial))
+	assert.Equal(t, [2]*Channel{parents[0], parents[1]}, vc.parents)
+
+	final := signTestState(t, vc.params, accs, func(s *channel.State) {
+		s.Version++
+		s.IsFinal = true
+	}, initial)
+	assert.Error(t, vc.addSettlement(0, peers[1], final), "sender of the other half")
+	nonFinal := signTestState(t, vc.params, accs, func(s *channel.State) { s.Version++ }, initial)
+	assert.Error(t, vc.addSettlement(0, peers[0], nonFinal), "non-final state")
+	require.NoError(t, vc.addSettlement(0, peers[0], final))
+	require.NoError(t, vc.addSettlement(0, peers[0], final), "repeated request")
+	otherFinal := signTestState(t, vc.params, accs, func(s *channel.State) {
+		s.Version += 2
+		s.IsFinal = true
+	}, initial)
+	assert.Error(t, vc.addSettlement(1, peers[1], otherFinal), "different final state")
+	require.NoError(t, vc.addSettlement(1, peers[1], final))
+	assert.Equal(t, [2]bool{true, true}, vc.settling)
+	assert.Equal(t, final, vc.tx)
+}
+
+func TestClient_restoreVirtual(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x5E570))
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// setup returns a client with the virtual channel persisted and the
+	// ledger channels with the given indices registered, which lock the
+	// virtual channel.
+	setup := func(registered ...int) (*Client, *virtualChannel, []*Channel, *mockAdjudicator) {
+		// The participants and the intermediary have the same balances.
+		alloc := channeltest.NewRandomAllocation(rng, 2)
+		alloc.Locked = nil
+		alloc.OfParts[1] = alloc.Clone().OfParts[0]
+		vc, _ := newTestVirtualChannel(t, rng, alloc)
+		adj := newMockAdjudicator()
+		c := &Client{
+			channels: makeChanRegistry(),
+			virtuals: make(map[channel.ID]*virtualChannel),
+			pr:       keyvalue.NewPersister(memorydb.NewDatabase()),
+			log:      log.Get(),
+		}
+
+		ledgers := make([]*Channel, 2)
+		for i := range ledgers {
+			bals := alloc.Clone()
+			ch, peerAcc := newFundingTestChannelWithAlloc(t, rng, adj, &bals)
+			require.NoError(t, ch.machine.SetFunded(ctx))
+			idxs := []channel.Index{0, 1}
+			idxs[i], idxs[1-i] = 1, 0
+			vc.parents[i], vc.peers[i], vc.idxs[i] = ch, wallettest.NewRandomAddress(rng), idxs
+			lockTestChannel(t, ch, peerAcc, c.newVirtualSub(vc, i))
+			ledgers[i] = ch
+		}
+		require.NoError(t, c.pr.VirtualChanged(ctx, vc.record()))
+		for _, i := range registered {
+			c.channels.Put(ledgers[i].ID(), ledgers[i])
+		}
+		return c, vc, ledgers, adj
+	}
+	restoredVirtuals := func(c *Client) []*persistence.VirtualChannel {
+		vcs, err := c.pr.(persistence.Restorer).RestoreVirtuals(ctx)
+		require.NoError(t, err)
+		return vcs
+	}
+
+	t.Run("linked", func(t *testing.T) {
+		c, vc, ledgers, _ := setup(0, 1)
+		require.NoError(t, c.restoreVirtuals(ctx, c.pr.(persistence.Restorer)))
+		require.Contains(t, c.virtuals, vc.params.ID())
+		restored := c.virtuals[vc.params.ID()]
+		assert.Equal(t, vc.idxs, restored.idxs)
+		for i, ledger := range ledgers {
+			assert.Same(t, ledger, restored.parents[i])
+			sub, err := ledger.sub(vc.params.ID())
+			require.NoError(t, err)
+			eq, err := equalEncoding(vc.tx, sub.tx())
+			require.NoError(t, err)
+			assert.True(t, eq, "restored transaction")
+			select {
+			case <-sub.funded:
+			default:
+				t.Error("restored virtual channel must be funded")
+			}
+		}
+		assert.Len(t, restoredVirtuals(c), 1)
+	})
+
+	t.Run("disputed", func(t *testing.T) {
+		// The ledger channel with the second participant was settled by
+		// dispute, so the first one is settled by dispute, too.
+		c, vc, ledgers, adj := setup(0)
+		require.NoError(t, c.restoreVirtuals(ctx, c.pr.(persistence.Restorer)))
+		req := <-adj.registered
+		assert.Equal(t, ledgers[0].ID(), req.Params.ID())
+		require.Len(t, req.SubChannels, 1)
+		assert.Equal(t, vc.params.ID(), req.SubChannels[0].Params.ID())
+		assert.Equal(t, ledgers[0].ID(), req.SubChannels[0].Parent)
+		assert.Equal(t, vc.idxs[0], req.SubChannels[0].Idxs)
+		<-adj.withdrawn
+		assert.Eventually(t, func() bool { return len(restoredVirtuals(c)) == 0 }, timeout, timeout/100)
+		assert.Equal(t, channel.Settled, ledgers[0].Phase())
+	})
+
+	t.Run("settled", func(t *testing.T) {
+		c, vc, _, _ := setup()
+		require.NoError(t, c.restoreVirtuals(ctx, c.pr.(persistence.Restorer)))
+		assert.NotContains(t, c.virtuals, vc.params.ID())
+		assert.Len(t, restoredVirtuals(c), 0)
+	})
+}
+
+// newTestVirtualChannel creates the record of a virtual channel whose
+// fully signed initial state has the given allocation with halved balances.
+// The accounts of its participants are also returned.
+func newTestVirtualChannel(t *testing.T, rng *rand.Rand, alloc *channel.Allocation) (*virtualChannel, []wallet.Account) {
+	accs := []wallet.Account{wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)}
+	parts := []wallet.Address{accs[0].Address(), accs[1].Address()}
+	params, err := channel.NewParams(60, parts, payment.AppDef(), big.NewInt(rng.Int63()))
+	require.NoError(t, err)
+
+	initial := &channel.State{
+		ID:         params.ID(),
+		App:        params.App,
+		Allocation: alloc.Clone(),
+		Data:       new(payment.NoData),
+	}
+	for _, bals := range initial.OfParts {
+		for _, bal := range bals {
+			bal.Div(bal, big.NewInt(2))
+		}
+	}
+	tx := signTestState(t, params, accs, func(*channel.State) {}, initial)
+	return &virtualChannel{params: params, tx: tx}, accs
+}
+
+// signTestState applies update to a clone of state and signs the result by
+// all accounts.
+func signTestState(
+	t *testing.T,
+	params *channel.Params,
+	accs []wallet.Account,
+	update func(*channel.State),
+	state *channel.State,
+) channel.Transaction {
+	state = state.Clone()
+	update(state)
+	tx := channel.Transaction{State: state, Sigs: make([]wallet.Sig, len(accs))}
+	for i, acc := range accs {
+		var err error
+		tx.Sigs[i], err = channel.Sign(acc, params, state)
+		require.NoError(t, err)
+	}
+	return tx
+}
+
+// lockTestChannel enables the funding update of the sub-channel in the test
+// channel.
+func lockTestChannel(t *testing.T, ch *Channel, peerAcc wallet.Account, sub *subChannel) {
+	ctx := context.Background()
+	state, err := ch.subFundingState(sub)
+	require.NoError(t, err)
+	require.NoError(t, ch.stateMachine.Update(ctx, state, 0))
+	signTestChannel(t, ch, peerAcc)
+	require.NoError(t, ch.machine.EnableUpdate(ctx))
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client_test
+
+import (
+	"context"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"perun.network/go-perun/apps/payment"
+	"perun.network/go-perun/channel"
+	channeltest "perun.network/go-perun/channel/test"
+	"perun.network/go-perun/client"
+	"perun.network/go-perun/peer"
+	"perun.network/go-perun/wallet"
+	wallettest "perun.network/go-perun/wallet/test"
+)
+
+func TestVirtualChannel(t *testing.T) {
+	const A, B, I = 0, 1, 2 // participants and intermediary
+	rng := rand.New(rand.NewSource(0x7124))
+	ctx, cancel := context.WithTimeout(context.Background(), 8*defaultTimeout)
+	defer cancel()
+
+	chs, errs := make([]chan *client.Channel, 3), make(chan error, 3)
+	clients, addrs, closeAll := setupMultiPartyClients(t, rng, 3, func(i int) client.ProposalHandler {
+		chs[i] = make(chan *client.Channel, 1)
+		return &acceptAllHandler{rng: rand.New(rand.NewSource(int64(i))), chs: chs[i], errs: errs}
+	})
+	defer closeAll()
+	clients[A].SetFinalizeTimeout(defaultTimeout / 10)
+	acceptedChannel := func(i int) *client.Channel {
+		select {
+		case ch := <-chs[i]:
+			return ch
+		case err := <-errs:
+			t.Fatalf("peer failed to accept: %v", err)
+		}
+		return nil
+	}
+
+	// Both ledger channels have the same allocation, in which the participant
+	// and the intermediary have the same balances.
+	ledgerBals := channeltest.NewRandomAllocation(rng, 2)
+	ledgerBals.Locked = nil
+	ledgerBals.OfParts[1] = ledgerBals.Clone().OfParts[0]
+	ledgers, iLedgers := make([]*client.Channel, 2), make([]*client.Channel, 2)
+	for _, p := range []int{A, B} {
+		bals := ledgerBals.Clone()
+		ledger, err := clients[p].ProposeChannel(ctx, &client.ChannelProposal{
+			ChallengeDuration: 10,
+			Nonce:             big.NewInt(rng.Int63()),
+			Account:           wallettest.NewRandomAccount(rng),
+			AppDef:            payment.AppDef(),
+			InitData:          new(payment.NoData),
+			InitBals:          &bals,
+			PeerAddrs:         []wallet.Address{addrs[p], addrs[I]},
+		})
+		require.NoError(t, err)
+		iLedgers[p] = acceptedChannel(I)
+		go ledger.ListenUpdates(&updateHandler{errs: make(chan error, 1)})
+		ledgers[p] = ledger
+	}
+
+	// The virtual channel is funded with half of the participants' balances.
+	virtualBals := ledgerBals.Clone()
+	for _, bal := range virtualBals.OfParts[0] {
+		bal.Div(bal, big.NewInt(2))
+	}
+	virtualBals.OfParts[1] = virtualBals.Clone().OfParts[0]
+	openVirtual := func() (*client.Channel, *client.Channel) {
+		bals := virtualBals.Clone()
+		ch, err := clients[A].ProposeChannel(ctx, &client.ChannelProposal{
+			ChallengeDuration: 10,
+			Nonce:             big.NewInt(rng.Int63()),
+			Account:           wallettest.NewRandomAccount(rng),
+			AppDef:            payment.AppDef(),
+			InitData:          new(payment.NoData),
+			InitBals:          &bals,
+			PeerAddrs:         []peer.Address{addrs[A], addrs[B]},
+			Intermediary:      addrs[I],
+		})
+		require.NoError(t, err)
+		peerCh := acceptedChannel(B)
+		assert.Equal(t, ch.ID(), peerCh.ID())
+		assert.Equal(t, channel.Acting, peerCh.Phase())
+		return ch, peerCh
+	}
+	settle := func(chs ...*client.Channel) {
+		settleErrs := make(chan error, len(chs))
+		for _, ch := range chs {
+			go func(ch *client.Channel) { settleErrs <- ch.Settle(ctx) }(ch)
+		}
+		for range chs {
+			require.NoError(t, <-settleErrs)
+		}
+		for _, ch := range chs {
+			assert.Equal(t, channel.Settled, ch.Phase())
+		}
+	}
+
+	t.Run("settle", func(t *testing.T) {
+		ch, peerCh := openVirtual()
+		funded := ledgerBals.Clone()
+		for i, bals := range funded.OfParts {
+			for a, bal := range bals {
+				bal.Sub(bal, virtualBals.OfParts[0][a])
+			}
+			funded.OfParts[i] = bals
+		}
+		for _, ledger := range ledgers {
+			require.Len(t, ledger.State().Locked, 1)
+			assert.Equal(t, ch.ID(), ledger.State().Locked[0].ID)
+			assertEqualBals(t, funded.OfParts, ledger.State().OfParts)
+		}
+
+		// A pays its balance of the first asset to B in the final state.
+		updateErrs := make(chan error, 1)
+		go peerCh.ListenUpdates(&updateHandler{errs: updateErrs})
+		final := ch.State().Clone()
+		final.Version++
+		final.IsFinal = true
+		amount := new(big.Int).Set(final.OfParts[0][0])
+		final.OfParts[0][0].SetUint64(0)
+		final.OfParts[1][0].Add(final.OfParts[1][0], amount)
+		require.NoError(t, ch.Update(ctx, client.ChannelUpdate{State: final, ActorIdx: ch.Idx()}))
+		require.NoError(t, <-updateErrs)
+
+		settle(ch, peerCh)
+
+		// A's ledger channel loses the amount to the intermediary, while B's
+		// ledger channel receives it from the intermediary.
+		settledA := ledgerBals.Clone()
+		settledA.OfParts[0][0].Sub(settledA.OfParts[0][0], amount)
+		settledA.OfParts[1][0].Add(settledA.OfParts[1][0], amount)
+		settledB := ledgerBals.Clone()
+		settledB.OfParts[0][0].Add(settledB.OfParts[0][0], amount)
+		settledB.OfParts[1][0].Sub(settledB.OfParts[1][0], amount)
+		for p, settled := range []channel.Allocation{settledA, settledB} {
+			assert.Len(t, ledgers[p].State().Locked, 0)
+			assertEqualBals(t, settled.OfParts, ledgers[p].State().OfParts)
+		}
+	})
+
+	t.Run("dispute", func(t *testing.T) {
+		// B doesn't handle updates of the virtual channel, so A's Finalize
+		// falls back to disputing A's ledger channel, which the intermediary
+		// settles, too. The intermediary then settles its ledger channel with
+		// B by dispute.
+		ch, peerCh := openVirtual()
+		require.NoError(t, ch.Finalize(ctx))
+		assert.False(t, ch.State().IsFinal)
+		assert.Equal(t, channel.Settled, ch.Phase())
+		assert.Equal(t, channel.Settled, ledgers[A].Phase())
+
+		require.NoError(t, iLedgers[A].Settle(ctx))
+		assert.Eventually(t, func() bool { return iLedgers[B].Phase() == channel.Settled },
+			defaultTimeout, defaultTimeout/100)
+
+		require.NoError(t, peerCh.Settle(ctx))
+		assert.Equal(t, channel.Settled, peerCh.Phase())
+		assert.Equal(t, channel.Settled, ledgers[B].Phase())
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"io"
+	"math/big"
+
+	"github.com/pkg/errors"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
+	"perun.network/go-perun/wire/msg"
+)
+
+func init() {
+	msg.RegisterDecoder(msg.VirtualChannelFunding,
+		func(r io.Reader) (msg.Msg, error) {
+			var m msgVirtualChannelFunding
+			return &m, m.Decode(r)
+		})
+	msg.RegisterDecoder(msg.VirtualChannelSettlement,
+		func(r io.Reader) (msg.Msg, error) {
+			var m msgVirtualChannelSettlement
+			return &m, m.Decode(r)
+		})
+}
+
+// msgVirtualChannelFunding is sent by a participant of a virtual channel to the
+// intermediary to request the funding of the virtual channel from their ledger
+// channel. It contains the channel parameters and the fully signed initial
+// state, so that the intermediary can verify the virtual channel.
+type msgVirtualChannelFunding struct {
+	// Parent is the ID of the ledger channel of the sender with the
+	// intermediary.
+	Parent            channel.ID
+	ChallengeDuration uint64
+	Nonce             *big.Int
+	Parts             []wallet.Address
+	AppDef            wallet.Address
+	// Initial is the fully signed initial state of the virtual channel.
+	Initial channel.Transaction
+	// Idx is the index of the sender in the virtual channel.
+	Idx channel.Index
+}
+
+// Type returns this message's type: VirtualChannelFunding
+func (*msgVirtualChannelFunding) Type() msg.Type {
+	return msg.VirtualChannelFunding
+}
+
+func (m msgVirtualChannelFunding) Encode(w io.Writer) error {
+	if len(m.Parts) > channel.MaxNumParts {
+		return errors.Errorf("too many participants: %d", len(m.Parts))
+	}
+	if err := wire.Encode(w, m.Parent, m.ChallengeDuration, m.Nonce, channel.Index(len(m.Parts))); err != nil {
+		return err
+	}
+	for i, part := range m.Parts {
+		if err := part.Encode(w); err != nil {
+			return errors.WithMessagef(err, "encoding participant %d", i)
+		}
+	}
+	if err := m.AppDef.Encode(w); err != nil {
+		return errors.WithMessage(err, "encoding app definition")
+	}
+	return wire.Encode(w, m.Initial, m.Idx)
+}
+
+func (m *msgVirtualChannelFunding) Decode(r io.Reader) (err error) {
+	var numParts channel.Index
+	if err := wire.Decode(r, &m.Parent, &m.ChallengeDuration, &m.Nonce, &numParts); err != nil {
+		return err
+	}
+	if numParts > channel.MaxNumParts {
+		return errors.Errorf("too many participants: %d", numParts)
+	}
+	m.Parts = make([]wallet.Address, numParts)
+	for i := range m.Parts {
+		if m.Parts[i], err = channel.DecodingBackends(r).DecodeAddress(r); err != nil {
+			return errors.WithMessagef(err, "decoding participant %d", i)
+		}
+	}
+	if m.AppDef, err = channel.DecodingBackends(r).DecodeAddress(r); err != nil {
+		return errors.WithMessage(err, "decoding app definition")
+	}
+	return wire.Decode(r, &m.Initial, &m.Idx)
+}
+
+// msgVirtualChannelSettlement is sent by a participant of a virtual channel to
+// the intermediary to request the settlement of the final state of the
+// virtual channel into their ledger channel.
+type msgVirtualChannelSettlement struct {
+	// Final is the fully signed final state of the virtual channel.
+	Final channel.Transaction
+	// Idx is the index of the sender in the virtual channel.
+	Idx channel.Index
+}
+
+// Type returns this message's type: VirtualChannelSettlement
+func (*msgVirtualChannelSettlement) Type() msg.Type {
+	return msg.VirtualChannelSettlement
+}
+
+func (m msgVirtualChannelSettlement) Encode(w io.Writer) error {
+	return wire.Encode(w, m.Final, m.Idx)
+}
+
+func (m *msgVirtualChannelSettlement) Decode(r io.Reader) error {
+	return wire.Decode(r, &m.Final, &m.Idx)
+}
@@ -0,0 +1,42 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package client
+
+import (
+	"math/rand"
+	"testing"
+
+	"perun.network/go-perun/channel"
+	"perun.network/go-perun/channel/test"
+	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire/msg"
+)
+
+func TestVirtualChannelMsgsSerialization(t *testing.T) {
+	rng := rand.New(rand.NewSource(0x7124))
+	for i := 0; i < 4; i++ {
+		params := test.NewRandomParams(rng, test.NewRandomApp(rng).Def())
+		randomTX := func() channel.Transaction {
+			return channel.Transaction{
+				State: test.NewRandomState(rng, params),
+				Sigs:  []wallet.Sig{newRandomSig(rng), newRandomSig(rng)},
+			}
+		}
+		msg.TestMsg(t, &msgVirtualChannelFunding{
+			Parent:            test.NewRandomChannelID(rng),
+			ChallengeDuration: params.ChallengeDuration,
+			Nonce:             params.Nonce,
+			Parts:             params.Parts,
+			AppDef:            params.App.Def(),
+			Initial:           randomTX(),
+			Idx:               channel.Index(i % 2),
+		})
+		msg.TestMsg(t, &msgVirtualChannelSettlement{
+			Final: randomTX(),
+			Idx:   channel.Index(i % 2),
+		})
+	}
+}
@@ -26,3 +26,13 @@ type Conn interface {
 	// Repeated calls to Close() result in an error.
 	Close() error
 }
+
+// A ContextConn is a Conn whose received messages are decoded within a
+// decoding context, see wire.DecodingContext. The Registry sets its decoding
+// context on all ContextConns before receiving the first message.
+type ContextConn interface {
+	Conn
+	// SetDecodingContext sets the decoding context of received messages. It
+	// must be called before the first call to Recv.
+	SetDecodingContext(ctx interface{})
+}
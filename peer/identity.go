@@ -13,6 +13,7 @@ import (
 
 	"perun.network/go-perun/pkg/test"
 	"perun.network/go-perun/wallet"
+	"perun.network/go-perun/wire"
 	"perun.network/go-perun/wire/msg"
 )
 
@@ -80,12 +81,23 @@ func (m *AuthResponseMsg) Encode(w io.Writer) error {
 	return m.Address.Encode(w)
 }
 
-// Decode decodes an AuthResponseMsg from an io.Reader.
+// Decode decodes an AuthResponseMsg from an io.Reader. If the decoding context
+// of the reader can decode addresses, e.g., the backends of a client, it is
+// used to decode the address. Otherwise, the global wallet backend is used.
 func (m *AuthResponseMsg) Decode(r io.Reader) (err error) {
-	m.Address, err = wallet.DecodeAddress(r)
+	if dec, ok := wire.DecodingContext(r).(addressDecoder); ok {
+		m.Address, err = dec.DecodeAddress(r)
+	} else {
+		m.Address, err = wallet.DecodeAddress(r)
+	}
 	return
 }
 
+// An addressDecoder decodes addresses, like the channel.Backends of a client.
+type addressDecoder interface {
+	DecodeAddress(io.Reader) (wallet.Address, error)
+}
+
 // NewAuthResponseMsg creates an authentication response message.
 // In the future, it will also take an authentication challenge message as
 // additional argument.
@@ -7,13 +7,16 @@ package peer
 
 import (
 	"context"
+	"io"
 	"math/rand"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"perun.network/go-perun/pkg/test"
+	"perun.network/go-perun/wallet"
 	wallettest "perun.network/go-perun/wallet/test"
 	"perun.network/go-perun/wire/msg"
 )
@@ -56,6 +59,30 @@ func TestExchangeAddrs_Success(t *testing.T) {
 	wg.Wait()
 }
 
+// countingDecoder is a decoding context that counts the decoded addresses.
+type countingDecoder struct{ n int }
+
+func (d *countingDecoder) DecodeAddress(r io.Reader) (wallet.Address, error) {
+	d.n++
+	return wallet.DecodeAddress(r)
+}
+
+func TestExchangeAddrs_DecodingContext(t *testing.T) {
+	rng := rand.New(rand.NewSource(0xc0de))
+	conn0, conn1 := newPipeConnPair()
+	defer conn0.Close()
+	defer conn1.Close()
+	dec := new(countingDecoder)
+	conn0.(ContextConn).SetDecodingContext(dec)
+	account0, account1 := wallettest.NewRandomAccount(rng), wallettest.NewRandomAccount(rng)
+
+	go ExchangeAddrs(context.Background(), account1, conn1)
+	addr, err := ExchangeAddrs(context.Background(), account0, conn0)
+	require.NoError(t, err)
+	assert.True(t, addr.Equals(account1.Address()))
+	assert.Equal(t, 1, dec.n)
+}
+
 func TestExchangeAddrs_Timeout(t *testing.T) {
 	rng := rand.New(rand.NewSource(0xDDDDDeDe))
 	a, _ := newPipeConnPair()
@@ -8,23 +8,31 @@ package peer
 import (
 	"io"
 
+	perunwire "perun.network/go-perun/wire"
 	wire "perun.network/go-perun/wire/msg"
 )
 
-var _ Conn = (*ioConn)(nil)
+var _ ContextConn = (*ioConn)(nil)
 
 // IoConn is a connection that communicates its messages over an io stream.
 type ioConn struct {
-	conn io.ReadWriteCloser
+	conn   io.ReadWriteCloser
+	reader io.Reader // reads from conn, carrying the decoding context
 }
 
-// NewIoConn creates a peer message connection from an io stream.
+// NewIoConn creates a peer message connection from an io stream. The returned
+// connection is a ContextConn.
 func NewIoConn(conn io.ReadWriteCloser) Conn {
 	return &ioConn{
-		conn: conn,
+		conn:   conn,
+		reader: conn,
 	}
 }
 
+func (c *ioConn) SetDecodingContext(ctx interface{}) {
+	c.reader = perunwire.WithDecodingContext(c.conn, ctx)
+}
+
 func (c *ioConn) Send(m wire.Msg) error {
 	if err := wire.Encode(m, c.conn); err != nil {
 		c.conn.Close()
@@ -34,7 +42,7 @@ func (c *ioConn) Send(m wire.Msg) error {
 }
 
 func (c *ioConn) Recv() (wire.Msg, error) {
-	m, err := wire.Decode(c.conn)
+	m, err := wire.Decode(c.reader)
 	if err != nil {
 		c.conn.Close()
 		return nil, err
@@ -28,6 +28,8 @@ type Registry struct {
 	dialer    Dialer      // Used for dialing peers (and later: repairing).
 	subscribe func(*Peer) // Sets up peer subscriptions.
 
+	decodingCtx interface{} // Decoding context of the received messages.
+
 	log log.Logger
 	perunsync.Closer
 }
@@ -54,6 +56,26 @@ func (r *Registry) SetExchangeAddrsTimeout(d time.Duration) {
 	atomic.StoreInt64(&r.exchangeAddrsTimeout, int64(d))
 }
 
+// SetDecodingContext sets the decoding context of the messages that are
+// received from peers, see wire.DecodingContext. It is set on all connections
+// that are ContextConns before they are authenticated. It only affects peers
+// that connect after the call.
+func (r *Registry) SetDecodingContext(ctx interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.decodingCtx = ctx
+}
+
+// setDecodingContext sets the decoding context of the registry on conn if it
+// is a ContextConn.
+func (r *Registry) setDecodingContext(conn Conn) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if cc, ok := conn.(ContextConn); ok && r.decodingCtx != nil {
+		cc.SetDecodingContext(r.decodingCtx)
+	}
+}
+
 // Close closes the registry's dialer and all its peers.
 func (r *Registry) Close() (err error) {
 	if err = r.Closer.Close(); err != nil {
@@ -114,6 +136,7 @@ func (r *Registry) setupConn(conn Conn) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
+	r.setDecodingContext(conn)
 	var peerAddr Address
 	var err error
 	if peerAddr, err = ExchangeAddrs(ctx, r.id, conn); err != nil {
@@ -209,6 +232,7 @@ func (r *Registry) authenticatedDial(ctx context.Context, peer *Peer, addr Addre
 		return errors.WithMessage(err, "failed to dial")
 	}
 
+	r.setDecodingContext(conn)
 	a, err := ExchangeAddrs(ctx, r.id, conn)
 	if err != nil || !a.Equals(addr) {
 		conn.Close()
@@ -7,7 +7,9 @@ package peer_test
 
 import (
 	"context"
+	"io"
 	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	peertest "perun.network/go-perun/peer/test"
 	"perun.network/go-perun/pkg/sync"
 	"perun.network/go-perun/pkg/test"
+	"perun.network/go-perun/wallet"
 	wallettest "perun.network/go-perun/wallet/test"
 )
 
@@ -63,6 +66,37 @@ func TestRegistry_Get_Pair(t *testing.T) {
 	})
 }
 
+// countingDecoder is a decoding context that counts the decoded addresses.
+type countingDecoder struct{ n int32 }
+
+func (d *countingDecoder) DecodeAddress(r io.Reader) (wallet.Address, error) {
+	atomic.AddInt32(&d.n, 1)
+	return wallet.DecodeAddress(r)
+}
+
+// The decoding context of the registry is used to decode the messages of its
+// peers.
+func TestRegistry_SetDecodingContext(t *testing.T) {
+	t.Parallel()
+	rng := rand.New(rand.NewSource(4))
+	var hub peertest.ConnHub
+	dialerID := wallettest.NewRandomAccount(rng)
+	listenerID := wallettest.NewRandomAccount(rng)
+	dialerReg := peer.NewRegistry(dialerID, func(*peer.Peer) {}, hub.NewDialer())
+	defer dialerReg.Close()
+	listenerReg := peer.NewRegistry(listenerID, func(*peer.Peer) {}, nil)
+	defer listenerReg.Close()
+	go listenerReg.Listen(hub.NewListener(listenerID.Address()))
+
+	dec := new(countingDecoder)
+	dialerReg.SetDecodingContext(dec)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*timeout)
+	defer cancel()
+	_, err := dialerReg.Get(ctx, listenerID.Address())
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dec.n))
+}
+
 // Tests that calling .Get() concurrently on the same peer works properly.
 func TestRegistry_Get_Multiple(t *testing.T) {
 	t.Parallel()
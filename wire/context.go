@@ -0,0 +1,40 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package wire
+
+import "io"
+
+// A ContextReader is an io.Reader that carries a decoding context, e.g., the
+// backends of the client that receives the decoded messages. Decoders whose
+// result depends on such a context retrieve it with DecodingContext.
+type ContextReader interface {
+	io.Reader
+	DecodingContext() interface{}
+}
+
+type contextReader struct {
+	io.Reader
+	ctx interface{}
+}
+
+func (r *contextReader) DecodingContext() interface{} {
+	return r.ctx
+}
+
+// WithDecodingContext returns a ContextReader that reads from r and carries
+// the decoding context ctx.
+func WithDecodingContext(r io.Reader, ctx interface{}) ContextReader {
+	return &contextReader{Reader: r, ctx: ctx}
+}
+
+// DecodingContext returns the decoding context of r or nil if r is not a
+// ContextReader.
+func DecodingContext(r io.Reader) interface{} {
+	if cr, ok := r.(ContextReader); ok {
+		return cr.DecodingContext()
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+// Copyright (c) 2020 Chair of Applied Cryptography, Technische Universität
+// Darmstadt, Germany. All rights reserved. This file is part of go-perun. Use
+// of this source code is governed by a MIT-style license that can be found in
+// the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodingContext(t *testing.T) {
+	r := bytes.NewReader([]byte{1, 2})
+	assert.Nil(t, DecodingContext(r))
+
+	cr := WithDecodingContext(r, "ctx")
+	assert.Equal(t, "ctx", DecodingContext(cr))
+	var b byte
+	require.NoError(t, Decode(cr, &b))
+	assert.Equal(t, byte(1), b)
+}
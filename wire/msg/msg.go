@@ -87,19 +87,30 @@ const (
 	ChannelUpdate
 	ChannelUpdateAcc
 	ChannelUpdateRej
+	ChannelProposalFinal
+	ChannelAction
+	ChannelSync
+	VirtualChannelFunding
+	VirtualChannelSettlement
 	LastType // upper bound on the message types of the Perun wire protocol
 )
 
 var typeNames = map[Type]string{
-	Ping:               "Ping",
-	Pong:               "Pong",
-	AuthResponse:       "AuthResponse",
-	ChannelProposal:    "ChannelProposal",
-	ChannelProposalAcc: "ChannelProposalAcc",
-	ChannelProposalRej: "ChannelProposalRej",
-	ChannelUpdate:      "ChannelUpdate",
-	ChannelUpdateAcc:   "ChannelUpdateAcc",
-	ChannelUpdateRej:   "ChannelUpdateRej",
+	Ping:                 "Ping",
+	Pong:                 "Pong",
+	AuthResponse:         "AuthResponse",
+	ChannelProposal:      "ChannelProposal",
+	ChannelProposalAcc:   "ChannelProposalAcc",
+	ChannelProposalRej:   "ChannelProposalRej",
+	ChannelUpdate:        "ChannelUpdate",
+	ChannelUpdateAcc:     "ChannelUpdateAcc",
+	ChannelUpdateRej:     "ChannelUpdateRej",
+	ChannelProposalFinal: "ChannelProposalFinal",
+	ChannelAction:        "ChannelAction",
+	ChannelSync:          "ChannelSync",
+
+	VirtualChannelFunding:    "VirtualChannelFunding",
+	VirtualChannelSettlement: "VirtualChannelSettlement",
 }
 
 // String returns the name of a message type if it is valid and name known
@@ -47,3 +47,21 @@ func TestRegisterExternalDecoder(t *testing.T) {
 		"registration of internal type should fail",
 	)
 }
+
+// TestType_WireValues checks that the values of the message types don't
+// change, as they are part of the wire protocol.
+func TestType_WireValues(t *testing.T) {
+	for typ, val := range map[Type]uint8{
+		Ping:               0,
+		Pong:               1,
+		AuthResponse:       2,
+		ChannelProposal:    3,
+		ChannelProposalAcc: 4,
+		ChannelProposalRej: 5,
+		ChannelUpdate:      6,
+		ChannelUpdateAcc:   7,
+		ChannelUpdateRej:   8,
+	} {
+		assert.Equal(t, val, uint8(typ), "wire value of %v", typ)
+	}
+}